@@ -0,0 +1,62 @@
+package main
+
+import (
+	"crypto/subtle"
+	"expvar"
+	"fmt"
+	"net/http"
+	"net/http/pprof"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// adminHandler serves profiling and metrics, for operators only
+func adminHandler() http.Handler {
+	r := mux.NewRouter()
+	r.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	r.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	r.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	r.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	r.PathPrefix("/debug/pprof/").HandlerFunc(pprof.Index)
+	r.Handle("/debug/vars", expvar.Handler())
+	return requireAdminToken(r)
+}
+
+// requireAdminToken accepts the admin token as a bearer token or as the
+// password of basic auth, so profiles can be fetched with curl and go tool pprof
+func requireAdminToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if _, password, ok := r.BasicAuth(); ok {
+			token = password
+		}
+		if subtle.ConstantTimeCompare([]byte(token), []byte(config().adminToken)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="admin"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// startAdmin mounts the admin endpoints on their own listener when an admin
+// address is configured, or under /debug on the main router otherwise
+func startAdmin(r *mux.Router) {
+	if config().adminToken == "" {
+		fmt.Printf("Admin endpoints disabled: %s is not set\n", AdminTokenEnvVar)
+		return
+	}
+
+	handler := adminHandler()
+	if config().adminAddr == "" {
+		r.PathPrefix("/debug/").Handler(handler)
+		return
+	}
+	go func() {
+		fmt.Printf("Admin server started on %s\n", config().adminAddr)
+		if err := http.ListenAndServe(config().adminAddr, handler); err != nil {
+			fmt.Printf("Error starting admin server: %v\n", err)
+		}
+	}()
+}
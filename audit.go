@@ -0,0 +1,164 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// auditLog appends one JSON line per archive request for compliance and
+// usage tracking
+type auditLog struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// auditRecord describes one archive request. API keys are only recorded as
+// fingerprints so the log can be shared without leaking credentials.
+type auditRecord struct {
+	Time       time.Time `json:"time"`
+	JobID      string    `json:"jobId,omitempty"`
+	Client     string    `json:"client"`
+	ForwardFor string    `json:"forwardedFor,omitempty"`
+	Method     string    `json:"method"`
+	Provider   string    `json:"provider,omitempty"`
+	APIKeys    []string  `json:"apiKeys,omitempty"`
+	Paths      []string  `json:"paths,omitempty"`
+	Entries    int       `json:"entries"`
+	Failed     int       `json:"failed"`
+	Bytes      int64     `json:"bytes"`
+	DurationMs int64     `json:"durationMs"`
+	Status     int       `json:"status"`
+	Outcome    string    `json:"outcome"` // complete, partial, failed or rejected
+	Error      string    `json:"error,omitempty"`
+}
+
+// openAuditLog opens the audit log for appending; "-" logs to stdout
+func openAuditLog(path string) (*auditLog, error) {
+	if path == "-" {
+		return &auditLog{w: os.Stdout}, nil
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o640)
+	if err != nil {
+		return nil, err
+	}
+	return &auditLog{w: file}, nil
+}
+
+// reopen switches the log to path, which also lets rotated logs be replaced
+func (l *auditLog) reopen(path string) error {
+	next, err := openAuditLog(path)
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	previous := l.w
+	l.w = next.w
+	l.mu.Unlock()
+	if file, ok := previous.(*os.File); ok && file != os.Stdout {
+		file.Close()
+	}
+	return nil
+}
+
+// write appends a record as a single line
+func (l *auditLog) write(record *auditRecord) {
+	line, err := json.Marshal(record)
+	if err != nil {
+		fmt.Printf("Failed to encode audit record: %v\n", err)
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, err := l.w.Write(append(line, '\n')); err != nil {
+		fmt.Printf("Failed to write audit record: %v\n", err)
+	}
+}
+
+// auditWriter records the status and size of a response for the audit log
+type auditWriter struct {
+	http.ResponseWriter
+	record  *auditRecord
+	status  int
+	written int64
+}
+
+// startAudit wraps w so that the request is written to the audit log by the
+// returned finish function. Without an audit log, w is returned unchanged.
+func startAudit(w http.ResponseWriter, r *http.Request) (http.ResponseWriter, func()) {
+	if auditTrail == nil {
+		return w, func() {}
+	}
+	started := time.Now()
+	aw := &auditWriter{ResponseWriter: w, record: &auditRecord{
+		Time:       started.UTC(),
+		Client:     r.RemoteAddr,
+		ForwardFor: r.Header.Get("X-Forwarded-For"),
+		Method:     r.Method,
+	}}
+	return aw, func() {
+		record := aw.record
+		record.Bytes = aw.written
+		record.DurationMs = time.Since(started).Milliseconds()
+		record.Status = aw.status
+		if record.Status == 0 {
+			record.Status = http.StatusOK
+		}
+		if record.Outcome == "" {
+			record.Outcome = "complete"
+			if record.Status >= http.StatusBadRequest {
+				record.Outcome = "rejected"
+			}
+		}
+		auditTrail.write(record)
+	}
+}
+
+func (a *auditWriter) WriteHeader(status int) {
+	if a.status == 0 {
+		a.status = status
+	}
+	a.ResponseWriter.WriteHeader(status)
+}
+
+func (a *auditWriter) Write(p []byte) (int, error) {
+	if a.status == 0 {
+		a.status = http.StatusOK
+	}
+	n, err := a.ResponseWriter.Write(p)
+	a.written += int64(n)
+	return n, err
+}
+
+// Flush passes flushes through to the response so streaming is unaffected
+func (a *auditWriter) Flush() {
+	if flusher, ok := a.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap gives http.ResponseController access to the underlying response
+func (a *auditWriter) Unwrap() http.ResponseWriter {
+	return a.ResponseWriter
+}
+
+// auditRecordOf returns the audit record of a response, or nil when the
+// request isn't audited
+func auditRecordOf(w http.ResponseWriter) *auditRecord {
+	if aw, ok := w.(*auditWriter); ok {
+		return aw.record
+	}
+	return nil
+}
+
+// keyFingerprint identifies an API key in the audit log without revealing it
+func keyFingerprint(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:6])
+}
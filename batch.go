@@ -0,0 +1,303 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gozipstreamer/zipstreamer"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+)
+
+// batchPath builds several archives from one request
+const batchPath = "/create-zip/batch"
+
+// batchStatusHeader carries the HTTP status of an archive in its part of a
+// multipart batch response
+const batchStatusHeader = "X-Zip-Response-Status"
+
+// batchRequest lists the archives of a batch. Each one is a zip descriptor
+// or, when it is a JSON array, a list of direct URLs.
+type batchRequest struct {
+	Archives []json.RawMessage `json:"archives"`
+}
+
+// batchArchive is one archive of a parsed batch
+type batchArchive struct {
+	jobID      string
+	descriptor *zipstreamer.ZipDescriptor
+	options    *zipOptions
+	raw        json.RawMessage // As given in the batch
+}
+
+// batchResult summarizes the outcome of one archive
+type batchResult struct {
+	JobID    string `json:"jobId"`
+	Filename string `json:"filename"`
+	Status   string `json:"status"`
+	Failed   int    `json:"failed"`
+	SHA256   string `json:"sha256,omitempty"`
+	URL      string `json:"url,omitempty"`
+	Link     string `json:"link,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// batchHandler builds every archive of a batch request. By default they are
+// streamed one after another as parts of a multipart/mixed response, followed
+// by a JSON part summarizing them. With async=true the archives are built in
+// the background into the requested target and the response only lists their
+// job IDs, which can be followed on /jobs/{id}.
+func batchHandler(w http.ResponseWriter, r *http.Request) {
+	w, finishAudit := startAudit(w, r)
+	defer finishAudit()
+	audit := auditRecordOf(w)
+
+	payload, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Failed to read request body", http.StatusBadRequest)
+		return
+	}
+	var async bool
+	if value := r.URL.Query().Get("async"); value != "" {
+		if async, err = strconv.ParseBool(value); err != nil {
+			http.Error(w, "invalid async parameter: "+value, http.StatusBadRequest)
+			return
+		}
+	}
+	archives, err := parseBatch(r, payload)
+	if err != nil {
+		writeBadRequest(w, err)
+		return
+	}
+	if async && (archives[0].options.target == "" || archives[0].options.tee) {
+		http.Error(w, "async batches require a target without tee", http.StatusBadRequest)
+		return
+	}
+	for _, archive := range archives {
+		auditDescriptor(audit, archive.descriptor)
+	}
+
+	if async {
+		// The archives outlive the request
+		r = r.WithContext(context.WithoutCancel(r.Context()))
+	}
+	r, release, ok := claimIdempotencyKey(w, r, payload)
+	if !ok {
+		return
+	}
+	batchID := requestJobID(r)
+	for i, archive := range archives {
+		archive.jobID = fmt.Sprintf("%s-%d", batchID, i+1)
+	}
+	fmt.Printf("Batch %s: %d archives\n", batchID, len(archives))
+
+	if async {
+		go func() {
+			defer release()
+			for _, archive := range archives {
+				part := &batchPart{header: make(http.Header)}
+				result := buildBatchArchive(part, r, archive)
+				fmt.Printf("Batch %s: archive %s finished as %s\n", batchID, result.JobID, result.Status)
+			}
+		}()
+
+		queued := make([]batchResult, 0, len(archives))
+		for _, archive := range archives {
+			queued = append(queued, batchResult{JobID: archive.jobID, Filename: archive.options.filename, Status: "queued"})
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Zip-Job-Id", batchID)
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(map[string]interface{}{"batchId": batchID, "archives": queued})
+		return
+	}
+	defer release()
+
+	parts := multipart.NewWriter(w)
+	w.Header().Set("Content-Type", "multipart/mixed; boundary="+parts.Boundary())
+	w.Header().Set("X-Zip-Job-Id", batchID)
+	flusher, _ := w.(http.Flusher)
+
+	results := make([]batchResult, 0, len(archives))
+	for _, archive := range archives {
+		part := &batchPart{parts: parts, flusher: flusher, header: make(http.Header)}
+		results = append(results, buildBatchArchive(part, r, archive))
+		if r.Context().Err() != nil {
+			return
+		}
+	}
+
+	summary, err := parts.CreatePart(textproto.MIMEHeader{"Content-Type": {"application/json"}})
+	if err != nil {
+		return
+	}
+	json.NewEncoder(summary).Encode(map[string]interface{}{"batchId": batchID, "archives": results})
+	parts.Close()
+}
+
+// parseBatch reads the archives of a batch with their options. The query
+// applies to every archive, so it can't name them.
+func parseBatch(r *http.Request, payload []byte) ([]*batchArchive, error) {
+	if err := validateBody(payload, "Batch", "Invalid batch request"); err != nil {
+		return nil, err
+	}
+	var batch batchRequest
+	if err := json.Unmarshal(payload, &batch); err != nil {
+		return nil, errors.New("Invalid batch request")
+	}
+	if len(batch.Archives) == 0 {
+		return nil, errors.New("batch has no archives")
+	}
+	if config().maxBatchArchives > 0 && len(batch.Archives) > config().maxBatchArchives {
+		return nil, fmt.Errorf("batch has %d archives, the limit is %d", len(batch.Archives), config().maxBatchArchives)
+	}
+	if r.URL.Query().Get("filename") != "" {
+		return nil, errors.New("filename can't be set for a batch, use suggestedFilename in each archive")
+	}
+
+	archives := make([]*batchArchive, 0, len(batch.Archives))
+	for i, raw := range batch.Archives {
+		var descriptor *zipstreamer.ZipDescriptor
+		var err error
+		if trimmed := bytes.TrimSpace(raw); len(trimmed) > 0 && trimmed[0] == '[' {
+			descriptor, err = zipstreamer.UnmarshalJsonURLList(raw)
+		} else {
+			descriptor, err = zipstreamer.UnmarshalJsonZipDescriptor(raw)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid archive %d: %v", i+1, err)
+		}
+		options, err := descriptorOptions(r, descriptor)
+		if err != nil {
+			return nil, fmt.Errorf("archive %d: %v", i+1, err)
+		}
+		if options.filename == "" {
+			options.filename = fmt.Sprintf("archive-%d", i+1)
+		}
+		archives = append(archives, &batchArchive{descriptor: descriptor, options: options, raw: raw})
+	}
+	return archives, nil
+}
+
+// buildBatchArchive streams one archive of a batch into its part
+func buildBatchArchive(part *batchPart, r *http.Request, archive *batchArchive) batchResult {
+	ctx := context.WithValue(r.Context(), jobIDKey{}, archive.jobID)
+	if config().jobKey != nil {
+		ctx = context.WithValue(ctx, jobRequestKey{}, archive.request(r))
+	}
+	processDescriptorRequest(part, r.WithContext(ctx), archive.descriptor, archive.options)
+	part.finish()
+	return part.result(archive)
+}
+
+// request returns a request building the archive alone, which resumes it on its own
+func (archive *batchArchive) request(r *http.Request) *jobRequest {
+	query := r.URL.Query()
+	query.Del("async")
+	query.Set("filename", archive.options.filename)
+	return &jobRequest{
+		Method: http.MethodPost,
+		URL:    "/create-zip?" + query.Encode(),
+		Header: map[string][]string{"Content-Type": {"application/json"}},
+		Body:   archive.raw,
+		Client: r.RemoteAddr,
+	}
+}
+
+// batchPart is the response writer of one archive in a batch. It writes into
+// a part of the multipart response, or discards the body when parts is nil.
+// Headers and trailers are kept to summarize the archive.
+type batchPart struct {
+	parts   *multipart.Writer
+	flusher http.Flusher
+	header  http.Header
+	status  int
+	body    io.Writer
+	errText strings.Builder // Start of the body of rejected archives
+}
+
+func (p *batchPart) Header() http.Header {
+	return p.header
+}
+
+func (p *batchPart) WriteHeader(status int) {
+	if p.status == 0 {
+		p.status = status
+	}
+}
+
+func (p *batchPart) Write(b []byte) (int, error) {
+	if p.body == nil {
+		if err := p.open(); err != nil {
+			return 0, err
+		}
+	}
+	if p.status >= http.StatusBadRequest && p.errText.Len() < 512 {
+		p.errText.Write(b)
+	}
+	return p.body.Write(b)
+}
+
+func (p *batchPart) Flush() {
+	if p.flusher != nil {
+		p.flusher.Flush()
+	}
+}
+
+// open starts the part with the headers describing the archive
+func (p *batchPart) open() error {
+	p.WriteHeader(http.StatusOK)
+	if p.parts == nil {
+		p.body = io.Discard
+		return nil
+	}
+	header := make(textproto.MIMEHeader)
+	for _, name := range []string{"Content-Type", "Content-Disposition", "X-Zip-Job-Id"} {
+		if value := p.header.Get(name); value != "" {
+			header.Set(name, value)
+		}
+	}
+	header.Set(batchStatusHeader, strconv.Itoa(p.status))
+	part, err := p.parts.CreatePart(header)
+	if err != nil {
+		return err
+	}
+	p.body = part
+	return nil
+}
+
+// finish opens the part of an archive that wrote no body
+func (p *batchPart) finish() {
+	if p.body == nil {
+		p.open()
+	}
+}
+
+// result reads the outcome of the archive from its trailers
+func (p *batchPart) result(archive *batchArchive) batchResult {
+	result := batchResult{
+		JobID:    archive.jobID,
+		Filename: archive.options.filename,
+		Status:   p.header.Get(statusTrailer),
+		SHA256:   p.header.Get(sha256Trailer),
+		URL:      p.header.Get(targetURLTrailer),
+		Link:     p.header.Get(downloadURLTrailer),
+	}
+	result.Failed, _ = strconv.Atoi(p.header.Get(failedCountTrailer))
+	// Archives without a status trailer were refused before streaming, or had no entries
+	switch {
+	case result.Status != "":
+	case p.status >= http.StatusBadRequest:
+		result.Status = "rejected"
+		result.Error = strings.TrimSpace(p.errText.String())
+	default:
+		result.Status = "empty"
+	}
+	return result
+}
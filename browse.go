@@ -0,0 +1,184 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"gozipstreamer/provider"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// browseItem is a listed file or folder as returned to frontends. Download
+// links are left out, since they can carry the provider's credentials.
+type browseItem struct {
+	ID      string     `json:"id,omitempty"`
+	Name    string     `json:"name"`
+	Path    string     `json:"path,omitempty"`
+	IsDir   bool       `json:"isDir"`
+	Size    int64      `json:"size"`
+	ModTime *time.Time `json:"modTime,omitempty"`
+	Error   string     `json:"error,omitempty"` // Why the item can't be archived
+}
+
+// maxCachedListings bounds the listing cache; the oldest listings are evicted first
+const maxCachedListings = 1024
+
+// listingCache keeps recent folder listings, so a UI navigating back and forth
+// doesn't hit the provider's API for every click. Listings are keyed by the
+// credential as well, so one user's listing is never served to another.
+type listingCache struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	entries map[string]cachedListing
+	order   []string // Insertion order, oldest first, for eviction
+}
+
+type cachedListing struct {
+	folder  *provider.Folder
+	expires time.Time
+}
+
+// browseCache is the shared listing cache, nil when caching is disabled
+var browseCache *listingCache
+
+func newListingCache(ttl time.Duration) *listingCache {
+	return &listingCache{ttl: ttl, entries: make(map[string]cachedListing)}
+}
+
+// listingKey identifies a listing by provider, credential, provider
+// parameters and path. The credential is hashed so the cache holds no secrets.
+func listingKey(providerName, apiKey string, params url.Values, path string) string {
+	names := make([]string, 0, len(params))
+	for name := range params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	h := sha256.New()
+	h.Write([]byte(providerName + "\n" + apiKey + "\n" + path + "\n"))
+	for _, name := range names {
+		h.Write([]byte(name + "=" + strings.Join(params[name], ",") + "\n"))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (c *listingCache) get(key string) (*provider.Folder, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cached, ok := c.entries[key]
+	if !ok || time.Now().After(cached.expires) {
+		return nil, false
+	}
+	return cached.folder, true
+}
+
+func (c *listingCache) put(key string, folder *provider.Folder) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entries[key]; !ok {
+		c.order = append(c.order, key)
+	}
+	c.entries[key] = cachedListing{folder: folder, expires: time.Now().Add(c.ttl)}
+	for len(c.order) > maxCachedListings {
+		delete(c.entries, c.order[0])
+		c.order = c.order[1:]
+	}
+}
+
+// browseCredential returns the API key of a browse request, preferring
+// headers so the key stays out of access logs
+func browseCredential(r *http.Request) string {
+	if key := r.Header.Get("X-Api-Key"); key != "" {
+		return key
+	}
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimPrefix(auth, "Bearer ")
+	}
+	return r.URL.Query().Get("apikey")
+}
+
+// providersHandler lists the registered providers
+func providersHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"providers": provider.Names()})
+}
+
+// listHandler lists a provider folder through the listing cache. The
+// refresh parameter bypasses the cache, e.g. after uploading files.
+func listHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	apiKey := browseCredential(r)
+	providerName := query.Get("provider")
+	if providerName == "" {
+		providerName = "premiumize"
+	}
+	folderPath := query.Get("path")
+	refresh, _ := strconv.ParseBool(query.Get("refresh"))
+
+	// The remaining parameters configure the provider, e.g. OneDrive's token type
+	params := url.Values{}
+	for name, values := range query {
+		switch name {
+		case "provider", "path", "apikey", "refresh":
+		default:
+			params[name] = values
+		}
+	}
+
+	// Listings are private to the credential that fetched them
+	w.Header().Set("Cache-Control", "private, no-store")
+
+	key := listingKey(providerName, apiKey, params, folderPath)
+	folder, cached := browseCache.get(key)
+	if !cached || refresh {
+		source, err := provider.New(providerName, apiKey, params)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if folder, err = source.List(folderPath); err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		browseCache.put(key, folder)
+	}
+
+	items := make([]browseItem, 0, len(folder.Items))
+	for _, item := range folder.Items {
+		listed := browseItem{ID: item.ID, Name: item.Name, Path: item.Path, IsDir: item.IsDir, Size: item.Size, Error: item.Error}
+		if !item.ModTime.IsZero() {
+			listed.ModTime = &item.ModTime
+		}
+		items = append(items, listed)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"name":  folder.Name,
+		"path":  folderPath,
+		"items": items,
+	})
+}
+
+// startBrowse mounts the listing API used by the UI
+func startBrowse(r *mux.Router) {
+	if config().browseCacheTTL > 0 {
+		browseCache = newListingCache(config().browseCacheTTL)
+	}
+	r.HandleFunc("/api/providers", providersHandler).Methods("GET")
+	r.HandleFunc("/api/list", listHandler).Methods("GET")
+}
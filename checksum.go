@@ -0,0 +1,63 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"net/http"
+)
+
+// checksumWriter hashes everything written to the client so the archive
+// checksum can be sent as a trailer
+type checksumWriter struct {
+	w      io.Writer
+	sha256 hash.Hash
+	crc32  hash.Hash32
+}
+
+func newChecksumWriter(w io.Writer) *checksumWriter {
+	return &checksumWriter{w: w, sha256: sha256.New(), crc32: crc32.NewIEEE()}
+}
+
+func (c *checksumWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.sha256.Write(p[:n])
+	c.crc32.Write(p[:n])
+	return n, err
+}
+
+// Flush passes flushes through to the response so streaming is unaffected
+func (c *checksumWriter) Flush() {
+	if flusher, ok := c.w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (c *checksumWriter) SHA256() string {
+	return hex.EncodeToString(c.sha256.Sum(nil))
+}
+
+func (c *checksumWriter) CRC32() string {
+	return fmt.Sprintf("%08x", c.crc32.Sum32())
+}
+
+// state returns the states of both checksums, to continue them with restore
+func (c *checksumWriter) state() (sha256State, crc32State []byte, err error) {
+	if sha256State, err = c.sha256.(encoding.BinaryMarshaler).MarshalBinary(); err != nil {
+		return nil, nil, err
+	}
+	crc32State, err = c.crc32.(encoding.BinaryMarshaler).MarshalBinary()
+	return sha256State, crc32State, err
+}
+
+// restore continues the checksums from states returned by state
+func (c *checksumWriter) restore(sha256State, crc32State []byte) error {
+	if err := c.sha256.(encoding.BinaryUnmarshaler).UnmarshalBinary(sha256State); err != nil {
+		return err
+	}
+	return c.crc32.(encoding.BinaryUnmarshaler).UnmarshalBinary(crc32State)
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// version is set at build time with -ldflags "-X main.version=..."
+var version = "dev"
+
+// maxSourceSummary keeps the archive comment readable for huge requests
+const maxSourceSummary = 1024
+
+// newJobID returns a random identifier for one archive request
+func newJobID() string {
+	id := make([]byte, 8)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// archiveComment describes where and when an archive was produced, so support
+// can trace an archive a user sends in back to the request. Reproducible
+// archives leave out the job and time, which differ between requests.
+func archiveComment(jobID string, entries *entrySet, reproducible bool) string {
+	source := entries.source
+	if len(source) > maxSourceSummary {
+		source = source[:maxSourceSummary] + "..."
+	}
+
+	var comment strings.Builder
+	fmt.Fprintf(&comment, "Generated by gozipstreamer %s\n", version)
+	if !reproducible {
+		fmt.Fprintf(&comment, "Job: %s\n", jobID)
+		fmt.Fprintf(&comment, "Created: %s\n", time.Now().UTC().Format(time.RFC3339))
+	}
+	fmt.Fprintf(&comment, "Source: %s", source)
+	return comment.String()
+}
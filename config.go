@@ -0,0 +1,554 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"gozipstreamer/zipstreamer"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	MaxArchiveSizeEnvVar  = "ZS_MAX_ARCHIVE_SIZE"
+	MaxEntriesEnvVar      = "ZS_MAX_ENTRIES"
+	TruncateEntriesEnvVar = "ZS_TRUNCATE_ENTRIES"
+
+	MaxIdleConnsPerHostEnvVar   = "ZS_MAX_IDLE_CONNS_PER_HOST"
+	DialTimeoutEnvVar           = "ZS_DIAL_TIMEOUT"
+	TLSHandshakeTimeoutEnvVar   = "ZS_TLS_HANDSHAKE_TIMEOUT"
+	ResponseHeaderTimeoutEnvVar = "ZS_RESPONSE_HEADER_TIMEOUT"
+	DisableCompressionEnvVar    = "ZS_DISABLE_UPSTREAM_COMPRESSION"
+	MaxRedirectsEnvVar          = "ZS_MAX_REDIRECTS"
+	RedirectForwardAuthEnvVar   = "ZS_REDIRECT_FORWARD_AUTH"
+	RedirectHostsEnvVar         = "ZS_REDIRECT_HOSTS"
+	CookieJarEnvVar             = "ZS_COOKIE_JAR"
+	ProxyEnvVar                 = "ZS_PROXY"
+	ProviderProxiesEnvVar       = "ZS_PROVIDER_PROXIES"
+	DNSCacheTTLEnvVar           = "ZS_DNS_CACHE_TTL"
+	EgressAllowEnvVar           = "ZS_EGRESS_ALLOW"
+	EgressDenyEnvVar            = "ZS_EGRESS_DENY"
+	EgressAllowInternalEnvVar   = "ZS_EGRESS_ALLOW_INTERNAL"
+
+	EntryTimeoutEnvVar  = "ZS_ENTRY_TIMEOUT"
+	StallTimeoutEnvVar  = "ZS_STALL_TIMEOUT"
+	MinThroughputEnvVar = "ZS_MIN_THROUGHPUT"
+	JobDeadlineEnvVar   = "ZS_JOB_DEADLINE"
+	KeepaliveEnvVar     = "ZS_KEEPALIVE_INTERVAL"
+
+	ETagCacheSizeEnvVar     = "ZS_ETAG_CACHE_SIZE"
+	ETagCacheMaxEntryEnvVar = "ZS_ETAG_CACHE_MAX_ENTRY"
+
+	DiskCacheDirEnvVar  = "ZS_DISK_CACHE_DIR"
+	DiskCacheSizeEnvVar = "ZS_DISK_CACHE_SIZE"
+	DiskCacheHitsEnvVar = "ZS_DISK_CACHE_MIN_HITS"
+
+	MemoryCacheSizeEnvVar     = "ZS_MEMORY_CACHE_SIZE"
+	MemoryCacheMaxEntryEnvVar = "ZS_MEMORY_CACHE_MAX_ENTRY"
+	MemoryCacheTTLEnvVar      = "ZS_MEMORY_CACHE_TTL"
+
+	CompressionLevelEnvVar   = "ZS_COMPRESSION_LEVEL"
+	CompressionPolicyEnvVar  = "ZS_COMPRESSION_POLICY"
+	CompressionWorkersEnvVar = "ZS_COMPRESSION_WORKERS"
+
+	MemoryBudgetEnvVar = "ZS_MEMORY_BUDGET"
+
+	AdminAddrEnvVar  = "ZS_ADMIN_ADDR"
+	AdminTokenEnvVar = "ZS_ADMIN_TOKEN"
+
+	AuditLogEnvVar = "ZS_AUDIT_LOG"
+
+	JobStoreEnvVar     = "ZS_JOB_STORE"
+	JobRetentionEnvVar = "ZS_JOB_RETENTION"
+	JobKeyEnvVar       = "ZS_JOB_KEY"
+
+	IdempotencyTTLEnvVar = "ZS_IDEMPOTENCY_TTL"
+
+	ModeEnvVar              = "ZS_MODE"
+	QueueURLEnvVar          = "ZS_QUEUE_URL"
+	QueueNameEnvVar         = "ZS_QUEUE_NAME"
+	QueueWaitEnvVar         = "ZS_QUEUE_WAIT"
+	AdvertiseURLEnvVar      = "ZS_ADVERTISE_URL"
+	WorkerTokenEnvVar       = "ZS_WORKER_TOKEN"
+	WorkerConcurrencyEnvVar = "ZS_WORKER_CONCURRENCY"
+
+	S3BucketEnvVar       = "ZS_S3_BUCKET"
+	S3RegionEnvVar       = "ZS_S3_REGION"
+	S3EndpointEnvVar     = "ZS_S3_ENDPOINT"
+	S3PrefixEnvVar       = "ZS_S3_PREFIX"
+	S3PathStyleEnvVar    = "ZS_S3_PATH_STYLE"
+	S3PartSizeEnvVar     = "ZS_S3_PART_SIZE"
+	S3AccessKeyEnvVar    = "ZS_S3_ACCESS_KEY_ID"
+	S3SecretKeyEnvVar    = "ZS_S3_SECRET_ACCESS_KEY"
+	S3SessionTokenEnvVar = "ZS_S3_SESSION_TOKEN"
+
+	GCSBucketEnvVar      = "ZS_GCS_BUCKET"
+	GCSEndpointEnvVar    = "ZS_GCS_ENDPOINT"
+	GCSPrefixEnvVar      = "ZS_GCS_PREFIX"
+	GCSCredentialsEnvVar = "ZS_GCS_CREDENTIALS"
+	GCSAccessTokenEnvVar = "ZS_GCS_ACCESS_TOKEN"
+	GCSChunkSizeEnvVar   = "ZS_GCS_CHUNK_SIZE"
+
+	LocalTargetDirEnvVar = "ZS_LOCAL_TARGET_DIR"
+
+	SMTPAddrEnvVar      = "ZS_SMTP_ADDR"
+	SMTPFromEnvVar      = "ZS_SMTP_FROM"
+	SMTPUsernameEnvVar  = "ZS_SMTP_USERNAME"
+	SMTPPasswordEnvVar  = "ZS_SMTP_PASSWORD"
+	NotifyDomainsEnvVar = "ZS_NOTIFY_DOMAINS"
+
+	LinkKeyEnvVar   = "ZS_LINK_KEY"
+	LinkTTLEnvVar   = "ZS_LINK_TTL"
+	PublicURLEnvVar = "ZS_PUBLIC_URL"
+
+	BrowseCacheTTLEnvVar = "ZS_BROWSE_CACHE_TTL"
+
+	TransferTimeoutEnvVar = "ZS_TRANSFER_TIMEOUT"
+
+	MaxBatchArchivesEnvVar = "ZS_MAX_BATCH_ARCHIVES"
+
+	ClamdAddrEnvVar    = "ZS_CLAMD_ADDR"
+	ClamdTimeoutEnvVar = "ZS_CLAMD_TIMEOUT"
+	ScanPolicyEnvVar   = "ZS_SCAN_POLICY"
+
+	AllowedContentTypesEnvVar = "ZS_ALLOWED_CONTENT_TYPES"
+	DeniedContentTypesEnvVar  = "ZS_DENIED_CONTENT_TYPES"
+
+	GRPCAddrEnvVar     = "ZS_GRPC_ADDR"
+	GRPCTokenEnvVar    = "ZS_GRPC_TOKEN"
+	GRPCInsecureEnvVar = "ZS_GRPC_INSECURE"
+
+	ListenSocketEnvVar = "ZS_LISTEN_SOCKET"
+	SocketModeEnvVar   = "ZS_SOCKET_MODE"
+
+	ShutdownTimeoutEnvVar = "ZS_SHUTDOWN_TIMEOUT"
+
+	SelfTestURLsEnvVar      = "ZS_SELFTEST_URLS"
+	SelfTestProvidersEnvVar = "ZS_SELFTEST_PROVIDERS"
+
+	EnvFileEnvVar     = "ZS_ENV_FILE"
+	ConfigWatchEnvVar = "ZS_CONFIG_WATCH"
+)
+
+// serverConfig holds the server-wide settings read from the environment
+type serverConfig struct {
+	maxArchiveSize     int64 // Bytes; 0 disables the limit
+	maxEntries         int   // 0 disables the limit
+	truncateEntries    bool  // Truncate oversized archives instead of rejecting them
+	transport          zipstreamer.TransportOptions
+	entryTimeout       time.Duration                 // Per-entry fetch timeout; 0 disables
+	stallTimeout       time.Duration                 // Stall detection window; 0 disables
+	minThroughput      int64                         // Bytes per second required within the stall window
+	jobDeadline        time.Duration                 // Wall-clock limit per archive; 0 disables
+	keepalive          time.Duration                 // Idle interval before held-back bytes are released; 0 disables
+	etagCacheSize      int64                         // Total bytes of small responses to cache; 0 disables
+	etagCacheEntry     int64                         // Largest response size that is cached
+	diskCacheDir       string                        // Directory for spooled hot files; empty disables
+	diskCacheSize      int64                         // Total bytes kept in the disk cache
+	diskCacheHits      int                           // Requests before an entry is considered hot
+	memCacheSize       int64                         // Total bytes of small entries kept in memory; 0 disables
+	memCacheEntry      int64                         // Largest entry kept in memory
+	memCacheTTL        time.Duration                 // How long a cached entry is served without refetching
+	compressionLevel   int                           // Deflate level of archives deflated without a level; 0 uses the default
+	compressionPolicy  zipstreamer.CompressionPolicy // Per-extension compression methods
+	compressionWorkers int                           // Entries deflated concurrently per archive; 1 compresses inline
+	memoryBudget       int64                         // Bytes of stream buffers across all archives; 0 disables the limit
+	adminAddr          string                        // Listen address of the admin server; empty mounts it on the main router
+	adminToken         string                        // Token required by the admin endpoints; empty disables them
+	cookieJar          bool                          // Keep cookies set by upstream responses for the rest of the archive
+	providerProxies    map[string]*url.URL           // Proxies for the API calls and downloads of single providers
+	auditLog           string                        // File receiving one JSON line per request, "-" for stdout; empty disables
+	jobStore           string                        // BoltDB file keeping the job history; empty disables
+	jobRetention       time.Duration                 // How long finished jobs are kept; 0 keeps them forever
+	jobKey             []byte                        // Key sealing the requests and credentials resuming jobs takes in the job history; nil disables resuming
+	idempotencyTTL     time.Duration                 // How long an idempotency key stays bound to its job after the build
+	mode               string                        // "frontend" or "worker" to distribute archives through a queue; empty builds them locally
+	queueURL           string                        // Redis URL of the job queue
+	queueName          string                        // Redis key of the job queue
+	queueWait          time.Duration                 // How long a front-end waits for a worker to start delivering
+	advertiseURL       string                        // Base URL under which workers reach this front-end
+	workerToken        string                        // Shared secret authenticating deliveries from workers
+	workerConcurrency  int                           // Archives a worker builds at the same time
+	s3                 *s3Config                     // Bucket of the s3 target; nil disables it
+	gcs                *gcsConfig                    // Bucket of the gcs target; nil disables it
+	localTargetDir     string                        // Directory of the local target, e.g. an NFS mount; empty disables it
+	smtpAddr           string                        // SMTP server sending job notifications as host:port; empty disables them
+	smtpFrom           string                        // Sender of job notifications
+	smtpUsername       string                        // SMTP login; empty sends without authentication
+	smtpPassword       string                        // SMTP password
+	notifyDomains      []string                      // Domains that notifications may be sent to; empty allows any
+	linkKey            string                        // Secret signing download links to archives in the local target; empty disables them
+	linkTTL            time.Duration                 // How long download links to stored archives stay valid; 0 disables them
+	publicURL          string                        // Base URL of this server in download links
+	browseCacheTTL     time.Duration                 // How long folder listings of the browse API are reused; 0 disables the cache
+	transferTimeout    time.Duration                 // How long torrent requests wait for their transfer to finish; 0 waits for the client
+	maxBatchArchives   int                           // Archives one batch request may define; 0 disables the limit
+	clamdAddr          string                        // clamd scanning every entry, as host:port or unix:///path; empty disables scanning
+	clamdTimeout       time.Duration                 // Limit of one entry's scan; 0 disables
+	scanPolicy         zipstreamer.ScanPolicy        // Whether infected entries are skipped, replaced by a warning or abort the archive
+	contentTypes       zipstreamer.ContentTypePolicy // MIME types entries are allowed or denied by their sniffed data; empty allows any
+	grpcAddr           string                        // Listen address of the gRPC archive job API; empty disables it
+	grpcToken          string                        // Bearer token required by the gRPC API; empty only with grpcInsecure
+	grpcInsecure       bool                          // Serve the gRPC API without a token to any caller
+	listenSocket       string                        // Unix socket served instead of TCP port 80; empty listens on TCP
+	socketMode         os.FileMode                   // Permissions of the Unix socket, e.g. 0660 for a proxy in the same group
+	shutdownTimeout    time.Duration                 // How long running archives may finish once the server is asked to stop
+	selfTestURLs       []string                      // URLs the self-test fetches to check outbound connectivity
+	selfTestProviders  []string                      // Providers whose API the self-test checks; empty checks all
+	configWatch        time.Duration                 // How often the environment file is checked for changes; 0 only reloads on SIGHUP
+}
+
+// currentConfig holds the configuration in effect. Reloads replace it as a
+// whole, so a setting read twice may change in between but never tears.
+var currentConfig atomic.Pointer[serverConfig]
+
+func init() {
+	if err := applyEnvFile(os.Getenv(EnvFileEnvVar)); err != nil {
+		fmt.Printf("Ignoring %s: %v\n", EnvFileEnvVar, err)
+	}
+	cfg, err := loadConfig()
+	if err != nil {
+		fmt.Printf("Invalid configuration: %v\n", err)
+		os.Exit(1)
+	}
+	currentConfig.Store(cfg)
+}
+
+// config returns the configuration in effect
+func config() *serverConfig {
+	return currentConfig.Load()
+}
+
+// loadConfig reads the server configuration from environment variables. It
+// fails on settings that would be unsafe to ignore.
+func loadConfig() (*serverConfig, error) {
+	egressAllow, err := envEgressRules(EgressAllowEnvVar)
+	if err != nil {
+		return nil, err
+	}
+	egressDeny, err := envEgressRules(EgressDenyEnvVar)
+	if err != nil {
+		return nil, err
+	}
+	proxy, err := envProxy(ProxyEnvVar)
+	if err != nil {
+		return nil, err
+	}
+	providerProxies, err := envProviderProxies(ProviderProxiesEnvVar)
+	if err != nil {
+		return nil, err
+	}
+
+	transport := zipstreamer.DefaultTransportOptions()
+	transport.MaxIdleConnsPerHost = int(envInt64(MaxIdleConnsPerHostEnvVar, int64(transport.MaxIdleConnsPerHost)))
+	transport.DialTimeout = envDuration(DialTimeoutEnvVar, transport.DialTimeout)
+	transport.TLSHandshakeTimeout = envDuration(TLSHandshakeTimeoutEnvVar, transport.TLSHandshakeTimeout)
+	transport.ResponseHeaderTimeout = envDuration(ResponseHeaderTimeoutEnvVar, transport.ResponseHeaderTimeout)
+	transport.DisableCompression = envBool(DisableCompressionEnvVar, transport.DisableCompression)
+	transport.MaxRedirects = int(envInt64(MaxRedirectsEnvVar, int64(transport.MaxRedirects)))
+	transport.ForwardAuthOnRedirect = envBool(RedirectForwardAuthEnvVar, transport.ForwardAuthOnRedirect)
+	transport.RedirectHosts = envList(RedirectHostsEnvVar)
+	if transport.ForwardAuthOnRedirect && len(transport.RedirectHosts) == 0 {
+		return nil, fmt.Errorf("%s requires %s to list the hosts credentials may be sent to", RedirectForwardAuthEnvVar, RedirectHostsEnvVar)
+	}
+	transport.Proxy = proxy
+	transport.DNSCacheTTL = envDuration(DNSCacheTTLEnvVar, transport.DNSCacheTTL)
+	transport.EgressAllow = egressAllow
+	transport.EgressDeny = egressDeny
+	transport.EgressAllowInternal = envBool(EgressAllowInternalEnvVar, false)
+
+	return &serverConfig{
+		maxArchiveSize:     envInt64(MaxArchiveSizeEnvVar, 0),
+		maxEntries:         int(envInt64(MaxEntriesEnvVar, 0)),
+		truncateEntries:    envBool(TruncateEntriesEnvVar, false),
+		transport:          transport,
+		entryTimeout:       envDuration(EntryTimeoutEnvVar, 0),
+		stallTimeout:       envDuration(StallTimeoutEnvVar, 0),
+		minThroughput:      envInt64(MinThroughputEnvVar, 1),
+		jobDeadline:        envDuration(JobDeadlineEnvVar, 0),
+		keepalive:          envDuration(KeepaliveEnvVar, 0),
+		etagCacheSize:      envInt64(ETagCacheSizeEnvVar, 0),
+		etagCacheEntry:     envInt64(ETagCacheMaxEntryEnvVar, 1024*1024),
+		diskCacheDir:       os.Getenv(DiskCacheDirEnvVar),
+		diskCacheSize:      envInt64(DiskCacheSizeEnvVar, 10*1024*1024*1024),
+		diskCacheHits:      int(envInt64(DiskCacheHitsEnvVar, 2)),
+		memCacheSize:       envInt64(MemoryCacheSizeEnvVar, 0),
+		memCacheEntry:      envInt64(MemoryCacheMaxEntryEnvVar, 256*1024),
+		memCacheTTL:        envDuration(MemoryCacheTTLEnvVar, 5*time.Minute),
+		compressionLevel:   envCompressionLevel(CompressionLevelEnvVar),
+		compressionPolicy:  envCompressionPolicy(CompressionPolicyEnvVar),
+		compressionWorkers: int(envInt64(CompressionWorkersEnvVar, 1)),
+		memoryBudget:       envInt64(MemoryBudgetEnvVar, 0),
+		adminAddr:          os.Getenv(AdminAddrEnvVar),
+		adminToken:         os.Getenv(AdminTokenEnvVar),
+		cookieJar:          envBool(CookieJarEnvVar, false),
+		providerProxies:    providerProxies,
+		auditLog:           os.Getenv(AuditLogEnvVar),
+		jobStore:           os.Getenv(JobStoreEnvVar),
+		jobRetention:       envDuration(JobRetentionEnvVar, 30*24*time.Hour),
+		jobKey:             envKey(JobKeyEnvVar),
+		idempotencyTTL:     envDuration(IdempotencyTTLEnvVar, 24*time.Hour),
+		mode:               os.Getenv(ModeEnvVar),
+		queueURL:           os.Getenv(QueueURLEnvVar),
+		queueName:          envString(QueueNameEnvVar, "gozipstreamer:jobs"),
+		queueWait:          envDuration(QueueWaitEnvVar, 30*time.Second),
+		advertiseURL:       os.Getenv(AdvertiseURLEnvVar),
+		workerToken:        os.Getenv(WorkerTokenEnvVar),
+		workerConcurrency:  int(envInt64(WorkerConcurrencyEnvVar, 4)),
+		s3:                 envS3Config(),
+		gcs:                envGCSConfig(),
+		localTargetDir:     os.Getenv(LocalTargetDirEnvVar),
+		smtpAddr:           os.Getenv(SMTPAddrEnvVar),
+		smtpFrom:           os.Getenv(SMTPFromEnvVar),
+		smtpUsername:       os.Getenv(SMTPUsernameEnvVar),
+		smtpPassword:       os.Getenv(SMTPPasswordEnvVar),
+		notifyDomains:      envList(NotifyDomainsEnvVar),
+		linkKey:            os.Getenv(LinkKeyEnvVar),
+		linkTTL:            envDuration(LinkTTLEnvVar, 24*time.Hour),
+		publicURL:          os.Getenv(PublicURLEnvVar),
+		browseCacheTTL:     envDuration(BrowseCacheTTLEnvVar, time.Minute),
+		transferTimeout:    envDuration(TransferTimeoutEnvVar, 15*time.Minute),
+		maxBatchArchives:   int(envInt64(MaxBatchArchivesEnvVar, 32)),
+		clamdAddr:          os.Getenv(ClamdAddrEnvVar),
+		clamdTimeout:       envDuration(ClamdTimeoutEnvVar, 5*time.Minute),
+		scanPolicy:         envScanPolicy(ScanPolicyEnvVar),
+		contentTypes:       envContentTypePolicy(AllowedContentTypesEnvVar, DeniedContentTypesEnvVar),
+		grpcAddr:           os.Getenv(GRPCAddrEnvVar),
+		grpcToken:          os.Getenv(GRPCTokenEnvVar),
+		grpcInsecure:       envBool(GRPCInsecureEnvVar, false),
+		listenSocket:       os.Getenv(ListenSocketEnvVar),
+		socketMode:         envFileMode(SocketModeEnvVar, 0o660),
+		shutdownTimeout:    envDuration(ShutdownTimeoutEnvVar, 30*time.Second),
+		selfTestURLs:       envList(SelfTestURLsEnvVar),
+		selfTestProviders:  envList(SelfTestProvidersEnvVar),
+		configWatch:        envDuration(ConfigWatchEnvVar, 0),
+	}, nil
+}
+
+// envS3Config reads the s3 target's bucket, returning nil when none is set.
+// Region and credentials fall back to the standard AWS variables.
+func envS3Config() *s3Config {
+	bucket := os.Getenv(S3BucketEnvVar)
+	if bucket == "" {
+		return nil
+	}
+	region := envString(S3RegionEnvVar, envString("AWS_REGION", "us-east-1"))
+	return &s3Config{
+		endpoint:     envString(S3EndpointEnvVar, "https://s3."+region+".amazonaws.com"),
+		region:       region,
+		bucket:       bucket,
+		prefix:       os.Getenv(S3PrefixEnvVar),
+		pathStyle:    envBool(S3PathStyleEnvVar, false),
+		accessKey:    envString(S3AccessKeyEnvVar, os.Getenv("AWS_ACCESS_KEY_ID")),
+		secretKey:    envString(S3SecretKeyEnvVar, os.Getenv("AWS_SECRET_ACCESS_KEY")),
+		sessionToken: envString(S3SessionTokenEnvVar, os.Getenv("AWS_SESSION_TOKEN")),
+		partSize:     envInt64(S3PartSizeEnvVar, 16*1024*1024),
+	}
+}
+
+// envGCSConfig reads the gcs target's bucket, returning nil when none is set.
+// Credentials fall back to GOOGLE_APPLICATION_CREDENTIALS.
+func envGCSConfig() *gcsConfig {
+	bucket := os.Getenv(GCSBucketEnvVar)
+	if bucket == "" {
+		return nil
+	}
+	return &gcsConfig{
+		endpoint:    envString(GCSEndpointEnvVar, "https://storage.googleapis.com"),
+		bucket:      bucket,
+		prefix:      os.Getenv(GCSPrefixEnvVar),
+		credentials: envString(GCSCredentialsEnvVar, os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")),
+		accessToken: os.Getenv(GCSAccessTokenEnvVar),
+		chunkSize:   envInt64(GCSChunkSizeEnvVar, 16*1024*1024),
+	}
+}
+
+// envString returns an environment variable, falling back to def when unset
+func envString(name, def string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return def
+}
+
+// envBool parses a boolean environment variable, falling back to def when unset or invalid
+func envBool(name string, def bool) bool {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		fmt.Printf("Ignoring invalid %s: %v\n", name, err)
+		return def
+	}
+	return parsed
+}
+
+// envInt64 parses an integer environment variable, falling back to def when unset or invalid
+func envInt64(name string, def int64) int64 {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+	parsed, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		fmt.Printf("Ignoring invalid %s: %v\n", name, err)
+		return def
+	}
+	return parsed
+}
+
+// envDuration parses a duration environment variable such as "30s", falling back to def when unset or invalid
+func envDuration(name string, def time.Duration) time.Duration {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		fmt.Printf("Ignoring invalid %s: %v\n", name, err)
+		return def
+	}
+	return parsed
+}
+
+// envFileMode parses octal permissions such as "0660", falling back to def when unset or invalid
+func envFileMode(name string, def os.FileMode) os.FileMode {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+	parsed, err := strconv.ParseUint(value, 8, 32)
+	if err != nil || parsed > 0o777 {
+		fmt.Printf("Ignoring invalid %s: %q\n", name, value)
+		return def
+	}
+	return os.FileMode(parsed)
+}
+
+// envCompressionLevel parses a compression level environment variable, using
+// the default Deflate level when unset or invalid
+func envCompressionLevel(name string) int {
+	value := os.Getenv(name)
+	if value == "" {
+		return 0
+	}
+	level, err := parseCompressionLevel(value)
+	if err != nil {
+		fmt.Printf("Ignoring invalid %s: %v\n", name, err)
+		return 0
+	}
+	return level
+}
+
+// envCompressionPolicy parses a compression policy environment variable,
+// falling back to the default policy when unset or invalid
+func envCompressionPolicy(name string) zipstreamer.CompressionPolicy {
+	value := os.Getenv(name)
+	if value == "" {
+		return zipstreamer.DefaultCompressionPolicy
+	}
+	policy, err := zipstreamer.ParseCompressionPolicy(value)
+	if err != nil {
+		fmt.Printf("Ignoring invalid %s: %v\n", name, err)
+		return zipstreamer.DefaultCompressionPolicy
+	}
+	return policy
+}
+
+// envScanPolicy parses a scan policy environment variable, falling back to
+// skipping infected entries when unset or invalid
+func envScanPolicy(name string) zipstreamer.ScanPolicy {
+	policy, err := zipstreamer.ParseScanPolicy(os.Getenv(name))
+	if err != nil {
+		fmt.Printf("Ignoring invalid %s: %v\n", name, err)
+		return zipstreamer.ScanSkip
+	}
+	return policy
+}
+
+// envContentTypePolicy reads the allowed and denied MIME types, where
+// "executables" stands for the types of programs and scripts
+func envContentTypePolicy(allowName, denyName string) zipstreamer.ContentTypePolicy {
+	policy := zipstreamer.ContentTypePolicy{Allow: envList(allowName)}
+	for _, contentType := range envList(denyName) {
+		if strings.EqualFold(contentType, "executables") {
+			policy.Deny = append(policy.Deny, zipstreamer.ExecutableContentTypes...)
+		} else {
+			policy.Deny = append(policy.Deny, contentType)
+		}
+	}
+	return policy
+}
+
+// envList parses a comma-separated environment variable, skipping empty items
+func envList(name string) []string {
+	var items []string
+	for _, item := range strings.Split(os.Getenv(name), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// envProxy parses a proxy URL environment variable, leaving the standard
+// proxy environment in charge when unset. An invalid URL is an error, since
+// ignoring it would send traffic around the proxy.
+func envProxy(name string) (*url.URL, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return nil, nil
+	}
+	proxy, err := zipstreamer.ParseProxyURL(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %v", name, err)
+	}
+	return proxy, nil
+}
+
+// envProviderProxies parses a list of provider=proxy pairs such as
+// "mega=socks5://proxy:1080,onedrive=http://proxy:3128". Invalid items are
+// errors, like those of envProxy.
+func envProviderProxies(name string) (map[string]*url.URL, error) {
+	proxies := make(map[string]*url.URL)
+	for _, item := range envList(name) {
+		providerName, value, ok := strings.Cut(item, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid %s item %q: expected provider=url", name, item)
+		}
+		proxy, err := zipstreamer.ParseProxyURL(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s item %q: %v", name, item, err)
+		}
+		proxies[strings.TrimSpace(providerName)] = proxy
+	}
+	return proxies, nil
+}
+
+// envEgressRules parses a comma-separated list of host names, IPs and CIDR
+// blocks. Invalid items are errors, since skipping them would leave
+// destinations open.
+func envEgressRules(name string) (zipstreamer.EgressRules, error) {
+	var rules zipstreamer.EgressRules
+	for _, item := range envList(name) {
+		if err := rules.Add(item); err != nil {
+			return rules, fmt.Errorf("invalid %s item %q: %v", name, item, err)
+		}
+	}
+	return rules, nil
+}
+
+// envKey derives a 256-bit key from a secret environment variable, or returns nil when unset
+func envKey(name string) []byte {
+	value := os.Getenv(name)
+	if value == "" {
+		return nil
+	}
+	key := sha256.Sum256([]byte(value))
+	return key[:]
+}
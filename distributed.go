@@ -0,0 +1,464 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Server modes; a standalone server builds the archives it is asked for
+const (
+	modeFrontend = "frontend" // Queues archive requests for workers and relays their output
+	modeWorker   = "worker"   // Builds queued archives and delivers them to the front-end
+)
+
+// Request headers forwarded to the worker building an archive. Idempotency
+// keys are claimed by the front-end, see claimSharedIdempotencyKey.
+var queuedHeaders = []string{"Content-Type", "TE", "X-Forwarded-For"}
+
+// runningClaimTTL bounds how long the idempotency key of a build is held
+// when the front-end relaying it stops before it finished
+const runningClaimTTL = time.Hour
+
+// Headers of the delivery request from a worker to the front-end
+const (
+	workerTokenHeader    = "X-Zs-Worker-Token"
+	deliveryStatusHeader = "X-Zs-Status"
+	deliveryHeaderHeader = "X-Zs-Header" // JSON of the archive response headers
+)
+
+// queuedJob is an archive request waiting for a worker
+type queuedJob struct {
+	ID       string              `json:"id"`
+	Callback string              `json:"callback"` // Where the worker delivers the archive
+	Method   string              `json:"method"`
+	URL      string              `json:"url"` // Path and query of the original request
+	Header   map[string][]string `json:"header"`
+	Body     []byte              `json:"body,omitempty"`
+	Client   string              `json:"client"`
+	Expires  time.Time           `json:"expires"` // Jobs picked up later are dropped, their client gave up
+}
+
+// delivery is an archive response arriving from a worker
+type delivery struct {
+	r    *http.Request
+	done chan struct{} // Closed by the front-end once the response was relayed
+}
+
+// pendingJob is a queued job whose client waits for a delivery
+type pendingJob struct {
+	deliveries chan *delivery
+	abandoned  chan struct{} // Closed when the client stopped waiting
+}
+
+var pendingJobs = struct {
+	sync.Mutex
+	jobs map[string]*pendingJob
+}{jobs: make(map[string]*pendingJob)}
+
+// queueHandler accepts archive requests on a front-end node. The request is
+// queued and the archive streamed by whichever worker picks it up is relayed
+// to the client.
+func queueHandler(queue *redisQueue) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, "Failed to read request body", http.StatusBadRequest)
+			return
+		}
+
+		jobID, release, ok := claimSharedIdempotencyKey(w, r, queue, body)
+		if !ok {
+			return
+		}
+		status, header := 0, http.Header{}
+		defer func() { release(status, header) }()
+
+		job := queuedJob{
+			ID:       jobID,
+			Callback: strings.TrimRight(config().advertiseURL, "/") + "/internal/deliver/",
+			Method:   r.Method,
+			URL:      r.URL.RequestURI(),
+			Header:   make(map[string][]string),
+			Body:     body,
+			Client:   r.RemoteAddr,
+			Expires:  time.Now().Add(config().queueWait),
+		}
+		job.Callback += job.ID
+		for _, name := range queuedHeaders {
+			if values := r.Header.Values(name); len(values) > 0 {
+				job.Header[name] = values
+			}
+		}
+		data, err := json.Marshal(&job)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		pending := &pendingJob{deliveries: make(chan *delivery), abandoned: make(chan struct{})}
+		pendingJobs.Lock()
+		pendingJobs.jobs[job.ID] = pending
+		pendingJobs.Unlock()
+		defer func() {
+			pendingJobs.Lock()
+			delete(pendingJobs.jobs, job.ID)
+			pendingJobs.Unlock()
+			close(pending.abandoned)
+		}()
+
+		if err := queue.push(data); err != nil {
+			fmt.Printf("Failed to queue job %s: %v\n", job.ID, err)
+			http.Error(w, "Failed to queue the archive", http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Printf("Queued job %s\n", job.ID)
+
+		timer := time.NewTimer(config().queueWait)
+		defer timer.Stop()
+		select {
+		case d := <-pending.deliveries:
+			defer close(d.done)
+			status, header = relayDelivery(w, d.r)
+		case <-timer.C:
+			http.Error(w, "No worker picked up the archive in time", http.StatusServiceUnavailable)
+		case <-r.Context().Done():
+		}
+	}
+}
+
+// relayDelivery copies a worker's archive response, including its trailers,
+// to the client. It returns the status and headers of the response.
+func relayDelivery(w http.ResponseWriter, r *http.Request) (int, http.Header) {
+	var header http.Header
+	if err := json.Unmarshal([]byte(r.Header.Get(deliveryHeaderHeader)), &header); err != nil {
+		http.Error(w, "Invalid delivery from worker", http.StatusBadGateway)
+		return http.StatusBadGateway, http.Header{}
+	}
+	for name, values := range header {
+		w.Header()[name] = values
+	}
+	status := http.StatusOK
+	fmt.Sscanf(r.Header.Get(deliveryStatusHeader), "%d", &status)
+	w.WriteHeader(status)
+
+	buf := make([]byte, 32*1024)
+	flusher, _ := w.(http.Flusher)
+	for {
+		n, err := r.Body.Read(buf)
+		if n > 0 {
+			if _, werr := w.Write(buf[:n]); werr != nil {
+				return status, header
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		if err != nil {
+			break
+		}
+	}
+	// Trailers are only known once the body was read
+	for name, values := range r.Trailer {
+		w.Header()[name] = values
+	}
+	return status, header
+}
+
+// sharedBuild is the build owning an idempotency key on a front-end. It is
+// kept in Redis, so that retries reaching any front-end find it.
+type sharedBuild struct {
+	JobID       string `json:"job"`
+	Fingerprint string `json:"fingerprint"`
+	Finished    bool   `json:"finished,omitempty"`
+	Status      string `json:"status,omitempty"` // Of a finished build that stored its archive in a target
+	URL         string `json:"url,omitempty"`    // Where it stored it
+}
+
+// claimSharedIdempotencyKey claims the idempotency key of a request queued
+// by a front-end in Redis, like claimIdempotencyKey does in memory, and
+// returns the ID of the job building the archive. release must be called with
+// the status and headers of the relayed response once the job is done. ok is
+// false when a response was already sent, such as the job of a build still
+// running for the key.
+func claimSharedIdempotencyKey(w http.ResponseWriter, r *http.Request, queue *redisQueue, body []byte) (jobID string, release func(int, http.Header), ok bool) {
+	key, ok := scopedIdempotencyKey(w, r)
+	if !ok {
+		return "", nil, false
+	}
+	if key == "" {
+		return newJobID(), func(int, http.Header) {}, true
+	}
+	hash := sha256.Sum256([]byte(key))
+	key = config().queueName + ":idempotency:" + hex.EncodeToString(hash[:])
+	build := &sharedBuild{JobID: newJobID(), Fingerprint: requestFingerprint(r, body)}
+	claimTTL := max(config().idempotencyTTL, runningClaimTTL)
+
+	data, _ := json.Marshal(build)
+	claimed, err := queue.claim(key, string(data), claimTTL)
+	if err == nil && !claimed {
+		var previous sharedBuild
+		var value []byte
+		if value, err = queue.get(key); err == nil && value != nil {
+			err = json.Unmarshal(value, &previous)
+		}
+		switch {
+		case err != nil:
+		case value == nil:
+			// The key expired since, so it can be claimed again
+			claimed, err = queue.claim(key, string(data), claimTTL)
+		case previous.Fingerprint != build.Fingerprint:
+			http.Error(w, "Idempotency key was already used for a different request", http.StatusUnprocessableEntity)
+			return "", nil, false
+		case !previous.Finished:
+			fmt.Printf("Job %s: retried with idempotency key while running\n", previous.JobID)
+			writeRunningJob(w, previous.JobID)
+			return "", nil, false
+		case previous.URL != "":
+			fmt.Printf("Job %s: retried with idempotency key after its archive was stored\n", previous.JobID)
+			writeStoredJob(w, &jobRecord{ID: previous.JobID, Status: previous.Status, URL: previous.URL})
+			return "", nil, false
+		default:
+			build.JobID = previous.JobID
+			fmt.Printf("Job %s: retried with idempotency key, building it again\n", build.JobID)
+			data, _ = json.Marshal(build)
+			claimed, err = true, queue.set(key, string(data), claimTTL)
+		}
+	}
+	if err != nil || !claimed {
+		fmt.Printf("Failed to claim idempotency key: %v\n", err)
+		http.Error(w, "Failed to claim the idempotency key", http.StatusServiceUnavailable)
+		return "", nil, false
+	}
+
+	release = func(status int, header http.Header) {
+		build.Finished = true
+		// The archive went only to a target when its location came with the response
+		if status == http.StatusOK && header.Get(targetURLTrailer) != "" {
+			build.Status, build.URL = header.Get(statusTrailer), header.Get(targetURLTrailer)
+		}
+		data, _ := json.Marshal(build)
+		var err error
+		if config().idempotencyTTL > 0 {
+			err = queue.set(key, string(data), config().idempotencyTTL)
+		} else {
+			err = queue.del(key)
+		}
+		if err != nil {
+			fmt.Printf("Job %s: failed to release idempotency key: %v\n", build.JobID, err)
+		}
+	}
+	return build.JobID, release, true
+}
+
+// deliverHandler receives the archive of a queued job from a worker and
+// hands it to the waiting client's request
+func deliverHandler(w http.ResponseWriter, r *http.Request) {
+	if subtle.ConstantTimeCompare([]byte(r.Header.Get(workerTokenHeader)), []byte(config().workerToken)) != 1 {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	pendingJobs.Lock()
+	pending := pendingJobs.jobs[mux.Vars(r)["id"]]
+	delete(pendingJobs.jobs, mux.Vars(r)["id"])
+	pendingJobs.Unlock()
+	if pending == nil {
+		http.Error(w, "No client is waiting for this job", http.StatusGone)
+		return
+	}
+
+	d := &delivery{r: r, done: make(chan struct{})}
+	select {
+	case pending.deliveries <- d:
+		<-d.done
+	case <-pending.abandoned:
+		http.Error(w, "No client is waiting for this job", http.StatusGone)
+	}
+}
+
+// startWorkers builds queued archives until the process exits
+func startWorkers(queueURL string) error {
+	for i := 0; i < config().workerConcurrency; i++ {
+		// BRPOP blocks its connection, so each worker gets its own
+		queue, err := newRedisQueue(queueURL, config().queueName)
+		if err != nil {
+			return err
+		}
+		go func() {
+			for {
+				data, err := queue.pop(30 * time.Second)
+				if err != nil {
+					fmt.Printf("Failed to read from queue: %v\n", err)
+					time.Sleep(time.Second)
+					continue
+				}
+				if data == nil {
+					continue
+				}
+				var job queuedJob
+				if err := json.Unmarshal(data, &job); err != nil {
+					fmt.Printf("Dropping invalid job: %v\n", err)
+					continue
+				}
+				if time.Now().After(job.Expires) {
+					fmt.Printf("Dropping expired job %s\n", job.ID)
+					continue
+				}
+				runQueuedJob(&job)
+			}
+		}()
+	}
+	fmt.Printf("Started %d workers on queue %s\n", config().workerConcurrency, config().queueName)
+	return nil
+}
+
+// runQueuedJob builds an archive with the regular handler, streaming the
+// response to the front-end as it is written
+func runQueuedJob(job *queuedJob) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// The archive keeps the ID the front-end logged when queuing it
+	ctx = context.WithValue(ctx, jobIDKey{}, job.ID)
+
+	r, err := http.NewRequestWithContext(ctx, job.Method, job.URL, strings.NewReader(string(job.Body)))
+	if err != nil {
+		fmt.Printf("Dropping job %s: %v\n", job.ID, err)
+		return
+	}
+	r.Header = job.Header
+	r.RemoteAddr = job.Client
+
+	fmt.Printf("Building queued job %s\n", job.ID)
+	dw := &deliveryWriter{job: job, header: make(http.Header), cancel: cancel}
+	if r.URL.Path == batchPath {
+		batchHandler(dw, r)
+	} else {
+		zipHandler(dw, r)
+	}
+	if err := dw.finish(); err != nil {
+		fmt.Printf("Failed to deliver job %s: %v\n", job.ID, err)
+	}
+}
+
+// deliveryWriter is the response writer of a queued job. The first write
+// starts a streaming POST to the front-end carrying the status and headers.
+type deliveryWriter struct {
+	job    *queuedJob
+	header http.Header
+	cancel context.CancelFunc // Stops the build when the delivery fails
+
+	pipe    *io.PipeWriter
+	trailer http.Header
+	result  chan error
+}
+
+func (d *deliveryWriter) Header() http.Header {
+	return d.header
+}
+
+func (d *deliveryWriter) WriteHeader(status int) {
+	if d.pipe != nil {
+		return
+	}
+
+	var body *io.PipeReader
+	body, d.pipe = io.Pipe()
+	req, err := http.NewRequest("POST", d.job.Callback, body)
+	if err != nil {
+		d.pipe.CloseWithError(err)
+		return
+	}
+	headers, _ := json.Marshal(d.header)
+	req.Header.Set(workerTokenHeader, config().workerToken)
+	req.Header.Set(deliveryStatusHeader, fmt.Sprintf("%d", status))
+	req.Header.Set(deliveryHeaderHeader, string(headers))
+
+	// Declared trailers are sent after the body
+	d.trailer = make(http.Header)
+	for _, names := range d.header.Values("Trailer") {
+		for _, name := range strings.Split(names, ",") {
+			d.trailer[http.CanonicalHeaderKey(strings.TrimSpace(name))] = nil
+		}
+	}
+	req.Trailer = d.trailer
+
+	d.result = make(chan error, 1)
+	go func() {
+		resp, err := http.DefaultClient.Do(req)
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				err = fmt.Errorf("front-end returned %s", resp.Status)
+			}
+		}
+		if err != nil {
+			body.CloseWithError(err)
+			d.cancel()
+		}
+		d.result <- err
+	}()
+}
+
+func (d *deliveryWriter) Write(p []byte) (int, error) {
+	d.WriteHeader(http.StatusOK)
+	return d.pipe.Write(p)
+}
+
+// Flush is a no-op; the pipe hands every write straight to the transport
+func (d *deliveryWriter) Flush() {}
+
+// finish sends the trailers and waits for the front-end to accept the archive
+func (d *deliveryWriter) finish() error {
+	d.WriteHeader(http.StatusOK)
+	for name := range d.trailer {
+		d.trailer[name] = d.header.Values(name)
+	}
+	d.pipe.Close()
+	return <-d.result
+}
+
+// startDistributed sets up the front-end or worker mode on the main router
+func startDistributed(r *mux.Router) error {
+	// Indexes are predicted without building the archive, so any mode serves them
+	r.HandleFunc(indexPath, indexHandler).Methods("POST")
+	if config().mode == "" {
+		r.HandleFunc("/create-zip", zipHandler).Methods("GET", "POST")
+		r.HandleFunc(batchPath, batchHandler).Methods("POST")
+		return nil
+	}
+	if config().queueURL == "" || config().workerToken == "" {
+		return fmt.Errorf("%s mode requires %s and %s", config().mode, QueueURLEnvVar, WorkerTokenEnvVar)
+	}
+
+	switch config().mode {
+	case modeFrontend:
+		if config().advertiseURL == "" {
+			return fmt.Errorf("frontend mode requires %s", AdvertiseURLEnvVar)
+		}
+		queue, err := newRedisQueue(config().queueURL, config().queueName)
+		if err != nil {
+			return err
+		}
+		r.HandleFunc("/create-zip", queueHandler(queue)).Methods("GET", "POST")
+		r.HandleFunc(batchPath, queueHandler(queue)).Methods("POST")
+		r.HandleFunc("/internal/deliver/{id}", deliverHandler).Methods("POST")
+		return nil
+	case modeWorker:
+		// Workers also serve direct requests, e.g. from a load balancer
+		r.HandleFunc("/create-zip", zipHandler).Methods("GET", "POST")
+		r.HandleFunc(batchPath, batchHandler).Methods("POST")
+		return startWorkers(config().queueURL)
+	}
+	return fmt.Errorf("unknown mode: %q", config().mode)
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"gozipstreamer/zipstreamer"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// Name of the report added to archives when files were left out
+const skippedManifestName = "SKIPPED.txt"
+
+// maxArchiveNameLength bounds default archive names joined from many roots, in characters
+const maxArchiveNameLength = 100
+
+// skippedEntry records a file that was left out of the archive and why
+type skippedEntry struct {
+	zipPath string
+	reason  string
+}
+
+// entrySet collects the entries of an archive along with the files left out of it
+type entrySet struct {
+	files    []*zipstreamer.FileEntry
+	skipped  []skippedEntry
+	source   string       // Summary of what was requested, for the archive comment
+	client   *http.Client // Client of the provider whose files are being added; nil uses the default
+	provider string       // Name of the provider whose files are being added, for sidecars
+	roots    []string     // Names of the requested folders and files, for the default archive name
+}
+
+func (s *entrySet) add(entry *zipstreamer.FileEntry) {
+	if s.client != nil {
+		entry.SetClient(s.client)
+	}
+	s.files = append(s.files, entry)
+}
+
+// prefix moves the files added and skipped since the given counts into a folder
+func (s *entrySet) prefix(files, skipped int, folder string) error {
+	if folder == "" {
+		return nil
+	}
+	prefixed, err := zipstreamer.PrefixZipPaths(s.files[files:], folder)
+	if err != nil {
+		return err
+	}
+	s.files = append(s.files[:files], prefixed...)
+	for i := skipped; i < len(s.skipped); i++ {
+		s.skipped[i].zipPath = path.Join(folder, s.skipped[i].zipPath)
+	}
+	return nil
+}
+
+// archiveName returns the default filename of the archive, named after the
+// requested roots and joined when there are several, or "" without roots
+func (s *entrySet) archiveName() string {
+	names := make([]string, 0, len(s.roots))
+	seen := make(map[string]bool)
+	for _, root := range s.roots {
+		name := sanitizeArchiveName(root)
+		if name != "" && !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	name := []rune(strings.Join(names, "_"))
+	if len(name) > maxArchiveNameLength {
+		name = name[:maxArchiveNameLength]
+	}
+	if len(name) == 0 {
+		return ""
+	}
+	return strings.TrimRight(string(name), " ._") + ".zip"
+}
+
+// sanitizeArchiveName replaces the characters of a root name that can't be
+// part of a filename on common systems
+func sanitizeArchiveName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		switch {
+		case r < 32 || r == 127:
+			return -1
+		case strings.ContainsRune(`<>:"/\|?*`, r):
+			return '_'
+		}
+		return r
+	}, name)
+	return strings.Trim(name, " .")
+}
+
+// skip records a file that will not be part of the archive
+func (s *entrySet) skip(zipPath, reason string) {
+	fmt.Printf("Skipping %s: %s\n", zipPath, reason)
+	s.skipped = append(s.skipped, skippedEntry{zipPath: zipPath, reason: reason})
+}
+
+// skippedManifest builds the report entry listing skipped files, or nil if none were skipped
+func (s *entrySet) skippedManifest() *zipstreamer.FileEntry {
+	if len(s.skipped) == 0 {
+		return nil
+	}
+
+	var manifest strings.Builder
+	fmt.Fprintf(&manifest, "The following %d files were not included in this archive:\n\n", len(s.skipped))
+	for _, skipped := range s.skipped {
+		fmt.Fprintf(&manifest, "%s: %s\n", skipped.zipPath, skipped.reason)
+	}
+
+	entry, err := zipstreamer.NewInlineFileEntry(skippedManifestName, []byte(manifest.String()))
+	if err != nil {
+		return nil
+	}
+	return entry
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+)
+
+// descriptorField is the form field carrying the descriptor of form posts
+const descriptorField = "descriptor"
+
+// maxFormMemory is how much of a multipart form is held in memory; larger
+// uploads are spooled to temporary files
+const maxFormMemory = 10 << 20
+
+// isFormPost reports whether a request was submitted by a plain HTML form.
+// JSON bodies sent with curl's default form content type are not forms.
+func isFormPost(r *http.Request, payload []byte) bool {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case "multipart/form-data":
+		return true
+	case "application/x-www-form-urlencoded":
+		trimmed := bytes.TrimSpace(payload)
+		return len(trimmed) == 0 || (trimmed[0] != '{' && trimmed[0] != '[')
+	}
+	return false
+}
+
+// formDescriptor reads the descriptor of a form post with the given body,
+// typed into the descriptor field or uploaded as a file. The other fields are
+// archive options like the query's, which take precedence over them.
+func formDescriptor(r *http.Request, body []byte) ([]byte, error) {
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	if err := r.ParseMultipartForm(maxFormMemory); err != nil && !errors.Is(err, http.ErrNotMultipart) {
+		return nil, err
+	}
+	var payload []byte
+	if file, _, err := r.FormFile(descriptorField); err == nil {
+		defer file.Close()
+		if payload, err = io.ReadAll(file); err != nil {
+			return nil, err
+		}
+	} else {
+		payload = []byte(r.PostFormValue(descriptorField))
+	}
+	if len(bytes.TrimSpace(payload)) == 0 {
+		return nil, errors.New("missing descriptor field")
+	}
+
+	query := r.URL.Query()
+	for name, values := range r.PostForm {
+		if name != descriptorField && !query.Has(name) {
+			query[name] = values
+		}
+	}
+	r.URL.RawQuery = query.Encode()
+	// The request goes on as a JSON post, which is how a job resumes it
+	r.Header.Set("Content-Type", "application/json")
+	return payload, nil
+}
@@ -0,0 +1,523 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"gozipstreamer/provider"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// gcsChunkAlign is the granularity of resumable upload chunks, except for the last one
+const gcsChunkAlign = 256 * 1024
+
+// gcsScope lets the token write objects and nothing else
+const gcsScope = "https://www.googleapis.com/auth/devstorage.read_write"
+
+// gcsConfig is the bucket that archives are uploaded to
+type gcsConfig struct {
+	endpoint    string // https://storage.googleapis.com or an emulator
+	bucket      string
+	prefix      string // Prepended to the object names
+	credentials string // Service account key file; empty uses the metadata server
+	accessToken string // Fixed access token, e.g. for an emulator; overrides credentials
+	chunkSize   int64
+}
+
+// gcsMaxComponents is the most objects a composite object can be made of
+const gcsMaxComponents = 1024
+
+// gcsTarget uploads an archive in chunks of a resumable upload. One chunk is
+// uploaded in the background while the next is filled.
+//
+// An upload can't be rewound to a checkpoint, so checkpointed archives are
+// uploaded in segments instead: each span between checkpoints is its own
+// object, composed at the checkpoint into a partial object that ends there.
+// The archive is composed from the partial object and the last segment.
+type gcsTarget struct {
+	cfg     *gcsConfig
+	tokens  provider.TokenSource
+	name    string
+	session string // Upload session URI
+
+	segments   bool // Uploaded in segments, for checkpoints
+	segment    int  // Number of the segment being uploaded
+	components int  // Segments composed into the partial object
+
+	buf    bytes.Buffer
+	chunks chan gcsChunk
+	done   chan struct{} // Closed once the queued chunks are uploaded
+	closed bool          // No more chunks are queued
+
+	mu  sync.Mutex
+	err error // First upload failure
+}
+
+// gcsChunk is a chunk queued for upload. The last one declares the object's
+// total size, which completes the upload.
+type gcsChunk struct {
+	data []byte
+	last bool
+}
+
+// openGCSTarget starts a resumable upload for the archive of a job
+func openGCSTarget(cfg *gcsConfig, tokens provider.TokenSource) targetOpener {
+	return func(jobID, filename string) (archiveTarget, error) {
+		t := &gcsTarget{cfg: cfg, tokens: tokens, name: cfg.prefix + jobID + "/" + targetFilename(filename)}
+		if err := t.start(t.name); err != nil {
+			return nil, err
+		}
+		return t, nil
+	}
+}
+
+// gcsCheckpoint is the resume state of an archive uploaded in segments
+type gcsCheckpoint struct {
+	Name       string `json:"name"`
+	Segment    int    `json:"segment"` // Number of the next segment
+	Components int    `json:"components"`
+}
+
+// resumeGCSTarget uploads the archive of a job in segments, continuing after
+// the partial object of a checkpoint when there is one
+func resumeGCSTarget(cfg *gcsConfig, tokens provider.TokenSource) targetResumer {
+	return func(jobID, filename string, state json.RawMessage, offset int64) (resumableTarget, error) {
+		t := &gcsTarget{cfg: cfg, tokens: tokens, name: cfg.prefix + jobID + "/" + targetFilename(filename), segments: true}
+		if state != nil {
+			var checkpoint gcsCheckpoint
+			if err := json.Unmarshal(state, &checkpoint); err != nil {
+				return nil, err
+			}
+			t.name, t.segment, t.components = checkpoint.Name, checkpoint.Segment, checkpoint.Components
+			// A compose after the checkpoint was recorded would have grown the partial object
+			size, err := t.objectSize(t.partialName())
+			if err != nil {
+				return nil, fmt.Errorf("failed to find partial archive: %v", err)
+			}
+			if size != offset {
+				return nil, fmt.Errorf("partial archive has %d bytes, the checkpoint %d", size, offset)
+			}
+			// The segment may have been completed before the server stopped
+			t.deleteObject(t.segmentName())
+		}
+		if err := t.start(t.segmentName()); err != nil {
+			return nil, err
+		}
+		return t, nil
+	}
+}
+
+// start begins the resumable upload of object and uploads its chunks as they are queued
+func (t *gcsTarget) start(object string) error {
+	start := strings.TrimRight(t.cfg.endpoint, "/") + "/upload/storage/v1/b/" + url.PathEscape(t.cfg.bucket) +
+		"/o?" + url.Values{"uploadType": {"resumable"}, "name": {object}}.Encode()
+	body := []byte(`{"contentType":"application/zip"}`)
+	resp, err := t.do("POST", start, body, map[string]string{"Content-Type": "application/json", "X-Upload-Content-Type": "application/zip"})
+	if err != nil {
+		return fmt.Errorf("failed to start upload: %v", err)
+	}
+	resp.Body.Close()
+	if t.session = resp.Header.Get("Location"); t.session == "" {
+		return errors.New("failed to start upload: no session URI")
+	}
+
+	t.chunks = make(chan gcsChunk, 1)
+	t.done = make(chan struct{})
+	t.closed = false
+	go t.uploadChunks()
+	return nil
+}
+
+func (t *gcsTarget) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	err := t.err
+	t.mu.Unlock()
+	if err != nil {
+		return 0, err
+	}
+
+	t.buf.Write(p)
+	for int64(t.buf.Len()) >= t.cfg.chunkSize {
+		t.chunks <- gcsChunk{data: bytes.Clone(t.buf.Next(int(t.cfg.chunkSize)))}
+	}
+	return len(p), nil
+}
+
+// uploadChunks uploads the queued chunks in order
+func (t *gcsTarget) uploadChunks() {
+	defer close(t.done)
+	var offset int64
+	for chunk := range t.chunks {
+		t.mu.Lock()
+		failed := t.err != nil
+		t.mu.Unlock()
+		if failed {
+			continue
+		}
+
+		total := "*"
+		if chunk.last {
+			total = fmt.Sprint(offset + int64(len(chunk.data)))
+		}
+		contentRange := fmt.Sprintf("bytes %d-%d/%s", offset, offset+int64(len(chunk.data))-1, total)
+		if len(chunk.data) == 0 {
+			contentRange = "bytes */" + total
+		}
+		resp, err := t.do("PUT", t.session, chunk.data, map[string]string{"Content-Range": contentRange})
+		if err == nil {
+			resp.Body.Close()
+		}
+		t.mu.Lock()
+		if err != nil {
+			t.err = fmt.Errorf("failed to upload chunk at %d: %v", offset, err)
+		}
+		t.mu.Unlock()
+		offset += int64(len(chunk.data))
+	}
+}
+
+// finishUpload uploads the buffer as the last chunk, which completes the
+// object, and waits for the upload
+func (t *gcsTarget) finishUpload() error {
+	t.closed = true
+	t.chunks <- gcsChunk{data: bytes.Clone(t.buf.Bytes()), last: true}
+	t.buf.Reset()
+	close(t.chunks)
+	<-t.done
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.err
+}
+
+// checkpoint completes the segment, composes it into the partial object and
+// starts the next one. The composite must leave room for the last segment,
+// so there are no more checkpoints once it nearly has the most components.
+func (t *gcsTarget) checkpoint() (json.RawMessage, error) {
+	if !t.segments || t.components+2 > gcsMaxComponents {
+		return nil, nil
+	}
+	if err := t.finishUpload(); err != nil {
+		return nil, err
+	}
+	if _, err := t.compose(t.partialName()); err != nil {
+		return nil, fmt.Errorf("failed to compose partial archive: %v", err)
+	}
+	t.deleteObject(t.segmentName())
+	t.components++
+	t.segment++
+	if err := t.start(t.segmentName()); err != nil {
+		return nil, err
+	}
+	return json.Marshal(gcsCheckpoint{Name: t.name, Segment: t.segment, Components: t.components})
+}
+
+// commit uploads the last chunk, which completes the upload, and composes
+// the archive of segments from them
+func (t *gcsTarget) commit() (string, error) {
+	if err := t.finishUpload(); err != nil {
+		return "", err
+	}
+	if t.segments {
+		if _, err := t.compose(t.name); err != nil {
+			return "", fmt.Errorf("failed to compose archive: %v", err)
+		}
+		t.deleteObject(t.segmentName())
+		if t.components > 0 {
+			t.deleteObject(t.partialName())
+		}
+	}
+	return strings.TrimRight(t.cfg.endpoint, "/") + "/" + url.PathEscape(t.cfg.bucket) + "/" + s3Escape(t.name), nil
+}
+
+// abort cancels the upload session, discarding the uploaded chunks, and
+// removes the objects of segments
+func (t *gcsTarget) abort() {
+	if !t.closed {
+		t.closed = true
+		close(t.chunks)
+	}
+	<-t.done
+	// A cancelled session answers with status 499
+	req, err := http.NewRequest("DELETE", t.session, nil)
+	if err != nil {
+		return
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		fmt.Printf("Failed to cancel upload of %s: %v\n", t.name, err)
+	} else {
+		resp.Body.Close()
+	}
+	if t.segments {
+		t.deleteObject(t.segmentName())
+		t.deleteObject(t.partialName())
+	}
+}
+
+// segmentName is the object of the segment being uploaded
+func (t *gcsTarget) segmentName() string {
+	return fmt.Sprintf("%s.segment-%d", t.name, t.segment)
+}
+
+// partialName is the object composed of the segments up to the last checkpoint
+func (t *gcsTarget) partialName() string {
+	return t.name + ".partial"
+}
+
+// objectURL returns the JSON API URL of an object in the bucket
+func (t *gcsTarget) objectURL(object string) string {
+	return strings.TrimRight(t.cfg.endpoint, "/") + "/storage/v1/b/" + url.PathEscape(t.cfg.bucket) + "/o/" + url.PathEscape(object)
+}
+
+// compose appends the current segment to the partial object, if any, as the
+// object destination and returns its size
+func (t *gcsTarget) compose(destination string) (int64, error) {
+	type source struct {
+		Name string `json:"name"`
+	}
+	var request struct {
+		SourceObjects []source          `json:"sourceObjects"`
+		Destination   map[string]string `json:"destination"`
+	}
+	if t.components > 0 {
+		request.SourceObjects = append(request.SourceObjects, source{Name: t.partialName()})
+	}
+	request.SourceObjects = append(request.SourceObjects, source{Name: t.segmentName()})
+	request.Destination = map[string]string{"contentType": "application/zip"}
+	body, err := json.Marshal(&request)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := t.do("POST", t.objectURL(destination)+"/compose", body, map[string]string{"Content-Type": "application/json"})
+	if err != nil {
+		return 0, err
+	}
+	return decodeObjectSize(resp)
+}
+
+// objectSize returns the size of an object
+func (t *gcsTarget) objectSize(object string) (int64, error) {
+	resp, err := t.do("GET", t.objectURL(object), nil, nil)
+	if err != nil {
+		return 0, err
+	}
+	return decodeObjectSize(resp)
+}
+
+// deleteObject removes an object that may not exist
+func (t *gcsTarget) deleteObject(object string) {
+	resp, err := t.do("DELETE", t.objectURL(object), nil, nil)
+	var gcsErr *gcsError
+	if errors.As(err, &gcsErr) && gcsErr.code == http.StatusNotFound {
+		return
+	}
+	if err != nil {
+		fmt.Printf("Failed to delete %s: %v\n", object, err)
+		return
+	}
+	resp.Body.Close()
+}
+
+// decodeObjectSize reads the size from an object resource
+func decodeObjectSize(resp *http.Response) (int64, error) {
+	defer resp.Body.Close()
+	var object struct {
+		Size int64 `json:"size,string"`
+	}
+	err := json.NewDecoder(resp.Body).Decode(&object)
+	return object.Size, err
+}
+
+// do sends an authorized request, failing on error statuses. Status 308
+// acknowledges an intermediate chunk. The body of successful responses is
+// left to the caller.
+func (t *gcsTarget) do(method, target string, body []byte, headers map[string]string) (*http.Response, error) {
+	req, err := http.NewRequest(method, target, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	token, err := t.tokens.Token()
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 && resp.StatusCode != http.StatusPermanentRedirect {
+		defer resp.Body.Close()
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, &gcsError{status: resp.Status, code: resp.StatusCode, message: strings.TrimSpace(string(message))}
+	}
+	return resp, nil
+}
+
+// gcsError is an error status returned by GCS
+type gcsError struct {
+	status  string
+	code    int
+	message string
+}
+
+func (e *gcsError) Error() string {
+	return fmt.Sprintf("GCS returned %s: %s", e.status, e.message)
+}
+
+// validate checks that the configuration can be used for uploads
+func (c *gcsConfig) validate() error {
+	if c.chunkSize <= 0 || c.chunkSize%gcsChunkAlign != 0 {
+		return fmt.Errorf("GCS chunk size must be a positive multiple of %d bytes", gcsChunkAlign)
+	}
+	if _, err := url.Parse(c.endpoint); err != nil {
+		return fmt.Errorf("invalid GCS endpoint: %v", err)
+	}
+	return nil
+}
+
+// tokenSource returns the source of access tokens for the bucket: the fixed
+// token, the service account key, or else the metadata server of the instance
+func (c *gcsConfig) tokenSource() (provider.TokenSource, error) {
+	if c.accessToken != "" {
+		return provider.StaticToken(c.accessToken), nil
+	}
+	if c.credentials == "" {
+		return &googleTokenSource{fetch: metadataToken}, nil
+	}
+
+	data, err := os.ReadFile(c.credentials)
+	if err != nil {
+		return nil, err
+	}
+	var key serviceAccountKey
+	if err := json.Unmarshal(data, &key); err != nil {
+		return nil, fmt.Errorf("invalid service account key: %v", err)
+	}
+	block, _ := pem.Decode([]byte(key.PrivateKey))
+	if block == nil {
+		return nil, errors.New("invalid service account key: no private key")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid service account key: %v", err)
+	}
+	rsaKey, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("invalid service account key: not an RSA key")
+	}
+	if key.TokenURI == "" {
+		key.TokenURI = "https://oauth2.googleapis.com/token"
+	}
+	return &googleTokenSource{fetch: func() (tokenGrant, error) { return key.token(rsaKey) }}, nil
+}
+
+// tokenGrant is the token response of Google's token endpoint and metadata server
+type tokenGrant struct {
+	AccessToken string `json:"access_token"`
+	ExpiresIn   int64  `json:"expires_in"`
+}
+
+// googleTokenSource caches the tokens of fetch until shortly before they expire
+type googleTokenSource struct {
+	fetch func() (tokenGrant, error)
+
+	mu     sync.Mutex
+	token  string
+	expiry time.Time
+}
+
+func (s *googleTokenSource) Token() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.token != "" && time.Until(s.expiry) > time.Minute {
+		return s.token, nil
+	}
+	grant, err := s.fetch()
+	if err != nil {
+		return "", fmt.Errorf("failed to get access token: %v", err)
+	}
+	if grant.AccessToken == "" {
+		return "", errors.New("failed to get access token: empty token")
+	}
+	s.token, s.expiry = grant.AccessToken, time.Now().Add(time.Duration(grant.ExpiresIn)*time.Second)
+	return s.token, nil
+}
+
+// metadataToken requests a token for the instance's service account
+func metadataToken() (tokenGrant, error) {
+	req, err := http.NewRequest("GET", "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token", nil)
+	if err != nil {
+		return tokenGrant{}, err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	return decodeTokenGrant(http.DefaultClient.Do(req))
+}
+
+// serviceAccountKey holds the fields of a service account key file used for tokens
+type serviceAccountKey struct {
+	ClientEmail string `json:"client_email"`
+	PrivateKey  string `json:"private_key"`
+	TokenURI    string `json:"token_uri"`
+}
+
+// token exchanges a signed JWT assertion for an access token
+func (k *serviceAccountKey) token(key *rsa.PrivateKey) (tokenGrant, error) {
+	now := time.Now()
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"RS256","typ":"JWT"}`))
+	claims, err := json.Marshal(map[string]interface{}{
+		"iss":   k.ClientEmail,
+		"scope": gcsScope,
+		"aud":   k.TokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	if err != nil {
+		return tokenGrant{}, err
+	}
+	unsigned := header + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		return tokenGrant{}, err
+	}
+
+	form := url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {unsigned + "." + base64.RawURLEncoding.EncodeToString(signature)},
+	}
+	return decodeTokenGrant(http.PostForm(k.TokenURI, form))
+}
+
+// decodeTokenGrant reads a token response
+func decodeTokenGrant(resp *http.Response, err error) (tokenGrant, error) {
+	if err != nil {
+		return tokenGrant{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return tokenGrant{}, fmt.Errorf("token request returned %s: %s", resp.Status, strings.TrimSpace(string(message)))
+	}
+	var grant tokenGrant
+	err = json.NewDecoder(resp.Body).Decode(&grant)
+	return grant, err
+}
@@ -3,3 +3,18 @@ module gozipstreamer
 go 1.23.3
 
 require github.com/gorilla/mux v1.8.1
+
+require golang.org/x/text v0.21.0
+
+require (
+	github.com/klauspost/compress v1.17.11
+	go.etcd.io/bbolt v1.3.11
+	golang.org/x/sys v0.29.0
+	google.golang.org/grpc v1.71.1
+	google.golang.org/protobuf v1.36.4
+)
+
+require (
+	golang.org/x/net v0.34.0 // indirect
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20250115164207-1a7da9e5054f // indirect
+)
@@ -0,0 +1,342 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gozipstreamer/grpcapi"
+	"gozipstreamer/zipstreamer"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// grpcServer serves the archive job API, nil when it is disabled
+var grpcServer *grpc.Server
+
+// archiveJobsServer builds archives submitted over gRPC into their target in
+// the background, like asynchronous batches, and serves their jobs from the
+// job history
+type archiveJobsServer struct {
+	grpcapi.UnimplementedArchiveJobsServer
+}
+
+// SubmitArchive validates a descriptor like a POST to /create-zip and queues
+// its archive
+func (s *archiveJobsServer) SubmitArchive(ctx context.Context, req *grpcapi.SubmitArchiveRequest) (*grpcapi.Job, error) {
+	if jobHistory == nil {
+		return nil, status.Errorf(codes.FailedPrecondition, "archive jobs require %s", JobStoreEnvVar)
+	}
+	payload, err := descriptorPayload(req.GetZipDescriptor())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err := validateBody(payload, "Descriptor", "Invalid descriptor"); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	descriptor, err := zipstreamer.UnmarshalJsonZipDescriptor(payload)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "Invalid descriptor: "+err.Error())
+	}
+
+	query := make(url.Values)
+	for name, value := range req.GetOptions() {
+		query.Set(name, value)
+	}
+	// The archive outlives the call that submitted it
+	r, err := http.NewRequestWithContext(context.WithoutCancel(ctx), http.MethodPost, "/create-zip?"+query.Encode(), nil)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if client, ok := peer.FromContext(ctx); ok {
+		r.RemoteAddr = client.Addr.String()
+	}
+	options, err := descriptorOptions(r, descriptor)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if options.target == "" || options.tee {
+		return nil, status.Error(codes.InvalidArgument, "archive jobs require a target without tee")
+	}
+
+	archive := &batchArchive{jobID: newJobID(), descriptor: descriptor, options: options, raw: payload}
+	// Entries are counted once provider sources are listed and remote zips
+	// expanded, when the archive starts like over HTTP
+	job := &jobRecord{
+		ID:       archive.jobID,
+		Status:   jobQueued,
+		Source:   descriptorSource(descriptor),
+		Filename: options.filename,
+		Created:  time.Now().UTC(),
+	}
+	// Resumes the job if the server stops before it is built
+	if config().jobKey != nil {
+		if job.Resume, err = (&jobResume{Request: archive.request(r)}).seal(); err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+	}
+	jobHistory.put(job)
+	fmt.Printf("Job %s: queued over gRPC\n", job.ID)
+
+	go func() {
+		result := buildBatchArchive(&batchPart{header: make(http.Header)}, r, archive)
+		fmt.Printf("Job %s: finished as %s\n", result.JobID, result.Status)
+		// Archives refused before streaming leave the queued job behind
+		if stored, err := jobHistory.get(job.ID); err == nil && stored != nil && stored.Status == jobQueued {
+			stored.Status = result.Status
+			stored.Error = result.Error
+			stored.Resume = nil
+			jobHistory.put(stored)
+		}
+	}()
+	return jobMessage(job), nil
+}
+
+// GetJob returns the current state of a job
+func (s *archiveJobsServer) GetJob(ctx context.Context, req *grpcapi.GetJobRequest) (*grpcapi.Job, error) {
+	job, err := lookupJob(req.GetId())
+	if err != nil {
+		return nil, err
+	}
+	return jobMessage(job), nil
+}
+
+// WatchJob sends the state of a job each time it is saved, until it finished
+// or the client went away
+func (s *archiveJobsServer) WatchJob(req *grpcapi.GetJobRequest, stream grpc.ServerStreamingServer[grpcapi.Job]) error {
+	ticker := time.NewTicker(jobProgressInterval / 2)
+	defer ticker.Stop()
+	var last time.Time
+	for {
+		job, err := lookupJob(req.GetId())
+		if err != nil {
+			return err
+		}
+		if !job.Updated.Equal(last) {
+			last = job.Updated
+			if err := stream.Send(jobMessage(job)); err != nil {
+				return err
+			}
+		}
+		if job.Status != jobQueued && job.Status != jobRunning {
+			return nil
+		}
+		select {
+		case <-ticker.C:
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		}
+	}
+}
+
+// lookupJob reads a job from the history as a gRPC error when it fails
+func lookupJob(id string) (*jobRecord, error) {
+	if jobHistory == nil {
+		return nil, status.Errorf(codes.FailedPrecondition, "archive jobs require %s", JobStoreEnvVar)
+	}
+	job, err := jobHistory.get(id)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if job == nil {
+		return nil, status.Error(codes.NotFound, "Job not found")
+	}
+	return job, nil
+}
+
+// jobMessage converts a job record to its gRPC message
+func jobMessage(job *jobRecord) *grpcapi.Job {
+	return &grpcapi.Job{
+		Id:          job.ID,
+		Status:      job.Status,
+		Filename:    job.Filename,
+		Entries:     int32(job.Entries),
+		EntriesDone: int32(job.Done),
+		Failed:      int32(job.Failed),
+		Bytes:       job.Bytes,
+		Error:       job.Error,
+		Url:         job.URL,
+		Created:     timestamppb.New(job.Created),
+		Updated:     timestamppb.New(job.Updated),
+	}
+}
+
+// descriptorPayload writes a gRPC descriptor as a JSON zip descriptor, so it
+// is validated and parsed exactly like one posted to /create-zip
+func descriptorPayload(descriptor *grpcapi.Descriptor) ([]byte, error) {
+	if descriptor == nil {
+		return nil, errors.New("descriptor is required")
+	}
+	return json.Marshal(descriptorBody(descriptor))
+}
+
+// descriptorBody returns the JSON fields of a descriptor, and of those merged
+// into it
+func descriptorBody(descriptor *grpcapi.Descriptor) map[string]interface{} {
+	files := make([]map[string]interface{}, 0, len(descriptor.GetFiles()))
+	for _, entry := range descriptor.GetFiles() {
+		files = append(files, entryBody(entry))
+	}
+
+	body := map[string]interface{}{"files": files, "suggestedFilename": descriptor.GetSuggestedFilename()}
+	for name, value := range map[string]string{
+		"compression": descriptor.GetCompression(),
+		"prefix":      descriptor.GetPrefix(),
+		"duplicates":  descriptor.GetDuplicates(),
+	} {
+		if value != "" {
+			body[name] = value
+		}
+	}
+	if len(descriptor.GetCredentials()) > 0 {
+		body["credentials"] = descriptor.GetCredentials()
+	}
+	if len(descriptor.GetDescriptors()) > 0 {
+		parts := make([]map[string]interface{}, 0, len(descriptor.GetDescriptors()))
+		for _, part := range descriptor.GetDescriptors() {
+			parts = append(parts, descriptorBody(part))
+		}
+		body["descriptors"] = parts
+	}
+	return body
+}
+
+// entryBody returns the JSON fields of a descriptor entry
+func entryBody(entry *grpcapi.Entry) map[string]interface{} {
+	file := map[string]interface{}{"zipPath": entry.GetZipPath()}
+	for name, value := range map[string]string{
+		"url":      entry.GetUrl(),
+		"crc32":    entry.GetCrc32(),
+		"md5":      entry.GetMd5(),
+		"provider": entry.GetProvider(),
+		"path":     entry.GetPath(),
+		"id":       entry.GetId(),
+		"username": entry.GetUsername(),
+		"password": entry.GetPassword(),
+	} {
+		if value != "" {
+			file[name] = value
+		}
+	}
+	for name, value := range map[string]*timestamppb.Timestamp{
+		"modified": entry.GetModified(),
+		"accessed": entry.GetAccessed(),
+		"created":  entry.GetCreated(),
+	} {
+		if value != nil {
+			file[name] = value.AsTime()
+		}
+	}
+	if entry.Size != nil {
+		file["size"] = entry.GetSize()
+	}
+	if entry.CompressedSize != nil {
+		file["compressedSize"] = entry.GetCompressedSize()
+	}
+	if entry.Uid != nil {
+		file["uid"] = entry.GetUid()
+	}
+	if entry.Gid != nil {
+		file["gid"] = entry.GetGid()
+	}
+	if entry.GetMethod() != 0 {
+		file["method"] = entry.GetMethod()
+	}
+	if len(entry.GetCookies()) > 0 {
+		file["cookies"] = entry.GetCookies()
+	}
+	if entry.GetNested() {
+		file["nested"] = true
+	}
+	if entry.GetExpand() {
+		file["expand"] = true
+	}
+	return file
+}
+
+// authorizeGRPC checks the bearer token of a call. Without a token the API
+// only starts when it was explicitly opened to any caller.
+func authorizeGRPC(ctx context.Context) error {
+	if config().grpcToken == "" && config().grpcInsecure {
+		return nil
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	var token string
+	if values := md.Get("authorization"); len(values) > 0 {
+		token = strings.TrimPrefix(values[0], "Bearer ")
+	}
+	if subtle.ConstantTimeCompare([]byte(token), []byte(config().grpcToken)) != 1 {
+		return status.Error(codes.Unauthenticated, "Unauthorized")
+	}
+	return nil
+}
+
+// startGRPC serves the archive job API on its own listener when a gRPC
+// address is configured. Archives are built in this process, so front-ends
+// that only queue requests for workers can't serve it.
+func startGRPC() error {
+	if config().grpcAddr == "" {
+		return nil
+	}
+	if config().mode == modeFrontend {
+		return fmt.Errorf("%s is not supported in frontend mode", GRPCAddrEnvVar)
+	}
+	if config().grpcToken == "" && !config().grpcInsecure {
+		return fmt.Errorf("%s requires %s, or %s=true to serve any caller", GRPCAddrEnvVar, GRPCTokenEnvVar, GRPCInsecureEnvVar)
+	}
+	listener, err := net.Listen("tcp", config().grpcAddr)
+	if err != nil {
+		return err
+	}
+
+	grpcServer = grpc.NewServer(
+		grpc.UnaryInterceptor(func(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+			if err := authorizeGRPC(ctx); err != nil {
+				return nil, err
+			}
+			return handler(ctx, req)
+		}),
+		grpc.StreamInterceptor(func(srv any, stream grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			if err := authorizeGRPC(stream.Context()); err != nil {
+				return err
+			}
+			return handler(srv, stream)
+		}),
+	)
+	grpcapi.RegisterArchiveJobsServer(grpcServer, &archiveJobsServer{})
+	go func() {
+		fmt.Printf("gRPC server started on %s\n", config().grpcAddr)
+		if err := grpcServer.Serve(listener); err != nil {
+			fmt.Printf("Error serving gRPC: %v\n", err)
+		}
+	}()
+	return nil
+}
+
+// stopGRPC lets the calls in progress finish until ctx is done, then closes them
+func stopGRPC(ctx context.Context) {
+	if grpcServer == nil {
+		return
+	}
+	done := make(chan struct{})
+	go func() {
+		grpcServer.GracefulStop()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		grpcServer.Stop()
+	}
+}
@@ -0,0 +1,221 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             v5.29.3
+// source: archive_jobs.proto
+
+package grpcapi
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	ArchiveJobs_SubmitArchive_FullMethodName = "/gozipstreamer.v1.ArchiveJobs/SubmitArchive"
+	ArchiveJobs_GetJob_FullMethodName        = "/gozipstreamer.v1.ArchiveJobs/GetJob"
+	ArchiveJobs_WatchJob_FullMethodName      = "/gozipstreamer.v1.ArchiveJobs/WatchJob"
+)
+
+// ArchiveJobsClient is the client API for ArchiveJobs service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// ArchiveJobs is the gRPC counterpart of asynchronous batches on
+// /create-zip/batch, for internal services. Archives are built into a target
+// in the background, and their jobs are followed until the archive is stored.
+type ArchiveJobsClient interface {
+	// SubmitArchive starts building the archive of a descriptor into a target
+	// and returns its queued job
+	SubmitArchive(ctx context.Context, in *SubmitArchiveRequest, opts ...grpc.CallOption) (*Job, error)
+	// GetJob returns the current state of a job, with the location of the
+	// archive once it is stored
+	GetJob(ctx context.Context, in *GetJobRequest, opts ...grpc.CallOption) (*Job, error)
+	// WatchJob streams the state of a job whenever it changes, until the job
+	// has finished
+	WatchJob(ctx context.Context, in *GetJobRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Job], error)
+}
+
+type archiveJobsClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewArchiveJobsClient(cc grpc.ClientConnInterface) ArchiveJobsClient {
+	return &archiveJobsClient{cc}
+}
+
+func (c *archiveJobsClient) SubmitArchive(ctx context.Context, in *SubmitArchiveRequest, opts ...grpc.CallOption) (*Job, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Job)
+	err := c.cc.Invoke(ctx, ArchiveJobs_SubmitArchive_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *archiveJobsClient) GetJob(ctx context.Context, in *GetJobRequest, opts ...grpc.CallOption) (*Job, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Job)
+	err := c.cc.Invoke(ctx, ArchiveJobs_GetJob_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *archiveJobsClient) WatchJob(ctx context.Context, in *GetJobRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Job], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &ArchiveJobs_ServiceDesc.Streams[0], ArchiveJobs_WatchJob_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[GetJobRequest, Job]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type ArchiveJobs_WatchJobClient = grpc.ServerStreamingClient[Job]
+
+// ArchiveJobsServer is the server API for ArchiveJobs service.
+// All implementations must embed UnimplementedArchiveJobsServer
+// for forward compatibility.
+//
+// ArchiveJobs is the gRPC counterpart of asynchronous batches on
+// /create-zip/batch, for internal services. Archives are built into a target
+// in the background, and their jobs are followed until the archive is stored.
+type ArchiveJobsServer interface {
+	// SubmitArchive starts building the archive of a descriptor into a target
+	// and returns its queued job
+	SubmitArchive(context.Context, *SubmitArchiveRequest) (*Job, error)
+	// GetJob returns the current state of a job, with the location of the
+	// archive once it is stored
+	GetJob(context.Context, *GetJobRequest) (*Job, error)
+	// WatchJob streams the state of a job whenever it changes, until the job
+	// has finished
+	WatchJob(*GetJobRequest, grpc.ServerStreamingServer[Job]) error
+	mustEmbedUnimplementedArchiveJobsServer()
+}
+
+// UnimplementedArchiveJobsServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedArchiveJobsServer struct{}
+
+func (UnimplementedArchiveJobsServer) SubmitArchive(context.Context, *SubmitArchiveRequest) (*Job, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SubmitArchive not implemented")
+}
+func (UnimplementedArchiveJobsServer) GetJob(context.Context, *GetJobRequest) (*Job, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetJob not implemented")
+}
+func (UnimplementedArchiveJobsServer) WatchJob(*GetJobRequest, grpc.ServerStreamingServer[Job]) error {
+	return status.Errorf(codes.Unimplemented, "method WatchJob not implemented")
+}
+func (UnimplementedArchiveJobsServer) mustEmbedUnimplementedArchiveJobsServer() {}
+func (UnimplementedArchiveJobsServer) testEmbeddedByValue()                     {}
+
+// UnsafeArchiveJobsServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to ArchiveJobsServer will
+// result in compilation errors.
+type UnsafeArchiveJobsServer interface {
+	mustEmbedUnimplementedArchiveJobsServer()
+}
+
+func RegisterArchiveJobsServer(s grpc.ServiceRegistrar, srv ArchiveJobsServer) {
+	// If the following call pancis, it indicates UnimplementedArchiveJobsServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&ArchiveJobs_ServiceDesc, srv)
+}
+
+func _ArchiveJobs_SubmitArchive_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SubmitArchiveRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ArchiveJobsServer).SubmitArchive(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ArchiveJobs_SubmitArchive_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ArchiveJobsServer).SubmitArchive(ctx, req.(*SubmitArchiveRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ArchiveJobs_GetJob_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetJobRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ArchiveJobsServer).GetJob(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ArchiveJobs_GetJob_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ArchiveJobsServer).GetJob(ctx, req.(*GetJobRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ArchiveJobs_WatchJob_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(GetJobRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ArchiveJobsServer).WatchJob(m, &grpc.GenericServerStream[GetJobRequest, Job]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type ArchiveJobs_WatchJobServer = grpc.ServerStreamingServer[Job]
+
+// ArchiveJobs_ServiceDesc is the grpc.ServiceDesc for ArchiveJobs service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var ArchiveJobs_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "gozipstreamer.v1.ArchiveJobs",
+	HandlerType: (*ArchiveJobsServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "SubmitArchive",
+			Handler:    _ArchiveJobs_SubmitArchive_Handler,
+		},
+		{
+			MethodName: "GetJob",
+			Handler:    _ArchiveJobs_GetJob_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "WatchJob",
+			Handler:       _ArchiveJobs_WatchJob_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "archive_jobs.proto",
+}
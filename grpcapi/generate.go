@@ -0,0 +1,5 @@
+// Package grpcapi holds the protobuf contract of the archive jobs gRPC API
+// and the code generated from it
+package grpcapi
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative archive_jobs.proto
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Header clients set so that retries of a request don't build the archive twice
+const idempotencyKeyHeader = "Idempotency-Key"
+
+// idempotentBuild is the archive build owning an idempotency key
+type idempotentBuild struct {
+	jobID       string
+	fingerprint string // Hash of the request, so a key can't be reused for a different archive
+	finished    bool
+	stored      *jobRecord // The finished job, when its archive went only to a target
+	expires     time.Time
+}
+
+// idempotencyKeys maps idempotency keys, scoped to their client, to their
+// builds. A retry while the build runs is answered with its job rather than
+// building the archive twice, and so is a retry of a build that stored its
+// one archive in a target. Streamed archives are kept nowhere, so a retry
+// after such a build finished streams the archive again under the same job
+// ID, as do retries of batches.
+var idempotencyKeys = struct {
+	sync.Mutex
+	builds map[string]*idempotentBuild
+}{builds: make(map[string]*idempotentBuild)}
+
+// jobIDKey carries the job ID fixed by an idempotency key in the request context
+type jobIDKey struct{}
+
+// idempotentBuildKey carries the build owning the request's idempotency key
+type idempotentBuildKey struct{}
+
+// claimIdempotencyKey registers the request as the build for its idempotency
+// key, and release must be called once the archive was streamed. Without a
+// key the request is returned unchanged. ok is false when a response was
+// already sent, such as the job of a build still running for the key.
+func claimIdempotencyKey(w http.ResponseWriter, r *http.Request, body []byte) (claimed *http.Request, release func(), ok bool) {
+	key, ok := scopedIdempotencyKey(w, r)
+	if !ok {
+		return nil, nil, false
+	}
+	if key == "" {
+		return r, func() {}, true
+	}
+	fingerprint := requestFingerprint(r, body)
+
+	idempotencyKeys.Lock()
+	now := time.Now()
+	for k, build := range idempotencyKeys.builds {
+		if build.finished && now.After(build.expires) {
+			delete(idempotencyKeys.builds, k)
+		}
+	}
+	previous := idempotencyKeys.builds[key]
+	if previous != nil && previous.fingerprint != fingerprint {
+		idempotencyKeys.Unlock()
+		http.Error(w, "Idempotency key was already used for a different request", http.StatusUnprocessableEntity)
+		return nil, nil, false
+	}
+	if previous != nil && !previous.finished {
+		idempotencyKeys.Unlock()
+		fmt.Printf("Job %s: retried with idempotency key while running\n", previous.jobID)
+		writeRunningJob(w, previous.jobID)
+		return nil, nil, false
+	}
+	if previous != nil && previous.stored != nil {
+		stored := previous.stored
+		idempotencyKeys.Unlock()
+		fmt.Printf("Job %s: retried with idempotency key after its archive was stored\n", stored.ID)
+		writeStoredJob(w, stored)
+		return nil, nil, false
+	}
+
+	jobID := requestJobID(r)
+	if previous != nil {
+		jobID = previous.jobID
+		fmt.Printf("Job %s: retried with idempotency key, building it again\n", jobID)
+	}
+	build := &idempotentBuild{jobID: jobID, fingerprint: fingerprint}
+	idempotencyKeys.builds[key] = build
+	idempotencyKeys.Unlock()
+
+	release = func() {
+		idempotencyKeys.Lock()
+		defer idempotencyKeys.Unlock()
+		build.finished = true
+		build.expires = time.Now().Add(config().idempotencyTTL)
+	}
+	ctx := context.WithValue(r.Context(), jobIDKey{}, jobID)
+	return r.WithContext(context.WithValue(ctx, idempotentBuildKey{}, build)), release, true
+}
+
+// noteStoredJob records the finished job of an idempotent build whose archive
+// went only to a target, so that retries are answered with it rather than
+// building and storing the archive again
+func noteStoredJob(r *http.Request, job *jobRecord) {
+	build, ok := r.Context().Value(idempotentBuildKey{}).(*idempotentBuild)
+	if !ok || build.jobID != job.ID || job.URL == "" {
+		return
+	}
+	idempotencyKeys.Lock()
+	defer idempotencyKeys.Unlock()
+	build.stored = job.public()
+}
+
+// scopedIdempotencyKey returns the idempotency key of the request scoped to
+// its client, or "" when it has none. ok is false when an error response was
+// sent for an invalid key.
+func scopedIdempotencyKey(w http.ResponseWriter, r *http.Request) (string, bool) {
+	key := r.Header.Get(idempotencyKeyHeader)
+	if key == "" {
+		return "", true
+	}
+	if len(key) > 255 {
+		http.Error(w, "Idempotency key is too long", http.StatusBadRequest)
+		return "", false
+	}
+	scope := idempotencyScope(r)
+	if scope == "" {
+		http.Error(w, "Idempotency keys require an API key", http.StatusBadRequest)
+		return "", false
+	}
+	return scope + "\x00" + key, true
+}
+
+// requestFingerprint hashes what makes up the archive of a request, so a key
+// can't be reused for a different archive
+func requestFingerprint(r *http.Request, body []byte) string {
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%s\n%s\n", r.Method, r.URL.RawQuery)
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// idempotencyScope identifies the client of a request by the API key sent in
+// a header or the query, so that clients can't collide on or look up each
+// other's keys. It is empty without an API key: client addresses are shared
+// by every client behind the same proxy. Requests with the same key and
+// credentials in their body are told apart by the request fingerprint.
+func idempotencyScope(r *http.Request) string {
+	if key := browseCredential(r); key != "" {
+		return "key:" + keyFingerprint(key)
+	}
+	return ""
+}
+
+// writeRunningJob answers a retry of a build still running with its job,
+// from the job history when it is recorded there
+func writeRunningJob(w http.ResponseWriter, jobID string) {
+	var job interface{} = map[string]string{"id": jobID, "status": jobRunning}
+	if jobHistory != nil {
+		if record, err := jobHistory.get(jobID); err == nil && record != nil {
+			job = record.public()
+			w.Header().Set("Location", "/jobs/"+jobID)
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Zip-Job-Id", jobID)
+	w.WriteHeader(http.StatusConflict)
+	json.NewEncoder(w).Encode(job)
+}
+
+// writeStoredJob answers a retry of a build that stored its archive with its
+// finished job, which tells where the archive is
+func writeStoredJob(w http.ResponseWriter, job *jobRecord) {
+	if jobHistory != nil {
+		w.Header().Set("Location", "/jobs/"+job.ID)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Zip-Job-Id", job.ID)
+	json.NewEncoder(w).Encode(job)
+}
+
+// requestJobID returns the job ID fixed for the request, or a new one
+func requestJobID(r *http.Request) string {
+	if jobID, ok := r.Context().Value(jobIDKey{}).(string); ok {
+		return jobID
+	}
+	return newJobID()
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gozipstreamer/zipstreamer"
+	"io"
+	"math"
+	"net/http"
+	"strings"
+)
+
+// indexPath describes the archive a descriptor produces without streaming it
+const indexPath = "/create-zip/index"
+
+// alignmentExtraLength is the size of the padding field before its padding
+const alignmentExtraLength = 6
+
+// archiveIndex is the central directory of a reproducible archive, as
+// predicted from the sizes listed in its descriptor
+type archiveIndex struct {
+	Size                   int64        `json:"size"`
+	CentralDirectoryOffset int64        `json:"centralDirectoryOffset"`
+	CentralDirectorySize   int64        `json:"centralDirectorySize"`
+	Entries                []indexEntry `json:"entries"`
+}
+
+// indexEntry locates one entry in the archive
+type indexEntry struct {
+	Name           string `json:"name"`
+	Offset         int64  `json:"offset"`     // Of the local header
+	DataOffset     int64  `json:"dataOffset"` // Of the data, which runs for compressedSize bytes
+	CompressedSize int64  `json:"compressedSize"`
+	Size           int64  `json:"size"`
+	Method         uint16 `json:"method"`
+	CRC32          string `json:"crc32,omitempty"` // Only known up front when the descriptor lists it
+}
+
+// indexHandler answers a descriptor with the central directory of the
+// archive /create-zip?reproducible=true streams for it, so clients can fetch
+// single entries with range requests against a stored copy. The prediction
+// only holds while every entry can be fetched.
+func indexHandler(w http.ResponseWriter, r *http.Request) {
+	payload, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Failed to read request body", http.StatusBadRequest)
+		return
+	}
+	if err := validateBody(payload, "Descriptor", "Invalid zip descriptor"); err != nil {
+		writeBadRequest(w, err)
+		return
+	}
+	descriptor, err := zipstreamer.UnmarshalJsonZipDescriptor(payload)
+	if err != nil {
+		http.Error(w, "Invalid zip descriptor: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	options, err := descriptorOptions(r, descriptor)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	options.index = true
+	options.reproducible = true
+	options.sorted = true
+	processDescriptorRequest(w, r, descriptor, options)
+}
+
+// writeArchiveIndex answers with the index of the archive built from files
+func writeArchiveIndex(w http.ResponseWriter, files []*zipstreamer.FileEntry, comment string, options *zipOptions) {
+	index, err := predictArchive(files, comment, options)
+	if err != nil {
+		http.Error(w, "Archive layout can't be predicted: "+err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(index)
+}
+
+// predictArchive lays out the archive like archive/zip writes it, see
+// calculateZipSize. Options that change the data or add files while
+// streaming make the layout unpredictable.
+func predictArchive(files []*zipstreamer.FileEntry, comment string, options *zipOptions) (*archiveIndex, error) {
+	switch {
+	case !allSizesKnown(files):
+		return nil, errors.New("the descriptor must list the size of every file")
+	case options.method() != zip.Store:
+		return nil, errors.New("compressed sizes are only known once the data is compressed")
+	case options.rewritesData():
+		return nil, errors.New("converted files change size")
+	case options.manifest:
+		return nil, errors.New("the manifest is only written once every file was fetched")
+	case config().jobDeadline > 0 || !config().contentTypes.Empty():
+		return nil, errors.New("the server may append a failure manifest")
+	case virusScanner != nil && config().scanPolicy == zipstreamer.ScanReplace:
+		return nil, errors.New("infected files may be replaced by warnings")
+	}
+
+	index := &archiveIndex{Entries: make([]indexEntry, 0, len(files))}
+	var offset int64
+	for _, file := range files {
+		if file.IsGzipSource() {
+			return nil, fmt.Errorf("%s is copied from gzip data of unknown size", file.ZipPath())
+		}
+		entry := indexEntry{Name: file.ZipPath(), Offset: offset, Size: max(file.Size(), 0), Method: zip.Store}
+		entry.CompressedSize = entry.Size
+		extraLen := extTimeSize + file.ExtraFieldsSize()
+		descriptor := int64(dataDescriptorSize)
+		if raw := file.Raw(); raw != nil {
+			entry.Method = raw.Method
+			entry.Size = raw.UncompressedSize
+			entry.CompressedSize = raw.CompressedSize
+			entry.CRC32 = fmt.Sprintf("%08x", raw.CRC32)
+			descriptor = 0
+		} else if file.IsDir() {
+			descriptor = 0
+		} else {
+			entry.CRC32 = strings.ToLower(file.Checksums().CRC32)
+		}
+
+		headerLen := localHeaderSize + int64(len(entry.Name)) + extraLen
+		if options.align > 1 && entry.Method == zip.Store && !file.IsDir() {
+			// Padding as written by zipstreamer's aligned writer
+			align := int64(options.align)
+			unpadded := offset + headerLen + alignmentExtraLength
+			headerLen += alignmentExtraLength + (align-unpadded%align)%align
+		}
+		entry.DataOffset = offset + headerLen
+		offset = entry.DataOffset + entry.CompressedSize + descriptor
+		index.CentralDirectorySize += centralDirSize + headerLen - localHeaderSize
+		index.Entries = append(index.Entries, entry)
+	}
+	index.CentralDirectoryOffset = offset
+	index.Size = offset + index.CentralDirectorySize + eocdSize + int64(len(comment))
+	if index.Size >= math.MaxUint32 {
+		return nil, errors.New("archives past 4GiB need Zip64 records")
+	}
+	return index, nil
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Statuses of jobs besides the complete, partial and failed outcomes of the status trailer
+const (
+	jobQueued      = "queued" // Accepted and waiting to be built
+	jobRunning     = "running"
+	jobInterrupted = "interrupted" // The server stopped while the job was running
+)
+
+// jobProgressInterval is how often the progress of running jobs is saved
+const jobProgressInterval = 2 * time.Second
+
+var jobsBucket = []byte("jobs")
+
+// jobRecord is the persisted state of one archive job
+type jobRecord struct {
+	ID       string    `json:"id"`
+	Status   string    `json:"status"`
+	Source   string    `json:"source"`
+	Filename string    `json:"filename,omitempty"`
+	Entries  int       `json:"entries"`
+	Done     int       `json:"entriesDone"`
+	Failed   int       `json:"failed"`
+	Bytes    int64     `json:"bytes"`
+	Error    string    `json:"error,omitempty"`
+	URL      string    `json:"url,omitempty"` // Location of the archive in its target
+	Created  time.Time `json:"created"`
+	Updated  time.Time `json:"updated"`
+
+	// What resuming the job after a restart takes, sealed since its request
+	// carries credentials. Kept while the job runs and left out of the job API.
+	Resume  []byte `json:"resume,omitempty"`
+	Resumes int    `json:"resumes,omitempty"`
+}
+
+// public returns the job without what resuming it takes
+func (job *jobRecord) public() *jobRecord {
+	copy := *job
+	copy.Resume = nil
+	return &copy
+}
+
+// jobStore keeps the history of archive jobs in a BoltDB file so that it
+// survives restarts. A nil store records nothing.
+type jobStore struct {
+	db        *bolt.DB
+	retention time.Duration // Finished jobs older than this are removed; 0 keeps them
+	mu        sync.Mutex    // Serializes updates of tracked jobs
+}
+
+// openJobStore opens the job history at path. Jobs that were running when
+// the server stopped, or still queued, are marked as interrupted.
+func openJobStore(path string, retention time.Duration) (*jobStore, error) {
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	s := &jobStore{db: db, retention: retention}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(jobsBucket)
+		if err != nil {
+			return err
+		}
+		return bucket.ForEach(func(key, value []byte) error {
+			var job jobRecord
+			if err := json.Unmarshal(value, &job); err != nil || (job.Status != jobRunning && job.Status != jobQueued) {
+				return nil
+			}
+			job.Status = jobInterrupted
+			job.Updated = time.Now().UTC()
+			data, err := json.Marshal(&job)
+			if err != nil {
+				return err
+			}
+			return bucket.Put(key, data)
+		})
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	if retention > 0 {
+		go func() {
+			for ; ; time.Sleep(time.Hour) {
+				if err := s.prune(); err != nil {
+					fmt.Printf("Failed to prune job history: %v\n", err)
+				}
+			}
+		}()
+	}
+	return s, nil
+}
+
+// put saves a job, replacing its previous state
+func (s *jobStore) put(job *jobRecord) {
+	if s == nil {
+		return
+	}
+	job.Updated = time.Now().UTC()
+	data, err := json.Marshal(job)
+	if err != nil {
+		fmt.Printf("Failed to encode job %s: %v\n", job.ID, err)
+		return
+	}
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(jobsBucket).Put([]byte(job.ID), data)
+	})
+	if err != nil {
+		fmt.Printf("Failed to save job %s: %v\n", job.ID, err)
+	}
+}
+
+// get returns a job, or nil if it is unknown
+func (s *jobStore) get(id string) (*jobRecord, error) {
+	var job *jobRecord
+	err := s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(jobsBucket).Get([]byte(id))
+		if data == nil {
+			return nil
+		}
+		job = &jobRecord{}
+		return json.Unmarshal(data, job)
+	})
+	return job, err
+}
+
+// list returns the most recent jobs first, optionally only those with status
+func (s *jobStore) list(status string, limit int) ([]*jobRecord, error) {
+	jobs := []*jobRecord{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(jobsBucket).ForEach(func(_, value []byte) error {
+			job := &jobRecord{}
+			if err := json.Unmarshal(value, job); err != nil {
+				return err
+			}
+			if status == "" || job.Status == status {
+				jobs = append(jobs, job)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].Created.After(jobs[j].Created) })
+	if limit > 0 && len(jobs) > limit {
+		jobs = jobs[:limit]
+	}
+	return jobs, nil
+}
+
+// prune removes finished jobs that were last updated before the retention period
+func (s *jobStore) prune() error {
+	cutoff := time.Now().Add(-s.retention)
+	return s.db.Update(func(tx *bolt.Tx) error {
+		cursor := tx.Bucket(jobsBucket).Cursor()
+		for key, value := cursor.First(); key != nil; key, value = cursor.Next() {
+			var job jobRecord
+			if err := json.Unmarshal(value, &job); err != nil {
+				continue
+			}
+			if job.Status != jobRunning && job.Updated.Before(cutoff) {
+				if err := cursor.Delete(); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// track saves the progress of a running job until the returned function is
+// called, after which the caller may update the job again
+func (s *jobStore) track(job *jobRecord, progress func() (int, int64)) func() {
+	if s == nil {
+		return func() {}
+	}
+	s.put(job)
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(jobProgressInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s.mu.Lock()
+				job.Done, job.Bytes = progress()
+				s.put(job)
+				s.mu.Unlock()
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		wg.Wait()
+	}
+}
+
+// saveResume records what resuming a tracked job takes, e.g. at a checkpoint
+func (s *jobStore) saveResume(job *jobRecord, sealed []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job.Resume = sealed
+	s.put(job)
+}
+
+// jobHandler serves the state of one job. Job IDs are random, so knowing one
+// is enough to look it up.
+func jobHandler(w http.ResponseWriter, r *http.Request) {
+	job, err := jobHistory.get(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if job == nil {
+		http.Error(w, "Job not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(job.public())
+}
+
+// jobsHandler lists recent jobs, filtered by the status and limit parameters
+func jobsHandler(w http.ResponseWriter, r *http.Request) {
+	limit := 100
+	if value := r.URL.Query().Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 {
+			http.Error(w, "Invalid limit parameter", http.StatusBadRequest)
+			return
+		}
+		limit = parsed
+	}
+
+	jobs, err := jobHistory.list(r.URL.Query().Get("status"), limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for i, job := range jobs {
+		jobs[i] = job.public()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"jobs": jobs})
+}
+
+// startJobHistory mounts the job API when the history is enabled. Listing
+// reveals every job, so it requires the admin token.
+func startJobHistory(r *mux.Router) {
+	if jobHistory == nil {
+		return
+	}
+	r.HandleFunc("/jobs/{id}", jobHandler).Methods("GET")
+	if config().adminToken != "" {
+		r.Handle("/jobs", requireAdminToken(http.HandlerFunc(jobsHandler))).Methods("GET")
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"gozipstreamer/zipstreamer"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Trailer carrying the expiring download link of the stored archive
+const downloadURLTrailer = "X-Zip-Download-URL"
+
+// archiveLinker is implemented by targets whose stored archives can be
+// shared through expiring download links
+type archiveLinker interface {
+	link(ttl time.Duration) (string, error)
+}
+
+// signedLinks signs download links for archives in the local target. The
+// job, expiry and file name are covered by an HMAC in the path, so links can
+// be shared without credentials or access to the job API.
+type signedLinks struct {
+	key     []byte
+	baseURL string // Prepended to the links; empty makes them relative
+	dir     string // Directory of the local target
+}
+
+// signedLinker is the configured link signer, nil when links are disabled
+var signedLinker *signedLinks
+
+// sign returns a link to an archive that is valid until expires
+func (l *signedLinks) sign(jobID, filename string, expires time.Time) string {
+	expiry := strconv.FormatInt(expires.Unix(), 10)
+	return fmt.Sprintf("%s/archives/%s/%s/%s/%s", strings.TrimRight(l.baseURL, "/"),
+		jobID, expiry, l.signature(jobID, expiry, filename), url.PathEscape(filename))
+}
+
+func (l *signedLinks) signature(jobID, expiry, filename string) string {
+	mac := hmac.New(sha256.New, l.key)
+	mac.Write([]byte(jobID + "\n" + expiry + "\n" + filename))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// verify checks the signature and expiry of a link
+func (l *signedLinks) verify(jobID, expiry, signature, filename string) error {
+	if !hmac.Equal([]byte(signature), []byte(l.signature(jobID, expiry, filename))) {
+		return errors.New("invalid signature")
+	}
+	expires, err := strconv.ParseInt(expiry, 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return errors.New("link expired")
+	}
+	return nil
+}
+
+// serveArchive serves a stored archive to holders of a valid link, with
+// range requests so large downloads can be resumed
+func (l *signedLinks) serveArchive(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	jobID, filename := vars["job"], vars["filename"]
+	if err := l.verify(jobID, vars["expires"], vars["signature"], filename); err != nil {
+		http.Error(w, "Forbidden: "+err.Error(), http.StatusForbidden)
+		return
+	}
+
+	// The signature vouches for both names, which targetFilename produced
+	file, err := os.Open(filepath.Join(l.dir, jobID, filename))
+	if err != nil {
+		http.Error(w, "Archive not found", http.StatusNotFound)
+		return
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		http.Error(w, "Archive not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", zipstreamer.ContentDisposition("attachment", filename))
+	http.ServeContent(w, r, filename, info.ModTime(), file)
+}
+
+// startSignedLinks serves the archives of the local target under signed links
+func startSignedLinks(r *mux.Router) {
+	if config().linkKey == "" || config().localTargetDir == "" {
+		return
+	}
+	dir, _ := filepath.Abs(config().localTargetDir)
+	signedLinker = &signedLinks{key: []byte(config().linkKey), baseURL: config().publicURL, dir: dir}
+	r.HandleFunc("/archives/{job}/{expires}/{signature}/{filename}", signedLinker.serveArchive).Methods("GET", "HEAD")
+}
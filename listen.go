@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+)
+
+// defaultListenAddr is the TCP address served when no socket is configured
+const defaultListenAddr = ":80"
+
+// listenFDsStart is the first file descriptor passed by systemd socket activation
+const listenFDsStart = 3
+
+// listen opens the listener of the main server: the socket passed by systemd,
+// the configured Unix socket for deployments behind a local reverse proxy, or
+// TCP port 80
+func listen() (net.Listener, error) {
+	if listener, err := activatedListener(); listener != nil || err != nil {
+		return listener, err
+	}
+	if config().listenSocket == "" {
+		return net.Listen("tcp", defaultListenAddr)
+	}
+
+	// A socket left behind by a previous run would make the bind fail
+	if info, err := os.Lstat(config().listenSocket); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", config().listenSocket)
+		}
+		if err := os.Remove(config().listenSocket); err != nil {
+			return nil, err
+		}
+	}
+	listener, err := net.Listen("unix", config().listenSocket)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(config().listenSocket, config().socketMode); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
+
+// activatedListener returns the socket systemd passed to the process, or nil
+// when it wasn't socket activated. systemd keeps the socket open while the
+// service restarts, so connections queue instead of being refused.
+func activatedListener() (net.Listener, error) {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || count < 1 {
+		return nil, nil
+	}
+	// Child processes such as plugins must not take the sockets for theirs
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+	if count > 1 {
+		fmt.Printf("Serving the first of %d activated sockets\n", count)
+	}
+
+	file := os.NewFile(listenFDsStart, "systemd-socket")
+	defer file.Close()
+	listener, err := net.FileListener(file)
+	if err != nil {
+		return nil, fmt.Errorf("activated socket: %v", err)
+	}
+	return listener, nil
+}
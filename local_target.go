@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// localTarget writes an archive to a temporary file next to its final path
+// and renames it into place once complete, so readers of the directory never
+// see a partial archive
+type localTarget struct {
+	*os.File
+	dir  string // Job directory, removed again when the archive is discarded
+	path string
+}
+
+// openLocalTarget creates the archive of a job under dir/<job ID>/
+func openLocalTarget(dir string) targetOpener {
+	return func(jobID, filename string) (archiveTarget, error) {
+		jobDir := filepath.Join(dir, jobID)
+		if err := os.MkdirAll(jobDir, 0755); err != nil {
+			return nil, err
+		}
+		name := targetFilename(filename)
+		file, err := os.CreateTemp(jobDir, "."+name+".tmp-*")
+		if err != nil {
+			return nil, err
+		}
+		return &localTarget{File: file, dir: jobDir, path: filepath.Join(jobDir, name)}, nil
+	}
+}
+
+// localCheckpoint is the resume state of a local archive
+type localCheckpoint struct {
+	Temp string `json:"temp"` // Temporary file holding the partial archive
+}
+
+// resumeLocalTarget continues the partial archive of a job in its temporary
+// file, cutting off what was written after the checkpoint
+func resumeLocalTarget(dir string) targetResumer {
+	open := openLocalTarget(dir)
+	return func(jobID, filename string, state json.RawMessage, offset int64) (resumableTarget, error) {
+		if state == nil {
+			target, err := open(jobID, filename)
+			if err != nil {
+				return nil, err
+			}
+			return target.(*localTarget), nil
+		}
+
+		var checkpoint localCheckpoint
+		if err := json.Unmarshal(state, &checkpoint); err != nil {
+			return nil, err
+		}
+		jobDir := filepath.Join(dir, jobID)
+		if filepath.Dir(checkpoint.Temp) != jobDir {
+			return nil, fmt.Errorf("partial archive %s is outside the job directory", checkpoint.Temp)
+		}
+		file, err := os.OpenFile(checkpoint.Temp, os.O_RDWR, 0)
+		if err != nil {
+			return nil, err
+		}
+		info, err := file.Stat()
+		if err == nil && info.Size() < offset {
+			err = fmt.Errorf("partial archive has %d of %d bytes", info.Size(), offset)
+		}
+		if err == nil {
+			err = file.Truncate(offset)
+		}
+		if err == nil {
+			_, err = file.Seek(offset, io.SeekStart)
+		}
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		return &localTarget{File: file, dir: jobDir, path: filepath.Join(jobDir, targetFilename(filename))}, nil
+	}
+}
+
+// checkpoint flushes the partial archive to disk
+func (t *localTarget) checkpoint() (json.RawMessage, error) {
+	if err := t.Sync(); err != nil {
+		return nil, err
+	}
+	return json.Marshal(localCheckpoint{Temp: t.Name()})
+}
+
+// commit flushes the archive to disk and moves it to its final path. Syncing
+// first keeps a crash from leaving a renamed but truncated file, e.g. on NFS.
+func (t *localTarget) commit() (string, error) {
+	if err := t.Sync(); err != nil {
+		return "", err
+	}
+	if err := t.Close(); err != nil {
+		return "", err
+	}
+	if err := os.Rename(t.Name(), t.path); err != nil {
+		return "", err
+	}
+	return t.path, nil
+}
+
+// link returns a signed link to the stored archive, or none when links are disabled
+func (t *localTarget) link(ttl time.Duration) (string, error) {
+	if signedLinker == nil {
+		return "", nil
+	}
+	return signedLinker.sign(filepath.Base(t.dir), filepath.Base(t.path), time.Now().Add(ttl)), nil
+}
+
+// abort removes the temporary file and the job directory if nothing else is in it
+func (t *localTarget) abort() {
+	t.Close()
+	if err := os.Remove(t.Name()); err != nil && !errors.Is(err, os.ErrNotExist) {
+		fmt.Printf("Failed to remove %s: %v\n", t.Name(), err)
+	}
+	os.Remove(t.dir)
+}
@@ -2,128 +2,280 @@ package main
 
 import (
 	"archive/zip"
+	"bytes"
 	"encoding/json"
+	"errors"
+	"expvar"
 	"fmt"
+	"gozipstreamer/provider"
 	"gozipstreamer/zipstreamer"
+	"io"
+	"math"
 	"net/http"
+	"net/url"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
 )
 
-// File size mapping (ZipPath -> Size)
-var fileSizeMap map[string]int64
-
-// APIResponse represents the structure of the API response from Premiumize.me
-type APIResponse struct {
-	Status  string `json:"status"`
-	Content []struct {
-		ID         string `json:"id"`
-		Name       string `json:"name"`
-		Type       string `json:"type"`
-		DirectLink string `json:"directlink,omitempty"`
-		Size       int64  `json:"size"`
-	} `json:"content"`
-	Name     string `json:"name"`
-	FolderID string `json:"folder_id"`
-	ParentID string `json:"parent_id"`
-}
-
-// fetchFolderContents retrieves the contents of a folder from Premiumize.me API
-func fetchFolderContents(apiKey, path string) (*APIResponse, error) {
-	encodedPath := strings.ReplaceAll(path, " ", "%20") // Encode spaces
-	apiURL := fmt.Sprintf("https://www.premiumize.me/api/folder/list?apikey=%s&path=%s", apiKey, encodedPath)
-
-	resp, err := http.Get(apiURL)
+// Shared caches of upstream responses, nil when disabled
+var (
+	etagCache   *zipstreamer.ETagCache
+	diskCache   *zipstreamer.DiskCache
+	memoryCache *zipstreamer.MemoryCache
+)
+
+// Shared budget for stream buffers, nil when unlimited
+var memoryBudget *zipstreamer.MemoryBudget
+
+// Audit log of archive requests, nil when disabled
+var auditTrail *auditLog
+
+// Persistent history of archive jobs, nil when disabled
+var jobHistory *jobStore
+
+// Malware scanner of every entry, nil when disabled
+var virusScanner zipstreamer.Scanner
+
+// Names of the warning manifests added to truncated and deadline-limited archives
+const (
+	truncatedManifestName = "TRUNCATED.txt"
+	failedManifestName    = "FAILED.txt"
+)
+
+// sourceManifestName is the machine-readable description of the archive's files
+const sourceManifestName = "manifest.json"
+
+// traverseFolder recursively builds the file list & tracks sizes. An empty
+// zipPath places the folder's contents under the folder's own name; modTime is
+// the folder's modification time when the provider reports one.
+func traverseFolder(source provider.Provider, path, zipPath string, modTime time.Time, options *zipOptions, entries *entrySet) error {
+	folder, err := source.List(path)
 	if err != nil {
-		return nil, fmt.Errorf("failed to fetch folder contents: %v", err)
+		return err
 	}
-	defer resp.Body.Close()
+	return addFolder(source, folder, path, zipPath, modTime, options, entries)
+}
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("API request failed with status: %s", resp.Status)
+// addFolder adds an already listed folder and recurses into its subfolders
+func addFolder(source provider.Provider, folder *provider.Folder, path, zipPath string, modTime time.Time, options *zipOptions, entries *entrySet) error {
+	if zipPath == "" {
+		zipPath = options.rootZipPath(path, folder)
 	}
 
-	var apiResponse APIResponse
-	if err := json.NewDecoder(resp.Body).Decode(&apiResponse); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal JSON response: %v", err)
+	// Every folder gets a directory entry so the extracted tree, including
+	// empty folders and folder times, matches the cloud layout
+	if zipPath != "." {
+		entry, err := zipstreamer.NewDirectoryEntry(filepath.ToSlash(zipPath), modTime)
+		if err == nil {
+			entries.add(entry)
+		}
 	}
 
-	if apiResponse.Status != "success" {
-		return nil, fmt.Errorf("API response status: %s", apiResponse.Status)
+	for _, item := range folder.Items {
+		currentZipPath := filepath.Join(zipPath, item.Name)
+
+		if item.Error != "" {
+			entries.skip(currentZipPath, item.Error)
+		} else if !item.IsDir {
+			addFileItem(item, currentZipPath, options, entries)
+		} else {
+			err := traverseFolder(source, item.Path, currentZipPath, item.ModTime, options, entries)
+			if err != nil {
+				return err
+			}
+		}
 	}
 
-	return &apiResponse, nil
+	return nil
+}
+
+// addFileItem appends a listed file to the archive if it passes the request's filters
+func addFileItem(item provider.Item, zipPath string, options *zipOptions, entries *entrySet) {
+	if !options.allowed(zipPath) {
+		return
+	}
+	if item.Error != "" {
+		entries.skip(zipPath, item.Error)
+		return
+	}
+	if options.maxFileSize > 0 && item.Size > options.maxFileSize {
+		entries.skip(zipPath, fmt.Sprintf("file size %d bytes exceeds the limit of %d bytes", item.Size, options.maxFileSize))
+		return
+	}
+
+	checksums := zipstreamer.Checksums{CRC32: item.CRC32, MD5: item.MD5}
+	entry, err := zipstreamer.NewFileEntry(item.URL, zipPath, zipstreamer.WithSize(item.Size), zipstreamer.WithReaderWrapper(item.Wrap), zipstreamer.WithChecksums(checksums))
+	if err != nil {
+		entries.skip(zipPath, err.Error())
+		return
+	}
+	if item.Authorize != nil {
+		entry.SetAuthorizer(item.Authorize)
+	}
+	entry.SetModTime(item.ModTime)
+	if options.sidecars {
+		entry.SetMetadata(itemMetadata(entries.provider, item))
+	}
+	entries.add(entry)
+}
+
+// sidecarSuffix is appended to the name of a file to name its metadata sidecar
+const sidecarSuffix = ".json"
+
+// itemSidecar is the metadata written next to a provider file
+type itemSidecar struct {
+	Provider string     `json:"provider"`
+	ID       string     `json:"id,omitempty"`
+	Name     string     `json:"name"`
+	Size     int64      `json:"size"`
+	Modified *time.Time `json:"modified,omitempty"`
+	CRC32    string     `json:"crc32,omitempty"`
+	MD5      string     `json:"md5,omitempty"`
+}
+
+// itemMetadata describes a listed file for its sidecar
+func itemMetadata(providerName string, item provider.Item) []byte {
+	sidecar := itemSidecar{
+		Provider: providerName,
+		ID:       item.ID,
+		Name:     item.Name,
+		Size:     item.Size,
+		CRC32:    item.CRC32,
+		MD5:      item.MD5,
+	}
+	if !item.ModTime.IsZero() {
+		sidecar.Modified = &item.ModTime
+	}
+	data, err := json.MarshalIndent(sidecar, "", "  ")
+	if err != nil {
+		return nil
+	}
+	return data
 }
 
-// traverseFolder recursively builds the file list & tracks sizes
-func traverseFolder(apiKey, path, parentZipPath string, files *[]*zipstreamer.FileEntry, rootPath string) error {
-	apiResponse, err := fetchFolderContents(apiKey, path)
+// resolveSource expands a provider reference from a descriptor. The path is
+// listed as a folder first; otherwise the file is looked up in its parent.
+func resolveSource(source provider.Provider, ref zipstreamer.SourceRef, options *zipOptions, entries *entrySet) error {
+	if ref.ID != "" {
+		return resolveSourceID(source, ref, options, entries)
+	}
+	if folder, err := source.List(ref.Path); err == nil {
+		entries.roots = append(entries.roots, options.rootArchiveName(rootZipName(ref.Path, folder)))
+		return addFolder(source, folder, ref.Path, ref.ZipPath, time.Time{}, options, entries)
+	}
+
+	parentPath, name := path.Split(strings.TrimRight(ref.Path, "/"))
+	parent, err := source.List(parentPath)
 	if err != nil {
 		return err
 	}
 
-	var relativeZipPath string
-	if path == rootPath {
-		relativeZipPath = filepath.Base(rootPath)
-	} else {
-		relativeZipPath = filepath.Join(parentZipPath, apiResponse.Name)
+	for _, item := range parent.Items {
+		if item.Name != name {
+			continue
+		}
+		zipPath := ref.ZipPath
+		if zipPath == "" {
+			zipPath = item.Name
+		}
+		if item.IsDir {
+			entries.roots = append(entries.roots, options.rootArchiveName(item.Name))
+			return traverseFolder(source, item.Path, zipPath, item.ModTime, options, entries)
+		}
+		entries.roots = append(entries.roots, strings.TrimSuffix(item.Name, path.Ext(item.Name)))
+		addFileItem(item, zipPath, options, entries)
+		return nil
 	}
 
-	for _, item := range apiResponse.Content {
-		currentZipPath := filepath.Join(relativeZipPath, item.Name)
+	return fmt.Errorf("%s not found", ref.Path)
+}
+
+// resolveSourceID expands a provider reference by item ID
+func resolveSourceID(source provider.Provider, ref zipstreamer.SourceRef, options *zipOptions, entries *entrySet) error {
+	resolver, ok := source.(provider.ItemResolver)
+	if !ok {
+		return fmt.Errorf("provider %s can't look up items by ID", ref.Provider)
+	}
+	item, err := resolver.Item(ref.ID)
+	if err != nil {
+		return err
+	}
 
-		if item.Type == "file" {
-			entry, err := zipstreamer.NewFileEntry(item.DirectLink, currentZipPath)
-			if err == nil {
-				*files = append(*files, entry)
-				fileSizeMap[currentZipPath] = item.Size // Store file size in map
-			}
-		} else if item.Type == "folder" {
-			err := traverseFolder(apiKey, filepath.Join(path, item.Name), relativeZipPath, files, rootPath)
-			if err != nil {
-				return err
-			}
+	zipPath := ref.ZipPath
+	if !item.IsDir {
+		if zipPath == "" {
+			zipPath = item.Name
 		}
+		entries.roots = append(entries.roots, strings.TrimSuffix(item.Name, path.Ext(item.Name)))
+		addFileItem(*item, zipPath, options, entries)
+		return nil
+	}
+	entries.roots = append(entries.roots, options.rootArchiveName(item.Name))
+	// Paths of folders found by ID may be opaque, so the root is named after the folder
+	if zipPath == "" {
+		zipPath = options.rootZipPath(item.Name, &provider.Folder{Name: item.Name})
 	}
+	return traverseFolder(source, item.Path, zipPath, item.ModTime, options, entries)
+}
 
-	return nil
+// rootZipName returns the top-level zip folder for a requested root. Share
+// links have no meaningful basename, so the listed folder name is used instead.
+func rootZipName(rootPath string, folder *provider.Folder) string {
+	if u, err := url.Parse(rootPath); err == nil && u.Scheme != "" && folder.Name != "" {
+		return folder.Name
+	}
+	return filepath.Base(rootPath)
 }
 
-// calculateZipSize computes the estimated ZIP file size
-func calculateZipSize(files []*zipstreamer.FileEntry) (int64, int64, int64, int64) {
-	const localHeaderSize = 30
-	const centralDirSize = 46
-	const eocdSize = 22
+// Sizes of the records archive/zip writes
+const (
+	localHeaderSize    = 30
+	centralDirSize     = 46
+	eocdSize           = 22
+	extTimeSize        = 9  // Extended timestamp extra field, in both headers
+	dataDescriptorSize = 16 // Without Zip64, which needs 4GiB entries
+)
 
+// calculateZipSize computes the estimated ZIP file size. It matches the
+// layout written by archive/zip: every header carries an extended timestamp
+// plus the NTFS times and owner of entries that have them, and streamed
+// entries are followed by a data descriptor, while raw entries have their
+// sizes in the local header.
+func calculateZipSize(files []*zipstreamer.FileEntry) (int64, int64, int64, int64) {
 	var totalLocalHeaders int64
 	var totalFileData int64
 	var totalCentralDir int64
+	var totalDescriptors int64
 
 	for _, file := range files {
-		var zipPath string
-		if zipPathMethod, ok := interface{}(file).(interface{ ZipPath() string }); ok {
-			zipPath = zipPathMethod.ZipPath()
-		} else {
-			zipPath = file.ZipPath()
+		filenameLen := int64(len(file.ZipPath()))
+		fileSize := file.Size()
+		if fileSize < 0 {
+			fileSize = 0
 		}
 
-		filenameLen := int64(len(zipPath))
-		fileSize := fileSizeMap[zipPath]
+		extraLen := extTimeSize + file.ExtraFieldsSize()
+		if file.Raw() == nil && !file.IsDir() {
+			totalDescriptors += dataDescriptorSize
+		}
 
-		totalLocalHeaders += localHeaderSize + filenameLen
+		totalLocalHeaders += localHeaderSize + filenameLen + extraLen
 		totalFileData += fileSize
-		totalCentralDir += centralDirSize + filenameLen
+		totalCentralDir += centralDirSize + filenameLen + extraLen
 	}
 
-	totalZipSize := totalLocalHeaders + totalFileData + totalCentralDir + eocdSize
+	totalZipSize := totalLocalHeaders + totalFileData + totalDescriptors + totalCentralDir + eocdSize
 
 	// Log the size breakdown
 	fmt.Printf("ZIP Size Breakdown:\n")
 	fmt.Printf("  - Local Headers: %d bytes\n", totalLocalHeaders)
 	fmt.Printf("  - File Data: %d bytes\n", totalFileData)
+	fmt.Printf("  - Data Descriptors: %d bytes\n", totalDescriptors)
 	fmt.Printf("  - Central Directory: %d bytes\n", totalCentralDir)
 	fmt.Printf("  - End of Central Directory: %d bytes\n", eocdSize)
 	fmt.Printf("  - Total ZIP Size: %d bytes\n", totalZipSize)
@@ -133,56 +285,387 @@ func calculateZipSize(files []*zipstreamer.FileEntry) (int64, int64, int64, int6
 
 // zipHandler handles API requests to generate ZIP
 func zipHandler(w http.ResponseWriter, r *http.Request) {
+	w, finishAudit := startAudit(w, r)
+	defer finishAudit()
+	audit := auditRecordOf(w)
+
+	if r.Method == "GET" && r.URL.Query().Get("urls") != "" {
+		processURLListRequest(w, withJobRequest(r, nil), []byte(r.URL.Query().Get("urls")))
+		return
+	}
+
 	if r.Method == "GET" {
 		apiKey := r.URL.Query().Get("apikey")
 		pathsParam := r.URL.Query().Get("paths")
+		idsParam := r.URL.Query().Get("ids")
+		magnet := r.URL.Query().Get("magnet") // Magnet link or torrent URL
+		providerName := r.URL.Query().Get("provider")
+		if providerName == "" {
+			providerName = "premiumize"
+		}
+		if audit != nil {
+			audit.Provider = providerName
+			if apiKey != "" {
+				audit.APIKeys = []string{keyFingerprint(apiKey)}
+			}
+		}
 
-		if apiKey == "" || pathsParam == "" {
+		if apiKey == "" || (pathsParam == "" && idsParam == "" && magnet == "") {
 			http.Error(w, "Missing API key or paths", http.StatusBadRequest)
 			return
 		}
 
-		var paths []string
-		err := json.Unmarshal([]byte(pathsParam), &paths)
+		var paths, ids []string
+		if pathsParam != "" {
+			if err := json.Unmarshal([]byte(pathsParam), &paths); err != nil {
+				http.Error(w, "Invalid paths parameter", http.StatusBadRequest)
+				return
+			}
+		}
+		if idsParam != "" {
+			if err := json.Unmarshal([]byte(idsParam), &ids); err != nil {
+				http.Error(w, "Invalid ids parameter", http.StatusBadRequest)
+				return
+			}
+		}
+		refs := make([]zipstreamer.SourceRef, 0, len(paths)+len(ids))
+		for _, rootPath := range paths {
+			refs = append(refs, zipstreamer.SourceRef{Provider: providerName, Path: rootPath})
+		}
+		for _, id := range ids {
+			refs = append(refs, zipstreamer.SourceRef{Provider: providerName, ID: id})
+		}
+		if audit != nil {
+			audit.Paths = paths
+			for _, id := range ids {
+				audit.Paths = append(audit.Paths, "id:"+id)
+			}
+			if magnet != "" {
+				audit.Paths = append(audit.Paths, magnet)
+			}
+		}
+
+		source, err := provider.New(providerName, apiKey, r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		options, err := parseZipOptions(r)
 		if err != nil {
-			http.Error(w, "Invalid paths parameter", http.StatusBadRequest)
+			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 
-		processZipRequest(w, apiKey, paths)
+		r, release, ok := claimIdempotencyKey(w, r, nil)
+		if !ok {
+			return
+		}
+		defer release()
+		if magnet != "" {
+			processTransferRequest(w, r, source, magnet, nil, options)
+			return
+		}
+		processZipRequest(w, withJobRequest(r, nil), providerName, source, refs, options)
+		return
+	}
+
+	if r.Method == "POST" {
+		payload, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, "Failed to read request body", http.StatusBadRequest)
+			return
+		}
+		if isFormPost(r, payload) {
+			if payload, err = formDescriptor(r, payload); err != nil {
+				http.Error(w, "Invalid form: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		if r.Header.Get("Content-Type") == torrentContentType {
+			processTorrentUpload(w, r, payload)
+			return
+		}
+		r = withJobRequest(r, payload)
+
+		// A bare JSON array is a list of direct URLs
+		if trimmed := bytes.TrimSpace(payload); len(trimmed) > 0 && trimmed[0] == '[' {
+			processURLListRequest(w, r, payload)
+			return
+		}
+
+		if err := validateBody(payload, "Descriptor", "Invalid zip descriptor"); err != nil {
+			writeBadRequest(w, err)
+			return
+		}
+		descriptor, err := zipstreamer.UnmarshalJsonZipDescriptor(payload)
+		if err != nil {
+			http.Error(w, "Invalid zip descriptor: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		auditDescriptor(audit, descriptor)
+
+		options, err := descriptorOptions(r, descriptor)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		r, release, ok := claimIdempotencyKey(w, r, payload)
+		if !ok {
+			return
+		}
+		defer release()
+		processDescriptorRequest(w, r, descriptor, options)
 		return
 	}
 
 	http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
 }
 
+// descriptorOptions reads the archive options of a descriptor request. The
+// query takes precedence over the descriptor's filename and compression.
+func descriptorOptions(r *http.Request, descriptor *zipstreamer.ZipDescriptor) (*zipOptions, error) {
+	options, err := parseZipOptions(r)
+	if err != nil {
+		return nil, err
+	}
+	if options.filename == "" {
+		options.filename = descriptor.SuggestedFilename()
+	}
+	if options.compression == "" && descriptor.Compression() != "" {
+		if _, err := parseCompressionMethod(descriptor.Compression()); err != nil {
+			return nil, err
+		}
+		options.compression = descriptor.Compression()
+	}
+	return options, nil
+}
+
+// auditDescriptor records the provider sources and credentials of a descriptor
+func auditDescriptor(audit *auditRecord, descriptor *zipstreamer.ZipDescriptor) {
+	if audit == nil {
+		return
+	}
+	for _, ref := range descriptor.Sources() {
+		audit.Paths = append(audit.Paths, ref.Provider+":"+sourceName(ref))
+		key := ref.Credential
+		if key == "" {
+			key = descriptor.Credential(ref.Provider)
+		}
+		if key != "" {
+			audit.APIKeys = append(audit.APIKeys, keyFingerprint(key))
+		}
+	}
+}
+
+// processURLListRequest streams a JSON array of direct URLs, which needs no
+// provider account. The list is the POST body or the urls parameter of a GET.
+func processURLListRequest(w http.ResponseWriter, r *http.Request, payload []byte) {
+	if err := validateBody(payload, "URLList", "Invalid URL list"); err != nil {
+		writeBadRequest(w, err)
+		return
+	}
+	descriptor, err := zipstreamer.UnmarshalJsonURLList(payload)
+	if err != nil {
+		http.Error(w, "Invalid URL list: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	options, err := parseZipOptions(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	r, release, ok := claimIdempotencyKey(w, r, payload)
+	if !ok {
+		return
+	}
+	defer release()
+	processDescriptorRequest(w, r, descriptor, options)
+}
+
 // Function to handle ZIP processing
-func processZipRequest(w http.ResponseWriter, apiKey string, paths []string) {
-	fileSizeMap = make(map[string]int64) // Initialize file size map
-	var fileEntries []*zipstreamer.FileEntry
-
-	// Recursively fetch all files and subfolders
-	for _, rootPath := range paths {
-		fmt.Printf("Processing folder: %s\n", rootPath)
-		err := traverseFolder(apiKey, rootPath, "", &fileEntries, rootPath)
+func processZipRequest(w http.ResponseWriter, r *http.Request, providerName string, source provider.Provider, refs []zipstreamer.SourceRef, options *zipOptions) {
+	names := make([]string, 0, len(refs))
+	for _, ref := range refs {
+		names = append(names, sourceName(ref))
+	}
+	entries := &entrySet{source: fmt.Sprintf("%s %s", providerName, strings.Join(names, ", "))}
+	entries.client = provider.HTTPClient(providerName)
+	entries.provider = providerName
+
+	// Recursively fetch all files and subfolders. Paths may also name single
+	// files, so selections can mix files and folders.
+	for _, ref := range refs {
+		fmt.Printf("Processing folder: %s\n", sourceName(ref))
+		err := resolveSource(source, ref, options, entries)
 		if err != nil {
-			fmt.Printf("Error processing %s: %v\n", rootPath, err)
+			fmt.Printf("Error processing %s: %v\n", sourceName(ref), err)
 		}
 	}
 
+	streamZip(w, r, entries, options)
+}
+
+// sourceName describes a provider reference in logs
+func sourceName(ref zipstreamer.SourceRef) string {
+	if ref.ID != "" {
+		return "id:" + ref.ID
+	}
+	return ref.Path
+}
+
+// descriptorSource describes the files, provider sources and remote zips of
+// a descriptor in logs and jobs
+func descriptorSource(descriptor *zipstreamer.ZipDescriptor) string {
+	source := fmt.Sprintf("descriptor with %d URLs", len(descriptor.Files()))
+	for _, ref := range descriptor.Sources() {
+		source += fmt.Sprintf(", %s %s", ref.Provider, sourceName(ref))
+	}
+	for _, remote := range descriptor.RemoteZips() {
+		source += fmt.Sprintf(", zip %s", remote.ZipPath())
+	}
+	return source
+}
+
+// processDescriptorRequest streams a JSON descriptor whose entries may mix
+// plain URLs with paths on any registered provider
+func processDescriptorRequest(w http.ResponseWriter, r *http.Request, descriptor *zipstreamer.ZipDescriptor, options *zipOptions) {
+	entries := &entrySet{files: append([]*zipstreamer.FileEntry{}, descriptor.Files()...), source: descriptorSource(descriptor)}
+
+	// Merged descriptors may use several accounts of the same provider
+	sources := make(map[string]provider.Provider)
+	for _, ref := range descriptor.Sources() {
+		credential := ref.Credential
+		if credential == "" {
+			credential = descriptor.Credential(ref.Provider)
+		}
+		key := ref.Provider + "\x00" + credential
+		source, ok := sources[key]
+		if !ok {
+			var err error
+			source, err = provider.New(ref.Provider, credential, r.URL.Query())
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			sources[key] = source
+		}
+
+		fmt.Printf("Processing %s source: %s\n", ref.Provider, sourceName(ref))
+		entries.client = provider.HTTPClient(ref.Provider)
+		entries.provider = ref.Provider
+		resolved, skipped := len(entries.files), len(entries.skipped)
+		if err := resolveSource(source, ref, options, entries); err != nil {
+			fmt.Printf("Error processing %s: %v\n", sourceName(ref), err)
+		}
+		if err := entries.prefix(resolved, skipped, ref.Prefix); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	for _, remote := range descriptor.RemoteZips() {
+		fmt.Printf("Expanding remote zip into %s/\n", remote.ZipPath())
+		expanded, failed, err := zipstreamer.ExpandRemoteZip(r.Context(), nil, remote, remote.ZipPath())
+		if err != nil {
+			entries.skip(remote.ZipPath()+"/", fmt.Sprintf("failed to read remote zip: %v", err))
+			continue
+		}
+		entries.files = append(entries.files, expanded...)
+		for _, failure := range failed {
+			entries.skip(failure.ZipPath, failure.Err.Error())
+		}
+	}
+
+	streamZip(w, r, entries, options)
+}
+
+// streamZip writes the collected entries to the response as a ZIP
+func streamZip(w http.ResponseWriter, r *http.Request, entries *entrySet, options *zipOptions) {
+	fileEntries := entries.files
+	if options.normalize {
+		fileEntries = zipstreamer.NormalizeZipPaths(fileEntries, options.normForm)
+	}
+	if options.windowsSafe {
+		fileEntries = zipstreamer.SanitizeWindowsZipPaths(fileEntries)
+	}
+
+	if options.flatten {
+		fileEntries = zipstreamer.FlattenZipPaths(fileEntries)
+	}
+
+	if duplicates := zipstreamer.DuplicateZipPaths(fileEntries); len(duplicates) > 0 {
+		// Collisions are expected when flattening, so they are always renamed
+		if options.rejectDupes && !options.flatten {
+			http.Error(w, "Duplicate zip paths: "+strings.Join(duplicates, ", "), http.StatusBadRequest)
+			return
+		}
+		fileEntries = renameDuplicates(fileEntries)
+	}
+	if options.sorted {
+		fileEntries = zipstreamer.SortEntries(fileEntries)
+	}
+	// Sidecars follow the final names of their files
+	if options.sidecars {
+		fileEntries = zipstreamer.AddSidecars(fileEntries, sidecarSuffix)
+	}
+
+	if config().maxEntries > 0 && len(fileEntries) > config().maxEntries {
+		if !config().truncateEntries {
+			message := fmt.Sprintf("Archive has %d entries, exceeding the limit of %d", len(fileEntries), config().maxEntries)
+			http.Error(w, message, http.StatusRequestEntityTooLarge)
+			return
+		}
+		fileEntries = truncateEntries(fileEntries, config().maxEntries)
+	}
+
+	if manifest := entries.skippedManifest(); manifest != nil {
+		fileEntries = append(fileEntries, manifest)
+	}
+
 	// Handle empty folder case
 	if len(fileEntries) == 0 {
 		fmt.Println("Empty folder detected. Returning an empty ZIP.")
 		w.Header().Set("Content-Type", "application/zip")
-		w.Header().Set("Content-Disposition", "attachment; filename=empty.zip")
+		if options.disposition != "" {
+			w.Header().Set("Content-Disposition", options.disposition+"; filename=empty.zip")
+		}
 
 		zipWriter := zip.NewWriter(w)
 		zipWriter.Close()
 		return
 	}
 
+	if options.reproducible {
+		zipstreamer.SetReproducibleTimes(fileEntries)
+	}
+	if options.filename == "" {
+		options.filename = entries.archiveName()
+	}
+
+	jobID := requestJobID(r)
+	previous := resumedJob(r)
+	comment := archiveComment(jobID, entries, options.reproducible)
+	if options.index {
+		writeArchiveIndex(w, fileEntries, comment, options)
+		return
+	}
+	fmt.Printf("Job %s: %s\n", jobID, entries.source)
+	audit := auditRecordOf(w)
+	if audit != nil {
+		audit.JobID = jobID
+		audit.Entries = len(fileEntries)
+	}
+	if servesSingleFile(fileEntries, options) {
+		streamSingleFile(w, r, entries, fileEntries[0], jobID, options)
+		return
+	}
+
 	// Compute ZIP size breakdown
 	zipSize, totalLocalHeaders, totalFileData, totalCentralDir := calculateZipSize(fileEntries)
+	zipSize += int64(len(comment))
 
 	// Log the computed ZIP size details
 	fmt.Printf("\nFinal ZIP Size: %d bytes\n", zipSize)
@@ -190,40 +673,383 @@ func processZipRequest(w http.ResponseWriter, apiKey string, paths []string) {
 	fmt.Printf("  - Actual File Data: %d bytes\n", totalFileData)
 	fmt.Printf("  - Central Directory: %d bytes\n", totalCentralDir)
 
+	if config().maxArchiveSize > 0 && zipSize > config().maxArchiveSize {
+		message := fmt.Sprintf("Archive size %d bytes exceeds the limit of %d bytes", zipSize, config().maxArchiveSize)
+		http.Error(w, message, http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	if options.preflight {
+		if dead := zipstreamer.Preflight(nil, fileEntries); len(dead) > 0 {
+			writeDeadLinks(w, dead)
+			return
+		}
+	}
+
+	var target archiveTarget
+	var resume *jobCheckpoint
+	request := resumableRequest(r, options)
+	if options.target != "" {
+		var err error
+		if target, resume, err = openJobTarget(r, jobID, request != nil, fileEntries, options); err != nil {
+			fmt.Printf("Job %s: failed to open %s target: %v\n", jobID, options.target, err)
+			http.Error(w, "Failed to open archive target", http.StatusBadGateway)
+			return
+		}
+	}
+
 	// Set headers for ZIP download
-	w.Header().Set("Content-Type", "application/zip")
-	w.Header().Set("Content-Disposition", "attachment; filename=archive.zip")
-	w.Header().Set("Content-Length", fmt.Sprintf("%d", zipSize))
-	w.Header().Set("Accept-Ranges", "bytes") // Enables Range Requests
+	if target == nil || options.tee {
+		w.Header().Set("Content-Type", "application/zip")
+		if options.disposition != "" {
+			w.Header().Set("Content-Disposition", zipstreamer.ContentDisposition(options.disposition, options.filename))
+		}
+		w.Header().Set("X-Zip-Job-Id", jobID)
+		// Sizes of plain URL entries are unknown, so the length can only be declared when all are listed.
+		// HTTP/1.1 only carries trailers on chunked responses, so clients asking for them get no length.
+		// A deadline or content type policy can append a failure manifest, so the length is unknown.
+		// Compressed sizes are only known once the data is compressed.
+		// Past 4GiB archive/zip adds Zip64 records that the estimate leaves out.
+		// The manifest is only written once every file was fetched, and converted files change size.
+		// Infected files may be replaced by warnings of another size, and alignment pads headers.
+		if options.align < 2 && allSizesKnown(fileEntries) && !options.trailers && config().jobDeadline == 0 && config().contentTypes.Empty() && !options.manifest &&
+			!options.rewritesData() && options.method() == zip.Store && zipSize < math.MaxUint32 &&
+			(virusScanner == nil || config().scanPolicy != zipstreamer.ScanReplace) {
+			w.Header().Set("Content-Length", fmt.Sprintf("%d", zipSize))
+		}
+		w.Header().Set("Accept-Ranges", "bytes") // Enables Range Requests
+	}
 
 	// Create ZIP stream
-	zipStream, err := zipstreamer.NewZipStream(fileEntries, w)
+	var out io.Writer = w
+	if target != nil {
+		out = target
+		if options.tee {
+			out = &teeWriter{w: w, target: target}
+		}
+	}
+	checksum := newChecksumWriter(out)
+	zipStream, err := zipstreamer.NewZipStream(fileEntries, checksum)
+	if err == nil && resume != nil {
+		err = checksum.restore(resume.SHA256, resume.CRC32)
+		zipStream.Resume = resume.Stream
+	}
 	if err != nil {
+		if target != nil {
+			target.abort()
+		}
 		http.Error(w, "Failed to create ZIP stream", http.StatusInternalServerError)
 		return
 	}
 
-	if err := zipStream.StreamAllFiles(); err != nil {
-		http.Error(w, "Failed to stream ZIP", http.StatusInternalServerError)
+	zipStream.Context = r.Context()
+	zipStream.HashEntries = options.hashEntries
+	if options.manifest {
+		zipStream.Manifest = sourceManifestName
+	}
+	zipStream.Comment = comment
+	zipStream.Alignment = options.align
+	zipStream.Reproducible = options.reproducible
+	if options.images != nil {
+		zipStream.Use(options.images.Middleware())
+	}
+	if options.text != nil {
+		zipStream.Use(options.text.Middleware())
+	}
+	if method := options.method(); method != zip.Store {
+		zipStream.CompressionMethod = method
+		zipStream.CompressionLevel = options.compressionLevel()
+		zipStream.CompressionPolicy = config().compressionPolicy
+		zipStream.Parallelism = config().compressionWorkers
+	}
+	zipStream.EntryTimeout = config().entryTimeout
+	zipStream.StallTimeout = config().stallTimeout
+	zipStream.MinThroughput = config().minThroughput
+	// Only clients need keeping alive, and held-back bytes would keep checkpoints from being taken
+	if target == nil || options.tee {
+		zipStream.KeepaliveInterval = config().keepalive
+	}
+	zipStream.Cache = etagCache
+	zipStream.DiskCache = diskCache
+	zipStream.MemoryCache = memoryCache
+	zipStream.Memory = memoryBudget
+	zipStream.Scanner = virusScanner
+	zipStream.ScanPolicy = config().scanPolicy
+	if !config().contentTypes.Empty() {
+		zipStream.ContentTypes = config().contentTypes
+		zipStream.FailureManifest = failedManifestName
 	}
+	if config().cookieJar {
+		zipStream.Jar = zipstreamer.NewCookieJar()
+	}
+	if config().jobDeadline > 0 {
+		zipStream.Deadline = time.Now().Add(config().jobDeadline)
+		zipStream.FailureManifest = failedManifestName
+	}
+
+	job := &jobRecord{
+		ID:       jobID,
+		Status:   jobRunning,
+		Source:   entries.source,
+		Filename: options.filename,
+		Entries:  len(fileEntries),
+		Created:  time.Now().UTC(),
+	}
+	if previous != nil {
+		job.Created, job.Resumes = previous.record.Created, previous.record.Resumes
+	}
+	if request != nil {
+		checkpointJob(zipStream, target.(resumableTarget), checksum, job, request, resume, fileEntries)
+	}
+	stopTracking := jobHistory.track(job, zipStream.Progress)
+
+	if target == nil || options.tee {
+		declareTrailers(w, options)
+	}
+	_, err = zipStream.StreamAllFiles()
+	if err != nil {
+		fmt.Printf("Failed to stream ZIP: %v\n", err)
+	}
+	fmt.Printf("Archive SHA-256: %s\n", checksum.SHA256())
+	fmt.Printf("Archive stats: %s\n", zipStream.Stats())
+	var targetErr error
+	var link string
+	if target != nil {
+		job.URL, targetErr = finishTarget(target, jobID, err)
+		if !options.tee {
+			// The stored archive is the only result, so failing to store it fails the job
+			err = targetErr
+		}
+		w.Header().Set(targetURLTrailer, job.URL)
+		link = archiveLink(target, job)
+		w.Header().Set(downloadURLTrailer, link)
+	}
+	writeTrailers(w, entries, zipStream.Failed(), checksum, err)
+
+	stopTracking()
+	job.Status = w.Header().Get(statusTrailer)
+	job.Done, job.Bytes = zipStream.Progress()
+	job.Failed = len(entries.skipped) + len(zipStream.Failed())
+	job.Resume = nil
+	if err != nil {
+		job.Error = err.Error()
+	} else if targetErr != nil {
+		job.Error = targetErr.Error()
+	}
+	jobHistory.put(job)
+	if target != nil && !options.tee {
+		noteStoredJob(r, job)
+	}
+	jobMailer.notify(options.notify, job, link)
+	if audit != nil {
+		audit.Failed = job.Failed
+		audit.Outcome = job.Status
+		audit.Error = job.Error
+	}
+	if options.hashEntries {
+		writeEntryHashesTrailer(w, zipStream.EntryHashes())
+	}
+	// Without a streamed archive, the trailers set above go out as headers of the result
+	if target != nil && !options.tee {
+		writeTargetResult(w, job, link, checksum.SHA256(), failedZipPaths(entries, zipStream.Failed()), err)
+	}
+}
+
+// writeDeadLinks rejects the request with the entries that failed preflight
+func writeDeadLinks(w http.ResponseWriter, dead []zipstreamer.FailedEntry) {
+	type deadLink struct {
+		Path   string `json:"path"`
+		Error  string `json:"error"`
+		Status int    `json:"status,omitempty"` // Of the upstream response, when there was one
+	}
+	links := make([]deadLink, 0, len(dead))
+	for _, failure := range dead {
+		link := deadLink{Path: failure.ZipPath, Error: failure.Err.Error()}
+		var fetchErr *zipstreamer.ErrFetchFailed
+		if errors.As(failure.Err, &fetchErr) {
+			link.Status = fetchErr.Status
+		}
+		links = append(links, link)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusFailedDependency)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error":     fmt.Sprintf("%d entries are unreachable", len(dead)),
+		"deadLinks": links,
+	})
+}
+
+// renameDuplicates renames entries sharing a zip path
+func renameDuplicates(files []*zipstreamer.FileEntry) []*zipstreamer.FileEntry {
+	renamed := zipstreamer.RenameDuplicateZipPaths(files)
+	for i, file := range renamed {
+		if file != files[i] {
+			fmt.Printf("Renaming duplicate entry %s to %s\n", files[i].ZipPath(), file.ZipPath())
+		}
+	}
+	return renamed
+}
+
+// truncateEntries keeps the first max entries and appends a manifest listing the omitted ones
+func truncateEntries(files []*zipstreamer.FileEntry, max int) []*zipstreamer.FileEntry {
+	omitted := files[max:]
+	fmt.Printf("Truncating archive from %d to %d entries\n", len(files), max)
+
+	var manifest strings.Builder
+	fmt.Fprintf(&manifest, "This archive was truncated to %d entries. The following %d entries were omitted:\n\n", max, len(omitted))
+	for _, file := range omitted {
+		manifest.WriteString(file.ZipPath() + "\n")
+	}
+
+	files = files[:max]
+	entry, err := zipstreamer.NewInlineFileEntry(truncatedManifestName, []byte(manifest.String()))
+	if err == nil {
+		files = append(files, entry)
+	}
+	return files
+}
+
+// allSizesKnown reports whether the stored size of every file entry is known
+// up front. gzip sources are stored with their compressed size, which is not.
+func allSizesKnown(files []*zipstreamer.FileEntry) bool {
+	for _, file := range files {
+		if file.IsDir() {
+			continue
+		}
+		if file.Size() < 0 || file.IsGzipSource() {
+			return false
+		}
+	}
+	return true
 }
 
 func main() {
+	if handled, err := serviceCommand(os.Args[1:]); handled {
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+	configureClients(config(), nil)
+	if config().jobStore != "" {
+		var err error
+		if jobHistory, err = openJobStore(config().jobStore, config().jobRetention); err != nil {
+			fmt.Printf("Error opening job store: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if config().auditLog != "" {
+		var err error
+		if auditTrail, err = openAuditLog(config().auditLog); err != nil {
+			fmt.Printf("Error opening audit log: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if config().etagCacheSize > 0 {
+		etagCache = zipstreamer.NewETagCache(config().etagCacheEntry, config().etagCacheSize)
+	}
+	if config().memCacheSize > 0 {
+		memoryCache = zipstreamer.NewMemoryCache(config().memCacheEntry, config().memCacheSize, config().memCacheTTL)
+	}
+	if config().diskCacheDir != "" {
+		var err error
+		diskCache, err = zipstreamer.NewDiskCache(config().diskCacheDir, config().diskCacheSize, config().diskCacheHits)
+		if err != nil {
+			fmt.Printf("Disk cache disabled: %v\n", err)
+		}
+	}
+
+	if config().s3 != nil {
+		if err := config().s3.validate(); err != nil {
+			fmt.Printf("Error configuring S3 target: %v\n", err)
+			os.Exit(1)
+		}
+		registerTarget("s3", openS3Target(config().s3), resumeS3Target(config().s3))
+	}
+	if config().gcs != nil {
+		tokens, err := config().gcs.tokenSource()
+		if err == nil {
+			err = config().gcs.validate()
+		}
+		if err != nil {
+			fmt.Printf("Error configuring GCS target: %v\n", err)
+			os.Exit(1)
+		}
+		registerTarget("gcs", openGCSTarget(config().gcs, tokens), resumeGCSTarget(config().gcs, tokens))
+	}
+	if config().localTargetDir != "" {
+		dir, err := filepath.Abs(config().localTargetDir)
+		if err == nil {
+			err = os.MkdirAll(dir, 0755)
+		}
+		if err != nil {
+			fmt.Printf("Error configuring local target: %v\n", err)
+			os.Exit(1)
+		}
+		registerTarget("local", openLocalTarget(dir), resumeLocalTarget(dir))
+	}
+
+	if config().smtpAddr != "" {
+		var err error
+		if jobMailer, err = newMailer(config().smtpAddr, config().smtpFrom, config().smtpUsername, config().smtpPassword, config().notifyDomains); err != nil {
+			fmt.Printf("Error configuring notifications: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if config().clamdAddr != "" {
+		scanner, err := zipstreamer.NewClamdScanner(config().clamdAddr, config().clamdTimeout)
+		if err != nil {
+			fmt.Printf("Error configuring clamd: %v\n", err)
+			os.Exit(1)
+		}
+		virusScanner = scanner
+	}
+
+	if config().memoryBudget > 0 {
+		memoryBudget = zipstreamer.NewMemoryBudget(config().memoryBudget)
+	}
+	// Metrics served on the admin endpoints' /debug/vars
+	expvar.Publish("memory_budget", expvar.Func(func() any { return memoryBudget.Limit() }))
+	expvar.Publish("memory_in_use", expvar.Func(func() any { return memoryBudget.InUse() }))
+
 	r := mux.NewRouter()
 
-	// If serving an HTML page, re-add this:
-	r.HandleFunc("/", serveHTML).Methods("GET")
+	startUI(r)
+	startBrowse(r)
 
-	// Handle ZIP streaming requests
-	r.HandleFunc("/create-zip", zipHandler).Methods("GET", "POST")
+	// Handle ZIP streaming requests, or queue them for workers
+	if err := startDistributed(r); err != nil {
+		fmt.Printf("Error starting %s mode: %v\n", config().mode, err)
+		os.Exit(1)
+	}
 
-	fmt.Println("Server started on :80")
-	if err := http.ListenAndServe(":80", r); err != nil {
-		fmt.Printf("Error starting server: %v\n", err)
+	startAdmin(r)
+	startOpenAPI(r)
+	startJobHistory(r)
+	startSignedLinks(r)
+	startSelfTest(r)
+	watchReload()
+	if err := startGRPC(); err != nil {
+		fmt.Printf("Error starting gRPC server: %v\n", err)
 		os.Exit(1)
 	}
-}
+	// Front-ends build no archives themselves
+	if config().mode != modeFrontend {
+		go resumeJobs()
+	}
 
-func serveHTML(w http.ResponseWriter, r *http.Request) {
-	http.ServeFile(w, r, "index.html")
+	listener, err := listen()
+	if err != nil {
+		fmt.Printf("Error starting server: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Server started on %s\n", listener.Addr())
+	if err := serve(&http.Server{Handler: r}, listener); err != nil {
+		fmt.Printf("Error serving: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Println("Server stopped")
 }
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// mailer emails the outcome of archive jobs to the address given with the
+// notify parameter. A nil mailer sends nothing.
+type mailer struct {
+	addr    string // SMTP server as host:port
+	from    *mail.Address
+	auth    smtp.Auth // nil sends without authentication
+	domains []string  // Allowed recipient domains; empty allows any
+}
+
+// jobMailer is the configured mailer, nil when SMTP is not configured
+var jobMailer *mailer
+
+// newMailer configures the SMTP server that notifications are sent through
+func newMailer(addr, from, username, password string, domains []string) (*mailer, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SMTP address: %v", err)
+	}
+	sender, err := mail.ParseAddress(from)
+	if err != nil {
+		return nil, fmt.Errorf("invalid sender address: %v", err)
+	}
+	m := &mailer{addr: addr, from: sender, domains: domains}
+	if username != "" {
+		// PlainAuth refuses to send the password unless the connection is
+		// encrypted or goes to localhost
+		m.auth = smtp.PlainAuth("", username, password, host)
+	}
+	return m, nil
+}
+
+// recipient validates a notification address against the allowed domains,
+// so the server can't be used to send mail to arbitrary addresses
+func (m *mailer) recipient(address string) (string, error) {
+	if m == nil {
+		return "", errors.New("notifications are not configured")
+	}
+	parsed, err := mail.ParseAddress(address)
+	if err != nil {
+		return "", err
+	}
+	if len(m.domains) > 0 {
+		domain := strings.ToLower(parsed.Address[strings.LastIndex(parsed.Address, "@")+1:])
+		if !m.domainAllowed(domain) {
+			return "", fmt.Errorf("domain %s is not allowed", domain)
+		}
+	}
+	return parsed.Address, nil
+}
+
+// domainAllowed matches a domain exactly or, for ".example.com" entries, by suffix
+func (m *mailer) domainAllowed(domain string) bool {
+	for _, allowed := range m.domains {
+		allowed = strings.ToLower(allowed)
+		if domain == allowed || (strings.HasPrefix(allowed, ".") && strings.HasSuffix(domain, allowed)) {
+			return true
+		}
+	}
+	return false
+}
+
+// notify sends the outcome of a finished job in the background, with the
+// download link of the stored archive if there is one
+func (m *mailer) notify(to string, job *jobRecord, link string) {
+	if m == nil || to == "" {
+		return
+	}
+	go func() {
+		if err := smtp.SendMail(m.addr, m.auth, m.from.Address, []string{to}, m.message(to, job, link)); err != nil {
+			fmt.Printf("Job %s: failed to send notification: %v\n", job.ID, err)
+		}
+	}()
+}
+
+// message composes the notification for a job
+func (m *mailer) message(to string, job *jobRecord, link string) []byte {
+	filename := job.Filename
+	if filename == "" {
+		filename = "archive.zip"
+	}
+	subject := fmt.Sprintf("Archive %s is ready", filename)
+	if job.Status == "failed" {
+		subject = fmt.Sprintf("Archive %s failed", filename)
+	}
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", m.from.String())
+	fmt.Fprintf(&msg, "To: %s\r\n", to)
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n")
+
+	fmt.Fprintf(&msg, "Job:      %s\r\n", job.ID)
+	fmt.Fprintf(&msg, "Status:   %s\r\n", job.Status)
+	fmt.Fprintf(&msg, "Source:   %s\r\n", job.Source)
+	fmt.Fprintf(&msg, "Entries:  %d of %d (%d failed)\r\n", job.Done, job.Entries, job.Failed)
+	fmt.Fprintf(&msg, "Size:     %d bytes\r\n", job.Bytes)
+	if link == "" {
+		link = job.URL
+	}
+	if link != "" {
+		fmt.Fprintf(&msg, "Download: %s\r\n", link)
+	}
+	if job.Error != "" {
+		fmt.Fprintf(&msg, "Error:    %s\r\n", job.Error)
+	}
+	return msg.Bytes()
+}
@@ -0,0 +1,481 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// openAPIPath serves the OpenAPI description of the server's endpoints
+const openAPIPath = "/openapi.json"
+
+// jsonSchema is the subset of JSON Schema the specification is written in,
+// which is also what request bodies are validated against
+type jsonSchema struct {
+	Ref                  string                 `json:"$ref,omitempty"`
+	Type                 string                 `json:"type,omitempty"`
+	Format               string                 `json:"format,omitempty"` // uri, which may be empty, or date-time
+	Description          string                 `json:"description,omitempty"`
+	Enum                 []string               `json:"enum,omitempty"` // Matched ignoring case, like the parsers do
+	Pattern              string                 `json:"pattern,omitempty"`
+	Minimum              *float64               `json:"minimum,omitempty"`
+	Maximum              *float64               `json:"maximum,omitempty"`
+	MinItems             int                    `json:"minItems,omitempty"`
+	Properties           map[string]*jsonSchema `json:"properties,omitempty"`
+	Required             []string               `json:"required,omitempty"`
+	AdditionalProperties *jsonSchema            `json:"additionalProperties,omitempty"`
+	Items                *jsonSchema            `json:"items,omitempty"`
+	OneOf                []*jsonSchema          `json:"oneOf,omitempty"`
+	pattern              *regexp.Regexp
+}
+
+// fieldError is a field of a request body that doesn't match the schema
+type fieldError struct {
+	Field   string `json:"field"` // Path of the field, e.g. files[2].size; empty for the whole body
+	Message string `json:"message"`
+}
+
+// validationError rejects a request body with every field that is invalid
+type validationError struct {
+	Message string       `json:"error"`
+	Fields  []fieldError `json:"fields"`
+}
+
+func (e *validationError) Error() string {
+	problems := make([]string, 0, len(e.Fields))
+	for _, field := range e.Fields {
+		problems = append(problems, strings.TrimPrefix(field.Field+" "+field.Message, " "))
+	}
+	return e.Message + ": " + strings.Join(problems, "; ")
+}
+
+// writeBadRequest answers with a validation error as structured JSON, and with
+// any other error as text
+func writeBadRequest(w http.ResponseWriter, err error) {
+	var invalid *validationError
+	if !errors.As(err, &invalid) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(invalid)
+}
+
+func number(n float64) *float64 {
+	return &n
+}
+
+func patterned(expr, description string) *jsonSchema {
+	return &jsonSchema{Type: "string", Pattern: expr, Description: description, pattern: regexp.MustCompile(expr)}
+}
+
+func ref(name string) *jsonSchema {
+	return &jsonSchema{Ref: "#/components/schemas/" + name}
+}
+
+// schemas are the components of the specification
+var schemas = map[string]*jsonSchema{
+	"Descriptor": {
+		Type:        "object",
+		Description: "Files of an archive, or descriptors merged into one",
+		Properties: map[string]*jsonSchema{
+			"files":             {Type: "array", Items: ref("Entry")},
+			"suggestedFilename": {Type: "string", Description: "Download name; .zip is appended when missing"},
+			"compression":       {Type: "string", Enum: []string{"store", "deflate", "zstd"}},
+			"credentials":       {Type: "object", AdditionalProperties: &jsonSchema{Type: "string"}, Description: "API keys by provider name"},
+			"descriptors":       {Type: "array", Items: ref("Descriptor"), Description: "Descriptors merged into the archive, instead of files"},
+			"duplicates":        {Type: "string", Enum: []string{"rename", "first", "last", "reject"}, Description: "What happens to files merged onto the same path"},
+			"prefix":            {Type: "string", Description: "Folder the files of a merged descriptor are placed in"},
+		},
+	},
+	"Entry": {
+		Type:        "object",
+		Description: "A file downloaded from url, a folder when zipPath ends with a slash, or a provider path or ID",
+		Properties: map[string]*jsonSchema{
+			"url":            {Type: "string", Format: "uri", Description: "Empty for folders"},
+			"zipPath":        {Type: "string"},
+			"size":           {Type: "integer", Minimum: number(-1), Description: "Size in bytes, which lets the archive size be declared; -1 if unknown"},
+			"crc32":          patterned("^[0-9a-fA-F]{1,8}$", "Verified while streaming"),
+			"md5":            patterned("^[0-9a-fA-F]{32}$", "Verified while streaming"),
+			"provider":       {Type: "string", Description: "Provider resolving path or id; url for plain URLs"},
+			"path":           {Type: "string"},
+			"id":             {Type: "string"},
+			"cookies":        {Type: "object", AdditionalProperties: &jsonSchema{Type: "string"}},
+			"username":       {Type: "string"},
+			"password":       {Type: "string"},
+			"method":         {Type: "integer", Minimum: number(0), Maximum: number(65535), Description: "Zip method of already compressed data"},
+			"compressedSize": {Type: "integer", Minimum: number(0)},
+			"modified":       {Type: "string", Format: "date-time"},
+			"accessed":       {Type: "string", Format: "date-time"},
+			"created":        {Type: "string", Format: "date-time"},
+			"uid":            {Type: "integer", Minimum: number(0), Maximum: number(1<<32 - 1)},
+			"gid":            {Type: "integer", Minimum: number(0), Maximum: number(1<<32 - 1)},
+			"nested":         {Type: "boolean", Description: "Store a remote zip whole"},
+			"expand":         {Type: "boolean", Description: "Copy the entries of a remote zip under zipPath"},
+		},
+	},
+	"URLList": {
+		Type:        "array",
+		Description: "Direct URLs, named after their last path segment unless named",
+		MinItems:    1,
+		Items: &jsonSchema{OneOf: []*jsonSchema{
+			{Type: "string", Format: "uri"},
+			{
+				Type:     "object",
+				Required: []string{"url"},
+				Properties: map[string]*jsonSchema{
+					"url":  {Type: "string", Format: "uri"},
+					"name": {Type: "string"},
+					"size": {Type: "integer", Minimum: number(0)},
+				},
+			},
+		}},
+	},
+	"ArchiveRequest": {OneOf: []*jsonSchema{ref("Descriptor"), ref("URLList")}},
+	"Batch": {
+		Type:     "object",
+		Required: []string{"archives"},
+		Properties: map[string]*jsonSchema{
+			"archives": {Type: "array", MinItems: 1, Items: ref("ArchiveRequest")},
+		},
+	},
+	"FieldErrors": {
+		Type: "object",
+		Properties: map[string]*jsonSchema{
+			"error": {Type: "string"},
+			"fields": {Type: "array", Items: &jsonSchema{
+				Type: "object",
+				Properties: map[string]*jsonSchema{
+					"field":   {Type: "string"},
+					"message": {Type: "string"},
+				},
+			}},
+		},
+	},
+}
+
+// validateBody checks a JSON request body against a schema. Bodies that are
+// not JSON at all are left to the parsers, which describe the syntax error.
+func validateBody(payload []byte, schemaName, message string) error {
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	decoder.UseNumber()
+	var value any
+	if err := decoder.Decode(&value); err != nil {
+		return nil
+	}
+	if errs := validate(value, schemas[schemaName], ""); len(errs) > 0 {
+		return &validationError{Message: message, Fields: errs}
+	}
+	return nil
+}
+
+// validate returns the fields of value that don't match s, below path
+func validate(value any, s *jsonSchema, path string) []fieldError {
+	if s.Ref != "" {
+		s = schemas[strings.TrimPrefix(s.Ref, "#/components/schemas/")]
+	}
+	if len(s.OneOf) > 0 {
+		return validateOneOf(value, s.OneOf, path)
+	}
+	invalid := func(format string, args ...any) []fieldError {
+		return []fieldError{{Field: path, Message: fmt.Sprintf(format, args...)}}
+	}
+	if s.Type != "" && !hasType(value, s.Type) {
+		return invalid("must be %s %s, not %s", article(s.Type), s.Type, jsonType(value))
+	}
+
+	switch value := value.(type) {
+	case string:
+		if len(s.Enum) > 0 && !containsFold(s.Enum, value) {
+			return invalid("must be one of %s", strings.Join(s.Enum, ", "))
+		}
+		if s.pattern != nil && !s.pattern.MatchString(value) {
+			return invalid("must match %s", s.Pattern)
+		}
+		switch s.Format {
+		case "uri":
+			if value == "" {
+				break
+			}
+			if u, err := url.Parse(value); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return invalid("must be an http or https URL")
+			}
+		case "date-time":
+			if _, err := time.Parse(time.RFC3339, value); err != nil {
+				return invalid("must be an RFC 3339 date-time")
+			}
+		}
+	case json.Number:
+		n, _ := value.Float64()
+		if s.Minimum != nil && n < *s.Minimum {
+			return invalid("must be at least %v", *s.Minimum)
+		}
+		if s.Maximum != nil && n > *s.Maximum {
+			return invalid("must be at most %v", *s.Maximum)
+		}
+	case []any:
+		if len(value) < s.MinItems {
+			return invalid("must have at least %d items", s.MinItems)
+		}
+		var errs []fieldError
+		for i, item := range value {
+			if s.Items != nil {
+				errs = append(errs, validate(item, s.Items, fmt.Sprintf("%s[%d]", path, i))...)
+			}
+		}
+		return errs
+	case map[string]any:
+		var errs []fieldError
+		for _, name := range s.Required {
+			if _, ok := value[name]; !ok {
+				errs = append(errs, fieldError{Field: joinField(path, name), Message: "is required"})
+			}
+		}
+		names := make([]string, 0, len(value))
+		for name := range value {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			property := s.Properties[name]
+			if property == nil {
+				property = s.AdditionalProperties
+			}
+			// Unknown properties are ignored, as the parsers do
+			if property != nil && value[name] != nil {
+				errs = append(errs, validate(value[name], property, joinField(path, name))...)
+			}
+		}
+		return errs
+	}
+	return nil
+}
+
+// validateOneOf reports the errors of the alternative matching the type of
+// value, so a mistyped field is named instead of every alternative failing
+func validateOneOf(value any, alternatives []*jsonSchema, path string) []fieldError {
+	types := make([]string, 0, len(alternatives))
+	for _, alternative := range alternatives {
+		resolved := alternative
+		if resolved.Ref != "" {
+			resolved = schemas[strings.TrimPrefix(resolved.Ref, "#/components/schemas/")]
+		}
+		if hasType(value, resolved.Type) {
+			return validate(value, alternative, path)
+		}
+		types = append(types, resolved.Type)
+	}
+	return []fieldError{{Field: path, Message: fmt.Sprintf("must be one of %s, not %s", strings.Join(types, ", "), jsonType(value))}}
+}
+
+func joinField(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+// hasType reports whether a decoded JSON value is of a schema type
+func hasType(value any, schemaType string) bool {
+	if schemaType == "integer" {
+		number, ok := value.(json.Number)
+		_, err := number.Int64()
+		return ok && err == nil
+	}
+	return jsonType(value) == schemaType
+}
+
+// jsonType names the type of a decoded JSON value
+func jsonType(value any) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case json.Number:
+		return "number"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	}
+	return "object"
+}
+
+func article(word string) string {
+	if strings.ContainsRune("aeiou", rune(word[0])) {
+		return "an"
+	}
+	return "a"
+}
+
+func containsFold(values []string, value string) bool {
+	for _, candidate := range values {
+		if strings.EqualFold(candidate, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// queryParameter documents an option read from the query string
+type queryParameter struct {
+	name        string
+	description string
+	schema      *jsonSchema
+}
+
+// archiveParameters are the options of every archive request, see parseZipOptions
+var archiveParameters = []queryParameter{
+	{"filename", "Download name; defaults to the requested roots' names", &jsonSchema{Type: "string"}},
+	{"include", "Glob, re: regular expression or JSON array of them that files must match", &jsonSchema{Type: "string"}},
+	{"exclude", "Glob, re: regular expression or JSON array of them that leave files out", &jsonSchema{Type: "string"}},
+	{"maxFileSize", "Files larger than this many bytes are skipped", &jsonSchema{Type: "integer", Minimum: number(0)}},
+	{"root", "Keep the root folder or strip it", &jsonSchema{Type: "string", Enum: []string{"keep", "strip"}}},
+	{"rootName", "Replaces the root folder's name", &jsonSchema{Type: "string"}},
+	{"layout", "Merge roots or give each its own folder", &jsonSchema{Type: "string", Enum: []string{"merge", "separate"}}},
+	{"duplicates", "Rename or reject files with the same path", &jsonSchema{Type: "string", Enum: []string{"rename", "reject"}}},
+	{"normalize", "Unicode form of zip paths", &jsonSchema{Type: "string", Enum: []string{"nfc", "nfd", "none"}}},
+	{"windowsSafe", "Rewrite names Windows can't extract", &jsonSchema{Type: "boolean"}},
+	{"sort", "Stream entries in deterministic order", &jsonSchema{Type: "boolean"}},
+	{"reproducible", "Byte-identical archives for identical requests", &jsonSchema{Type: "boolean"}},
+	{"disposition", "Content-Disposition type, or none to omit the header", &jsonSchema{Type: "string", Enum: []string{"attachment", "inline", "none"}}},
+	{"single", "Send a lone file as is or redirect to it instead of zipping it", &jsonSchema{Type: "string", Enum: []string{"zip", singleStream, singleRedirect}}},
+	{"flatten", "Put every file at the archive root", &jsonSchema{Type: "boolean"}},
+	{"hashes", "Report a SHA-256 of every entry in a trailer", &jsonSchema{Type: "boolean"}},
+	{"manifest", "Add a manifest describing the source of every file", &jsonSchema{Type: "boolean"}},
+	{"sidecars", "Add the provider's metadata of every file next to it", &jsonSchema{Type: "boolean"}},
+	{"preflight", "Probe every URL before streaming starts", &jsonSchema{Type: "boolean"}},
+	{"align", "Align the data of stored entries to this many bytes", &jsonSchema{Type: "integer", Minimum: number(0), Maximum: number(65535)}},
+	{"level", "Compression level 0-9 or store, speed, default or best", &jsonSchema{Type: "string"}},
+	{"compression", "Compression method", &jsonSchema{Type: "string", Enum: []string{"store", "deflate", "zstd"}}},
+	{"target", "Configured target storing the archive", &jsonSchema{Type: "string"}},
+	{"tee", "Stream the archive to the client as well as the target", &jsonSchema{Type: "boolean"}},
+	{"notify", "Email address told about the outcome", &jsonSchema{Type: "string"}},
+	{"imageMaxWidth", "Downscale images wider than this", &jsonSchema{Type: "integer", Minimum: number(1)}},
+	{"imageMaxHeight", "Downscale images higher than this", &jsonSchema{Type: "integer", Minimum: number(1)}},
+	{"imageQuality", "JPEG quality of converted images", &jsonSchema{Type: "integer", Minimum: number(1), Maximum: number(100)}},
+	{"textEncoding", "Encoding text files are converted from, or auto", &jsonSchema{Type: "string"}},
+	{"lineEndings", "Line endings text files are converted to", &jsonSchema{Type: "string", Enum: []string{"lf", "crlf"}}},
+}
+
+// providerParameters select files of a provider on GET /create-zip
+var providerParameters = []queryParameter{
+	{"apikey", "Credential of the provider", &jsonSchema{Type: "string"}},
+	{"provider", "Provider name, premiumize by default", &jsonSchema{Type: "string"}},
+	{"paths", "JSON array of folder or file paths", &jsonSchema{Type: "string"}},
+	{"ids", "JSON array of item IDs", &jsonSchema{Type: "string"}},
+	{"magnet", "Magnet link or torrent URL downloaded first", &jsonSchema{Type: "string"}},
+	{"urls", "JSON array of direct URLs, instead of a provider", &jsonSchema{Type: "string"}},
+}
+
+func parameters(params ...[]queryParameter) []map[string]any {
+	var list []map[string]any
+	for _, group := range params {
+		for _, param := range group {
+			list = append(list, map[string]any{"name": param.name, "in": "query", "description": param.description, "schema": param.schema})
+		}
+	}
+	return list
+}
+
+func jsonBody(schemaName string) map[string]any {
+	return map[string]any{"application/json": map[string]any{"schema": ref(schemaName)}}
+}
+
+func response(description string, content map[string]any) map[string]any {
+	resp := map[string]any{"description": description}
+	if content != nil {
+		resp["content"] = content
+	}
+	return resp
+}
+
+// openAPISpec builds the OpenAPI 3 description of the endpoints this server
+// mounts, from the same schemas request bodies are validated against
+func openAPISpec() map[string]any {
+	archive := map[string]any{"application/zip": map[string]any{"schema": map[string]any{"type": "string", "format": "binary"}}}
+	invalid := response("Invalid request; descriptors are answered with their field errors", jsonBody("FieldErrors"))
+	paths := map[string]any{
+		"/create-zip": map[string]any{
+			"get": map[string]any{
+				"summary":    "Stream an archive of provider files or direct URLs",
+				"parameters": parameters(providerParameters, archiveParameters),
+				"responses":  map[string]any{"200": response("The archive", archive), "400": invalid},
+			},
+			"post": map[string]any{
+				"summary":    "Stream an archive of a descriptor, URL list, torrent or HTML form",
+				"parameters": parameters(archiveParameters),
+				"requestBody": map[string]any{"required": true, "content": map[string]any{
+					"application/json":                  map[string]any{"schema": ref("ArchiveRequest")},
+					"application/x-www-form-urlencoded": map[string]any{"schema": map[string]any{"type": "object", "properties": map[string]any{descriptorField: map[string]any{"type": "string"}}}},
+					"multipart/form-data":               map[string]any{"schema": map[string]any{"type": "object", "properties": map[string]any{descriptorField: map[string]any{"type": "string", "format": "binary"}}}},
+					torrentContentType:                  map[string]any{"schema": map[string]any{"type": "string", "format": "binary"}},
+				}},
+				"responses": map[string]any{"200": response("The archive", archive), "400": invalid},
+			},
+		},
+		batchPath: map[string]any{
+			"post": map[string]any{
+				"summary":     "Build several archives, streamed as multipart/mixed parts or in the background with async",
+				"parameters":  append(parameters(archiveParameters), map[string]any{"name": "async", "in": "query", "schema": map[string]any{"type": "boolean"}}),
+				"requestBody": map[string]any{"required": true, "content": jsonBody("Batch")},
+				"responses":   map[string]any{"200": response("The archives or their job IDs", nil), "400": invalid},
+			},
+		},
+		indexPath: map[string]any{
+			"post": map[string]any{
+				"summary":     "Predict the central directory of the reproducible archive of a descriptor",
+				"parameters":  parameters(archiveParameters),
+				"requestBody": map[string]any{"required": true, "content": jsonBody("Descriptor")},
+				"responses": map[string]any{
+					"200": response("Entry names, offsets, sizes and CRCs", map[string]any{"application/json": map[string]any{}}),
+					"400": invalid,
+					"422": response("The layout depends on data only known while streaming", nil),
+				},
+			},
+		},
+		"/jobs/{id}": map[string]any{
+			"get": map[string]any{
+				"summary":    "Status of a job",
+				"parameters": []map[string]any{{"name": "id", "in": "path", "required": true, "schema": map[string]any{"type": "string"}}},
+				"responses":  map[string]any{"200": response("The job", map[string]any{"application/json": map[string]any{}}), "404": response("Unknown job", nil)},
+			},
+		},
+		"/api/providers": map[string]any{
+			"get": map[string]any{"summary": "Registered providers", "responses": map[string]any{"200": response("Provider names", nil)}},
+		},
+		"/api/list": map[string]any{
+			"get": map[string]any{
+				"summary":    "List a provider folder",
+				"parameters": parameters(providerParameters[:2], []queryParameter{{"path", "Folder to list", &jsonSchema{Type: "string"}}}),
+				"responses":  map[string]any{"200": response("The folder's items", nil)},
+			},
+		},
+	}
+	return map[string]any{
+		"openapi":    "3.0.3",
+		"info":       map[string]any{"title": "gozipstreamer", "version": "1"},
+		"paths":      paths,
+		"components": map[string]any{"schemas": schemas},
+	}
+}
+
+// startOpenAPI serves the specification
+func startOpenAPI(r *mux.Router) {
+	r.HandleFunc(openAPIPath, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(openAPISpec())
+	}).Methods("GET")
+}
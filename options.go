@@ -0,0 +1,444 @@
+package main
+
+import (
+	"archive/zip"
+	"compress/flate"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gozipstreamer/provider"
+	"gozipstreamer/zipstreamer"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"golang.org/x/text/encoding/htmlindex"
+	"golang.org/x/text/unicode/norm"
+)
+
+// zipOptions holds the per-request options that shape the archive
+type zipOptions struct {
+	include     []pathFilter
+	exclude     []pathFilter
+	maxFileSize int64  // Files larger than this many bytes are skipped; 0 keeps all
+	stripRoot   bool   // Place the root folder's contents at the archive root
+	rootName    string // Replaces the root folder's name when set
+	rejectDupes bool   // Reject archives with duplicate zip paths instead of renaming
+	normalize   bool   // Normalize zip paths to normForm
+	normForm    norm.Form
+	windowsSafe bool // Rewrite names that cannot be extracted on Windows
+	sorted      bool // Stream entries in deterministic order
+	flatten     bool // Put every file at the archive root
+	filename    string
+	trailers    bool                     // Client sent "TE: trailers" and wants the status trailers
+	hashEntries bool                     // Report a SHA-256 of every entry
+	manifest    bool                     // Add a manifest.json describing the source of every file
+	sidecars    bool                     // Add a <name>.json with the provider's metadata next to every file
+	preflight   bool                     // Probe every URL before streaming starts
+	align       int                      // Stored entries' data starts at a multiple of this many bytes; 0 disables
+	level       int                      // Compression level 1-9; without compression, 1-9 selects Deflate
+	compression string                   // store, deflate or zstd; empty follows level
+	target      string                   // Name of the target storing the archive; empty only streams it
+	tee         bool                     // Stream the archive to the client as well as the target
+	notify      string                   // Email address told about the outcome of the job
+	images      *zipstreamer.ImageFilter // Resizes images while streaming; nil keeps them
+	text        *zipstreamer.TextFilter  // Converts text files while streaming; nil keeps them
+
+	separateRoots bool           // Give every requested root its own uniquely named top-level folder
+	usedRoots     map[string]int // Root folder names handed out so far, for separateRoots
+	reproducible  bool           // Same request, same bytes: sorted entries and fixed times
+	index         bool           // Answer with the archive's central directory instead of the archive
+	single        string         // Send a lone file as singleStream or singleRedirect instead of zipping it; empty zips it
+	disposition   string         // Content-Disposition type, attachment or inline; empty omits the header
+}
+
+// pathFilter matches zip paths by glob or, with a "re:" prefix, by regular expression.
+// Globs without a slash are matched against the file name only.
+type pathFilter struct {
+	glob string
+	re   *regexp.Regexp
+}
+
+// parseZipOptions reads the archive options from the request query
+func parseZipOptions(r *http.Request) (*zipOptions, error) {
+	query := r.URL.Query()
+	options := &zipOptions{normalize: true, normForm: norm.NFC, filename: query.Get("filename")}
+	options.trailers = strings.Contains(strings.ToLower(r.Header.Get("TE")), "trailers")
+
+	var err error
+	if options.include, err = parseFilters(query["include"]); err != nil {
+		return nil, fmt.Errorf("invalid include parameter: %v", err)
+	}
+	if options.exclude, err = parseFilters(query["exclude"]); err != nil {
+		return nil, fmt.Errorf("invalid exclude parameter: %v", err)
+	}
+	if value := query.Get("maxFileSize"); value != "" {
+		if options.maxFileSize, err = strconv.ParseInt(value, 10, 64); err != nil || options.maxFileSize < 0 {
+			return nil, fmt.Errorf("invalid maxFileSize parameter: %s", value)
+		}
+	}
+
+	switch query.Get("root") {
+	case "", "keep":
+	case "strip":
+		options.stripRoot = true
+	default:
+		return nil, fmt.Errorf("invalid root parameter: %s", query.Get("root"))
+	}
+	switch query.Get("layout") {
+	case "", "merge":
+	case "separate":
+		if options.stripRoot {
+			return nil, errors.New("layout=separate can't be combined with root=strip")
+		}
+		options.separateRoots = true
+		options.usedRoots = make(map[string]int)
+	default:
+		return nil, fmt.Errorf("invalid layout parameter: %s", query.Get("layout"))
+	}
+	switch query.Get("duplicates") {
+	case "", "rename":
+	case "reject":
+		options.rejectDupes = true
+	default:
+		return nil, fmt.Errorf("invalid duplicates parameter: %s", query.Get("duplicates"))
+	}
+	switch strings.ToLower(query.Get("normalize")) {
+	case "", "nfc":
+	case "nfd":
+		options.normForm = norm.NFD
+	case "none":
+		options.normalize = false
+	default:
+		return nil, fmt.Errorf("invalid normalize parameter: %s", query.Get("normalize"))
+	}
+	if value := query.Get("windowsSafe"); value != "" {
+		if options.windowsSafe, err = strconv.ParseBool(value); err != nil {
+			return nil, fmt.Errorf("invalid windowsSafe parameter: %s", value)
+		}
+	}
+	if value := query.Get("sort"); value != "" {
+		if options.sorted, err = strconv.ParseBool(value); err != nil {
+			return nil, fmt.Errorf("invalid sort parameter: %s", value)
+		}
+	}
+	if value := query.Get("reproducible"); value != "" {
+		if options.reproducible, err = strconv.ParseBool(value); err != nil {
+			return nil, fmt.Errorf("invalid reproducible parameter: %s", value)
+		}
+		options.sorted = options.sorted || options.reproducible
+	}
+	switch options.disposition = strings.ToLower(query.Get("disposition")); options.disposition {
+	case "":
+		options.disposition = "attachment"
+	case "attachment", "inline":
+	case "none":
+		options.disposition = ""
+	default:
+		return nil, fmt.Errorf("invalid disposition parameter: %s", query.Get("disposition"))
+	}
+	switch options.single = query.Get("single"); options.single {
+	case "", singleStream, singleRedirect:
+	case "zip":
+		options.single = ""
+	default:
+		return nil, fmt.Errorf("invalid single parameter: %s", options.single)
+	}
+	if value := query.Get("flatten"); value != "" {
+		if options.flatten, err = strconv.ParseBool(value); err != nil {
+			return nil, fmt.Errorf("invalid flatten parameter: %s", value)
+		}
+	}
+	if value := query.Get("hashes"); value != "" {
+		if options.hashEntries, err = strconv.ParseBool(value); err != nil {
+			return nil, fmt.Errorf("invalid hashes parameter: %s", value)
+		}
+	}
+	if value := query.Get("manifest"); value != "" {
+		if options.manifest, err = strconv.ParseBool(value); err != nil {
+			return nil, fmt.Errorf("invalid manifest parameter: %s", value)
+		}
+	}
+	if value := query.Get("sidecars"); value != "" {
+		if options.sidecars, err = strconv.ParseBool(value); err != nil {
+			return nil, fmt.Errorf("invalid sidecars parameter: %s", value)
+		}
+	}
+	if value := query.Get("preflight"); value != "" {
+		if options.preflight, err = strconv.ParseBool(value); err != nil {
+			return nil, fmt.Errorf("invalid preflight parameter: %s", value)
+		}
+	}
+	if value := query.Get("align"); value != "" {
+		if options.align, err = strconv.Atoi(value); err != nil || options.align < 0 || options.align > zipstreamer.MaxAlignment {
+			return nil, fmt.Errorf("invalid align parameter: %s", value)
+		}
+	}
+	if value := query.Get("level"); value != "" {
+		if options.level, err = parseCompressionLevel(value); err != nil {
+			return nil, fmt.Errorf("invalid level parameter: %s", value)
+		}
+	}
+	if value := query.Get("compression"); value != "" {
+		if _, err := parseCompressionMethod(value); err != nil {
+			return nil, fmt.Errorf("invalid compression parameter: %s", value)
+		}
+		options.compression = value
+	}
+	if options.target = query.Get("target"); options.target != "" {
+		if _, ok := targetOpeners[options.target]; !ok {
+			return nil, fmt.Errorf("unknown target %q, configured targets: %s", options.target, strings.Join(targetNames(), ", "))
+		}
+	}
+	if value := query.Get("tee"); value != "" {
+		if options.tee, err = strconv.ParseBool(value); err != nil {
+			return nil, fmt.Errorf("invalid tee parameter: %s", value)
+		}
+		if options.tee && options.target == "" {
+			return nil, errors.New("tee requires a target")
+		}
+	}
+	if options.images, err = parseImageFilter(query); err != nil {
+		return nil, err
+	}
+	if options.text, err = parseTextFilter(query); err != nil {
+		return nil, err
+	}
+	if value := query.Get("notify"); value != "" {
+		if options.notify, err = jobMailer.recipient(value); err != nil {
+			return nil, fmt.Errorf("invalid notify parameter: %v", err)
+		}
+	}
+	if rootName := query.Get("rootName"); rootName != "" {
+		if strings.Contains(rootName, "/") || rootName == "." || rootName == ".." {
+			return nil, fmt.Errorf("invalid rootName parameter: %s", rootName)
+		}
+		if options.separateRoots {
+			return nil, errors.New("layout=separate can't be combined with rootName")
+		}
+		options.rootName = rootName
+	}
+
+	return options, nil
+}
+
+// parseImageFilter reads the image resizing options, returning nil when none are set
+func parseImageFilter(query url.Values) (*zipstreamer.ImageFilter, error) {
+	filter := &zipstreamer.ImageFilter{}
+	for _, param := range []struct {
+		name  string
+		value *int
+		max   int
+	}{
+		{"imageMaxWidth", &filter.MaxWidth, 1 << 16},
+		{"imageMaxHeight", &filter.MaxHeight, 1 << 16},
+		{"imageQuality", &filter.Quality, 100},
+	} {
+		value := query.Get(param.name)
+		if value == "" {
+			continue
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > param.max {
+			return nil, fmt.Errorf("invalid %s parameter: %s", param.name, value)
+		}
+		*param.value = n
+	}
+	if filter.MaxWidth == 0 && filter.MaxHeight == 0 && filter.Quality == 0 {
+		return nil, nil
+	}
+	return filter, nil
+}
+
+// parseTextFilter reads the text conversion options, returning nil when none
+// are set. textEncoding names the encoding of the files, or auto to detect it.
+func parseTextFilter(query url.Values) (*zipstreamer.TextFilter, error) {
+	filter := &zipstreamer.TextFilter{}
+	encodingName := query.Get("textEncoding")
+	switch strings.ToLower(encodingName) {
+	case "", "auto":
+	default:
+		source, err := htmlindex.Get(encodingName)
+		if err != nil {
+			return nil, fmt.Errorf("invalid textEncoding parameter: %s", encodingName)
+		}
+		filter.Source = source
+	}
+	switch strings.ToLower(query.Get("lineEndings")) {
+	case "":
+	case "lf":
+		filter.LineEnding = zipstreamer.LF
+	case "crlf":
+		filter.LineEnding = zipstreamer.CRLF
+	default:
+		return nil, fmt.Errorf("invalid lineEndings parameter: %s", query.Get("lineEndings"))
+	}
+	if encodingName == "" && filter.LineEnding == zipstreamer.KeepLineEndings {
+		return nil, nil
+	}
+	return filter, nil
+}
+
+// rewritesData reports whether entries are transformed while streaming, so
+// their sizes in the archive are unknown
+func (o *zipOptions) rewritesData() bool {
+	return o.images != nil || o.text != nil
+}
+
+// parseCompressionMethod maps a compression name onto its zip method
+func parseCompressionMethod(name string) (uint16, error) {
+	switch strings.ToLower(name) {
+	case "store":
+		return zip.Store, nil
+	case "deflate":
+		return zip.Deflate, nil
+	case "zstd":
+		return zipstreamer.Zstd, nil
+	}
+	return 0, fmt.Errorf("unknown compression method %q", name)
+}
+
+// method returns the zip compression method for the archive. Requests that
+// only set a level are deflated, as before compression could be chosen; the
+// configured level doesn't make archives deflated.
+func (o *zipOptions) method() uint16 {
+	if o.compression != "" {
+		method, _ := parseCompressionMethod(o.compression)
+		return method
+	}
+	if o.level > 0 {
+		return zip.Deflate
+	}
+	return zip.Store
+}
+
+// compressionLevel returns the level of the archive's method: the requested
+// one, or for Deflate the configured level when none was requested
+func (o *zipOptions) compressionLevel() int {
+	if o.level == 0 && o.method() == zip.Deflate {
+		return config().compressionLevel
+	}
+	return o.level
+}
+
+// parseCompressionLevel accepts a Deflate level from 0 (store) to 9 or one of
+// the names store, speed, default and best
+func parseCompressionLevel(value string) (int, error) {
+	switch strings.ToLower(value) {
+	case "store":
+		return 0, nil
+	case "speed":
+		return flate.BestSpeed, nil
+	case "default":
+		return 6, nil
+	case "best":
+		return flate.BestCompression, nil
+	}
+	level, err := strconv.Atoi(value)
+	if err != nil || level < 0 || level > flate.BestCompression {
+		return 0, errors.New("compression level must be between 0 and 9")
+	}
+	return level, nil
+}
+
+// parseFilters accepts each value either as a JSON array of patterns or as a single pattern
+func parseFilters(values []string) ([]pathFilter, error) {
+	var filters []pathFilter
+	for _, value := range values {
+		var patterns []string
+		if err := json.Unmarshal([]byte(value), &patterns); err != nil {
+			patterns = []string{value}
+		}
+
+		for _, pattern := range patterns {
+			if pattern == "" {
+				continue
+			}
+			if strings.HasPrefix(pattern, "re:") {
+				re, err := regexp.Compile(strings.TrimPrefix(pattern, "re:"))
+				if err != nil {
+					return nil, err
+				}
+				filters = append(filters, pathFilter{re: re})
+				continue
+			}
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, err
+			}
+			filters = append(filters, pathFilter{glob: pattern})
+		}
+	}
+	return filters, nil
+}
+
+func (f pathFilter) match(zipPath string) bool {
+	zipPath = strings.ReplaceAll(zipPath, "\\", "/")
+	if f.re != nil {
+		return f.re.MatchString(zipPath)
+	}
+	if !strings.Contains(f.glob, "/") {
+		zipPath = path.Base(zipPath)
+	}
+	matched, _ := path.Match(f.glob, zipPath)
+	return matched
+}
+
+// allowed reports whether a file passes the include/exclude filters
+func (o *zipOptions) allowed(zipPath string) bool {
+	for _, filter := range o.exclude {
+		if filter.match(zipPath) {
+			return false
+		}
+	}
+	if len(o.include) == 0 {
+		return true
+	}
+	for _, filter := range o.include {
+		if filter.match(zipPath) {
+			return true
+		}
+	}
+	return false
+}
+
+// uniqueRootName numbers repeated root folder names, so roots that share a
+// name don't interleave their trees
+func (o *zipOptions) uniqueRootName(name string) string {
+	o.usedRoots[name]++
+	if o.usedRoots[name] == 1 {
+		return name
+	}
+	for i := o.usedRoots[name]; ; i++ {
+		candidate := fmt.Sprintf("%s (%d)", name, i)
+		if o.usedRoots[candidate] == 0 {
+			o.usedRoots[candidate]++
+			return candidate
+		}
+	}
+}
+
+// rootArchiveName returns the name a requested root folder gives the archive,
+// which follows rootName
+func (o *zipOptions) rootArchiveName(folderName string) string {
+	if o.rootName != "" {
+		return o.rootName
+	}
+	return folderName
+}
+
+// rootZipPath returns the zip folder that a requested root is placed under
+func (o *zipOptions) rootZipPath(rootPath string, folder *provider.Folder) string {
+	if o.separateRoots {
+		return o.uniqueRootName(rootZipName(rootPath, folder))
+	}
+	if o.stripRoot {
+		return "."
+	}
+	if o.rootName != "" {
+		return o.rootName
+	}
+	return rootZipName(rootPath, folder)
+}
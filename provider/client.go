@@ -0,0 +1,56 @@
+package provider
+
+import (
+	"net/http"
+	"sync"
+)
+
+var (
+	clientsMu     sync.RWMutex
+	clients       = map[string]*http.Client{}
+	defaultClient *http.Client // nil uses http.DefaultClient
+)
+
+// SetDefaultHTTPClient makes providers without a client of their own use
+// client for their API calls, e.g. one enforcing egress rules. A nil client
+// restores http.DefaultClient.
+func SetDefaultHTTPClient(client *http.Client) {
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+	defaultClient = client
+}
+
+// SetHTTPClient makes the named provider use client for its API calls, e.g.
+// to egress through a proxy. A nil client restores the default one. Calls
+// already made keep their client.
+func SetHTTPClient(name string, client *http.Client) {
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+	if client == nil {
+		delete(clients, name)
+		return
+	}
+	clients[name] = client
+}
+
+// HTTPClient returns the client configured for the named provider, or nil
+// when it uses the default one. Downloads of its files should use it too.
+func HTTPClient(name string) *http.Client {
+	clientsMu.RLock()
+	defer clientsMu.RUnlock()
+	return clients[name]
+}
+
+// APIClient returns the client the named provider makes its API calls with,
+// the default one for a provider without its own
+func APIClient(name string) *http.Client {
+	clientsMu.RLock()
+	defer clientsMu.RUnlock()
+	if client := clients[name]; client != nil {
+		return client
+	}
+	if defaultClient != nil {
+		return defaultClient
+	}
+	return http.DefaultClient
+}
@@ -0,0 +1,384 @@
+package provider
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const megaAPIURL = "https://g.api.mega.co.nz/cs"
+
+// megaFileKeyLength is the length of a file's node key: its AES key folded
+// with the CTR nonce and the meta-MAC
+const megaFileKeyLength = 32
+
+// megaNode represents a file or folder node in a Mega folder listing
+type megaNode struct {
+	Handle     string `json:"h"`
+	Parent     string `json:"p"`
+	Type       int    `json:"t"` // 0 = file, 1 = folder
+	Attributes string `json:"a"`
+	Key        string `json:"k"`
+	Size       int64  `json:"s"`
+	Timestamp  int64  `json:"ts"`
+}
+
+// megaTree holds the decrypted nodes of a shared folder
+type megaTree struct {
+	root     string
+	names    map[string]string
+	keys     map[string][]byte
+	children map[string][]megaNode
+}
+
+// Mega lists public Mega folder links and decrypts file contents while streaming.
+// Paths are folder links of the form https://mega.nz/folder/HANDLE#KEY, optionally
+// followed by /folder/SUBHANDLE.
+type Mega struct {
+	mu    sync.Mutex
+	trees map[string]*megaTree
+	seq   int64
+}
+
+func init() {
+	Register("mega", func(apiKey string, params url.Values) (Provider, error) {
+		return NewMega(), nil
+	})
+}
+
+func NewMega() *Mega {
+	return &Mega{trees: make(map[string]*megaTree)}
+}
+
+// List returns the contents of the folder referenced by link
+func (m *Mega) List(link string) (*Folder, error) {
+	publicHandle, masterKey, subfolder, err := parseMegaLink(link)
+	if err != nil {
+		return nil, err
+	}
+
+	tree, err := m.tree(publicHandle, masterKey)
+	if err != nil {
+		return nil, err
+	}
+
+	folderHandle := tree.root
+	if subfolder != "" {
+		folderHandle = subfolder
+	}
+	name, ok := tree.names[folderHandle]
+	if !ok {
+		return nil, fmt.Errorf("mega folder not found: %s", folderHandle)
+	}
+
+	nodes := tree.children[folderHandle]
+	var fileHandles []string
+	for _, node := range nodes {
+		if key, ok := tree.keys[node.Handle]; ok && node.Type == 0 && len(key) == megaFileKeyLength {
+			fileHandles = append(fileHandles, node.Handle)
+		}
+	}
+	links, err := m.downloadLinks(publicHandle, fileHandles)
+	if err != nil {
+		return nil, err
+	}
+
+	baseLink := "https://mega.nz/folder/" + publicHandle + "#" + base64.RawURLEncoding.EncodeToString(masterKey)
+	folder := &Folder{Name: name}
+	for _, node := range nodes {
+		// Without its key a file could only be streamed as ciphertext
+		if _, ok := tree.keys[node.Handle]; !ok {
+			folder.Items = append(folder.Items, Item{
+				ID:    node.Handle,
+				Name:  node.Handle,
+				IsDir: node.Type == 1,
+				Error: "failed to decrypt the node key or attributes",
+			})
+			continue
+		}
+		if node.Type == 0 && len(tree.keys[node.Handle]) != megaFileKeyLength {
+			folder.Items = append(folder.Items, Item{
+				ID:    node.Handle,
+				Name:  tree.names[node.Handle],
+				Size:  node.Size,
+				Error: fmt.Sprintf("invalid file key of %d bytes", len(tree.keys[node.Handle])),
+			})
+			continue
+		}
+		item := Item{
+			ID:      node.Handle,
+			Name:    tree.names[node.Handle],
+			IsDir:   node.Type == 1,
+			Size:    node.Size,
+			ModTime: time.Unix(node.Timestamp, 0),
+		}
+		if item.IsDir {
+			item.Path = baseLink + "/folder/" + node.Handle
+		} else {
+			item.URL = links[node.Handle]
+			item.Wrap = megaDecrypter(tree.keys[node.Handle])
+		}
+		folder.Items = append(folder.Items, item)
+	}
+
+	return folder, nil
+}
+
+// tree fetches and decrypts the node tree of a shared folder, caching the result
+func (m *Mega) tree(publicHandle string, masterKey []byte) (*megaTree, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if tree, ok := m.trees[publicHandle]; ok {
+		return tree, nil
+	}
+
+	var results []struct {
+		Nodes []megaNode `json:"f"`
+	}
+	err := m.call(publicHandle, []map[string]interface{}{{"a": "f", "c": 1, "r": 1, "ca": 1}}, &results)
+	if err != nil {
+		return nil, err
+	}
+	if len(results) == 0 {
+		return nil, errors.New("empty mega folder listing")
+	}
+
+	tree := &megaTree{
+		names:    make(map[string]string),
+		keys:     make(map[string][]byte),
+		children: make(map[string][]megaNode),
+	}
+	for _, node := range results[0].Nodes {
+		// Nodes we cannot decrypt have no key or name, and are listed as failed
+		key, err := decryptMegaNodeKey(node.Key, masterKey)
+		if err != nil {
+			continue
+		}
+		name, err := decryptMegaAttributes(node.Attributes, megaAESKey(key))
+		if err != nil {
+			continue
+		}
+		tree.keys[node.Handle] = key
+		tree.names[node.Handle] = name
+	}
+	for _, node := range results[0].Nodes {
+		if _, ok := tree.names[node.Parent]; ok {
+			tree.children[node.Parent] = append(tree.children[node.Parent], node)
+		} else if _, ok := tree.keys[node.Handle]; ok && node.Type == 1 {
+			tree.root = node.Handle
+		}
+	}
+	if tree.root == "" {
+		return nil, errors.New("mega folder has no root node")
+	}
+
+	m.trees[publicHandle] = tree
+	return tree, nil
+}
+
+// downloadLinks resolves temporary download URLs for file handles in one batch
+func (m *Mega) downloadLinks(publicHandle string, handles []string) (map[string]string, error) {
+	links := make(map[string]string, len(handles))
+	if len(handles) == 0 {
+		return links, nil
+	}
+
+	commands := make([]map[string]interface{}, 0, len(handles))
+	for _, handle := range handles {
+		commands = append(commands, map[string]interface{}{"a": "g", "g": 1, "ssl": 1, "n": handle})
+	}
+
+	var results []json.RawMessage
+	if err := m.call(publicHandle, commands, &results); err != nil {
+		return nil, err
+	}
+
+	for i, raw := range results {
+		var result struct {
+			URL string `json:"g"`
+		}
+		// Per-command failures are returned as bare error codes
+		if json.Unmarshal(raw, &result) == nil && i < len(handles) {
+			links[handles[i]] = result.URL
+		}
+	}
+	return links, nil
+}
+
+// call posts a batch of commands to the Mega API in the context of a shared folder
+func (m *Mega) call(publicHandle string, commands interface{}, v interface{}) error {
+	body, err := json.Marshal(commands)
+	if err != nil {
+		return err
+	}
+
+	apiURL := fmt.Sprintf("%s?id=%d&n=%s", megaAPIURL, atomic.AddInt64(&m.seq, 1), url.QueryEscape(publicHandle))
+	resp, err := APIClient("mega").Post(apiURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to fetch folder contents: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("mega API request failed with status: %s", resp.Status)
+	}
+
+	payload, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	// A bare number instead of an array is an API error code
+	var code int
+	if json.Unmarshal(payload, &code) == nil {
+		return fmt.Errorf("mega API error code: %d", code)
+	}
+
+	if err := json.Unmarshal(payload, v); err != nil {
+		return fmt.Errorf("failed to unmarshal JSON response: %v", err)
+	}
+	return nil
+}
+
+// parseMegaLink extracts the public handle, key and optional subfolder handle
+func parseMegaLink(link string) (string, []byte, string, error) {
+	var handle, key, subfolder string
+
+	if i := strings.Index(link, "/folder/"); i >= 0 {
+		rest := link[i+len("/folder/"):]
+		parts := strings.SplitN(rest, "#", 2)
+		if len(parts) != 2 {
+			return "", nil, "", errors.New("mega link is missing the decryption key")
+		}
+		handle = parts[0]
+		keyParts := strings.SplitN(parts[1], "/folder/", 2)
+		key = keyParts[0]
+		if len(keyParts) == 2 {
+			subfolder = keyParts[1]
+		}
+	} else if i := strings.Index(link, "#F!"); i >= 0 {
+		parts := strings.Split(link[i+len("#F!"):], "!")
+		if len(parts) < 2 {
+			return "", nil, "", errors.New("mega link is missing the decryption key")
+		}
+		handle, key = parts[0], parts[1]
+	} else {
+		return "", nil, "", errors.New("not a mega folder link")
+	}
+
+	masterKey, err := megaBase64Decode(key)
+	if err != nil || len(masterKey) != 16 {
+		return "", nil, "", errors.New("invalid mega folder key")
+	}
+	return handle, masterKey, subfolder, nil
+}
+
+// decryptMegaNodeKey decrypts a node key of the form "owner:key" with the folder key
+func decryptMegaNodeKey(nodeKey string, masterKey []byte) ([]byte, error) {
+	if i := strings.Index(nodeKey, ":"); i >= 0 {
+		nodeKey = nodeKey[i+1:]
+	}
+	if i := strings.Index(nodeKey, "/"); i >= 0 {
+		nodeKey = nodeKey[:i]
+	}
+
+	encrypted, err := megaBase64Decode(nodeKey)
+	if err != nil || len(encrypted)%16 != 0 {
+		return nil, errors.New("invalid mega node key")
+	}
+
+	block, err := aes.NewCipher(masterKey)
+	if err != nil {
+		return nil, err
+	}
+	key := make([]byte, len(encrypted))
+	for i := 0; i < len(encrypted); i += 16 {
+		block.Decrypt(key[i:i+16], encrypted[i:i+16])
+	}
+	return key, nil
+}
+
+// megaAESKey derives the AES key from a node key; file keys fold their 32 bytes in half
+func megaAESKey(nodeKey []byte) []byte {
+	if len(nodeKey) < 32 {
+		return nodeKey
+	}
+	key := make([]byte, 16)
+	for i := range key {
+		key[i] = nodeKey[i] ^ nodeKey[i+16]
+	}
+	return key
+}
+
+// decryptMegaAttributes decrypts a node's attribute block and returns its name
+func decryptMegaAttributes(attributes string, key []byte) (string, error) {
+	encrypted, err := megaBase64Decode(attributes)
+	if err != nil || len(encrypted)%16 != 0 {
+		return "", errors.New("invalid mega node attributes")
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return "", err
+	}
+	decrypted := make([]byte, len(encrypted))
+	cipher.NewCBCDecrypter(block, make([]byte, 16)).CryptBlocks(decrypted, encrypted)
+
+	decrypted = bytes.TrimRight(decrypted, "\x00")
+	if !bytes.HasPrefix(decrypted, []byte("MEGA")) {
+		return "", errors.New("failed to decrypt mega node attributes")
+	}
+
+	var attrs struct {
+		Name string `json:"n"`
+	}
+	if err := json.Unmarshal(decrypted[4:], &attrs); err != nil {
+		return "", err
+	}
+	return attrs.Name, nil
+}
+
+// megaDecrypter returns a reader wrapper that decrypts file contents with
+// AES-CTR. Contents it can't decrypt fail to read rather than pass through
+// as ciphertext.
+func megaDecrypter(nodeKey []byte) func(io.Reader) io.Reader {
+	return func(r io.Reader) io.Reader {
+		if len(nodeKey) != megaFileKeyLength {
+			return failedReader{fmt.Errorf("invalid mega file key of %d bytes", len(nodeKey))}
+		}
+		block, err := aes.NewCipher(megaAESKey(nodeKey))
+		if err != nil {
+			return failedReader{fmt.Errorf("failed to decrypt mega file: %v", err)}
+		}
+		iv := make([]byte, 16)
+		copy(iv, nodeKey[16:24])
+		return &cipher.StreamReader{S: cipher.NewCTR(block, iv), R: r}
+	}
+}
+
+// failedReader fails every read with err
+type failedReader struct {
+	err error
+}
+
+func (r failedReader) Read([]byte) (int, error) {
+	return 0, r.err
+}
+
+func megaBase64Decode(s string) ([]byte, error) {
+	s = strings.TrimRight(s, "=")
+	s = strings.NewReplacer("+", "-", "/", "_").Replace(s)
+	return base64.RawURLEncoding.DecodeString(s)
+}
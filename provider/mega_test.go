@@ -0,0 +1,33 @@
+package provider
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io"
+	"testing"
+)
+
+func TestMegaDecrypter(t *testing.T) {
+	// AES key 2b7e151628aed2a6abf7158809cf4f3c folded with nonce 0001020304050607
+	// and meta-MAC 08090a0b0c0d0e0f
+	nodeKey, _ := hex.DecodeString("2b7f17152cabd4a1a3fe1f8305c24133000102030405060708090a0b0c0d0e0f")
+	ciphertext, _ := hex.DecodeString("19ce20015553385e50b3eb4c2523e4390a1c99741d0d6cb96780e3fcf631a88d")
+	want, _ := hex.DecodeString("6bc1bee22e409f96e93d7e117393172aae2d8a571e03ac9c9eb76fac45af8e51")
+
+	got, err := io.ReadAll(megaDecrypter(nodeKey)(bytes.NewReader(ciphertext)))
+	if err != nil {
+		t.Fatalf("decrypting: %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("decrypted %x, want %x", got, want)
+	}
+}
+
+func TestMegaDecrypterInvalidKey(t *testing.T) {
+	for _, length := range []int{0, 16, 31, 48} {
+		data, err := io.ReadAll(megaDecrypter(make([]byte, length))(bytes.NewReader([]byte("ciphertext"))))
+		if err == nil || len(data) != 0 {
+			t.Errorf("key of %d bytes read %q with error %v, want no data and an error", length, data, err)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package provider
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// tokenExpiryMargin refreshes access tokens this long before they expire, so
+// a request started just before expiry still carries a valid token
+const tokenExpiryMargin = time.Minute
+
+// TokenSource supplies OAuth2 access tokens to providers. Implementations
+// refresh expired tokens themselves and must be safe for concurrent use.
+type TokenSource interface {
+	Token() (string, error)
+}
+
+type staticToken string
+
+func (t staticToken) Token() (string, error) {
+	return string(t), nil
+}
+
+// StaticToken returns a source that always supplies the same access token
+func StaticToken(token string) TokenSource {
+	return staticToken(token)
+}
+
+// RefreshTokenSource exchanges a refresh token for access tokens at an OAuth2
+// token endpoint whenever the current access token is about to expire
+type RefreshTokenSource struct {
+	tokenURL     string
+	clientID     string
+	clientSecret string
+	client       *http.Client // nil uses the default provider client
+
+	mu           sync.Mutex
+	refreshToken string
+	accessToken  string
+	expiry       time.Time
+}
+
+type tokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int64  `json:"expires_in"`
+	Error        string `json:"error"`
+	Description  string `json:"error_description"`
+}
+
+// NewRefreshTokenSource creates a token source for the refresh token grant.
+// clientSecret may be empty for public clients.
+func NewRefreshTokenSource(tokenURL, clientID, clientSecret, refreshToken string) *RefreshTokenSource {
+	return &RefreshTokenSource{
+		tokenURL:     tokenURL,
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		refreshToken: refreshToken,
+	}
+}
+
+// Token returns the current access token, refreshing it first if it expires soon
+func (s *RefreshTokenSource) Token() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.accessToken != "" && time.Until(s.expiry) > tokenExpiryMargin {
+		return s.accessToken, nil
+	}
+	if err := s.refresh(); err != nil {
+		return "", err
+	}
+	return s.accessToken, nil
+}
+
+// refresh requests a new access token; the caller holds the lock
+func (s *RefreshTokenSource) refresh() error {
+	form := url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {s.refreshToken},
+		"client_id":     {s.clientID},
+	}
+	if s.clientSecret != "" {
+		form.Set("client_secret", s.clientSecret)
+	}
+
+	client := s.client
+	if client == nil {
+		client = APIClient("")
+	}
+	resp, err := client.Post(s.tokenURL, "application/x-www-form-urlencoded", strings.NewReader(form.Encode()))
+	if err != nil {
+		return fmt.Errorf("failed to refresh access token: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var token tokenResponse
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return fmt.Errorf("failed to unmarshal token response: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK || token.AccessToken == "" {
+		return fmt.Errorf("token refresh failed with status %s: %s %s", resp.Status, token.Error, token.Description)
+	}
+
+	s.accessToken = token.AccessToken
+	s.expiry = time.Now().Add(time.Duration(token.ExpiresIn) * time.Second)
+	if token.ExpiresIn <= 0 {
+		// Without a lifetime the token is used until a request is rejected
+		s.expiry = time.Now().Add(time.Hour)
+	}
+	// Some providers rotate the refresh token on every use
+	if token.RefreshToken != "" {
+		s.refreshToken = token.RefreshToken
+	}
+	return nil
+}
+
+// bearerAuthorizer returns an Item.Authorize function that adds a current
+// access token from source to download requests
+func bearerAuthorizer(source TokenSource) func(*http.Request) error {
+	return func(req *http.Request) error {
+		token, err := source.Token()
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+		return nil
+	}
+}
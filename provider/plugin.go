@@ -0,0 +1,193 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PluginsEnvVar lists external provider binaries as "name=/path/to/binary,..."
+const PluginsEnvVar = "ZS_PLUGINS"
+
+const pluginTimeout = 2 * time.Minute
+
+// pluginResolveConcurrency caps the "resolve" requests a plugin runs at once
+const pluginResolveConcurrency = 8
+
+// PluginRequest is written as JSON to the plugin's stdin. Op is either "list",
+// which must answer with a PluginFolder, or "resolve", which must answer with a
+// PluginItem carrying the download URL of the item identified by ID/Path.
+// Plugins supporting selection by ID also answer "item" with {"item": PluginItem}
+// for the item identified by ID.
+type PluginRequest struct {
+	Op     string              `json:"op"`
+	Path   string              `json:"path,omitempty"`
+	ID     string              `json:"id,omitempty"`
+	APIKey string              `json:"apikey,omitempty"`
+	Params map[string][]string `json:"params,omitempty"`
+}
+
+// PluginItem is a file or folder reported by a plugin. Files without a URL are
+// resolved with a follow-up "resolve" request.
+type PluginItem struct {
+	ID      string    `json:"id"`
+	Name    string    `json:"name"`
+	Type    string    `json:"type"` // "file" or "folder"
+	Path    string    `json:"path"`
+	URL     string    `json:"url,omitempty"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime,omitempty"`
+	CRC32   string    `json:"crc32,omitempty"`
+	MD5     string    `json:"md5,omitempty"`
+}
+
+// PluginFolder is the response to a "list" request
+type PluginFolder struct {
+	Name  string       `json:"name"`
+	Items []PluginItem `json:"items"`
+}
+
+// pluginResponse covers both reply shapes; a non-empty Error fails the request
+type pluginResponse struct {
+	Name  string       `json:"name"`
+	Items []PluginItem `json:"items"`
+	URL   string       `json:"url"`
+	Item  *PluginItem  `json:"item,omitempty"`
+	Error string       `json:"error,omitempty"`
+}
+
+// Plugin is a provider implemented by an external binary speaking JSON over
+// stdin/stdout. The binary is executed once per operation, so listing a folder
+// also executes it once for each file reported without a URL, up to
+// pluginResolveConcurrency at a time.
+type Plugin struct {
+	command  string
+	apiKey   string
+	params   url.Values
+	resolves chan struct{} // Slots of the "resolve" requests running
+}
+
+func init() {
+	for _, spec := range strings.Split(os.Getenv(PluginsEnvVar), ",") {
+		name, command, ok := strings.Cut(strings.TrimSpace(spec), "=")
+		if !ok || name == "" || command == "" {
+			continue
+		}
+		Register(name, func(apiKey string, params url.Values) (Provider, error) {
+			return NewPlugin(command, apiKey, params), nil
+		})
+	}
+}
+
+func NewPlugin(command, apiKey string, params url.Values) *Plugin {
+	return &Plugin{command: command, apiKey: apiKey, params: params, resolves: make(chan struct{}, pluginResolveConcurrency)}
+}
+
+// List returns the contents of the folder at folderPath
+func (p *Plugin) List(folderPath string) (*Folder, error) {
+	response, err := p.run(PluginRequest{Op: "list", Path: folderPath})
+	if err != nil {
+		return nil, err
+	}
+
+	// Items are resolved concurrently, keeping the order of the listing
+	folder := &Folder{Name: response.Name, Items: make([]Item, len(response.Items))}
+	errs := make([]error, len(response.Items))
+	var wg sync.WaitGroup
+	for i, pluginItem := range response.Items {
+		wg.Add(1)
+		go func(i int, pluginItem PluginItem) {
+			defer wg.Done()
+			item, err := p.item(pluginItem)
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			folder.Items[i] = *item
+		}(i, pluginItem)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return folder, nil
+}
+
+// Item looks up a file or folder by ID through the plugin's "item" operation
+func (p *Plugin) Item(id string) (*Item, error) {
+	response, err := p.run(PluginRequest{Op: "item", ID: id})
+	if err != nil {
+		return nil, err
+	}
+	if response.Item == nil {
+		return nil, fmt.Errorf("plugin %s returned no item for %s", p.command, id)
+	}
+	return p.item(*response.Item)
+}
+
+// item converts a reported item, resolving the download URL of files without one
+func (p *Plugin) item(pluginItem PluginItem) (*Item, error) {
+	item := &Item{
+		ID:      pluginItem.ID,
+		Name:    pluginItem.Name,
+		IsDir:   pluginItem.Type == "folder",
+		Path:    pluginItem.Path,
+		URL:     pluginItem.URL,
+		Size:    pluginItem.Size,
+		ModTime: pluginItem.ModTime,
+		CRC32:   pluginItem.CRC32,
+		MD5:     pluginItem.MD5,
+	}
+	if !item.IsDir && item.URL == "" {
+		p.resolves <- struct{}{}
+		resolved, err := p.run(PluginRequest{Op: "resolve", ID: pluginItem.ID, Path: pluginItem.Path})
+		<-p.resolves
+		if err != nil {
+			return nil, err
+		}
+		item.URL = resolved.URL
+	}
+	return item, nil
+}
+
+// run executes the plugin binary with a single request and decodes its reply
+func (p *Plugin) run(request PluginRequest) (*pluginResponse, error) {
+	request.APIKey = p.apiKey
+	request.Params = p.params
+
+	input, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), pluginTimeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, p.command)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("plugin %s failed: %v: %s", p.command, err, strings.TrimSpace(stderr.String()))
+	}
+
+	var response pluginResponse
+	if err := json.Unmarshal(stdout.Bytes(), &response); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal plugin response: %v", err)
+	}
+	if response.Error != "" {
+		return nil, errors.New(response.Error)
+	}
+	return &response, nil
+}
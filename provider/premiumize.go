@@ -0,0 +1,175 @@
+package provider
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// premiumizeAPIURL is the base of the Premiumize.me API endpoints
+const premiumizeAPIURL = "https://www.premiumize.me/api/"
+
+// APIResponse represents the structure of the API response from Premiumize.me
+type APIResponse struct {
+	Status  string `json:"status"`
+	Content []struct {
+		ID         string `json:"id"`
+		Name       string `json:"name"`
+		Type       string `json:"type"`
+		DirectLink string `json:"directlink,omitempty"`
+		Size       int64  `json:"size"`
+	} `json:"content"`
+	Name     string `json:"name"`
+	FolderID string `json:"folder_id"`
+	ParentID string `json:"parent_id"`
+}
+
+// Premiumize lists folders through the Premiumize.me API
+type Premiumize struct {
+	apiKey string
+}
+
+func init() {
+	Register("premiumize", func(apiKey string, params url.Values) (Provider, error) {
+		return NewPremiumize(apiKey), nil
+	})
+}
+
+func NewPremiumize(apiKey string) *Premiumize {
+	return &Premiumize{apiKey: apiKey}
+}
+
+// premiumizeIDPrefix marks folder paths that are folder IDs, as returned for
+// items looked up by ID, whose path is unknown
+const premiumizeIDPrefix = "id:"
+
+// premiumizeItemDetails is the response of the item details endpoint
+type premiumizeItemDetails struct {
+	ID         string `json:"id"`
+	Name       string `json:"name"`
+	Type       string `json:"type"`
+	Size       int64  `json:"size"`
+	Link       string `json:"link"`
+	DirectLink string `json:"directlink"`
+}
+
+// List returns the contents of the folder at folderPath
+func (p *Premiumize) List(folderPath string) (*Folder, error) {
+	query := url.Values{"apikey": {p.apiKey}}
+	folderID, byID := strings.CutPrefix(folderPath, premiumizeIDPrefix)
+	if byID {
+		query.Set("id", folderID)
+	}
+
+	apiResponse := &APIResponse{}
+	var err error
+	if byID {
+		err = fetchPremiumize("folder/list", query, apiResponse)
+	} else {
+		apiResponse, err = fetchFolderContents(p.apiKey, folderPath)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	folder := &Folder{Name: apiResponse.Name}
+	for _, item := range apiResponse.Content {
+		itemPath := path.Join(folderPath, item.Name)
+		if byID {
+			itemPath = premiumizeIDPrefix + item.ID
+		}
+		folder.Items = append(folder.Items, Item{
+			ID:    item.ID,
+			Name:  item.Name,
+			IsDir: item.Type == "folder",
+			Path:  itemPath,
+			URL:   item.DirectLink,
+			Size:  item.Size,
+		})
+	}
+	return folder, nil
+}
+
+// Item looks up a file or folder by ID. Folders are tried first, since the
+// item details endpoint only knows files.
+func (p *Premiumize) Item(id string) (*Item, error) {
+	query := url.Values{"apikey": {p.apiKey}, "id": {id}}
+	var folder APIResponse
+	if err := fetchPremiumize("folder/list", query, &folder); err == nil {
+		return &Item{ID: id, Name: folder.Name, IsDir: true, Path: premiumizeIDPrefix + id}, nil
+	}
+
+	var details premiumizeItemDetails
+	if err := fetchPremiumize("item/details", query, &details); err != nil {
+		return nil, err
+	}
+	link := details.DirectLink
+	if link == "" {
+		link = details.Link
+	}
+	return &Item{ID: details.ID, Name: details.Name, URL: link, Size: details.Size}, nil
+}
+
+// fetchPremiumize calls an API endpoint and decodes its response into v,
+// failing unless the response reports success
+func fetchPremiumize(endpoint string, query url.Values, v interface{}) error {
+	resp, err := APIClient("premiumize").Get(premiumizeAPIURL + endpoint + "?" + query.Encode())
+	if err != nil {
+		return fmt.Errorf("failed to fetch %s: %v", endpoint, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("API request failed with status: %s", resp.Status)
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %v", endpoint, err)
+	}
+	var status struct {
+		Status  string `json:"status"`
+		Message string `json:"message"`
+	}
+	if err := json.Unmarshal(data, &status); err != nil {
+		return fmt.Errorf("failed to unmarshal JSON response: %v", err)
+	}
+	if status.Status != "success" {
+		return fmt.Errorf("API response status: %s %s", status.Status, status.Message)
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("failed to unmarshal JSON response: %v", err)
+	}
+	return nil
+}
+
+// fetchFolderContents retrieves the contents of a folder from Premiumize.me API
+func fetchFolderContents(apiKey, path string) (*APIResponse, error) {
+	encodedPath := strings.ReplaceAll(path, " ", "%20") // Encode spaces
+	apiURL := fmt.Sprintf("https://www.premiumize.me/api/folder/list?apikey=%s&path=%s", apiKey, encodedPath)
+
+	resp, err := APIClient("premiumize").Get(apiURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch folder contents: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("API request failed with status: %s", resp.Status)
+	}
+
+	var apiResponse APIResponse
+	if err := json.NewDecoder(resp.Body).Decode(&apiResponse); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal JSON response: %v", err)
+	}
+
+	if apiResponse.Status != "success" {
+		return nil, fmt.Errorf("API response status: %s", apiResponse.Status)
+	}
+
+	return &apiResponse, nil
+}
@@ -0,0 +1,115 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Transfer is a Premiumize transfer, downloading a torrent into the cloud
+type Transfer struct {
+	ID       string  `json:"id"`
+	Name     string  `json:"name"`
+	Status   string  `json:"status"` // waiting, queued, running, finished, seeding or a failure
+	Message  string  `json:"message"`
+	Progress float64 `json:"progress"`
+	FolderID string  `json:"folder_id"` // Set once finished, for multi-file torrents
+	FileID   string  `json:"file_id"`   // Set once finished, for single-file torrents
+}
+
+// Item returns the ID reference of the downloaded folder or file for Item lookups
+func (t *Transfer) Item() string {
+	if t.FileID != "" {
+		return t.FileID
+	}
+	return t.FolderID
+}
+
+// CreateTransfer starts downloading a magnet link or torrent URL. Cached
+// torrents finish almost immediately.
+func (p *Premiumize) CreateTransfer(src string) (string, error) {
+	form := url.Values{"src": {src}}
+	return p.postTransfer("application/x-www-form-urlencoded", strings.NewReader(form.Encode()))
+}
+
+// UploadTransfer starts downloading the torrent described by a .torrent file
+func (p *Premiumize) UploadTransfer(torrent []byte) (string, error) {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreateFormFile("file", "upload.torrent")
+	if err != nil {
+		return "", err
+	}
+	part.Write(torrent)
+	if err := form.Close(); err != nil {
+		return "", err
+	}
+	return p.postTransfer(form.FormDataContentType(), &body)
+}
+
+func (p *Premiumize) postTransfer(contentType string, body io.Reader) (string, error) {
+	apiURL := "https://www.premiumize.me/api/transfer/create?" + url.Values{"apikey": {p.apiKey}}.Encode()
+	resp, err := APIClient("premiumize").Post(apiURL, contentType, body)
+	if err != nil {
+		return "", fmt.Errorf("failed to create transfer: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var created struct {
+		Status  string `json:"status"`
+		Message string `json:"message"`
+		ID      string `json:"id"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
+		return "", fmt.Errorf("failed to unmarshal JSON response: %v", err)
+	}
+	if created.Status != "success" {
+		return "", fmt.Errorf("failed to create transfer: %s", created.Message)
+	}
+	return created.ID, nil
+}
+
+// WaitTransfer polls the transfer list until the transfer has finished,
+// failed, or ctx is done
+func (p *Premiumize) WaitTransfer(ctx context.Context, id string, interval time.Duration) (*Transfer, error) {
+	for {
+		var list struct {
+			Transfers []Transfer `json:"transfers"`
+		}
+		if err := fetchPremiumize("transfer/list", url.Values{"apikey": {p.apiKey}}, &list); err != nil {
+			return nil, err
+		}
+
+		found := false
+		for i := range list.Transfers {
+			transfer := &list.Transfers[i]
+			if transfer.ID != id {
+				continue
+			}
+			found = true
+			switch transfer.Status {
+			case "finished", "seeding":
+				if transfer.Item() != "" {
+					return transfer, nil
+				}
+			case "error", "timeout", "banned", "deleted":
+				return nil, fmt.Errorf("transfer %s: %s", transfer.Status, transfer.Message)
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("transfer %s not found", id)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package provider
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Item is a single file or folder returned by a provider listing
+type Item struct {
+	ID      string
+	Name    string
+	IsDir   bool
+	Path    string // Provider path used to list this item when it is a folder
+	URL     string // Direct download link for files
+	Size    int64
+	ModTime time.Time
+	Wrap    func(io.Reader) io.Reader // Optional transform of the downloaded body, e.g. decryption
+	CRC32   string                    // Hex digests reported by the provider, if any
+	MD5     string
+	// Authorize adds credentials to every download request, e.g. a freshly
+	// refreshed access token. nil means the URL works on its own.
+	Authorize func(*http.Request) error
+	// Error tells why the item can't be archived, e.g. it failed to decrypt.
+	// Such items are reported as failed instead of being added.
+	Error string
+}
+
+// Folder is the result of listing a single folder
+type Folder struct {
+	Name  string
+	Items []Item
+}
+
+// Provider lists folders on a storage backend
+type Provider interface {
+	List(path string) (*Folder, error)
+}
+
+// ItemResolver is implemented by providers that can look up files and folders
+// by ID. Unlike paths, IDs survive renames and can't match the wrong item.
+// The Path of a returned folder is accepted by List.
+type ItemResolver interface {
+	Item(id string) (*Item, error)
+}
+
+// Factory creates a provider from the credentials and parameters of a request
+type Factory func(apiKey string, params url.Values) (Provider, error)
+
+var factories = map[string]Factory{}
+
+// Register makes a provider available under the given name
+func Register(name string, factory Factory) {
+	factories[name] = factory
+}
+
+// New creates the provider registered under name
+func New(name, apiKey string, params url.Values) (Provider, error) {
+	factory, ok := factories[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown provider: %s", name)
+	}
+	return factory(apiKey, params)
+}
+
+// Names returns the names of all registered providers
+func Names() []string {
+	names := make([]string, 0, len(factories))
+	for name := range factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Endpoint returns the base URL of a provider's API, used to check that it can
+// be reached, or "" when it has none, like plugins, or rclone without a
+// configured RC server
+func Endpoint(name string) string {
+	switch name {
+	case "premiumize":
+		return premiumizeAPIURL
+	case "mega":
+		return megaAPIURL
+	case "onedrive":
+		return graphBaseURL
+	case "rclone":
+		return os.Getenv(RcloneURLEnvVar)
+	}
+	return ""
+}
+
+// escapePath escapes each segment of a slash-separated path for use in a URL
+func escapePath(p string) string {
+	segments := strings.Split(p, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return strings.Join(segments, "/")
+}
@@ -0,0 +1,155 @@
+package provider
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const RcloneURLEnvVar = "ZS_RCLONE_URL"
+
+// rcloneListResponse represents the result of the operations/list RC call
+type rcloneListResponse struct {
+	List []struct {
+		Path    string            `json:"Path"`
+		Name    string            `json:"Name"`
+		Size    int64             `json:"Size"`
+		ModTime time.Time         `json:"ModTime"`
+		IsDir   bool              `json:"IsDir"`
+		ID      string            `json:"ID"`
+		Hashes  map[string]string `json:"Hashes"`
+	} `json:"list"`
+}
+
+// Rclone lists any configured rclone remote through the rclone RC API. The
+// rclone daemon must run with --rc-serve so file contents can be downloaded.
+// Paths take the usual rclone form, e.g. "gdrive:Movies/Some Folder". The RC
+// server should require --rc-user and --rc-pass, which callers pass as their
+// API key.
+type Rclone struct {
+	baseURL  *url.URL
+	user     string
+	password string
+}
+
+func init() {
+	Register("rclone", func(apiKey string, params url.Values) (Provider, error) {
+		// The API key carries the RC server's "user:password". The server's
+		// own credentials are never used for a request, since they would open
+		// every configured remote to anyone who can reach the streamer.
+		user, password, ok := strings.Cut(apiKey, ":")
+		if !ok || user == "" || password == "" {
+			return nil, errors.New("rclone requires the RC server's user:password as the API key")
+		}
+		return NewRclone(os.Getenv(RcloneURLEnvVar), user, password)
+	})
+}
+
+// NewRclone creates a provider for the rclone RC server at rcURL
+func NewRclone(rcURL, user, password string) (*Rclone, error) {
+	if rcURL == "" {
+		rcURL = "http://localhost:5572"
+	}
+	baseURL, err := url.Parse(strings.TrimRight(rcURL, "/"))
+	if err != nil {
+		return nil, err
+	}
+	if baseURL.Scheme != "http" && baseURL.Scheme != "https" {
+		return nil, errors.New("rclone url must be a http url")
+	}
+	return &Rclone{baseURL: baseURL, user: user, password: password}, nil
+}
+
+// List returns the contents of the folder at remotePath
+func (r *Rclone) List(remotePath string) (*Folder, error) {
+	fs, dir, err := splitRclonePath(remotePath)
+	if err != nil {
+		return nil, err
+	}
+
+	var listing rcloneListResponse
+	params := map[string]interface{}{
+		"fs":     fs,
+		"remote": dir,
+		"opt":    map[string]interface{}{"showHash": true, "hashTypes": []string{"md5", "crc32"}},
+	}
+	if err := r.call("operations/list", params, &listing); err != nil {
+		return nil, err
+	}
+
+	name := strings.TrimSuffix(fs, ":")
+	if dir != "" {
+		name = dir[strings.LastIndex(dir, "/")+1:]
+	}
+
+	folder := &Folder{Name: name}
+	for _, item := range listing.List {
+		folder.Items = append(folder.Items, Item{
+			ID:      item.ID,
+			Name:    item.Name,
+			IsDir:   item.IsDir,
+			Path:    fs + item.Path,
+			URL:     r.downloadURL(fs, item.Path),
+			Size:    item.Size,
+			ModTime: item.ModTime,
+			CRC32:   item.Hashes["crc32"],
+			MD5:     item.Hashes["md5"],
+		})
+	}
+	return folder, nil
+}
+
+// downloadURL returns the --rc-serve URL of a file, carrying the RC credentials
+func (r *Rclone) downloadURL(fs, filePath string) string {
+	u := *r.baseURL
+	if r.user != "" {
+		u.User = url.UserPassword(r.user, r.password)
+	}
+	return u.String() + "/[" + fs + "]/" + escapePath(filePath)
+}
+
+func (r *Rclone) call(method string, params interface{}, v interface{}) error {
+	body, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", r.baseURL.String()+"/"+method, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if r.user != "" {
+		req.SetBasicAuth(r.user, r.password)
+	}
+
+	resp, err := APIClient("rclone").Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to fetch folder contents: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("rclone RC request failed with status: %s", resp.Status)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("failed to unmarshal JSON response: %v", err)
+	}
+	return nil
+}
+
+// splitRclonePath splits "remote:dir/sub" into the fs "remote:" and the path "dir/sub"
+func splitRclonePath(remotePath string) (string, string, error) {
+	i := strings.Index(remotePath, ":")
+	if i < 0 {
+		return "", "", errors.New("rclone path must start with a remote name, e.g. remote:path")
+	}
+	return remotePath[:i+1], strings.Trim(remotePath[i+1:], "/"), nil
+}
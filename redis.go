@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// redisQueue is a minimal Redis client covering the list commands used by
+// the job queue, and the string commands front-ends share idempotency keys
+// with. Jobs are pushed with LPUSH and popped with BRPOP, so each job goes to
+// exactly one worker.
+type redisQueue struct {
+	addr     string
+	password string
+	db       int
+	name     string // Key of the list holding queued jobs
+
+	mu   sync.Mutex
+	conn net.Conn
+	rd   *bufio.Reader
+}
+
+// newRedisQueue parses a URL such as "redis://:password@host:6379/0"
+func newRedisQueue(rawURL, name string) (*redisQueue, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "redis" {
+		return nil, fmt.Errorf("unsupported queue scheme: %q", u.Scheme)
+	}
+	q := &redisQueue{addr: u.Host, name: name}
+	if !strings.Contains(q.addr, ":") {
+		q.addr += ":6379"
+	}
+	if u.User != nil {
+		q.password, _ = u.User.Password()
+	}
+	if db := strings.Trim(u.Path, "/"); db != "" {
+		if q.db, err = strconv.Atoi(db); err != nil {
+			return nil, fmt.Errorf("invalid queue database: %q", db)
+		}
+	}
+	return q, nil
+}
+
+// push adds a job to the queue
+func (q *redisQueue) push(job []byte) error {
+	_, err := q.do(0, "LPUSH", q.name, string(job))
+	return err
+}
+
+// pop waits up to timeout for a job, returning nil when none arrived
+func (q *redisQueue) pop(timeout time.Duration) ([]byte, error) {
+	seconds := strconv.Itoa(int(timeout / time.Second))
+	reply, err := q.do(timeout+5*time.Second, "BRPOP", q.name, seconds)
+	if err != nil || reply == nil {
+		return nil, err
+	}
+	// BRPOP replies with the list name and the element
+	items, ok := reply.([]interface{})
+	if !ok || len(items) != 2 {
+		return nil, errors.New("unexpected BRPOP reply")
+	}
+	job, _ := items[1].(string)
+	return []byte(job), nil
+}
+
+// claim sets key to value for ttl unless it is already set, reporting
+// whether it was
+func (q *redisQueue) claim(key, value string, ttl time.Duration) (bool, error) {
+	reply, err := q.do(0, "SET", key, value, "NX", "PX", strconv.FormatInt(ttl.Milliseconds(), 10))
+	return reply != nil, err
+}
+
+// get returns the value of key, or nil when it isn't set
+func (q *redisQueue) get(key string) ([]byte, error) {
+	reply, err := q.do(0, "GET", key)
+	if err != nil || reply == nil {
+		return nil, err
+	}
+	value, _ := reply.(string)
+	return []byte(value), nil
+}
+
+// set sets key to value for ttl
+func (q *redisQueue) set(key, value string, ttl time.Duration) error {
+	_, err := q.do(0, "SET", key, value, "PX", strconv.FormatInt(ttl.Milliseconds(), 10))
+	return err
+}
+
+// del removes key
+func (q *redisQueue) del(key string) error {
+	_, err := q.do(0, "DEL", key)
+	return err
+}
+
+// do sends a command and reads its reply, reconnecting after failures.
+// A timeout of 0 uses a short default.
+func (q *redisQueue) do(timeout time.Duration, args ...string) (interface{}, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.conn == nil {
+		if err := q.connect(); err != nil {
+			return nil, err
+		}
+	}
+	if timeout == 0 {
+		timeout = 10 * time.Second
+	}
+	q.conn.SetDeadline(time.Now().Add(timeout))
+	reply, err := q.command(args...)
+	var redisErr redisError
+	if err != nil && !errors.As(err, &redisErr) {
+		// The connection is in an unknown state
+		q.conn.Close()
+		q.conn = nil
+	}
+	return reply, err
+}
+
+// connect dials the server and selects the database; the caller holds the lock
+func (q *redisQueue) connect() error {
+	conn, err := net.DialTimeout("tcp", q.addr, 10*time.Second)
+	if err != nil {
+		return fmt.Errorf("failed to connect to queue: %v", err)
+	}
+	q.conn, q.rd = conn, bufio.NewReader(conn)
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	if q.password != "" {
+		if _, err := q.command("AUTH", q.password); err != nil {
+			conn.Close()
+			q.conn = nil
+			return fmt.Errorf("queue authentication failed: %v", err)
+		}
+	}
+	if q.db != 0 {
+		if _, err := q.command("SELECT", strconv.Itoa(q.db)); err != nil {
+			conn.Close()
+			q.conn = nil
+			return fmt.Errorf("failed to select queue database: %v", err)
+		}
+	}
+	return nil
+}
+
+// command writes a RESP array of bulk strings and reads the reply
+func (q *redisQueue) command(args ...string) (interface{}, error) {
+	var buf strings.Builder
+	fmt.Fprintf(&buf, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&buf, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if _, err := q.conn.Write([]byte(buf.String())); err != nil {
+		return nil, err
+	}
+	return readRESP(q.rd)
+}
+
+// redisError is an error reply from the server
+type redisError string
+
+func (e redisError) Error() string {
+	return string(e)
+}
+
+// readRESP reads one reply. Bulk strings become strings, nil replies nil and
+// arrays []interface{}.
+func readRESP(rd *bufio.Reader) (interface{}, error) {
+	line, err := rd.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("empty reply")
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		size, err := strconv.Atoi(line[1:])
+		if err != nil || size < 0 {
+			return nil, err
+		}
+		data := make([]byte, size+2)
+		if _, err := io.ReadFull(rd, data); err != nil {
+			return nil, err
+		}
+		return string(data[:size]), nil
+	case '*':
+		count, err := strconv.Atoi(line[1:])
+		if err != nil || count < 0 {
+			return nil, err
+		}
+		items := make([]interface{}, count)
+		for i := range items {
+			if items[i], err = readRESP(rd); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("unexpected reply: %q", line)
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"gozipstreamer/provider"
+	"gozipstreamer/zipstreamer"
+	"os"
+	"os/signal"
+	"reflect"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// reloadMu serializes reloads triggered by signals and by the file watch
+var reloadMu sync.Mutex
+
+// envFileKeys are the variables the environment file set last time, so
+// those removed from it are unset on reload
+var envFileKeys = map[string]bool{}
+
+// applyEnvFile sets the variables of an environment file of KEY=VALUE lines,
+// as used by systemd's EnvironmentFile, over the process environment.
+// Provider plugins are registered before it is read, so ZS_PLUGINS must be
+// set in the environment itself.
+func applyEnvFile(path string) error {
+	values, err := readEnvFile(path)
+	if err != nil {
+		return err
+	}
+	setEnvFile(values)
+	return nil
+}
+
+// readEnvFile parses an environment file, nil when path is empty
+func readEnvFile(path string) (map[string]string, error) {
+	if path == "" {
+		return nil, nil
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	values := make(map[string]string)
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(strings.TrimPrefix(text, "export "), "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("%s:%d: expected KEY=VALUE", path, line)
+		}
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		values[key] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// setEnvFile sets the variables of an environment file and unsets those it
+// set last time but no longer does. It returns a function restoring the
+// environment as it was before.
+func setEnvFile(values map[string]string) (undo func()) {
+	previousKeys := envFileKeys
+	saved := make(map[string]*string)
+	save := func(key string) {
+		if _, ok := saved[key]; ok {
+			return
+		}
+		if value, ok := os.LookupEnv(key); ok {
+			saved[key] = &value
+		} else {
+			saved[key] = nil
+		}
+	}
+
+	for key := range envFileKeys {
+		if _, ok := values[key]; !ok {
+			save(key)
+			os.Unsetenv(key)
+		}
+	}
+	envFileKeys = make(map[string]bool, len(values))
+	for key, value := range values {
+		save(key)
+		os.Setenv(key, value)
+		envFileKeys[key] = true
+	}
+
+	return func() {
+		for key, value := range saved {
+			if value == nil {
+				os.Unsetenv(key)
+			} else {
+				os.Setenv(key, *value)
+			}
+		}
+		envFileKeys = previousKeys
+	}
+}
+
+// configureClients sets up the upstream clients of cfg: the shared one, also
+// used for provider API calls so they obey the egress rules, and those of
+// providers with their own proxy. They are only replaced when their
+// settings changed since previous, so idle connections stay reusable.
+func configureClients(cfg, previous *serverConfig) {
+	if previous != nil && reflect.DeepEqual(cfg.transport, previous.transport) &&
+		reflect.DeepEqual(cfg.providerProxies, previous.providerProxies) {
+		return
+	}
+	client := zipstreamer.NewClient(cfg.transport)
+	zipstreamer.SetDefaultClient(client)
+	provider.SetDefaultHTTPClient(client)
+	if previous != nil {
+		for name := range previous.providerProxies {
+			if _, ok := cfg.providerProxies[name]; !ok {
+				provider.SetHTTPClient(name, nil)
+			}
+		}
+	}
+	for name, proxy := range cfg.providerProxies {
+		transport := cfg.transport
+		transport.Proxy = proxy
+		provider.SetHTTPClient(name, zipstreamer.NewClient(transport))
+	}
+}
+
+// keepStartupSettings copies into next the settings that were used to set up
+// listeners, stores, caches, targets and other components at startup, which
+// only change with a restart. The admin and gRPC tokens and the audit log can
+// change but not be turned on or off, since their endpoints and log are set
+// up once.
+func keepStartupSettings(next, current *serverConfig) {
+	next.etagCacheSize, next.etagCacheEntry = current.etagCacheSize, current.etagCacheEntry
+	next.diskCacheDir, next.diskCacheSize, next.diskCacheHits = current.diskCacheDir, current.diskCacheSize, current.diskCacheHits
+	next.memCacheSize, next.memCacheEntry, next.memCacheTTL = current.memCacheSize, current.memCacheEntry, current.memCacheTTL
+	next.memoryBudget = current.memoryBudget
+	next.adminAddr = current.adminAddr
+	if (next.adminToken == "") != (current.adminToken == "") {
+		next.adminToken = current.adminToken
+	}
+	if (next.auditLog == "") != (current.auditLog == "") {
+		next.auditLog = current.auditLog
+	}
+	next.jobStore, next.jobRetention, next.jobKey = current.jobStore, current.jobRetention, current.jobKey
+	next.mode, next.queueURL, next.queueName = current.mode, current.queueURL, current.queueName
+	next.advertiseURL, next.workerToken, next.workerConcurrency = current.advertiseURL, current.workerToken, current.workerConcurrency
+	next.s3, next.gcs, next.localTargetDir = current.s3, current.gcs, current.localTargetDir
+	next.smtpAddr, next.smtpFrom, next.smtpUsername, next.smtpPassword = current.smtpAddr, current.smtpFrom, current.smtpUsername, current.smtpPassword
+	next.notifyDomains = current.notifyDomains
+	next.linkKey, next.publicURL = current.linkKey, current.publicURL
+	next.browseCacheTTL = current.browseCacheTTL
+	next.clamdAddr, next.clamdTimeout = current.clamdAddr, current.clamdTimeout
+	next.grpcAddr, next.grpcInsecure = current.grpcAddr, current.grpcInsecure
+	if (next.grpcToken == "") != (current.grpcToken == "") {
+		next.grpcToken = current.grpcToken
+	}
+	next.listenSocket, next.socketMode = current.listenSocket, current.socketMode
+	next.configWatch = current.configWatch
+}
+
+// reloadConfig re-reads the environment file and the environment, and
+// switches to the new configuration. Limits, egress rules, timeouts, provider
+// credentials and the like apply to the next requests and entries, while
+// archives being streamed go on undisturbed. The audit log is reopened, so
+// it can be rotated too. An invalid configuration is refused as a whole,
+// leaving the environment and the configuration in effect unchanged.
+func reloadConfig() error {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+	values, err := readEnvFile(os.Getenv(EnvFileEnvVar))
+	if err != nil {
+		return err
+	}
+	undo := setEnvFile(values)
+	current := config()
+	next, err := loadConfig()
+	if err != nil {
+		undo()
+		return err
+	}
+	keepStartupSettings(next, current)
+
+	configureClients(next, current)
+	if auditTrail != nil {
+		if err := auditTrail.reopen(next.auditLog); err != nil {
+			fmt.Printf("Failed to reopen audit log, keeping the previous one: %v\n", err)
+			next.auditLog = current.auditLog
+		}
+	}
+	currentConfig.Store(next)
+	return nil
+}
+
+// watchReload reloads the configuration on SIGHUP and, when a watch interval
+// is set, whenever the environment file changes
+func watchReload() {
+	reload := func(reason string) {
+		if err := reloadConfig(); err != nil {
+			fmt.Printf("Configuration not reloaded on %s: %v\n", reason, err)
+			return
+		}
+		fmt.Printf("Configuration reloaded on %s\n", reason)
+	}
+
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+	go func() {
+		for range hangups {
+			reload("SIGHUP")
+		}
+	}()
+
+	path := os.Getenv(EnvFileEnvVar)
+	if path == "" || config().configWatch <= 0 {
+		return
+	}
+	go func() {
+		var modified time.Time
+		if info, err := os.Stat(path); err == nil {
+			modified = info.ModTime()
+		}
+		for range time.Tick(config().configWatch) {
+			info, err := os.Stat(path)
+			if err != nil || info.ModTime().Equal(modified) {
+				continue
+			}
+			modified = info.ModTime()
+			reload("change of " + path)
+		}
+	}()
+}
@@ -0,0 +1,330 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gozipstreamer/zipstreamer"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// jobCheckpointInterval is how often resumable jobs record a checkpoint
+const jobCheckpointInterval = 30 * time.Second
+
+// maxJobResumes is how often a job is resumed before it is given up as failed,
+// so that a job crashing the server doesn't do so on every start
+const maxJobResumes = 3
+
+// Headers of a job's request that are replayed to resume it. The credentials
+// that survive a resume are those /create-zip reads: the apikey parameter in
+// the URL, and the credentials, passwords, cookies and headers of the
+// descriptor's entries in the body, which are only stored sealed with the job
+// key. No other headers are kept, so credentials sent in headers, e.g. an
+// Authorization header meant for a proxy in front of the server, are not
+// stored and don't take part in a resumed build.
+var replayedHeaders = []string{"Content-Type", "X-Forwarded-For"}
+
+// jobRequest is the archive request of a job, replayed to resume it
+type jobRequest struct {
+	Method string              `json:"method"`
+	URL    string              `json:"url"` // Path and query of the original request
+	Header map[string][]string `json:"header,omitempty"`
+	Body   []byte              `json:"body,omitempty"`
+	Client string              `json:"client"`
+}
+
+// jobCheckpoint is the state of a job's archive at an entry boundary
+type jobCheckpoint struct {
+	Entries string                  `json:"entries"` // Fingerprint of the archive's entries, which must match to resume
+	Target  json.RawMessage         `json:"target"`
+	Stream  *zipstreamer.Checkpoint `json:"stream"`
+	SHA256  []byte                  `json:"sha256"` // States of the archive checksums
+	CRC32   []byte                  `json:"crc32"`
+}
+
+// jobResume is what resuming a job takes: its request and the last
+// checkpoint of its archive, if any. The request carries the job's
+// credentials, so it is only stored sealed with the job key.
+type jobResume struct {
+	Request    *jobRequest    `json:"request"`
+	Checkpoint *jobCheckpoint `json:"checkpoint,omitempty"`
+}
+
+// seal encrypts the resume state with the job key
+func (resume *jobResume) seal() ([]byte, error) {
+	aead, err := jobCipher()
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := json.Marshal(resume)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+// openJobResume decrypts a resume state sealed with the job key
+func openJobResume(sealed []byte) (*jobResume, error) {
+	aead, err := jobCipher()
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("sealed resume state is truncated")
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, errors.New("resume state was sealed with another job key")
+	}
+	resume := &jobResume{}
+	if err := json.Unmarshal(plaintext, resume); err != nil {
+		return nil, err
+	}
+	return resume, nil
+}
+
+// jobCipher returns the AEAD sealing resume states with the job key
+func jobCipher() (cipher.AEAD, error) {
+	if config().jobKey == nil {
+		return nil, fmt.Errorf("%s is not set", JobKeyEnvVar)
+	}
+	block, err := aes.NewCipher(config().jobKey)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// jobRequestKey carries the replayable request of a job in the request context
+type jobRequestKey struct{}
+
+// resumedJobKey carries the interrupted job a request resumes
+type resumedJobKey struct{}
+
+// interruptedJob is the record of a job interrupted by a restart with the
+// checkpoint it resumes from, if any
+type interruptedJob struct {
+	record     *jobRecord
+	checkpoint *jobCheckpoint
+}
+
+// withJobRequest records the request in its context with its body, so that a
+// job building its archive into a target can be resumed by replaying it
+func withJobRequest(r *http.Request, body []byte) *http.Request {
+	if config().jobKey == nil {
+		return r
+	}
+	request := &jobRequest{Method: r.Method, URL: r.URL.RequestURI(), Header: make(map[string][]string), Body: body, Client: r.RemoteAddr}
+	for _, name := range replayedHeaders {
+		if values := r.Header.Values(name); len(values) > 0 {
+			request.Header[name] = values
+		}
+	}
+	return r.WithContext(context.WithValue(r.Context(), jobRequestKey{}, request))
+}
+
+// resumedJob returns the interrupted job the request resumes, if any
+func resumedJob(r *http.Request) *interruptedJob {
+	job, _ := r.Context().Value(resumedJobKey{}).(*interruptedJob)
+	return job
+}
+
+// entriesFingerprint identifies the entries of an archive, which a resumed
+// job resolves again and which must not have changed since its checkpoint
+func entriesFingerprint(fileEntries []*zipstreamer.FileEntry) string {
+	hash := sha256.New()
+	for _, entry := range fileEntries {
+		fmt.Fprintf(hash, "%q %d %t\n", entry.ZipPath(), entry.Size(), entry.IsDir())
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// resumableRequest returns the recorded request of a job that can be
+// resumed: one building its archive only into a target, with a job history
+func resumableRequest(r *http.Request, options *zipOptions) *jobRequest {
+	request, _ := r.Context().Value(jobRequestKey{}).(*jobRequest)
+	if options.target == "" || options.tee || jobHistory == nil {
+		return nil
+	}
+	return request
+}
+
+// openJobTarget opens the target of a job. Jobs that can be resumed open it
+// for checkpoints, and when resuming continue the partial archive of their
+// checkpoint if their entries are unchanged; otherwise they start over.
+func openJobTarget(r *http.Request, jobID string, resumable bool, fileEntries []*zipstreamer.FileEntry, options *zipOptions) (archiveTarget, *jobCheckpoint, error) {
+	if !resumable {
+		target, err := targetOpeners[options.target](jobID, options.filename)
+		return target, nil, err
+	}
+
+	resume := targetResumers[options.target]
+	if previous := resumedJob(r); previous != nil && previous.checkpoint != nil {
+		checkpoint := previous.checkpoint
+		target, err := resume(jobID, options.filename, checkpoint.Target, checkpoint.Stream.Offset)
+		switch {
+		case err != nil:
+			fmt.Printf("Job %s: failed to resume the partial archive, starting over: %v\n", jobID, err)
+		case checkpoint.Entries != entriesFingerprint(fileEntries):
+			fmt.Printf("Job %s: entries changed since the checkpoint, starting over\n", jobID)
+			target.abort()
+		default:
+			fmt.Printf("Job %s: resuming after entry %d at %d bytes\n", jobID, checkpoint.Stream.Entries, checkpoint.Stream.Offset)
+			return target, checkpoint, nil
+		}
+	}
+	target, err := resume(jobID, options.filename, nil, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	return target, nil, nil
+}
+
+// checkpointJob records the checkpoints of a job's stream into a resumable
+// target in its record. Until the first one, the job resumes from the
+// checkpoint it started at, if any.
+func checkpointJob(zipStream *zipstreamer.ZipStream, target resumableTarget, checksum *checksumWriter, job *jobRecord, request *jobRequest, start *jobCheckpoint, fileEntries []*zipstreamer.FileEntry) {
+	sealed, err := (&jobResume{Request: request, Checkpoint: start}).seal()
+	if err != nil {
+		fmt.Printf("Job %s: can't be resumed: %v\n", job.ID, err)
+		return
+	}
+	job.Resume = sealed
+
+	fingerprint := entriesFingerprint(fileEntries)
+	zipStream.CheckpointPeriod = jobCheckpointInterval
+	zipStream.OnCheckpoint = func(stream *zipstreamer.Checkpoint) error {
+		state, err := target.checkpoint()
+		if err != nil || state == nil {
+			return err
+		}
+		resume := &jobResume{Request: request, Checkpoint: &jobCheckpoint{Entries: fingerprint, Target: state, Stream: stream}}
+		if resume.Checkpoint.SHA256, resume.Checkpoint.CRC32, err = checksum.state(); err != nil {
+			return err
+		}
+		sealed, err := resume.seal()
+		if err != nil {
+			return err
+		}
+		jobHistory.saveResume(job, sealed)
+		return nil
+	}
+}
+
+// resumeJobs rebuilds the jobs interrupted by the last shutdown that can be
+// resumed, oldest first. Each continues from its last checkpoint, if it has one.
+func resumeJobs() {
+	if jobHistory == nil || config().jobKey == nil {
+		return
+	}
+	jobs, err := jobHistory.list(jobInterrupted, 0)
+	if err != nil {
+		fmt.Printf("Failed to list interrupted jobs: %v\n", err)
+		return
+	}
+	for i := len(jobs) - 1; i >= 0; i-- {
+		job := jobs[i]
+		if job.Resume == nil {
+			continue
+		}
+		if job.Resumes >= maxJobResumes {
+			fmt.Printf("Job %s: interrupted %d times, giving up\n", job.ID, job.Resumes+1)
+			failInterruptedJob(job, "interrupted too often")
+			continue
+		}
+		job.Resumes++
+		jobHistory.put(job)
+		resumeJob(job)
+	}
+}
+
+// resumeJob replays the request of an interrupted job under its job ID
+func resumeJob(job *jobRecord) {
+	resume, err := openJobResume(job.Resume)
+	var r *http.Request
+	if err == nil {
+		ctx := context.WithValue(context.Background(), jobIDKey{}, job.ID)
+		ctx = context.WithValue(ctx, resumedJobKey{}, &interruptedJob{record: job, checkpoint: resume.Checkpoint})
+		r, err = http.NewRequestWithContext(ctx, resume.Request.Method, resume.Request.URL, bytes.NewReader(resume.Request.Body))
+	}
+	if err != nil {
+		fmt.Printf("Job %s: failed to resume: %v\n", job.ID, err)
+		failInterruptedJob(job, err.Error())
+		return
+	}
+	for name, values := range resume.Request.Header {
+		r.Header[name] = values
+	}
+	r.RemoteAddr = resume.Request.Client
+
+	fmt.Printf("Job %s: resuming interrupted job\n", job.ID)
+	response := &jobResponse{header: make(http.Header), status: http.StatusOK}
+	zipHandler(response, r)
+	// Requests refused before streaming leave the interrupted job behind
+	stored, err := jobHistory.get(job.ID)
+	if err != nil || stored == nil {
+		return
+	}
+	if stored.Status == jobInterrupted {
+		failInterruptedJob(stored, response.refusal())
+	}
+	fmt.Printf("Job %s: finished as %s\n", job.ID, stored.Status)
+}
+
+// failInterruptedJob gives up an interrupted job
+func failInterruptedJob(job *jobRecord, reason string) {
+	job.Status = "failed"
+	job.Error = reason
+	job.Resume = nil
+	jobHistory.put(job)
+}
+
+// maxRefusalLength limits how much of a refused response is kept as its reason
+const maxRefusalLength = 1024
+
+// jobResponse takes the response to the replayed request of a resumed job,
+// whose archive doesn't go to a client. It keeps the status and the start of
+// the body to tell why a request was refused.
+type jobResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (r *jobResponse) Header() http.Header {
+	return r.header
+}
+
+func (r *jobResponse) WriteHeader(status int) {
+	r.status = status
+}
+
+func (r *jobResponse) Write(p []byte) (int, error) {
+	if r.status >= http.StatusBadRequest && r.body.Len() < maxRefusalLength {
+		r.body.Write(p[:min(len(p), maxRefusalLength-r.body.Len())])
+	}
+	return len(p), nil
+}
+
+// refusal describes a response that refused the request
+func (r *jobResponse) refusal() string {
+	reason := fmt.Sprintf("resuming was refused with status %d", r.status)
+	if message := strings.TrimSpace(r.body.String()); message != "" {
+		reason += ": " + message
+	}
+	return reason
+}
@@ -0,0 +1,448 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// minS3PartSize is the smallest part S3 accepts, except for the last one
+const minS3PartSize = 5 * 1024 * 1024
+
+// s3Config is the bucket that archives are uploaded to
+type s3Config struct {
+	endpoint     string // e.g. https://s3.eu-central-1.amazonaws.com or a MinIO URL
+	region       string
+	bucket       string
+	prefix       string // Prepended to the object keys
+	pathStyle    bool   // Address the bucket in the path instead of the host name
+	accessKey    string
+	secretKey    string
+	sessionToken string
+	partSize     int64
+}
+
+// s3Target uploads an archive as the parts of a multipart upload. One part is
+// uploaded in the background while the next is filled. A full part is held
+// back in the buffer, so that a checkpoint can upload what it holds as a
+// part of at least the minimum size.
+type s3Target struct {
+	cfg      *s3Config
+	key      string
+	uploadID string
+
+	buf     bytes.Buffer
+	parts   chan []byte
+	wg      sync.WaitGroup
+	pending sync.WaitGroup // Parts queued but not yet uploaded
+	mu      sync.Mutex
+	etags   []string // Indexed by part number - 1
+	err     error    // First upload failure
+	closed  bool     // No more parts are queued
+}
+
+// openS3Target starts a multipart upload for the archive of a job
+func openS3Target(cfg *s3Config) targetOpener {
+	return func(jobID, filename string) (archiveTarget, error) {
+		t := &s3Target{cfg: cfg, key: cfg.prefix + jobID + "/" + targetFilename(filename), parts: make(chan []byte, 1)}
+
+		var result struct {
+			UploadID string `xml:"UploadId"`
+		}
+		if err := t.call("POST", url.Values{"uploads": {""}}, nil, &result); err != nil {
+			return nil, fmt.Errorf("failed to start upload: %v", err)
+		}
+		t.uploadID = result.UploadID
+
+		t.wg.Add(1)
+		go t.uploadParts()
+		return t, nil
+	}
+}
+
+func (t *s3Target) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	err := t.err
+	t.mu.Unlock()
+	if err != nil {
+		return 0, err
+	}
+
+	t.buf.Write(p)
+	for int64(t.buf.Len()) >= 2*t.cfg.partSize {
+		t.queue(bytes.Clone(t.buf.Next(int(t.cfg.partSize))))
+	}
+	return len(p), nil
+}
+
+// queue hands a part to the upload
+func (t *s3Target) queue(part []byte) {
+	t.pending.Add(1)
+	t.parts <- part
+}
+
+// uploadParts uploads the queued parts in order
+func (t *s3Target) uploadParts() {
+	defer t.wg.Done()
+	for part := range t.parts {
+		t.mu.Lock()
+		failed := t.err != nil
+		number := len(t.etags) + 1
+		t.etags = append(t.etags, "")
+		t.mu.Unlock()
+		if failed {
+			t.pending.Done()
+			continue
+		}
+
+		query := url.Values{"partNumber": {fmt.Sprint(number)}, "uploadId": {t.uploadID}}
+		resp, err := t.do("PUT", query, part)
+		t.mu.Lock()
+		if err != nil {
+			t.err = fmt.Errorf("failed to upload part %d: %v", number, err)
+		} else {
+			t.etags[number-1] = resp.Header.Get("ETag")
+			resp.Body.Close()
+		}
+		t.mu.Unlock()
+		t.pending.Done()
+	}
+}
+
+// s3Checkpoint is the resume state of a multipart upload
+type s3Checkpoint struct {
+	Key      string   `json:"key"`
+	UploadID string   `json:"uploadId"`
+	ETags    []string `json:"etags"`
+}
+
+// resumeS3Target continues the multipart upload of a job after its uploaded
+// parts, which hold the archive up to the checkpoint
+func resumeS3Target(cfg *s3Config) targetResumer {
+	open := openS3Target(cfg)
+	return func(jobID, filename string, state json.RawMessage, offset int64) (resumableTarget, error) {
+		if state == nil {
+			target, err := open(jobID, filename)
+			if err != nil {
+				return nil, err
+			}
+			return target.(*s3Target), nil
+		}
+
+		var checkpoint s3Checkpoint
+		if err := json.Unmarshal(state, &checkpoint); err != nil {
+			return nil, err
+		}
+		t := &s3Target{cfg: cfg, key: checkpoint.Key, uploadID: checkpoint.UploadID, parts: make(chan []byte, 1), etags: checkpoint.ETags}
+		// Uploads that were completed or aborted since are gone
+		var parts struct{}
+		query := url.Values{"uploadId": {t.uploadID}, "max-parts": {"1"}}
+		if err := t.call("GET", query, nil, &parts); err != nil {
+			return nil, fmt.Errorf("failed to find upload: %v", err)
+		}
+
+		t.wg.Add(1)
+		go t.uploadParts()
+		return t, nil
+	}
+}
+
+// checkpoint uploads the buffer as a part and waits for every queued part.
+// Less than the minimum part size since the last checkpoint can't be
+// uploaded before the archive is complete, so there is no checkpoint then.
+func (t *s3Target) checkpoint() (json.RawMessage, error) {
+	if t.buf.Len() > 0 && t.buf.Len() < minS3PartSize {
+		return nil, nil
+	}
+	if t.buf.Len() > 0 {
+		t.queue(bytes.Clone(t.buf.Bytes()))
+		t.buf.Reset()
+	}
+	t.pending.Wait()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.err != nil {
+		return nil, t.err
+	}
+	return json.Marshal(s3Checkpoint{Key: t.key, UploadID: t.uploadID, ETags: t.etags})
+}
+
+// commit uploads the last part and completes the upload
+func (t *s3Target) commit() (string, error) {
+	t.queue(bytes.Clone(t.buf.Bytes()))
+	t.finishParts()
+	if t.err != nil {
+		return "", t.err
+	}
+
+	type completedPart struct {
+		PartNumber int
+		ETag       string
+	}
+	var complete struct {
+		XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+		Parts   []completedPart `xml:"Part"`
+	}
+	for i, etag := range t.etags {
+		complete.Parts = append(complete.Parts, completedPart{PartNumber: i + 1, ETag: etag})
+	}
+	body, err := xml.Marshal(&complete)
+	if err != nil {
+		return "", err
+	}
+	// Completion can fail with a 200 status, which carries an error document
+	var result struct {
+		XMLName  xml.Name
+		Location string `xml:"Location"`
+		Message  string `xml:"Message"`
+	}
+	if err := t.call("POST", url.Values{"uploadId": {t.uploadID}}, body, &result); err != nil {
+		return "", fmt.Errorf("failed to complete upload: %v", err)
+	}
+	if result.XMLName.Local == "Error" {
+		return "", fmt.Errorf("failed to complete upload: %s", result.Message)
+	}
+	return t.cfg.objectURL(t.key).String(), nil
+}
+
+// link returns a presigned download URL of the stored archive
+func (t *s3Target) link(ttl time.Duration) (string, error) {
+	return t.cfg.presign(t.key, ttl, time.Now()), nil
+}
+
+// abort discards the uploaded parts, which would otherwise be billed
+func (t *s3Target) abort() {
+	t.finishParts()
+	if _, err := t.do("DELETE", url.Values{"uploadId": {t.uploadID}}, nil); err != nil {
+		fmt.Printf("Failed to abort upload of %s: %v\n", t.key, err)
+	}
+}
+
+// finishParts waits for the queued parts to be uploaded
+func (t *s3Target) finishParts() {
+	if !t.closed {
+		close(t.parts)
+		t.closed = true
+	}
+	t.wg.Wait()
+}
+
+// call sends a signed request and decodes its XML response into v
+func (t *s3Target) call(method string, query url.Values, body []byte, v interface{}) error {
+	resp, err := t.do(method, query, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return xml.NewDecoder(resp.Body).Decode(v)
+}
+
+// do sends a signed request for the object, failing on error statuses. The
+// body of successful responses is left to the caller.
+func (t *s3Target) do(method string, query url.Values, body []byte) (*http.Response, error) {
+	u := t.cfg.objectURL(t.key)
+	u.RawQuery = strings.ReplaceAll(query.Encode(), "+", "%20")
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	t.cfg.sign(req, body, time.Now())
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		defer resp.Body.Close()
+		var s3Err struct {
+			Code    string `xml:"Code"`
+			Message string `xml:"Message"`
+		}
+		xml.NewDecoder(io.LimitReader(resp.Body, 64*1024)).Decode(&s3Err)
+		return nil, fmt.Errorf("S3 returned %s: %s %s", resp.Status, s3Err.Code, s3Err.Message)
+	}
+	return resp, nil
+}
+
+// objectURL returns the URL of an object in the bucket
+func (c *s3Config) objectURL(key string) *url.URL {
+	u, _ := url.Parse(strings.TrimRight(c.endpoint, "/"))
+	escaped := s3Escape(key)
+	if c.pathStyle {
+		u.Path = "/" + c.bucket + "/" + key
+		u.RawPath = "/" + s3Escape(c.bucket) + "/" + escaped
+	} else {
+		u.Host = c.bucket + "." + u.Host
+		u.Path = "/" + key
+		u.RawPath = "/" + escaped
+	}
+	return u
+}
+
+// sign adds an AWS Signature Version 4 to the request
+func (c *s3Config) sign(req *http.Request, body []byte, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	payloadHash := sha256Hex(body)
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if c.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", c.sessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name := range req.Header {
+		if lower := strings.ToLower(name); strings.HasPrefix(lower, "x-amz-") {
+			headers[lower] = strings.TrimSpace(req.Header.Get(name))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := c.scope(date)
+	signature := c.signature(date, amzDate, canonicalRequest)
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		c.accessKey, scope, signedHeaders, signature))
+}
+
+// presign returns a URL that allows anyone to download the object until it
+// expires. S3 accepts lifetimes of up to a week.
+func (c *s3Config) presign(key string, ttl time.Duration, now time.Time) string {
+	if ttl > 7*24*time.Hour {
+		ttl = 7 * 24 * time.Hour
+	}
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	u := c.objectURL(key)
+	query := url.Values{
+		"X-Amz-Algorithm":     {"AWS4-HMAC-SHA256"},
+		"X-Amz-Credential":    {c.accessKey + "/" + c.scope(date)},
+		"X-Amz-Date":          {amzDate},
+		"X-Amz-Expires":       {fmt.Sprint(int64(ttl.Seconds()))},
+		"X-Amz-SignedHeaders": {"host"},
+	}
+	if c.sessionToken != "" {
+		query.Set("X-Amz-Security-Token", c.sessionToken)
+	}
+	canonicalRequest := strings.Join([]string{
+		"GET",
+		u.EscapedPath(),
+		canonicalQuery(query),
+		"host:" + u.Host + "\n",
+		"host",
+		"UNSIGNED-PAYLOAD",
+	}, "\n")
+
+	u.RawQuery = canonicalQuery(query) + "&X-Amz-Signature=" + c.signature(date, amzDate, canonicalRequest)
+	return u.String()
+}
+
+// scope is the credential scope of signatures made on date
+func (c *s3Config) scope(date string) string {
+	return date + "/" + c.region + "/s3/aws4_request"
+}
+
+// signature signs a canonical request with the key derived for date
+func (c *s3Config) signature(date, amzDate, canonicalRequest string) string {
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + c.scope(date) + "\n" + sha256Hex([]byte(canonicalRequest))
+	key := hmacSHA256([]byte("AWS4"+c.secretKey), date)
+	key = hmacSHA256(key, c.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	return hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+// validate checks that the configuration can be used for uploads
+func (c *s3Config) validate() error {
+	if c.accessKey == "" || c.secretKey == "" {
+		return errors.New("S3 credentials are not set")
+	}
+	if _, err := url.Parse(c.endpoint); err != nil {
+		return fmt.Errorf("invalid S3 endpoint: %v", err)
+	}
+	if c.partSize < minS3PartSize {
+		return fmt.Errorf("S3 part size must be at least %d bytes", minS3PartSize)
+	}
+	return nil
+}
+
+// canonicalQuery encodes query parameters sorted by name as SigV4 requires
+func canonicalQuery(query url.Values) string {
+	var pairs []string
+	for name, values := range query {
+		for _, value := range values {
+			pairs = append(pairs, s3EscapeQuery(name)+"="+s3EscapeQuery(value))
+		}
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "&")
+}
+
+// s3Escape percent-encodes a path, keeping unreserved characters and slashes
+func s3Escape(s string) string {
+	return sigV4Escape(s, "-_.~/")
+}
+
+// s3EscapeQuery percent-encodes a query component, keeping unreserved characters
+func s3EscapeQuery(s string) string {
+	return sigV4Escape(s, "-_.~")
+}
+
+// sigV4Escape percent-encodes everything but alphanumerics and the keep characters
+func sigV4Escape(s, keep string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || strings.IndexByte(keep, c) >= 0 {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"gozipstreamer/provider"
+	"gozipstreamer/zipstreamer"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// selfTestTimeout limits each connectivity check of the self-test
+const selfTestTimeout = 10 * time.Second
+
+// Outcomes of self-test checks
+const (
+	checkPass = "pass"
+	checkFail = "fail"
+	checkSkip = "skip" // Not configured, so nothing to check
+)
+
+// selfTestCheck is the outcome of one check of the self-test
+type selfTestCheck struct {
+	Name     string `json:"name"`
+	Status   string `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Duration int64  `json:"durationMs"`
+}
+
+// selfTestReport is the response of /selftest
+type selfTestReport struct {
+	Status string          `json:"status"`
+	Checks []selfTestCheck `json:"checks"`
+}
+
+// selfTestHandler runs every check and answers 503 when any of them failed,
+// so deploy scripts can gate on the status code alone
+func selfTestHandler(w http.ResponseWriter, r *http.Request) {
+	checks := []func(context.Context) selfTestCheck{checkEgressRules}
+	for _, dir := range []string{config().diskCacheDir, config().localTargetDir, os.TempDir()} {
+		if dir != "" {
+			checks = append(checks, checkWritable(dir))
+		}
+	}
+	for _, name := range selfTestProviders() {
+		checks = append(checks, checkProvider(name))
+	}
+	if len(config().selfTestURLs) == 0 {
+		checks = append(checks, func(context.Context) selfTestCheck {
+			return selfTestCheck{Name: "outbound", Status: checkSkip, Detail: SelfTestURLsEnvVar + " is not set"}
+		})
+	}
+	for _, target := range config().selfTestURLs {
+		checks = append(checks, checkOutbound(target))
+	}
+
+	report := selfTestReport{Status: checkPass, Checks: make([]selfTestCheck, len(checks))}
+	var wg sync.WaitGroup
+	for i, check := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			start := time.Now()
+			report.Checks[i] = check(r.Context())
+			report.Checks[i].Duration = time.Since(start).Milliseconds()
+		}()
+	}
+	wg.Wait()
+
+	status := http.StatusOK
+	for _, check := range report.Checks {
+		if check.Status == checkFail {
+			report.Status = checkFail
+			status = http.StatusServiceUnavailable
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(report)
+}
+
+// selfTestProviders lists the providers whose API is checked: the configured
+// ones, or every provider with an API endpoint
+func selfTestProviders() []string {
+	if len(config().selfTestProviders) > 0 {
+		return config().selfTestProviders
+	}
+	var names []string
+	for _, name := range provider.Names() {
+		if provider.Endpoint(name) != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// checkEgressRules reports whether an allowlist restricts destinations.
+// Invalid rules can't get this far, since they fail the configuration.
+func checkEgressRules(context.Context) selfTestCheck {
+	check := selfTestCheck{Name: "egress rules", Status: checkPass, Detail: "allowlist configured"}
+	if config().transport.EgressAllow.Empty() {
+		check.Detail = "no allowlist, every destination not denied is allowed"
+	}
+	return check
+}
+
+// checkWritable creates and removes a file in dir
+func checkWritable(dir string) func(context.Context) selfTestCheck {
+	return func(context.Context) selfTestCheck {
+		check := selfTestCheck{Name: "writable " + dir, Status: checkPass}
+		file, err := os.CreateTemp(dir, ".selftest-*")
+		if err == nil {
+			file.Close()
+			err = os.Remove(file.Name())
+		}
+		if err != nil {
+			check.Status = checkFail
+			check.Detail = err.Error()
+		}
+		return check
+	}
+}
+
+// checkProvider requests the API endpoint of a provider through its client.
+// Any response shows the API is reachable, even one refusing the missing
+// credentials.
+func checkProvider(name string) func(context.Context) selfTestCheck {
+	return func(ctx context.Context) selfTestCheck {
+		endpoint := provider.Endpoint(name)
+		if endpoint == "" {
+			return selfTestCheck{Name: "provider " + name, Status: checkSkip, Detail: "no API endpoint"}
+		}
+		client := provider.HTTPClient(name)
+		if client == nil {
+			client = http.DefaultClient
+		}
+		return reachable(ctx, "provider "+name, client, endpoint)
+	}
+}
+
+// checkOutbound requests a URL through the client used for archive entries
+func checkOutbound(target string) func(context.Context) selfTestCheck {
+	return func(ctx context.Context) selfTestCheck {
+		return reachable(ctx, "outbound "+target, zipstreamer.DefaultClient(), target)
+	}
+}
+
+// reachable reports whether a GET of target gets any HTTP response
+func reachable(ctx context.Context, name string, client *http.Client, target string) selfTestCheck {
+	check := selfTestCheck{Name: name, Status: checkPass}
+	ctx, cancel := context.WithTimeout(ctx, selfTestTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		check.Status = checkFail
+		check.Detail = err.Error()
+		return check
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		check.Status = checkFail
+		check.Detail = err.Error()
+		return check
+	}
+	resp.Body.Close()
+	check.Detail = resp.Status
+	return check
+}
+
+// startSelfTest mounts the self-test, which requires the admin token since
+// it reveals the configuration and makes outbound requests
+func startSelfTest(r *mux.Router) {
+	if config().adminToken == "" {
+		return
+	}
+	r.Handle("/selftest", requireAdminToken(http.HandlerFunc(selfTestHandler))).Methods("GET")
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// isServiceCommand reports whether the first argument manages the Windows service
+func isServiceCommand(command string) bool {
+	switch command {
+	case "install", "uninstall", "start", "stop":
+		return true
+	}
+	return false
+}
+
+// serve runs the main server until the process is asked to stop, then gives
+// the archives being streamed up to the shutdown timeout to finish
+func serve(server *http.Server, listener net.Listener) error {
+	stop, stopped := notifyStop()
+	defer stopped()
+
+	errs := make(chan error, 1)
+	go func() { errs <- server.Serve(listener) }()
+	select {
+	case err := <-errs:
+		return err
+	case <-stop:
+	}
+
+	fmt.Printf("Shutting down, waiting up to %s for running archives\n", config().shutdownTimeout)
+	ctx, cancel := context.WithTimeout(context.Background(), config().shutdownTimeout)
+	defer cancel()
+	err := server.Shutdown(ctx)
+	stopGRPC(ctx)
+	if errors.Is(err, context.DeadlineExceeded) {
+		fmt.Println("Shutdown timed out, dropping the remaining archives")
+		return nil
+	}
+	return err
+}
+
+// signalStop returns a channel closed on the first interrupt or termination
+// signal. A second signal exits right away.
+func signalStop() <-chan struct{} {
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	stop := make(chan struct{})
+	go func() {
+		sig := <-signals
+		fmt.Printf("Received %s\n", sig)
+		close(stop)
+		<-signals
+		os.Exit(1)
+	}()
+	return stop
+}
@@ -0,0 +1,20 @@
+//go:build !windows
+
+package main
+
+import "errors"
+
+// notifyStop returns a channel closed when the server should stop, and a
+// function to call once it has
+func notifyStop() (stop <-chan struct{}, stopped func()) {
+	return signalStop(), func() {}
+}
+
+// serviceCommand refuses the service management commands, which only exist
+// on Windows. systemd and other managers run the server in the foreground.
+func serviceCommand(args []string) (handled bool, err error) {
+	if len(args) == 0 || !isServiceCommand(args[0]) {
+		return false, nil
+	}
+	return true, errors.New("service commands are only supported on Windows")
+}
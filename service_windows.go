@@ -0,0 +1,146 @@
+//go:build windows
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/windows/registry"
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+// serviceName is the name the server is registered under with the service control manager
+const serviceName = "gozipstreamer"
+
+// windowsService reports the server to the service control manager and
+// relays its stop requests
+type windowsService struct {
+	stop    chan struct{} // Closed when the manager asks the service to stop
+	stopped chan struct{} // Closed once the server has shut down
+}
+
+// notifyStop returns a channel closed when the server should stop, and a
+// function to call once it has. Under the service control manager the stop
+// comes from its Stop and Shutdown controls, and the service is only
+// reported as stopped after the running archives finished.
+func notifyStop() (stop <-chan struct{}, stopped func()) {
+	inService, err := svc.IsWindowsService()
+	if err != nil || !inService {
+		return signalStop(), func() {}
+	}
+
+	service := &windowsService{stop: make(chan struct{}), stopped: make(chan struct{})}
+	exited := make(chan struct{})
+	go func() {
+		defer close(exited)
+		if err := svc.Run(serviceName, service); err != nil {
+			fmt.Printf("Error running as a service: %v\n", err)
+		}
+	}()
+	return service.stop, func() {
+		close(service.stopped)
+		<-exited
+	}
+}
+
+// Execute implements svc.Handler
+func (s *windowsService) Execute(args []string, requests <-chan svc.ChangeRequest, changes chan<- svc.Status) (bool, uint32) {
+	changes <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+	for {
+		select {
+		case req := <-requests:
+			switch req.Cmd {
+			case svc.Interrogate:
+				changes <- req.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				changes <- svc.Status{State: svc.StopPending, WaitHint: uint32(config().shutdownTimeout / time.Millisecond)}
+				close(s.stop)
+				<-s.stopped
+				return false, 0
+			}
+		case <-s.stopped:
+			// The server failed without being asked to stop
+			return true, 1
+		}
+	}
+}
+
+// serviceCommand handles the commands registering and controlling the
+// Windows service: install, uninstall, start and stop. The ZS_ variables set
+// when installing are kept as the environment of the service.
+func serviceCommand(args []string) (handled bool, err error) {
+	if len(args) == 0 || !isServiceCommand(args[0]) {
+		return false, nil
+	}
+	manager, err := mgr.Connect()
+	if err != nil {
+		return true, err
+	}
+	defer manager.Disconnect()
+	if args[0] == "install" {
+		return true, installService(manager)
+	}
+
+	service, err := manager.OpenService(serviceName)
+	if err != nil {
+		return true, fmt.Errorf("service %s is not installed: %v", serviceName, err)
+	}
+	defer service.Close()
+	switch args[0] {
+	case "uninstall":
+		err = service.Delete()
+	case "start":
+		err = service.Start()
+	case "stop":
+		_, err = service.Control(svc.Stop)
+	}
+	if err == nil {
+		fmt.Printf("Service %s: %s done\n", serviceName, args[0])
+	}
+	return true, err
+}
+
+// installService registers the running executable as an automatically started service
+func installService(manager *mgr.Mgr) error {
+	if service, err := manager.OpenService(serviceName); err == nil {
+		service.Close()
+		return fmt.Errorf("service %s is already installed", serviceName)
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	service, err := manager.CreateService(serviceName, executable, mgr.Config{
+		DisplayName: "gozipstreamer",
+		Description: "Streams zip archives of remote files",
+		StartType:   mgr.StartAutomatic,
+	})
+	if err != nil {
+		return err
+	}
+	defer service.Close()
+
+	var environment []string
+	for _, variable := range os.Environ() {
+		if strings.HasPrefix(variable, "ZS_") {
+			environment = append(environment, variable)
+		}
+	}
+	if len(environment) > 0 {
+		key, err := registry.OpenKey(registry.LOCAL_MACHINE, `SYSTEM\CurrentControlSet\Services\`+serviceName, registry.SET_VALUE)
+		if err != nil {
+			return errors.Join(err, service.Delete())
+		}
+		defer key.Close()
+		if err := key.SetStringsValue("Environment", environment); err != nil {
+			return errors.Join(err, service.Delete())
+		}
+	}
+	fmt.Printf("Service %s installed with %d ZS_ variables\n", serviceName, len(environment))
+	return nil
+}
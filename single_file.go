@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"gozipstreamer/zipstreamer"
+	"mime"
+	"net/http"
+	"path"
+	"time"
+)
+
+// Ways of answering a request that resolves to a single file
+const (
+	singleStream   = "stream"   // Send the file itself instead of an archive
+	singleRedirect = "redirect" // Redirect to the file's URL, streaming it when that's not possible
+)
+
+// servesSingleFile reports whether the request asked for its lone file
+// without an archive and the entries allow it
+func servesSingleFile(files []*zipstreamer.FileEntry, options *zipOptions) bool {
+	return options.single != "" && len(files) == 1 && files[0].Servable() &&
+		options.target == "" && !options.manifest
+}
+
+// streamSingleFile answers with a lone file under its own name instead of an
+// archive holding it
+func streamSingleFile(w http.ResponseWriter, r *http.Request, entries *entrySet, entry *zipstreamer.FileEntry, jobID string, options *zipOptions) {
+	name := path.Base(entry.ZipPath())
+	// Clients fetching the URL themselves would bypass scanning and conversion,
+	// and the cookies the archive's jar would collect
+	if options.single == singleRedirect && entry.Redirectable() && virusScanner == nil &&
+		config().contentTypes.Empty() && !options.rewritesData() && !config().cookieJar {
+		fmt.Printf("Job %s: redirecting to the only file %s\n", jobID, name)
+		http.Redirect(w, r, entry.Url().String(), http.StatusFound)
+		return
+	}
+
+	contentType := mime.TypeByExtension(path.Ext(name))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", contentType)
+	if options.disposition != "" {
+		w.Header().Set("Content-Disposition", zipstreamer.FileContentDisposition(options.disposition, name))
+	}
+	w.Header().Set("X-Zip-Job-Id", jobID)
+	if entry.Size() >= 0 && !options.rewritesData() {
+		w.Header().Set("Content-Length", fmt.Sprintf("%d", entry.Size()))
+	}
+
+	zipStream, err := zipstreamer.NewZipStream([]*zipstreamer.FileEntry{entry}, w)
+	if err != nil {
+		http.Error(w, "Failed to create file stream", http.StatusInternalServerError)
+		return
+	}
+	zipStream.Context = r.Context()
+	if options.images != nil {
+		zipStream.Use(options.images.Middleware())
+	}
+	if options.text != nil {
+		zipStream.Use(options.text.Middleware())
+	}
+	zipStream.EntryTimeout = config().entryTimeout
+	zipStream.StallTimeout = config().stallTimeout
+	zipStream.MinThroughput = config().minThroughput
+	zipStream.Cache = etagCache
+	zipStream.DiskCache = diskCache
+	zipStream.MemoryCache = memoryCache
+	zipStream.Memory = memoryBudget
+	zipStream.Scanner = virusScanner
+	zipStream.ScanPolicy = config().scanPolicy
+	zipStream.ContentTypes = config().contentTypes
+	if config().cookieJar {
+		zipStream.Jar = zipstreamer.NewCookieJar()
+	}
+
+	job := &jobRecord{
+		ID:       jobID,
+		Status:   jobRunning,
+		Source:   entries.source,
+		Filename: name,
+		Entries:  1,
+		Created:  time.Now().UTC(),
+	}
+	stopTracking := jobHistory.track(job, zipStream.Progress)
+	_, err = zipStream.StreamFile()
+	stopTracking()
+	job.Done, job.Bytes = zipStream.Progress()
+	job.Status = "complete"
+	if err != nil {
+		fmt.Printf("Failed to stream %s: %v\n", name, err)
+		job.Status = "failed"
+		job.Error = err.Error()
+		job.Failed = 1
+		if job.Bytes == 0 {
+			// Nothing was sent yet, so the headers can still report the failure
+			w.Header().Del("Content-Disposition")
+			http.Error(w, "Failed to fetch the file: "+err.Error(), http.StatusBadGateway)
+		}
+	}
+	fmt.Printf("File stats: %s\n", zipStream.Stats())
+	jobHistory.put(job)
+	if audit := auditRecordOf(w); audit != nil {
+		audit.Failed = job.Failed
+		audit.Outcome = job.Status
+		audit.Error = job.Error
+	}
+}
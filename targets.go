@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gozipstreamer/zipstreamer"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// Trailer carrying the location of the stored archive when it is also streamed to the client
+const targetURLTrailer = "X-Zip-Target-URL"
+
+// archiveTarget stores a copy of an archive while it is streamed, e.g. in a
+// bucket. Writes may block while data is uploaded.
+type archiveTarget interface {
+	io.Writer
+	// commit completes the stored archive and returns its location
+	commit() (string, error)
+	// abort discards a partially stored archive
+	abort()
+}
+
+// targetOpener opens a target for the archive of a job
+type targetOpener func(jobID, filename string) (archiveTarget, error)
+
+// resumableTarget is a target whose partial archive outlives the server, so
+// that a job interrupted by a restart can continue where it left off
+type resumableTarget interface {
+	archiveTarget
+	// checkpoint makes everything written so far durable and returns the
+	// state to resume from, or nil when the target can't resume at this point
+	checkpoint() (json.RawMessage, error)
+}
+
+// targetResumer opens the archive of a job so that it can be checkpointed.
+// Given the state of a checkpoint, it continues the partial archive stored
+// then, which holds the first offset bytes of the archive.
+type targetResumer func(jobID, filename string, state json.RawMessage, offset int64) (resumableTarget, error)
+
+// targetOpeners are the configured targets by name
+var targetOpeners = map[string]targetOpener{}
+
+// targetResumers open the configured targets for jobs that can resume
+var targetResumers = map[string]targetResumer{}
+
+// registerTarget makes a configured target available to the target parameter
+func registerTarget(name string, open targetOpener, resume targetResumer) {
+	targetOpeners[name] = open
+	targetResumers[name] = resume
+}
+
+// targetNames returns the names of the configured targets
+func targetNames() []string {
+	names := make([]string, 0, len(targetOpeners))
+	for name := range targetOpeners {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// targetFilename returns the archive's file name with separators removed, so
+// it can't place the archive outside the target's prefix
+func targetFilename(filename string) string {
+	return strings.NewReplacer("/", "_", "\\", "_").Replace(zipstreamer.EscapeSuggestedFilename(filename))
+}
+
+// teeWriter streams the archive to the client and the target at the same time
+type teeWriter struct {
+	w      http.ResponseWriter
+	target archiveTarget
+}
+
+func (t *teeWriter) Write(p []byte) (int, error) {
+	n, err := t.w.Write(p)
+	if err != nil {
+		return n, err
+	}
+	if _, err := t.target.Write(p[:n]); err != nil {
+		return n, fmt.Errorf("failed to write to target: %v", err)
+	}
+	return n, nil
+}
+
+// Flush passes flushes through to the response so streaming is unaffected
+func (t *teeWriter) Flush() {
+	if flusher, ok := t.w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// finishTarget commits the stored archive after a successful stream and
+// discards it otherwise, returning its location
+func finishTarget(target archiveTarget, jobID string, streamErr error) (string, error) {
+	if streamErr != nil {
+		target.abort()
+		return "", streamErr
+	}
+	location, err := target.commit()
+	if err != nil {
+		target.abort()
+		fmt.Printf("Job %s: failed to store archive: %v\n", jobID, err)
+		return "", err
+	}
+	fmt.Printf("Job %s: archive stored at %s\n", jobID, location)
+	return location, nil
+}
+
+// failureStatus maps the reason an archive could not be built to a response
+// status. When every file failed the sources are at fault, as with preflight;
+// otherwise the target is.
+func failureStatus(err error) int {
+	switch {
+	case errors.Is(err, zipstreamer.ErrEmptyArchive):
+		return http.StatusFailedDependency
+	case errors.Is(err, zipstreamer.ErrDisallowedURL):
+		return http.StatusForbidden
+	case errors.Is(err, zipstreamer.ErrPathInvalid):
+		return http.StatusBadRequest
+	}
+	return http.StatusBadGateway
+}
+
+// archiveLink returns an expiring download link to a stored archive, or none
+// when the target can't provide one
+func archiveLink(target archiveTarget, job *jobRecord) string {
+	linker, ok := target.(archiveLinker)
+	if !ok || job.URL == "" || config().linkTTL <= 0 {
+		return ""
+	}
+	link, err := linker.link(config().linkTTL)
+	if err != nil {
+		fmt.Printf("Job %s: failed to create download link: %v\n", job.ID, err)
+	}
+	return link
+}
+
+// writeTargetResult answers a request whose archive went only to the target.
+// err is the reason nothing was stored, if so.
+func writeTargetResult(w http.ResponseWriter, job *jobRecord, link, sha256 string, failed []string, err error) {
+	status := http.StatusOK
+	if job.URL == "" {
+		status = failureStatus(err)
+	}
+	if failed == nil {
+		failed = []string{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"jobId":  job.ID,
+		"status": job.Status,
+		"url":    job.URL,
+		"link":   link,
+		"size":   job.Bytes,
+		"sha256": sha256,
+		"failed": failed,
+		"error":  job.Error,
+	})
+}
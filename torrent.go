@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"gozipstreamer/provider"
+	"gozipstreamer/zipstreamer"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// transferPollInterval is how often a pending transfer is checked
+const transferPollInterval = 5 * time.Second
+
+// torrentContentType marks POST bodies that are .torrent files
+const torrentContentType = "application/x-bittorrent"
+
+// processTorrentUpload streams the contents of a .torrent file posted as the
+// request body. Credentials and options are passed as query parameters.
+func processTorrentUpload(w http.ResponseWriter, r *http.Request, torrent []byte) {
+	query := r.URL.Query()
+	providerName := query.Get("provider")
+	if providerName == "" {
+		providerName = "premiumize"
+	}
+	apiKey := query.Get("apikey")
+	if audit := auditRecordOf(w); audit != nil {
+		audit.Provider = providerName
+		audit.Paths = []string{"uploaded torrent"}
+		if apiKey != "" {
+			audit.APIKeys = []string{keyFingerprint(apiKey)}
+		}
+	}
+	if apiKey == "" {
+		http.Error(w, "Missing API key", http.StatusBadRequest)
+		return
+	}
+
+	source, err := provider.New(providerName, apiKey, query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	options, err := parseZipOptions(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	r, release, ok := claimIdempotencyKey(w, r, torrent)
+	if !ok {
+		return
+	}
+	defer release()
+	processTransferRequest(w, r, source, "", torrent, options)
+}
+
+// processTransferRequest downloads a magnet link, torrent URL or uploaded
+// torrent into the Premiumize cloud and streams the result once the transfer
+// has finished. Cached torrents finish right away; others can take a while,
+// so the wait is bounded by the transfer timeout.
+func processTransferRequest(w http.ResponseWriter, r *http.Request, source provider.Provider, src string, torrent []byte, options *zipOptions) {
+	premiumize, ok := source.(*provider.Premiumize)
+	if !ok {
+		http.Error(w, "Torrents are only supported by the premiumize provider", http.StatusBadRequest)
+		return
+	}
+
+	var id string
+	var err error
+	if torrent != nil {
+		id, err = premiumize.UploadTransfer(torrent)
+	} else {
+		id, err = premiumize.CreateTransfer(src)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	fmt.Printf("Waiting for transfer %s\n", id)
+
+	ctx := r.Context()
+	if config().transferTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, config().transferTimeout)
+		defer cancel()
+	}
+	transfer, err := premiumize.WaitTransfer(ctx, id, transferPollInterval)
+	if err != nil {
+		if r.Context().Err() != nil {
+			return
+		}
+		status := http.StatusBadGateway
+		if errors.Is(err, context.DeadlineExceeded) {
+			status = http.StatusGatewayTimeout
+		}
+		http.Error(w, fmt.Sprintf("Transfer %s did not finish: %v", id, err), status)
+		return
+	}
+
+	if options.filename == "" {
+		options.filename = strings.TrimSuffix(transfer.Name, ".torrent")
+	}
+	ref := zipstreamer.SourceRef{Provider: "premiumize", ID: transfer.Item()}
+	processZipRequest(w, r, "premiumize", source, []zipstreamer.SourceRef{ref}, options)
+}
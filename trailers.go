@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"gozipstreamer/zipstreamer"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// HTTP trailers summarizing the outcome of a stream. The status code is sent
+// before any entry is fetched, so this is the only way to report failures.
+const (
+	statusTrailer        = "X-Zip-Status"
+	failedCountTrailer   = "X-Zip-Failed-Count"
+	failedEntriesTrailer = "X-Zip-Failed-Entries"
+	sha256Trailer        = "X-Zip-SHA256"
+	crc32Trailer         = "X-Zip-CRC32"
+	entryHashesTrailer   = "X-Zip-Entry-Hashes"
+)
+
+// declareTrailers announces the status trailers; it must be called before the body is written
+func declareTrailers(w http.ResponseWriter, options *zipOptions) {
+	trailers := []string{statusTrailer, failedCountTrailer, failedEntriesTrailer, sha256Trailer, crc32Trailer}
+	if options.hashEntries {
+		trailers = append(trailers, entryHashesTrailer)
+	}
+	if options.target != "" {
+		trailers = append(trailers, targetURLTrailer, downloadURLTrailer)
+	}
+	w.Header().Set("Trailer", strings.Join(trailers, ", "))
+}
+
+// writeEntryHashesTrailer sends the per-entry hash report as base64-encoded JSON,
+// since header values cannot carry arbitrary file names
+func writeEntryHashesTrailer(w http.ResponseWriter, hashes []zipstreamer.EntryHash) {
+	if hashes == nil {
+		hashes = []zipstreamer.EntryHash{}
+	}
+	report, err := json.Marshal(hashes)
+	if err != nil {
+		return
+	}
+	w.Header().Set(entryHashesTrailer, base64.StdEncoding.EncodeToString(report))
+}
+
+// writeTrailers reports skipped and failed entries and the archive checksums
+// once streaming has finished. Failed paths are URL-escaped and comma-separated
+// to keep the header valid.
+func writeTrailers(w http.ResponseWriter, entries *entrySet, failed []zipstreamer.FailedEntry, checksum *checksumWriter, streamErr error) {
+	paths := failedZipPaths(entries, failed)
+	for i := range paths {
+		paths[i] = url.PathEscape(paths[i])
+	}
+
+	status := "complete"
+	if streamErr != nil {
+		status = "failed"
+	} else if len(paths) > 0 {
+		status = "partial"
+	}
+
+	w.Header().Set(statusTrailer, status)
+	w.Header().Set(failedCountTrailer, fmt.Sprintf("%d", len(paths)))
+	w.Header().Set(failedEntriesTrailer, strings.Join(paths, ","))
+	w.Header().Set(sha256Trailer, checksum.SHA256())
+	w.Header().Set(crc32Trailer, checksum.CRC32())
+}
+
+// failedZipPaths lists the skipped and failed entries of an archive
+func failedZipPaths(entries *entrySet, failed []zipstreamer.FailedEntry) []string {
+	paths := make([]string, 0, len(entries.skipped)+len(failed))
+	for _, skipped := range entries.skipped {
+		paths = append(paths, skipped.zipPath)
+	}
+	for _, failure := range failed {
+		paths = append(paths, failure.ZipPath)
+	}
+	return paths
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// uiFiles is the single-page UI, built into the binary so the server has no
+// files to deploy next to it
+//
+//go:embed ui
+var uiFiles embed.FS
+
+// startUI serves the UI at the root
+func startUI(r *mux.Router) {
+	static, _ := fs.Sub(uiFiles, "ui")
+	r.Handle("/", http.FileServer(http.FS(static))).Methods("GET", "HEAD")
+}
@@ -0,0 +1,90 @@
+package zipstreamer
+
+import (
+	"archive/zip"
+	"encoding/binary"
+	"strings"
+)
+
+// alignmentExtraID is the extra field zipalign pads local headers with. Its
+// data is the alignment as a uint16 followed by zeros.
+const alignmentExtraID = 0xd935
+
+// Sizes of the records archive/zip writes around an entry's data
+const (
+	localHeaderLength      = 30
+	dataDescriptorLength   = 16
+	dataDescriptor64Length = 24
+	zip64LocalExtraLength  = 20
+	alignmentExtraLength   = 6 // Without padding
+	uint32max              = 1<<32 - 1
+)
+
+// MaxAlignment is the largest alignment the padding field can describe
+const MaxAlignment = 1<<16 - 1
+
+// alignHeader pads the header of a streamed entry, see align
+func (w *archiveWriter) alignHeader(header *zip.FileHeader) error {
+	// archive/zip appends an extended timestamp after the extra fields
+	var appended int64
+	if !header.Modified.IsZero() {
+		appended = extTimeExtraSize
+	}
+	return w.align(header, appended)
+}
+
+// alignRaw pads the header of a raw entry, see align
+func (w *archiveWriter) alignRaw(header *zip.FileHeader) error {
+	// Without a data descriptor, sizes past 4GiB go into a Zip64 extra field
+	var appended int64
+	if header.Flags&0x8 == 0 && (header.CompressedSize64 > uint32max || header.UncompressedSize64 > uint32max) {
+		appended = zip64LocalExtraLength
+	}
+	return w.align(header, appended)
+}
+
+// align pads the extra field of a stored entry's header, like zipalign, so
+// its data starts at a multiple of the alignment and can be memory-mapped
+// straight out of the archive. appended is the length of the extra fields
+// archive/zip adds to the local header itself.
+func (w *archiveWriter) align(header *zip.FileHeader, appended int64) error {
+	offset, err := w.offset()
+	if err != nil {
+		return err
+	}
+	if header.Method != zip.Store || strings.HasSuffix(header.Name, "/") {
+		return nil
+	}
+
+	dataStart := offset + localHeaderLength + int64(len(header.Name)) + int64(len(header.Extra)) + appended + alignmentExtraLength
+	padding := (w.alignment - dataStart%w.alignment) % w.alignment
+	field := make([]byte, alignmentExtraLength+padding)
+	binary.LittleEndian.PutUint16(field[0:], alignmentExtraID)
+	binary.LittleEndian.PutUint16(field[2:], uint16(len(field)-4))
+	binary.LittleEndian.PutUint16(field[4:], uint16(w.alignment))
+	header.Extra = append(header.Extra, field...)
+	return nil
+}
+
+// offset returns where the next local header starts. It finishes the data of
+// the pending entry and flushes the buffered archive to learn it.
+func (w *archiveWriter) offset() (int64, error) {
+	var descriptor int64
+	if pending := w.pending; pending != nil {
+		w.pending = nil
+		if pending.compressor != nil {
+			if err := pending.Close(); err != nil {
+				return 0, err
+			}
+		}
+		compressed, uncompressed := pending.sizes()
+		descriptor = dataDescriptorLength
+		if compressed > uint32max || uncompressed > uint32max {
+			descriptor = dataDescriptor64Length
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return 0, err
+	}
+	return w.out.n + descriptor, nil
+}
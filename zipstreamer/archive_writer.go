@@ -0,0 +1,257 @@
+package zipstreamer
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+)
+
+// defaultDeflateLevel is the level archive/zip deflates with by default
+const defaultDeflateLevel = 5
+
+// archiveWriter is the zip.Writer of a stream. When tracking entries it
+// keeps their headers and the sizes of the last one's data, so a checkpoint
+// can be taken between entries and a later stream can resume from it. With an
+// alignment it pads the local headers of stored entries. Every header must be
+// created through it.
+type archiveWriter struct {
+	*zip.Writer
+	out       *countingWriter
+	alignment int64
+	pending   *pendingEntry     // The last entry, whose data descriptor is not written yet
+	tracking  bool              // Entries are tracked for checkpoints
+	headers   []*zip.FileHeader // Of every entry, when tracking
+}
+
+// pendingEntry tracks the data of the last entry written so far. Streamed
+// entries pass through it as their compressor.
+type pendingEntry struct {
+	header       *zip.FileHeader // Of raw entries, which carry their sizes
+	compressor   io.WriteCloser
+	compressed   *countingWriter
+	uncompressed int64
+	crc32        hash.Hash32 // Of the uncompressed data, when tracking
+	closed       bool
+}
+
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func newArchiveWriter(w io.Writer, alignment int) *archiveWriter {
+	out := &countingWriter{w: w}
+	archive := &archiveWriter{Writer: zip.NewWriter(out), out: out}
+	if alignment > 1 {
+		archive.alignment = int64(alignment)
+		archive.registerDefaultCompressors()
+	}
+	return archive
+}
+
+// trackEntries keeps the headers and the data of the entries, so checkpoints
+// can be taken between them. It must be called before any compressor is
+// registered.
+func (w *archiveWriter) trackEntries() {
+	w.tracking = true
+	w.registerDefaultCompressors()
+}
+
+// registerDefaultCompressors registers the compressors archive/zip uses by
+// default through RegisterCompressor, so their entries are tracked while
+// deflating like an untracked stream does
+func (w *archiveWriter) registerDefaultCompressors() {
+	w.RegisterCompressor(zip.Store, func(out io.Writer) (io.WriteCloser, error) {
+		return nopWriteCloser{out}, nil
+	})
+	w.RegisterCompressor(zip.Deflate, func(out io.Writer) (io.WriteCloser, error) {
+		return flate.NewWriter(out, defaultDeflateLevel)
+	})
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+// RegisterCompressor registers comp for method, tracking the entries it
+// compresses when tracking entries or aligning
+func (w *archiveWriter) RegisterCompressor(method uint16, comp zip.Compressor) {
+	if !w.tracking && w.alignment == 0 {
+		w.Writer.RegisterCompressor(method, comp)
+		return
+	}
+	w.Writer.RegisterCompressor(method, func(out io.Writer) (io.WriteCloser, error) {
+		compressed := &countingWriter{w: out}
+		compressor, err := comp(compressed)
+		if err != nil {
+			return nil, err
+		}
+		w.pending = &pendingEntry{compressor: compressor, compressed: compressed}
+		if w.tracking {
+			w.pending.crc32 = crc32.NewIEEE()
+		}
+		return w.pending, nil
+	})
+}
+
+// CreateHeader adds a streamed entry, see zip.Writer.CreateHeader
+func (w *archiveWriter) CreateHeader(header *zip.FileHeader) (io.Writer, error) {
+	if w.alignment != 0 {
+		if err := w.alignHeader(header); err != nil {
+			return nil, err
+		}
+	}
+	w.track(header)
+	return w.Writer.CreateHeader(header)
+}
+
+// CreateRaw adds an entry of compressed data, see zip.Writer.CreateRaw
+func (w *archiveWriter) CreateRaw(header *zip.FileHeader) (io.Writer, error) {
+	if !w.tracking && w.alignment == 0 {
+		return w.Writer.CreateRaw(header)
+	}
+	if w.alignment != 0 {
+		if err := w.alignRaw(header); err != nil {
+			return nil, err
+		}
+	}
+	w.track(header)
+	writer, err := w.Writer.CreateRaw(header)
+	if err == nil && header.Flags&0x8 != 0 {
+		w.pending = &pendingEntry{header: header}
+	}
+	return writer, err
+}
+
+// track records the header of a new entry. The entry before it is finished
+// by archive/zip, so it is no longer pending.
+func (w *archiveWriter) track(header *zip.FileHeader) {
+	if !w.tracking {
+		return
+	}
+	w.pending = nil
+	w.headers = append(w.headers, header)
+}
+
+// checkpoint finishes the data of the last entry and flushes the archive. It
+// returns the offset reached, before the data descriptor of the last entry,
+// and a copy of the headers of every entry as the central directory will
+// record them.
+func (w *archiveWriter) checkpoint() (int64, []*zip.FileHeader, error) {
+	if !w.tracking {
+		return 0, nil, errors.New("entries are not tracked")
+	}
+	pending := w.pending
+	if pending != nil && pending.compressor != nil {
+		if err := pending.Close(); err != nil {
+			return 0, nil, err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return 0, nil, err
+	}
+
+	headers := make([]*zip.FileHeader, len(w.headers))
+	for i, header := range w.headers {
+		headers[i] = cloneHeader(header)
+	}
+	// archive/zip only fills in the sizes of the last entry when the next one starts
+	if pending != nil && pending.compressor != nil && len(headers) > 0 {
+		last := headers[len(headers)-1]
+		compressed, uncompressed := pending.sizes()
+		last.CRC32 = pending.crc32.Sum32()
+		last.CompressedSize64, last.UncompressedSize64 = uint64(compressed), uint64(uncompressed)
+	}
+	return w.out.n, headers, nil
+}
+
+// resume continues an archive of which the first offset bytes, holding the
+// entries of headers, were written by an earlier stream. The entries are
+// written again into nothing, so archive/zip learns them for the central
+// directory, and the archive goes on at offset.
+func (w *archiveWriter) resume(headers []*zip.FileHeader, offset int64) error {
+	destination := w.out.w
+	w.out.w = io.Discard
+	defer func() { w.out.w = destination }()
+
+	zeros := make([]byte, 1024*1024)
+	for _, header := range headers {
+		header = cloneHeader(header)
+		entryWriter, err := w.Writer.CreateRaw(header)
+		if err != nil {
+			return err
+		}
+		w.pending = nil
+		w.headers = append(w.headers, header)
+		if header.Flags&0x8 != 0 {
+			w.pending = &pendingEntry{header: header}
+		}
+		for size := int64(header.CompressedSize64); size > 0; {
+			n := min(size, int64(len(zeros)))
+			if _, err := entryWriter.Write(zeros[:n]); err != nil {
+				return err
+			}
+			size -= n
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if w.out.n != offset {
+		return fmt.Errorf("entries end at %d instead of %d", w.out.n, offset)
+	}
+	return nil
+}
+
+// cloneHeader copies a header with its extra fields, which archive/zip appends to
+func cloneHeader(header *zip.FileHeader) *zip.FileHeader {
+	clone := *header
+	clone.Extra = bytes.Clone(header.Extra)
+	return &clone
+}
+
+// sizes returns the compressed and uncompressed size of the entry's data
+func (p *pendingEntry) sizes() (int64, int64) {
+	if p.header != nil {
+		return int64(p.header.CompressedSize64), int64(p.header.UncompressedSize64)
+	}
+	return p.compressed.n, p.uncompressed
+}
+
+func (p *pendingEntry) Write(data []byte) (int, error) {
+	if p.closed {
+		return 0, errors.New("write to a finished entry")
+	}
+	n, err := p.compressor.Write(data)
+	p.uncompressed += int64(n)
+	if p.crc32 != nil {
+		p.crc32.Write(data[:n])
+	}
+	return n, err
+}
+
+// Close finishes the compressed data. archive/zip closes it again when the
+// next entry is created, which is a no-op.
+func (p *pendingEntry) Close() error {
+	if p.closed {
+		return nil
+	}
+	p.closed = true
+	return p.compressor.Close()
+}
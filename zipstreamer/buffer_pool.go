@@ -0,0 +1,37 @@
+package zipstreamer
+
+import (
+	"io"
+	"os"
+	"strconv"
+	"sync"
+)
+
+const CopyBufferSizeEnvVar = "ZS_COPY_BUFFER_SIZE"
+
+const defaultCopyBufferSize = 256 * 1024
+
+// copyBufferPool shares copy buffers between concurrent streams to cut
+// allocations and GC pressure
+var copyBufferPool = sync.Pool{
+	New: func() interface{} {
+		buffer := make([]byte, copyBufferSize())
+		return &buffer
+	},
+}
+
+// copyBufferSize reads the buffer size from the environment
+func copyBufferSize() int {
+	size, err := strconv.Atoi(os.Getenv(CopyBufferSizeEnvVar))
+	if err != nil || size <= 0 {
+		return defaultCopyBufferSize
+	}
+	return size
+}
+
+// pooledCopy copies src to dst with a buffer borrowed from the pool
+func pooledCopy(dst io.Writer, src io.Reader) (int64, error) {
+	buffer := copyBufferPool.Get().(*[]byte)
+	defer copyBufferPool.Put(buffer)
+	return io.CopyBuffer(dst, src, *buffer)
+}
@@ -0,0 +1,80 @@
+package zipstreamer
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Checkpoint is the state of a stream at an entry boundary. A stream of the
+// same entries given it as Resume continues the archive from there, writing
+// only what follows the first Offset bytes, so an interrupted build can go on
+// without fetching the entries before the boundary again.
+type Checkpoint struct {
+	Entries  int                 `json:"entries"` // Entries reached, whether added or failed
+	Added    int                 `json:"added"`
+	Offset   int64               `json:"offset"`  // Archive bytes written up to the boundary
+	Headers  []*zip.FileHeader   `json:"headers"` // Of the entries written, as the central directory records them
+	Failed   []CheckpointFailure `json:"failed,omitempty"`
+	Manifest []ManifestEntry     `json:"manifest,omitempty"`
+	Hashes   []EntryHash         `json:"hashes,omitempty"`
+}
+
+// CheckpointFailure is an entry left out of the archive before a checkpoint
+type CheckpointFailure struct {
+	ZipPath string `json:"path"`
+	Error   string `json:"error"`
+}
+
+// CheckpointHook is called on the streaming goroutine with a checkpoint once
+// the archive up to its Offset was written to the destination. Writes to the
+// destination may still be buffered, so the hook should make them durable
+// before recording the checkpoint. Returning an error stops the stream.
+type CheckpointHook func(checkpoint *Checkpoint) error
+
+// checkpoint hands the state of the stream before entry i to OnCheckpoint, at
+// most once per CheckpointPeriod. A keepalive holds back the last bytes of the
+// archive, so no checkpoints are taken with one.
+func (z *ZipStream) checkpoint(zipWriter *archiveWriter, i, added int) error {
+	if z.OnCheckpoint == nil || z.KeepaliveInterval > 0 || i == z.first || time.Since(z.lastCheckpoint) < z.CheckpointPeriod {
+		return nil
+	}
+	offset, headers, err := zipWriter.checkpoint()
+	if err != nil {
+		return err
+	}
+	checkpoint := &Checkpoint{
+		Entries:  i,
+		Added:    added,
+		Offset:   offset,
+		Headers:  headers,
+		Manifest: append([]ManifestEntry(nil), z.manifest...),
+		Hashes:   append([]EntryHash(nil), z.hashes...),
+	}
+	for _, failure := range z.failed {
+		checkpoint.Failed = append(checkpoint.Failed, CheckpointFailure{ZipPath: failure.ZipPath, Error: failure.Err.Error()})
+	}
+	z.lastCheckpoint = time.Now()
+	return z.OnCheckpoint(checkpoint)
+}
+
+// resume picks up the archive at the Resume checkpoint, whose bytes the
+// destination already holds
+func (z *ZipStream) resume(zipWriter *archiveWriter) error {
+	checkpoint := z.Resume
+	if checkpoint.Entries < 0 || checkpoint.Entries > len(z.entries) {
+		return fmt.Errorf("checkpoint after entry %d of %d", checkpoint.Entries, len(z.entries))
+	}
+	if err := zipWriter.resume(checkpoint.Headers, checkpoint.Offset); err != nil {
+		return fmt.Errorf("failed to resume from checkpoint: %v", err)
+	}
+	z.first = checkpoint.Entries
+	z.manifest = append(z.manifest, checkpoint.Manifest...)
+	z.hashes = append(z.hashes, checkpoint.Hashes...)
+	for _, failure := range checkpoint.Failed {
+		z.failed = append(z.failed, FailedEntry{ZipPath: failure.ZipPath, Err: errors.New(failure.Error)})
+	}
+	z.counters.bytesWritten.Store(checkpoint.Offset)
+	return nil
+}
@@ -0,0 +1,60 @@
+package zipstreamer
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+)
+
+// checksumVerifier hashes an entry's data as it is copied and compares the
+// result with the checksums supplied for the entry
+type checksumVerifier struct {
+	expected Checksums
+	crc32    hash.Hash32
+	md5      hash.Hash
+}
+
+// newChecksumVerifier returns nil when the entry has no checksums to verify
+func newChecksumVerifier(expected Checksums) *checksumVerifier {
+	if expected.CRC32 == "" && expected.MD5 == "" {
+		return nil
+	}
+	v := &checksumVerifier{expected: expected}
+	if expected.CRC32 != "" {
+		v.crc32 = crc32.NewIEEE()
+	}
+	if expected.MD5 != "" {
+		v.md5 = md5.New()
+	}
+	return v
+}
+
+// wrap tees the reader through the hashes
+func (v *checksumVerifier) wrap(r io.Reader) io.Reader {
+	var writers []io.Writer
+	if v.crc32 != nil {
+		writers = append(writers, v.crc32)
+	}
+	if v.md5 != nil {
+		writers = append(writers, v.md5)
+	}
+	return io.TeeReader(r, io.MultiWriter(writers...))
+}
+
+// verify reports a mismatch between the streamed data and the expected checksums
+func (v *checksumVerifier) verify() error {
+	if v.crc32 != nil {
+		if actual := fmt.Sprintf("%08x", v.crc32.Sum32()); actual != v.expected.CRC32 {
+			return fmt.Errorf("CRC32 mismatch: expected %s, got %s", v.expected.CRC32, actual)
+		}
+	}
+	if v.md5 != nil {
+		if actual := hex.EncodeToString(v.md5.Sum(nil)); actual != v.expected.MD5 {
+			return fmt.Errorf("MD5 mismatch: expected %s, got %s", v.expected.MD5, actual)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+package zipstreamer
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestRedirectPolicyForwardsAuthOnlyToListedHosts(t *testing.T) {
+	tests := []struct {
+		name  string
+		hosts []string
+		to    string
+		want  string
+	}{
+		{"no hosts listed", nil, "https://elsewhere.example/file", ""},
+		{"listed host", []string{"cdn.example"}, "https://cdn.example/file", "Bearer secret"},
+		{"listed subdomains", []string{".cdn.example"}, "https://eu.cdn.example/file", "Bearer secret"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := DefaultTransportOptions()
+			opts.MaxRedirects = 5
+			opts.ForwardAuthOnRedirect = true
+			opts.RedirectHosts = tt.hosts
+
+			first, _ := http.NewRequest(http.MethodGet, "https://origin.example/file", nil)
+			first.Header.Set("Authorization", "Bearer secret")
+			next, _ := http.NewRequest(http.MethodGet, tt.to, nil)
+			err := redirectPolicy(opts)(next, []*http.Request{first})
+			if err != nil && tt.hosts != nil {
+				t.Fatalf("redirect refused: %v", err)
+			}
+			if got := next.Header.Get("Authorization"); got != tt.want {
+				t.Errorf("Authorization = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
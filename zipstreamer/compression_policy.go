@@ -0,0 +1,76 @@
+package zipstreamer
+
+import (
+	"archive/zip"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// CompressionPolicy maps lowercase file extensions, including the dot, to the
+// compression method used for them, so already compressed media isn't run
+// through the compressor for nothing. Extensions that aren't listed use the
+// archive's CompressionMethod.
+type CompressionPolicy map[string]uint16
+
+// DefaultCompressionPolicy stores common compressed media and archive formats
+// and deflates text formats
+var DefaultCompressionPolicy = CompressionPolicy{
+	".7z": zip.Store, ".avi": zip.Store, ".bz2": zip.Store, ".flac": zip.Store,
+	".gif": zip.Store, ".gz": zip.Store, ".heic": zip.Store, ".jpeg": zip.Store,
+	".jpg": zip.Store, ".m4a": zip.Store, ".m4v": zip.Store, ".mkv": zip.Store,
+	".mov": zip.Store, ".mp3": zip.Store, ".mp4": zip.Store, ".ogg": zip.Store,
+	".png": zip.Store, ".rar": zip.Store, ".webm": zip.Store, ".webp": zip.Store,
+	".xz": zip.Store, ".zip": zip.Store, ".zst": zip.Store,
+
+	".csv": zip.Deflate, ".htm": zip.Deflate, ".html": zip.Deflate, ".json": zip.Deflate,
+	".log": zip.Deflate, ".md": zip.Deflate, ".nfo": zip.Deflate, ".srt": zip.Deflate,
+	".txt": zip.Deflate, ".xml": zip.Deflate,
+}
+
+// ParseCompressionPolicy reads a policy of the form
+// "store:.mkv,.mp4;deflate:.txt,.srt"
+func ParseCompressionPolicy(spec string) (CompressionPolicy, error) {
+	policy := CompressionPolicy{}
+	for _, rule := range strings.Split(spec, ";") {
+		rule = strings.TrimSpace(rule)
+		if rule == "" {
+			continue
+		}
+		name, extensions, ok := strings.Cut(rule, ":")
+		if !ok {
+			return nil, fmt.Errorf("missing method in %q", rule)
+		}
+
+		var method uint16
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "store":
+			method = zip.Store
+		case "deflate":
+			method = zip.Deflate
+		default:
+			return nil, fmt.Errorf("unknown compression method %q", name)
+		}
+		for _, extension := range strings.Split(extensions, ",") {
+			extension = strings.ToLower(strings.TrimSpace(extension))
+			if extension == "" {
+				continue
+			}
+			if !strings.HasPrefix(extension, ".") {
+				extension = "." + extension
+			}
+			policy[extension] = method
+		}
+	}
+	return policy, nil
+}
+
+// method returns the compression method for a zip path. Uncompressed archives
+// stay uncompressed so their size can be computed up front, and extensions
+// listed as compressed use the archive's method, e.g. Zstd instead of Deflate.
+func (p CompressionPolicy) method(zipPath string, archiveMethod uint16) uint16 {
+	if method, ok := p[strings.ToLower(path.Ext(zipPath))]; ok && method == zip.Store {
+		return zip.Store
+	}
+	return archiveMethod
+}
@@ -0,0 +1,125 @@
+package zipstreamer
+
+import (
+	"fmt"
+	"os"
+)
+
+// contentSpool keeps the data of an entry whose content hash is shared with
+// later entries, so it is downloaded once and written to every path
+type contentSpool struct {
+	remaining map[string]int    // Entries still to be written per content key
+	files     map[string]string // Spooled data per content key
+}
+
+// contentKey identifies an entry's data by the checksums supplied for it, or
+// returns "" when the data can't be identified before downloading
+func (e *FileEntry) contentKey() string {
+	if e.checksums.MD5 != "" {
+		return "md5:" + e.checksums.MD5
+	}
+	if e.checksums.CRC32 != "" && e.size >= 0 {
+		return fmt.Sprintf("crc32:%s:%d", e.checksums.CRC32, e.size)
+	}
+	return ""
+}
+
+func newContentSpool(entries []*FileEntry) *contentSpool {
+	s := &contentSpool{remaining: make(map[string]int), files: make(map[string]string)}
+	for _, entry := range entries {
+		if entry.Url() != nil && !entry.IsDir() {
+			if key := entry.contentKey(); key != "" {
+				s.remaining[key]++
+			}
+		}
+	}
+	return s
+}
+
+// create returns a writer to spool an entry's data into when later entries
+// share its content, or nil
+func (s *contentSpool) create(key string) *spoolFileWriter {
+	if key == "" || s.remaining[key] < 2 {
+		return nil
+	}
+	file, err := os.CreateTemp("", "zs-dedup-*")
+	if err != nil {
+		return nil
+	}
+	return &spoolFileWriter{file: file}
+}
+
+// store keeps a completely written spool file for the remaining duplicates,
+// or removes it if it could not be written
+func (s *contentSpool) store(key string, w *spoolFileWriter, complete bool) {
+	if err := w.file.Close(); err != nil || w.err != nil || !complete {
+		os.Remove(w.file.Name())
+		return
+	}
+	s.files[key] = w.file.Name()
+}
+
+// done marks one entry with the key as handled and drops the spool file once
+// no entry needs it anymore
+func (s *contentSpool) done(key string) {
+	if key == "" {
+		return
+	}
+	s.remaining[key]--
+	if s.remaining[key] <= 0 {
+		if name, ok := s.files[key]; ok {
+			os.Remove(name)
+			delete(s.files, key)
+		}
+	}
+}
+
+// cleanup removes all spool files left after streaming
+func (s *contentSpool) cleanup() {
+	for _, name := range s.files {
+		os.Remove(name)
+	}
+}
+
+// writeSpooled writes an entry from data spooled for an earlier duplicate.
+// It reports false when there is no spooled copy.
+func (z *ZipStream) writeSpooled(zipWriter *archiveWriter, entry *FileEntry, key string) (bool, error) {
+	name, ok := z.contents.files[key]
+	if !ok {
+		return false, nil
+	}
+	file, err := os.Open(name)
+	if err != nil {
+		return false, nil
+	}
+	defer file.Close()
+
+	fmt.Printf("Writing %s from a duplicate's data\n", entry.ZipPath())
+	header := entry.fileHeader(z.entryMethod(entry))
+	entryWriter, err := zipWriter.CreateHeader(header)
+	if err != nil {
+		return false, err
+	}
+	out, hasher := z.entryOutput(entryWriter)
+	if z.entrySize, err = pooledCopy(out, file); err != nil {
+		return false, err
+	}
+	if hasher != nil {
+		z.hashes = append(z.hashes, hasher.result(entry.ZipPath()))
+	}
+	return true, nil
+}
+
+// spoolFileWriter remembers write errors instead of returning them, so a full
+// temp disk only disables dedup for the entry
+type spoolFileWriter struct {
+	file *os.File
+	err  error
+}
+
+func (w *spoolFileWriter) Write(p []byte) (int, error) {
+	if w.err == nil {
+		_, w.err = w.file.Write(p)
+	}
+	return len(p), nil
+}
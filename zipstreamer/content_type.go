@@ -0,0 +1,115 @@
+package zipstreamer
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// sniffLength is the number of leading bytes a content type is sniffed from
+const sniffLength = 512
+
+// ExecutableContentTypes are the types SniffContentType reports for programs
+// and scripts, e.g. to deny them all
+var ExecutableContentTypes = []string{
+	"application/x-msdownload",
+	"application/x-executable",
+	"application/x-mach-binary",
+	"text/x-shellscript",
+}
+
+// executableMagic maps the leading bytes of programs to their content type,
+// which http.DetectContentType reports as generic binary data
+var executableMagic = []struct {
+	magic       string
+	contentType string
+}{
+	{"MZ", "application/x-msdownload"},
+	{"\x7fELF", "application/x-executable"},
+	{"\xfe\xed\xfa\xce", "application/x-mach-binary"},
+	{"\xfe\xed\xfa\xcf", "application/x-mach-binary"},
+	{"\xce\xfa\xed\xfe", "application/x-mach-binary"},
+	{"\xcf\xfa\xed\xfe", "application/x-mach-binary"},
+	{"#!", "text/x-shellscript"},
+}
+
+// SniffContentType returns the MIME type of data from its first bytes,
+// recognizing executables on top of http.DetectContentType
+func SniffContentType(data []byte) string {
+	for _, executable := range executableMagic {
+		if bytes.HasPrefix(data, []byte(executable.magic)) {
+			return executable.contentType
+		}
+	}
+	return http.DetectContentType(data)
+}
+
+// ContentTypePolicy rejects entries by the type sniffed from their data.
+// Types match exactly or by a "type/*" pattern, ignoring parameters.
+type ContentTypePolicy struct {
+	Allow []string // Types entries must have, e.g. "image/*"; empty allows any
+	Deny  []string // Types rejected even when allowed, e.g. ExecutableContentTypes
+}
+
+// Empty reports whether the policy allows every entry
+func (p ContentTypePolicy) Empty() bool {
+	return len(p.Allow) == 0 && len(p.Deny) == 0
+}
+
+// check returns an error when the policy rejects contentType
+func (p ContentTypePolicy) check(contentType string) error {
+	switch {
+	case matchContentType(p.Deny, contentType):
+		return classify(ErrTypeRejected, fmt.Sprintf("content type %s is denied", contentType))
+	case len(p.Allow) > 0 && !matchContentType(p.Allow, contentType):
+		return classify(ErrTypeRejected, fmt.Sprintf("content type %s is not allowed", contentType))
+	}
+	return nil
+}
+
+// matchContentType reports whether contentType matches one of the patterns
+func matchContentType(patterns []string, contentType string) bool {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(strings.TrimSpace(pattern))
+		if prefix, ok := strings.CutSuffix(pattern, "/*"); ok {
+			if strings.HasPrefix(mediaType, prefix+"/") {
+				return true
+			}
+		} else if mediaType == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// sniffSource peeks at the start of an entry's data and rejects it when the
+// ContentTypes policy doesn't allow its type. Nothing of it is written yet.
+func (z *ZipStream) sniffSource(entry *FileEntry, source *entrySource) error {
+	buffered := bufio.NewReaderSize(source.body, sniffLength)
+	source.body = buffered
+	peeked, err := buffered.Peek(sniffLength)
+	if source.verifier.err != nil {
+		return source.verifier.err
+	}
+	if err != nil && err != io.EOF {
+		return err
+	}
+
+	prefix := peeked
+	if entry.raw != nil || source.gzipEncoded {
+		// Compressed data is sniffed from whatever its first bytes decode to
+		decoded, err := decodedData(entry, source, bytes.NewReader(peeked))
+		if err != nil {
+			return err
+		}
+		prefix = make([]byte, sniffLength)
+		n, _ := io.ReadFull(decoded, prefix)
+		prefix = prefix[:n]
+	}
+	return z.ContentTypes.check(SniffContentType(prefix))
+}
@@ -0,0 +1,107 @@
+package zipstreamer
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// DuplicateZipPaths returns every zip path that is used by more than one file entry
+func DuplicateZipPaths(entries []*FileEntry) []string {
+	seen := make(map[string]int, len(entries))
+	var duplicates []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		seen[entry.zipPath]++
+		if seen[entry.zipPath] == 2 {
+			duplicates = append(duplicates, entry.zipPath)
+		}
+	}
+	return duplicates
+}
+
+// RenameDuplicateZipPaths returns the entries with later duplicate files renamed
+// to "name (1).ext", "name (2).ext", ... The result keeps the order and length
+// of entries, and repeated directory entries are left as they are.
+func RenameDuplicateZipPaths(entries []*FileEntry) []*FileEntry {
+	used := make(map[string]bool, len(entries))
+	result := make([]*FileEntry, 0, len(entries))
+
+	for _, entry := range entries {
+		if !used[entry.zipPath] || entry.IsDir() {
+			used[entry.zipPath] = true
+			result = append(result, entry)
+			continue
+		}
+
+		ext := path.Ext(entry.zipPath)
+		base := strings.TrimSuffix(entry.zipPath, ext)
+		for i := 1; ; i++ {
+			candidate := fmt.Sprintf("%s (%d)%s", base, i, ext)
+			if !used[candidate] {
+				used[candidate] = true
+				renamed := *entry
+				renamed.zipPath = candidate
+				result = append(result, &renamed)
+				break
+			}
+		}
+	}
+	return result
+}
+
+// NormalizeZipPaths returns the entries with their zip paths converted to the
+// given Unicode normalization form, keeping the order and length of entries.
+// macOS-originated names are usually NFD while most other systems expect NFC.
+func NormalizeZipPaths(entries []*FileEntry, form norm.Form) []*FileEntry {
+	return mapZipPaths(entries, form.String)
+}
+
+// mapZipPaths returns the entries with fn applied to each zip path. Entries
+// whose path is unchanged are reused; the others are copied.
+func mapZipPaths(entries []*FileEntry, fn func(string) string) []*FileEntry {
+	result := make([]*FileEntry, 0, len(entries))
+	for _, entry := range entries {
+		mapped := fn(entry.zipPath)
+		if mapped == entry.zipPath {
+			result = append(result, entry)
+			continue
+		}
+		renamed := *entry
+		renamed.zipPath = mapped
+		result = append(result, &renamed)
+	}
+	return result
+}
+
+// SortEntries returns a copy of entries with directories first, then files,
+// each ordered lexicographically by zip path, so the same set of entries
+// always produces the same archive layout
+func SortEntries(entries []*FileEntry) []*FileEntry {
+	sorted := append([]*FileEntry{}, entries...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].IsDir() != sorted[j].IsDir() {
+			return sorted[i].IsDir()
+		}
+		return sorted[i].zipPath < sorted[j].zipPath
+	})
+	return sorted
+}
+
+// FlattenZipPaths returns the file entries moved to the archive root, dropping
+// all directory entries. Colliding names can then be resolved with
+// RenameDuplicateZipPaths.
+func FlattenZipPaths(entries []*FileEntry) []*FileEntry {
+	files := make([]*FileEntry, 0, len(entries))
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			files = append(files, entry)
+		}
+	}
+	return mapZipPaths(files, path.Base)
+}
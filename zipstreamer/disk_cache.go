@@ -0,0 +1,185 @@
+package zipstreamer
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// DiskCache spools frequently requested entries to a local directory so
+// popular folders are zipped at disk speed instead of being fetched from the
+// provider again. An entry is spooled once it has been requested minHits times,
+// and the least recently used files are evicted once maxSize bytes are stored.
+// All methods are safe on a nil *DiskCache, which caches nothing.
+type DiskCache struct {
+	mu      sync.Mutex
+	dir     string
+	maxSize int64
+	minHits int
+	size    int64
+	hits    map[string]int
+	files   map[string]*diskCacheFile
+}
+
+type diskCacheFile struct {
+	path     string
+	size     int64
+	lastUsed time.Time
+}
+
+// NewDiskCache creates a cache in dir, removing files left by a previous run
+func NewDiskCache(dir string, maxSize int64, minHits int) (*DiskCache, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	stale, _ := filepath.Glob(filepath.Join(dir, "zs-*"))
+	for _, file := range stale {
+		os.Remove(file)
+	}
+	if minHits < 1 {
+		minHits = 1
+	}
+
+	return &DiskCache{
+		dir:     dir,
+		maxSize: maxSize,
+		minHits: minHits,
+		hits:    make(map[string]int),
+		files:   make(map[string]*diskCacheFile),
+	}, nil
+}
+
+// open returns the spooled copy of url and counts the request towards making it hot
+func (c *DiskCache) open(url string) (io.ReadCloser, bool) {
+	if c == nil {
+		return nil, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.hits[url]++
+	cached, ok := c.files[url]
+	if !ok {
+		return nil, false
+	}
+	file, err := os.Open(cached.path)
+	if err != nil {
+		c.remove(url)
+		return nil, false
+	}
+	cached.lastUsed = time.Now()
+	return file, true
+}
+
+// spool returns a writer that records the body of a hot entry, or nil when the
+// entry is not hot yet or already cached
+func (c *DiskCache) spool(url string) *diskSpool {
+	if c == nil {
+		return nil
+	}
+
+	c.mu.Lock()
+	hot := c.hits[url] >= c.minHits
+	_, cached := c.files[url]
+	c.mu.Unlock()
+	if !hot || cached {
+		return nil
+	}
+
+	file, err := os.CreateTemp(c.dir, "zs-tmp-*")
+	if err != nil {
+		return nil
+	}
+	return &diskSpool{cache: c, url: url, file: file}
+}
+
+// add moves a completed spool file into the cache and evicts old entries
+func (c *DiskCache) add(url, tempPath string, size int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.files[url]; ok || size > c.maxSize {
+		os.Remove(tempPath)
+		return
+	}
+
+	sum := sha256.Sum256([]byte(url))
+	path := filepath.Join(c.dir, "zs-"+hex.EncodeToString(sum[:]))
+	if err := os.Rename(tempPath, path); err != nil {
+		os.Remove(tempPath)
+		return
+	}
+	c.files[url] = &diskCacheFile{path: path, size: size, lastUsed: time.Now()}
+	c.size += size
+
+	for c.size > c.maxSize {
+		var oldestURL string
+		var oldest *diskCacheFile
+		for candidateURL, candidate := range c.files {
+			if oldest == nil || candidate.lastUsed.Before(oldest.lastUsed) {
+				oldestURL, oldest = candidateURL, candidate
+			}
+		}
+		fmt.Printf("Evicting %s from disk cache\n", oldestURL)
+		c.remove(oldestURL)
+	}
+}
+
+// remove deletes a cached file; the caller holds the lock
+func (c *DiskCache) remove(url string) {
+	if cached, ok := c.files[url]; ok {
+		os.Remove(cached.path)
+		c.size -= cached.size
+		delete(c.files, url)
+	}
+}
+
+// diskSpool records an entry's raw body while it is streamed
+type diskSpool struct {
+	cache *DiskCache
+	url   string
+	file  *os.File
+	size  int64
+	err   error
+	done  bool
+}
+
+func (s *diskSpool) Write(p []byte) (int, error) {
+	// Spool failures must not affect the archive, so errors are only remembered
+	if s.err == nil {
+		n, err := s.file.Write(p)
+		s.size += int64(n)
+		s.err = err
+	}
+	return len(p), nil
+}
+
+// commit stores the spooled body once the entry was streamed successfully
+func (s *diskSpool) commit() {
+	if s.done {
+		return
+	}
+	s.done = true
+	closeErr := s.file.Close()
+	if s.err != nil || closeErr != nil {
+		os.Remove(s.file.Name())
+		return
+	}
+	s.cache.add(s.url, s.file.Name(), s.size)
+}
+
+// discard drops the spooled body unless it was committed
+func (s *diskSpool) discard() {
+	if s.done {
+		return
+	}
+	s.done = true
+	s.file.Close()
+	os.Remove(s.file.Name())
+}
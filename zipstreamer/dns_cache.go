@@ -0,0 +1,110 @@
+package zipstreamer
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+)
+
+// maxDNSEntries is the number of cached hosts above which expired entries are dropped
+const maxDNSEntries = 1024
+
+// dnsCache remembers resolved upstream addresses for a while, so archives
+// with thousands of entries on one CDN host resolve it once instead of per
+// connection. Concurrent lookups of the same host share a single query.
+type dnsCache struct {
+	resolver *net.Resolver
+	ttl      time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*dnsEntry
+}
+
+type dnsEntry struct {
+	ready   chan struct{} // Closed once the lookup finished
+	addrs   []string
+	err     error
+	expires time.Time
+}
+
+func newDNSCache(ttl time.Duration) *dnsCache {
+	return &dnsCache{resolver: net.DefaultResolver, ttl: ttl, entries: make(map[string]*dnsEntry)}
+}
+
+// lookup returns the addresses of host, resolving it only when no fresh
+// result is cached. Failures are not cached.
+func (c *dnsCache) lookup(ctx context.Context, host string) ([]string, error) {
+	c.mu.Lock()
+	entry, ok := c.entries[host]
+	if !ok || (isClosed(entry.ready) && (entry.err != nil || time.Now().After(entry.expires))) {
+		if len(c.entries) >= maxDNSEntries {
+			c.prune()
+		}
+		entry = &dnsEntry{ready: make(chan struct{})}
+		c.entries[host] = entry
+		c.mu.Unlock()
+
+		// The shared lookup must not fail because the first caller gave up
+		entry.addrs, entry.err = c.resolver.LookupHost(context.WithoutCancel(ctx), host)
+		entry.expires = time.Now().Add(c.ttl)
+		close(entry.ready)
+		return entry.addrs, entry.err
+	}
+	c.mu.Unlock()
+
+	select {
+	case <-entry.ready:
+		return entry.addrs, entry.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// prune drops finished lookups that expired; the caller holds the lock
+func (c *dnsCache) prune() {
+	now := time.Now()
+	for host, entry := range c.entries {
+		if isClosed(entry.ready) && now.After(entry.expires) {
+			delete(c.entries, host)
+		}
+	}
+}
+
+// dialContext wraps dial so host names are resolved through the cache. Every
+// cached address is tried in turn, like the standard dialer does.
+func (c *dnsCache) dialContext(dial func(ctx context.Context, network, address string) (net.Conn, error)) func(ctx context.Context, network, address string) (net.Conn, error) {
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(address)
+		if err != nil || net.ParseIP(host) != nil {
+			return dial(ctx, network, address)
+		}
+
+		addrs, err := c.lookup(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		var lastErr error
+		for _, addr := range addrs {
+			conn, err := dial(ctx, network, net.JoinHostPort(addr, port))
+			if err == nil {
+				return conn, nil
+			}
+			lastErr = err
+			if ctx.Err() != nil {
+				break
+			}
+		}
+		return nil, lastErr
+	}
+}
+
+// isClosed reports whether ch has been closed
+func isClosed(ch chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
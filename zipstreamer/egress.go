@@ -0,0 +1,129 @@
+package zipstreamer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"syscall"
+)
+
+// EgressRules match upstream destinations either by host name, where
+// ".example.com" covers all subdomains, or by address as an IP or CIDR block.
+// The zero value matches nothing.
+type EgressRules struct {
+	hosts    []string
+	networks []*net.IPNet
+}
+
+// Add adds a host name, IP address or CIDR block such as "10.0.0.0/8"
+func (r *EgressRules) Add(rule string) error {
+	rule = strings.TrimSpace(rule)
+	if rule == "" {
+		return errors.New("empty egress rule")
+	}
+	if strings.Contains(rule, "/") {
+		_, network, err := net.ParseCIDR(rule)
+		if err != nil {
+			return err
+		}
+		r.networks = append(r.networks, network)
+		return nil
+	}
+	if ip := net.ParseIP(rule); ip != nil {
+		bits := 8 * len(ip.To16())
+		if ip.To4() != nil {
+			ip, bits = ip.To4(), 32
+		}
+		r.networks = append(r.networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+		return nil
+	}
+	r.hosts = append(r.hosts, strings.ToLower(rule))
+	return nil
+}
+
+// Empty reports whether no rules were added
+func (r EgressRules) Empty() bool {
+	return len(r.hosts) == 0 && len(r.networks) == 0
+}
+
+// match reports whether the destination host name or its resolved address
+// is covered by a rule
+func (r EgressRules) match(host string, ip net.IP) bool {
+	if host != "" && hostAllowed(host, r.hosts) {
+		return true
+	}
+	return r.matchAddress(ip)
+}
+
+// matchAddress reports whether an IP or CIDR rule covers ip
+func (r EgressRules) matchAddress(ip net.IP) bool {
+	for _, network := range r.networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// internalAddress reports whether ip is a loopback, link-local, private or
+// unspecified address, such as those of cloud metadata services
+func internalAddress(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsPrivate() || ip.IsUnspecified()
+}
+
+// egressHostKey carries the host name being dialed down to the socket control hook
+type egressHostKey struct{}
+
+// egressPolicy checks every upstream connection against allow and deny rules
+// once the address has been resolved, so a host name that later resolves to
+// a different address (DNS rebinding) cannot reach unapproved destinations.
+// An allowed host name doesn't vouch for internal addresses: those are only
+// reached when an IP or CIDR rule allows them, or allowInternal is set.
+// With a proxy, the proxy's address is what gets checked.
+type egressPolicy struct {
+	allow         EgressRules // Empty allows every destination not denied
+	deny          EgressRules
+	allowInternal bool
+}
+
+// dialContext remembers the dialed host name for control before dialing
+func (p egressPolicy) dialContext(dial func(ctx context.Context, network, address string) (net.Conn, error)) func(ctx context.Context, network, address string) (net.Conn, error) {
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			return nil, err
+		}
+		if net.ParseIP(host) != nil {
+			host = ""
+		}
+		return dial(context.WithValue(ctx, egressHostKey{}, host), network, address)
+	}
+}
+
+// control rejects connections to disallowed addresses before they are made
+func (p egressPolicy) control(ctx context.Context, network, address string, _ syscall.RawConn) error {
+	host, _ := ctx.Value(egressHostKey{}).(string)
+	addr, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return fmt.Errorf("egress to %s is not allowed: unresolved address", address)
+	}
+
+	denied := p.deny.match(host, ip) || (!p.allow.Empty() && !p.allow.match(host, ip))
+	if !denied && !p.allowInternal && internalAddress(ip) && !p.allow.matchAddress(ip) {
+		denied = true
+	}
+	if denied {
+		if host != "" {
+			return fmt.Errorf("egress to %s (%s) is not allowed", host, ip)
+		}
+		return fmt.Errorf("egress to %s is not allowed", ip)
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package zipstreamer
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestEgressAllowedNameResolvingToLoopback(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	// The server listens on 127.0.0.1, which localhost resolves to
+	target := strings.Replace(server.URL, "127.0.0.1", "localhost", 1)
+
+	tests := []struct {
+		name          string
+		allow         []string
+		allowInternal bool
+		wantErr       bool
+	}{
+		{"allowed name", []string{"localhost"}, false, true},
+		{"allowed name with internal opt-in", []string{"localhost"}, true, false},
+		{"allowed address", []string{"localhost", "127.0.0.0/8"}, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := DefaultTransportOptions()
+			for _, rule := range tt.allow {
+				if err := opts.EgressAllow.Add(rule); err != nil {
+					t.Fatal(err)
+				}
+			}
+			opts.EgressAllowInternal = tt.allowInternal
+
+			resp, err := NewClient(opts).Get(target)
+			if err == nil {
+				resp.Body.Close()
+			}
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil && !strings.Contains(err.Error(), "is not allowed") {
+				t.Fatalf("err = %v, want an egress refusal", err)
+			}
+		})
+	}
+}
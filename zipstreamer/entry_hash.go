@@ -0,0 +1,37 @@
+package zipstreamer
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+)
+
+// EntryHash describes an entry's data as it was written to the archive
+type EntryHash struct {
+	ZipPath string `json:"path"`
+	Size    int64  `json:"size"`
+	SHA256  string `json:"sha256"`
+}
+
+// hashingWriter hashes and counts the data written to a zip entry
+type hashingWriter struct {
+	w    io.Writer
+	hash hash.Hash
+	size int64
+}
+
+func newHashingWriter(w io.Writer) *hashingWriter {
+	return &hashingWriter{w: w, hash: sha256.New()}
+}
+
+func (h *hashingWriter) Write(p []byte) (int, error) {
+	n, err := h.w.Write(p)
+	h.hash.Write(p[:n])
+	h.size += int64(n)
+	return n, err
+}
+
+func (h *hashingWriter) result(zipPath string) EntryHash {
+	return EntryHash{ZipPath: zipPath, Size: h.size, SHA256: hex.EncodeToString(h.hash.Sum(nil))}
+}
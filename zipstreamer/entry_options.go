@@ -0,0 +1,129 @@
+package zipstreamer
+
+import (
+	"archive/zip"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// EntryOption sets an optional property of an entry created with NewFileEntry
+type EntryOption func(*entryOptions)
+
+// entryOptions collects the options of NewFileEntry before the entry exists
+type entryOptions struct {
+	size      int64
+	modTime   time.Time
+	accessed  time.Time
+	created   time.Time
+	owner     *fileOwner
+	mode      os.FileMode
+	headers   http.Header
+	checksums Checksums
+	wrap      ReaderWrapper
+	urlPrefix *string // nil falls back to ZS_URL_PREFIX
+}
+
+// WithSize sets the expected size of the entry's data, which is used to
+// estimate the archive size and to verify the downloaded byte count
+func WithSize(size int64) EntryOption {
+	return func(o *entryOptions) { o.size = size }
+}
+
+// WithModTime sets the modification time written for the entry instead of
+// the time of streaming
+func WithModTime(modTime time.Time) EntryOption {
+	return func(o *entryOptions) { o.modTime = modTime }
+}
+
+// WithAccessTime sets the last access time written for the entry along with
+// its modification time
+func WithAccessTime(accessTime time.Time) EntryOption {
+	return func(o *entryOptions) { o.accessed = accessTime }
+}
+
+// WithCreateTime sets the creation time written for the entry along with its
+// modification time
+func WithCreateTime(createTime time.Time) EntryOption {
+	return func(o *entryOptions) { o.created = createTime }
+}
+
+// WithOwner sets the numeric user and group of the extracted file
+func WithOwner(uid, gid int) EntryOption {
+	return func(o *entryOptions) { o.owner = &fileOwner{uid: uint32(uid), gid: uint32(gid)} }
+}
+
+// WithMode sets the permission bits of the extracted file
+func WithMode(mode os.FileMode) EntryOption {
+	return func(o *entryOptions) { o.mode = mode }
+}
+
+// WithHeaders sends the headers with every request for the entry's data.
+// Like cookies they keep the entry out of the shared caches.
+func WithHeaders(headers http.Header) EntryOption {
+	return func(o *entryOptions) { o.headers = headers.Clone() }
+}
+
+// WithCRC32 verifies the entry's data against its CRC-32 while streaming
+func WithCRC32(crc uint32) EntryOption {
+	return func(o *entryOptions) { o.checksums.CRC32 = fmt.Sprintf("%08x", crc) }
+}
+
+// WithChecksums verifies the entry's data against the hex digests supplied by
+// a provider or descriptor while streaming. Empty digests are not verified.
+func WithChecksums(checksums Checksums) EntryOption {
+	return func(o *entryOptions) {
+		o.checksums = Checksums{
+			CRC32: strings.ToLower(strings.TrimSpace(checksums.CRC32)),
+			MD5:   strings.ToLower(strings.TrimSpace(checksums.MD5)),
+		}
+	}
+}
+
+// WithReaderWrapper passes the entry's downloaded body through wrap, e.g. to
+// decrypt client-side encrypted sources
+func WithReaderWrapper(wrap ReaderWrapper) EntryOption {
+	return func(o *entryOptions) { o.wrap = wrap }
+}
+
+// WithURLPrefix only allows URLs starting with prefix. Without it the prefix
+// is still read from ZS_URL_PREFIX.
+func WithURLPrefix(prefix string) EntryOption {
+	return func(o *entryOptions) { o.urlPrefix = &prefix }
+}
+
+// apply sets the options that are stored on the entry itself
+func (o *entryOptions) apply(entry *FileEntry) {
+	entry.modTime = o.modTime
+	entry.accessed = o.accessed
+	entry.created = o.created
+	entry.owner = o.owner
+	entry.mode = o.mode
+	if entry.IsDir() {
+		return
+	}
+	entry.size = o.size
+	entry.headers = o.headers
+	entry.checksums = o.checksums
+	entry.wrap = o.wrap
+}
+
+// fileHeader returns the archive header of a file entry, carrying its times,
+// owner and mode when they are known
+func (f *FileEntry) fileHeader(method uint16) *zip.FileHeader {
+	header := &zip.FileHeader{
+		Name:     f.zipPath,
+		Method:   method,
+		Modified: f.modTime,
+		Extra:    f.extraFields(),
+	}
+	if header.Modified.IsZero() {
+		header.Modified = time.Now()
+	}
+	if f.mode != 0 {
+		header.SetMode(f.mode)
+	}
+	return header
+}
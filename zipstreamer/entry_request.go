@@ -0,0 +1,68 @@
+package zipstreamer
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+)
+
+// RequestAuthorizer adds credentials to a request for an entry's data. It is
+// called for every request, including resumed ones, so it may refresh tokens.
+type RequestAuthorizer func(*http.Request) error
+
+// SetAuthorizer makes every request for the entry's data pass through authorize
+func (e *FileEntry) SetAuthorizer(authorize RequestAuthorizer) {
+	e.authorize = authorize
+}
+
+// SetClient fetches the entry's data with client instead of the stream's, e.g.
+// one that egresses through a provider-specific proxy
+func (e *FileEntry) SetClient(client *http.Client) {
+	e.client = client
+}
+
+// SetBasicAuth sends the credentials as Basic auth with every request for the
+// entry's data
+func (e *FileEntry) SetBasicAuth(username, password string) {
+	e.basicAuth = url.UserPassword(username, password)
+}
+
+// prepareRequest adds the entry's own credentials to a request for its data
+func (e *FileEntry) prepareRequest(req *http.Request) error {
+	for name, values := range e.headers {
+		req.Header[name] = append([]string(nil), values...)
+	}
+	e.preferIdentity(req)
+	for _, cookie := range e.cookies {
+		req.AddCookie(cookie)
+	}
+	if e.basicAuth != nil {
+		password, _ := e.basicAuth.Password()
+		req.SetBasicAuth(e.basicAuth.Username(), password)
+	}
+	if e.authorize != nil {
+		if err := e.authorize(req); err != nil {
+			return fmt.Errorf("failed to authorize request: %v", err)
+		}
+	}
+	return nil
+}
+
+// private reports whether the entry's data depends on its own credentials and
+// must not be shared through the caches
+func (e *FileEntry) private() bool {
+	return len(e.cookies) > 0 || len(e.headers) > 0 || e.basicAuth != nil || e.authorize != nil || e.member != nil
+}
+
+// Cookies returns the cookies sent with every request for the entry's data
+func (e *FileEntry) Cookies() []*http.Cookie {
+	return e.cookies
+}
+
+// NewCookieJar returns a jar for one archive, so session cookies set by one
+// upstream response are sent with the following requests of that archive only
+func NewCookieJar() http.CookieJar {
+	jar, _ := cookiejar.New(nil)
+	return jar
+}
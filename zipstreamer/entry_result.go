@@ -0,0 +1,36 @@
+package zipstreamer
+
+import "time"
+
+// EntryResult reports what happened to one entry of the archive
+type EntryResult struct {
+	ZipPath  string
+	Bytes    int64         // Data written to the archive, before compression
+	Duration time.Duration // From starting on the entry until it was added or failed
+	Err      error         // Why the entry is not in the archive, nil when it is
+}
+
+// EntryStartHook is called before an entry is written. Returning an error
+// leaves the entry out of the archive with that error. With Parallelism the
+// entry may already be fetched and compressed by then.
+type EntryStartHook func(entry *FileEntry) error
+
+// EntryResultHook is called once an entry was added to the archive or failed.
+// Hooks run on the streaming goroutine, so slow hooks slow the stream.
+type EntryResultHook func(result EntryResult)
+
+// startEntry resets the measurements of the entry about to be written
+func (z *ZipStream) startEntry() {
+	z.entryStart = time.Now()
+	z.entrySize = 0
+}
+
+// added records an entry that made it into the archive
+func (z *ZipStream) added(entry *FileEntry) {
+	z.noteWritten(entry)
+	result := EntryResult{ZipPath: entry.ZipPath(), Bytes: z.entrySize, Duration: time.Since(z.entryStart)}
+	z.results = append(z.results, result)
+	if z.OnEntryComplete != nil {
+		z.OnEntryComplete(result)
+	}
+}
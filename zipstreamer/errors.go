@@ -0,0 +1,57 @@
+package zipstreamer
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+)
+
+// Sentinels classifying failures, to be matched with errors.Is. The errors
+// returned keep their specific messages.
+var (
+	ErrDisallowedURL = errors.New("URL not allowed")
+	ErrPathInvalid   = errors.New("invalid zip path")
+	ErrEmptyArchive  = errors.New("empty file - all files and folders failed")
+	ErrTypeRejected  = errors.New("content type not allowed")
+)
+
+// ErrFetchFailed is returned when upstream answers a request for an entry's
+// data with an unexpected status. Match it with errors.As.
+type ErrFetchFailed struct {
+	Status int
+	status string // Status line of the response, e.g. "404 Not Found"
+}
+
+// fetchFailed describes an unexpected upstream response
+func fetchFailed(resp *http.Response) error {
+	return &ErrFetchFailed{Status: resp.StatusCode, status: resp.Status}
+}
+
+func (e *ErrFetchFailed) Error() string {
+	if e.status != "" {
+		return "upstream returned " + e.status
+	}
+	text := http.StatusText(e.Status)
+	if text == "" {
+		return "upstream returned " + strconv.Itoa(e.Status)
+	}
+	return "upstream returned " + strconv.Itoa(e.Status) + " " + text
+}
+
+// classifiedError is a specific error that matches one of the sentinels
+type classifiedError struct {
+	class   error
+	message string
+}
+
+func classify(class error, message string) error {
+	return &classifiedError{class: class, message: message}
+}
+
+func (e *classifiedError) Error() string {
+	return e.message
+}
+
+func (e *classifiedError) Is(target error) bool {
+	return target == e.class
+}
@@ -0,0 +1,97 @@
+package zipstreamer
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"sync"
+)
+
+// ETagCache keeps small upstream responses in memory keyed by URL and
+// revalidates them with If-None-Match, so covers, nfo files and subtitles that
+// appear in many archives are only downloaded again when they change
+type ETagCache struct {
+	mu           sync.Mutex
+	maxEntrySize int64
+	maxTotalSize int64
+	totalSize    int64
+	entries      map[string]*cachedResponse
+	order        []string // Insertion order, oldest first, for eviction
+}
+
+type cachedResponse struct {
+	etag string
+	data []byte
+}
+
+// NewETagCache creates a cache holding responses of up to maxEntrySize bytes,
+// evicting the oldest once maxTotalSize bytes are stored
+func NewETagCache(maxEntrySize, maxTotalSize int64) *ETagCache {
+	return &ETagCache{
+		maxEntrySize: maxEntrySize,
+		maxTotalSize: maxTotalSize,
+		entries:      make(map[string]*cachedResponse),
+	}
+}
+
+// prepare adds a validator for a cached copy of the request's URL
+func (c *ETagCache) prepare(req *http.Request) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if cached, ok := c.entries[req.URL.String()]; ok {
+		req.Header.Set("If-None-Match", cached.etag)
+	}
+}
+
+// serve returns the body to stream for a response: the cached copy on 304, a
+// freshly cached copy for small responses carrying an ETag, or resp.Body itself
+func (c *ETagCache) serve(url string, resp *http.Response) (io.ReadCloser, bool) {
+	if resp.StatusCode == http.StatusNotModified {
+		c.mu.Lock()
+		cached, ok := c.entries[url]
+		c.mu.Unlock()
+		if !ok {
+			return nil, false
+		}
+		resp.Body.Close()
+		return io.NopCloser(bytes.NewReader(cached.data)), true
+	}
+
+	etag := resp.Header.Get("ETag")
+	if resp.StatusCode != http.StatusOK || etag == "" || resp.Header.Get("Content-Encoding") != "" || resp.ContentLength < 0 || resp.ContentLength > c.maxEntrySize {
+		return resp.Body, true
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, c.maxEntrySize+1))
+	resp.Body.Close()
+	if err != nil {
+		return nil, false
+	}
+	if int64(len(data)) <= c.maxEntrySize {
+		c.store(url, &cachedResponse{etag: etag, data: data})
+	}
+	return io.NopCloser(bytes.NewReader(data)), true
+}
+
+func (c *ETagCache) store(url string, response *cachedResponse) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if old, ok := c.entries[url]; ok {
+		c.totalSize -= int64(len(old.data))
+	} else {
+		c.order = append(c.order, url)
+	}
+	c.entries[url] = response
+	c.totalSize += int64(len(response.data))
+
+	for c.totalSize > c.maxTotalSize && len(c.order) > 0 {
+		oldest := c.order[0]
+		c.order = c.order[1:]
+		if evicted, ok := c.entries[oldest]; ok {
+			c.totalSize -= int64(len(evicted.data))
+			delete(c.entries, oldest)
+		}
+	}
+}
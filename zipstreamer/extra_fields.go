@@ -0,0 +1,101 @@
+package zipstreamer
+
+import (
+	"archive/zip"
+	"encoding/binary"
+	"time"
+)
+
+// IDs of the extra fields written to headers
+const (
+	ntfsExtraID      = 0x000a // NTFS times in 100ns units
+	extTimeExtraID   = 0x5455 // Extended timestamp, Unix seconds
+	unixOwnerExtraID = 0x7875 // Info-ZIP Unix UID and GID
+)
+
+// Sizes of the extra fields including their 4 byte header
+const (
+	ntfsExtraSize      = 36
+	extTimeExtraSize   = 9
+	unixOwnerExtraSize = 15
+)
+
+// ntfsEpochOffset is the number of 100ns intervals from 1601, the NTFS epoch,
+// to the Unix epoch
+const ntfsEpochOffset = 116444736000000000
+
+// fileOwner holds the numeric owner of an extracted file
+type fileOwner struct {
+	uid, gid uint32
+}
+
+// SetModTime sets the modification time written for the entry, e.g. one
+// reported by a provider
+func (f *FileEntry) SetModTime(modTime time.Time) {
+	f.modTime = modTime
+}
+
+// SetOwner sets the numeric user and group of the extracted file, which
+// Unix extraction tools restore when run as root
+func (f *FileEntry) SetOwner(uid, gid int) {
+	f.owner = &fileOwner{uid: uint32(uid), gid: uint32(gid)}
+}
+
+// ExtraFieldsSize returns the bytes of extra fields in each header of the
+// entry besides the extended timestamp archive/zip adds itself
+func (f *FileEntry) ExtraFieldsSize() int64 {
+	return int64(len(f.extraFields()))
+}
+
+// extraFields returns the NTFS times and owner of the entry when they are known
+func (f *FileEntry) extraFields() []byte {
+	var extra []byte
+	if !f.modTime.IsZero() {
+		accessTime, createTime := f.accessed, f.created
+		if accessTime.IsZero() {
+			accessTime = f.modTime
+		}
+		if createTime.IsZero() {
+			createTime = f.modTime
+		}
+		field := make([]byte, ntfsExtraSize)
+		binary.LittleEndian.PutUint16(field[0:], ntfsExtraID)
+		binary.LittleEndian.PutUint16(field[2:], ntfsExtraSize-4)
+		// 4 reserved bytes, then the attribute holding the three times
+		binary.LittleEndian.PutUint16(field[8:], 0x0001)
+		binary.LittleEndian.PutUint16(field[10:], 24)
+		binary.LittleEndian.PutUint64(field[12:], ntfsTime(f.modTime))
+		binary.LittleEndian.PutUint64(field[20:], ntfsTime(accessTime))
+		binary.LittleEndian.PutUint64(field[28:], ntfsTime(createTime))
+		extra = append(extra, field...)
+	}
+	if f.owner != nil {
+		field := make([]byte, unixOwnerExtraSize)
+		binary.LittleEndian.PutUint16(field[0:], unixOwnerExtraID)
+		binary.LittleEndian.PutUint16(field[2:], unixOwnerExtraSize-4)
+		field[4] = 1 // Version
+		field[5] = 4
+		binary.LittleEndian.PutUint32(field[6:], f.owner.uid)
+		field[10] = 4
+		binary.LittleEndian.PutUint32(field[11:], f.owner.gid)
+		extra = append(extra, field...)
+	}
+	return extra
+}
+
+// ntfsTime converts a time to 100ns intervals since 1601
+func ntfsTime(t time.Time) uint64 {
+	return uint64(t.UnixNano()/100 + ntfsEpochOffset)
+}
+
+// setRawTimes fills in the MS-DOS time fields and the extended timestamp,
+// which unlike CreateHeader, CreateRaw leaves to the caller
+func setRawTimes(header *zip.FileHeader) {
+	header.ModifiedDate, header.ModifiedTime = msDosTime(header.Modified)
+	field := make([]byte, extTimeExtraSize)
+	binary.LittleEndian.PutUint16(field[0:], extTimeExtraID)
+	binary.LittleEndian.PutUint16(field[2:], extTimeExtraSize-4)
+	field[4] = 1 // Modification time only
+	binary.LittleEndian.PutUint32(field[5:], uint32(header.Modified.Unix()))
+	header.Extra = append(header.Extra, field...)
+}
@@ -1,32 +1,77 @@
 package zipstreamer
 
 import (
-	"errors"
+	"io"
+	"net/http"
 	"net/url"
 	"os"
 	"path"
 	"strings"
+	"time"
 )
 
 type FileEntry struct {
-	url     *url.URL
-	zipPath string
+	url       *url.URL
+	zipPath   string
+	wrap      ReaderWrapper
+	content   []byte      // Inline data for generated entries
+	modTime   time.Time   // Zero means the time of streaming
+	accessed  time.Time   // Written with modTime when known, see extraFields
+	created   time.Time   // Like accessed
+	owner     *fileOwner  // Numeric owner of the extracted file; nil leaves the default
+	size      int64       // Expected size in bytes, -1 if unknown
+	checksums Checksums   // Expected digests of the data
+	raw       *RawData    // Set when the data is already compressed
+	nested    bool        // A zip stored as is, see NewNestedZipEntry
+	member    *byteRange  // Span of the entry in a remote zip, see ExpandRemoteZip
+	metadata  []byte      // JSON describing the source, see AddSidecars
+	mode      os.FileMode // Permission bits of the extracted file; zero leaves the default
+	headers   http.Header // Sent with every request for the data
+	cookies   []*http.Cookie
+	basicAuth *url.Userinfo // Kept out of url so it never shows up in logs
+	authorize RequestAuthorizer
+	client    *http.Client // Overrides the stream's client when set
 }
 
+// Checksums are the expected digests of an entry's data as hex strings, as
+// supplied by a provider or descriptor. Empty fields are not verified.
+type Checksums struct {
+	CRC32 string
+	MD5   string
+}
+
+// ReaderWrapper transforms an entry's body before it is written to the zip
+type ReaderWrapper func(io.Reader) io.Reader
+
+// UrlPrefixEnvVar restricts the URLs of new entries to a prefix.
+//
+// Deprecated: pass WithURLPrefix to NewFileEntry instead. The variable is only
+// read when that option is not given.
 const UrlPrefixEnvVar = "ZS_URL_PREFIX"
 
-func NewFileEntry(urlString string, zipPath string) (*FileEntry, error) {
-	zipPath = path.Clean(zipPath)
-	if path.IsAbs(zipPath) {
-		return nil, errors.New("zip path must be relative")
+// NewFileEntry creates an entry downloading urlString into zipPath, or a
+// directory entry when zipPath ends with a slash. Options attach what is
+// known about the file up front.
+func NewFileEntry(urlString string, zipPath string, opts ...EntryOption) (*FileEntry, error) {
+	options := entryOptions{size: -1}
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	isDir := strings.HasSuffix(strings.ReplaceAll(zipPath, "\\", "/"), "/")
+	zipPath, err := cleanZipPath(zipPath)
+	if err != nil {
+		return nil, err
 	}
 
 	// ✅ Allow empty folders (directories ending with '/')
-	if strings.HasSuffix(zipPath, "/") {
-		return &FileEntry{
+	if isDir {
+		entry := &FileEntry{
 			url:     nil, // No URL needed for empty directories
-			zipPath: zipPath,
-		}, nil
+			zipPath: zipPath + "/",
+		}
+		options.apply(entry)
+		return entry, nil
 	}
 
 	// Validate file entries with URL
@@ -35,15 +80,104 @@ func NewFileEntry(urlString string, zipPath string) (*FileEntry, error) {
 		return nil, err
 	}
 	if url.Scheme != "http" && url.Scheme != "https" {
-		return nil, errors.New("url must be a http url")
+		return nil, classify(ErrDisallowedURL, "url must be a http url")
 	}
 
 	urlPrefix := os.Getenv(UrlPrefixEnvVar)
+	if options.urlPrefix != nil {
+		urlPrefix = *options.urlPrefix
+	}
 	if !strings.HasPrefix(urlString, urlPrefix) {
-		return nil, errors.New("URL not allowed")
+		return nil, ErrDisallowedURL
 	}
 
-	return &FileEntry{url: url, zipPath: zipPath}, nil
+	entry := &FileEntry{url: url, zipPath: zipPath}
+	options.apply(entry)
+	if url.User != nil {
+		entry.basicAuth = url.User
+		url.User = nil
+	}
+	return entry, nil
+}
+
+// NewFileEntryWithReaderWrapper creates a file entry whose downloaded body is
+// passed through wrap, e.g. to decrypt client-side encrypted sources.
+//
+// Deprecated: pass WithReaderWrapper to NewFileEntry instead.
+func NewFileEntryWithReaderWrapper(urlString string, zipPath string, wrap ReaderWrapper) (*FileEntry, error) {
+	return NewFileEntry(urlString, zipPath, WithReaderWrapper(wrap))
+}
+
+// NewFileEntryWithSize creates a file entry with a known size, which is used to
+// estimate the archive size and to verify the downloaded byte count. wrap may be nil.
+//
+// Deprecated: pass WithSize and WithReaderWrapper to NewFileEntry instead.
+func NewFileEntryWithSize(urlString string, zipPath string, size int64, wrap ReaderWrapper) (*FileEntry, error) {
+	return NewFileEntry(urlString, zipPath, WithSize(size), WithReaderWrapper(wrap))
+}
+
+// NewFileEntryWithChecksums creates a file entry whose data is verified against
+// the given checksums while streaming. size may be -1 and wrap may be nil.
+//
+// Deprecated: pass WithSize, WithReaderWrapper and WithChecksums to
+// NewFileEntry instead.
+func NewFileEntryWithChecksums(urlString string, zipPath string, size int64, wrap ReaderWrapper, checksums Checksums) (*FileEntry, error) {
+	return NewFileEntry(urlString, zipPath, WithSize(size), WithReaderWrapper(wrap), WithChecksums(checksums))
+}
+
+// NewDirectoryEntry creates an explicit directory entry carrying the source
+// folder's modification time
+func NewDirectoryEntry(zipPath string, modTime time.Time) (*FileEntry, error) {
+	zipPath, err := cleanZipPath(zipPath)
+	if err != nil {
+		return nil, err
+	}
+	return &FileEntry{zipPath: zipPath + "/", modTime: modTime}, nil
+}
+
+// NewInlineFileEntry creates an entry whose data is generated by the server
+// (e.g. reports) instead of being downloaded
+func NewInlineFileEntry(zipPath string, content []byte) (*FileEntry, error) {
+	zipPath, err := cleanZipPath(zipPath)
+	if err != nil {
+		return nil, err
+	}
+	return &FileEntry{zipPath: zipPath, content: content, size: int64(len(content))}, nil
+}
+
+// cleanZipPath normalizes a zip path and rejects any path that could be
+// extracted outside of the archive root (zip-slip)
+func cleanZipPath(zipPath string) (string, error) {
+	// Extractors on Windows treat backslashes as separators
+	zipPath = strings.ReplaceAll(zipPath, "\\", "/")
+	if strings.ContainsRune(zipPath, 0) {
+		return "", classify(ErrPathInvalid, "zip path must not contain NUL bytes")
+	}
+	if path.IsAbs(zipPath) || hasDriveLetter(zipPath) {
+		return "", classify(ErrPathInvalid, "zip path must be relative")
+	}
+
+	zipPath = path.Clean(zipPath)
+	if zipPath == "." || zipPath == ".." || strings.HasPrefix(zipPath, "../") {
+		return "", classify(ErrPathInvalid, "zip path escapes the archive root")
+	}
+	// Windows drops trailing dots and spaces, which turns "..." or ".. " into
+	// an empty name or a parent reference
+	for _, segment := range strings.Split(zipPath, "/") {
+		if strings.TrimRight(segment, ". ") == "" && strings.Contains(segment, ".") {
+			return "", classify(ErrPathInvalid, "zip path has a name of only dots")
+		}
+	}
+	return zipPath, nil
+}
+
+// hasDriveLetter reports whether a path starts with a Windows volume such as "C:"
+func hasDriveLetter(p string) bool {
+	if len(p) < 2 || p[1] != ':' {
+		return false
+	}
+	c := p[0] | 0x20
+	return c >= 'a' && c <= 'z'
 }
 
 func (f *FileEntry) Url() *url.URL {
@@ -53,3 +187,28 @@ func (f *FileEntry) Url() *url.URL {
 func (f *FileEntry) ZipPath() string {
 	return f.zipPath
 }
+
+// IsDir reports whether the entry is a directory
+func (f *FileEntry) IsDir() bool {
+	return strings.HasSuffix(f.zipPath, "/")
+}
+
+// Content returns the data of an inline entry, or nil for downloaded entries
+func (f *FileEntry) Content() []byte {
+	return f.content
+}
+
+// ModTime returns the entry's modification time, or the zero time if unknown
+func (f *FileEntry) ModTime() time.Time {
+	return f.modTime
+}
+
+// Size returns the expected size of the entry's data, or -1 if it is unknown
+func (f *FileEntry) Size() int64 {
+	return f.size
+}
+
+// Checksums returns the expected digests of the entry's data
+func (f *FileEntry) Checksums() Checksums {
+	return f.checksums
+}
@@ -0,0 +1,158 @@
+package zipstreamer
+
+import (
+	"errors"
+	"path"
+	"testing"
+	"time"
+)
+
+func TestCleanZipPath(t *testing.T) {
+	tests := []struct {
+		name    string
+		zipPath string
+		want    string // Empty when the path must be rejected
+	}{
+		{"plain file", "docs/report.pdf", "docs/report.pdf"},
+		{"redundant segments", "docs//./report.pdf", "docs/report.pdf"},
+		{"parent inside the root", "docs/old/../report.pdf", "docs/report.pdf"},
+		{"dots inside a name", "v1.2..3/notes..txt", "v1.2..3/notes..txt"},
+		{"hidden file", "docs/.env", "docs/.env"},
+		{"backslash separators", `docs\report.pdf`, "docs/report.pdf"},
+
+		{"parent", "..", ""},
+		{"leading parent", "../etc/passwd", ""},
+		{"parent after a folder", "docs/../../etc/passwd", ""},
+		{"parent at the end", "docs/../..", ""},
+		{"root only", ".", ""},
+		{"empty", "", ""},
+		{"absolute", "/etc/passwd", ""},
+		{"absolute after cleaning", "//etc/passwd", ""},
+		{"backslash traversal", `..\..\windows\system32`, ""},
+		{"mixed separator traversal", `docs\..\../etc`, ""},
+		{"backslash absolute", `\windows\system32`, ""},
+		{"drive letter", "C:/Windows/System32", ""},
+		{"relative drive letter", "c:secrets.txt", ""},
+		{"backslash drive letter", `D:\data`, ""},
+		{"UNC path", `\\server\share\file`, ""},
+		{"UNC path with slashes", "//server/share/file", ""},
+		{"NUL byte", "report.pdf\x00.exe", ""},
+		{"NUL in a folder", "docs\x00/report.pdf", ""},
+		{"only dots", "...", ""},
+		{"only dots in a folder", "docs/..../report.pdf", ""},
+		{"parent with a trailing space", "docs/.. /report.pdf", ""},
+		{"parent with a trailing dot and space", ".. ./etc", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := cleanZipPath(test.zipPath)
+			if test.want == "" {
+				if err == nil {
+					t.Fatalf("cleanZipPath(%q) = %q, want an error", test.zipPath, got)
+				}
+				if !errors.Is(err, ErrPathInvalid) {
+					t.Fatalf("cleanZipPath(%q) error %v is not ErrPathInvalid", test.zipPath, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("cleanZipPath(%q) failed: %v", test.zipPath, err)
+			}
+			if got != test.want {
+				t.Fatalf("cleanZipPath(%q) = %q, want %q", test.zipPath, got, test.want)
+			}
+		})
+	}
+}
+
+func TestCleanZipPathAfterJoin(t *testing.T) {
+	tests := []struct {
+		folder string
+		name   string
+		valid  bool
+	}{
+		{"docs", "report.pdf", true},
+		{"docs", "../report.pdf", true},
+		{"docs", "../../report.pdf", false},
+		{"docs/2024", "../../../etc/passwd", false},
+		{"..", "docs/report.pdf", false},
+		{"docs", `..\..\report.pdf`, false},
+		{"docs", "/etc/passwd", true}, // Joining makes it relative to the folder
+		{"docs", "...", false},
+	}
+	for _, test := range tests {
+		joined := path.Join(test.folder, test.name)
+		_, err := cleanZipPath(joined)
+		if valid := err == nil; valid != test.valid {
+			t.Errorf("cleanZipPath(path.Join(%q, %q)) = %q valid %v, want %v (err %v)",
+				test.folder, test.name, joined, valid, test.valid, err)
+		}
+	}
+}
+
+func TestNewFileEntryRejectsHostileNames(t *testing.T) {
+	for _, zipPath := range []string{
+		"../evil.sh",
+		"a/../../evil.sh",
+		`..\evil.sh`,
+		"/etc/cron.d/evil",
+		"C:/evil.exe",
+		`\\host\share\evil.exe`,
+		"evil\x00.txt",
+		"...",
+		"../",
+	} {
+		if entry, err := NewFileEntry("https://example.com/file", zipPath); err == nil {
+			t.Errorf("NewFileEntry accepted %q as %q", zipPath, entry.ZipPath())
+		}
+		if _, err := NewInlineFileEntry(zipPath, []byte("data")); err == nil {
+			t.Errorf("NewInlineFileEntry accepted %q", zipPath)
+		}
+	}
+}
+
+func TestPrefixZipPathsStaysInRoot(t *testing.T) {
+	file, err := NewFileEntry("https://example.com/file", "docs/report.pdf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	folder, err := NewDirectoryEntry("docs", time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		prefix string
+		want   []string // Nil when the prefix must be rejected
+	}{
+		{"", []string{"docs/report.pdf", "docs/"}},
+		{"backup", []string{"backup/docs/report.pdf", "backup/docs/"}},
+		{"/backup/", []string{"backup/docs/report.pdf", "backup/docs/"}},
+		{"a/../backup", []string{"backup/docs/report.pdf", "backup/docs/"}},
+		{"..", nil},
+		{"../outside", nil},
+		{"a/../../outside", nil},
+		{`..\outside`, nil},
+		{"C:", nil},
+		{"...", nil},
+	}
+	entries := []*FileEntry{file, folder}
+	for _, test := range tests {
+		prefixed, err := PrefixZipPaths(entries, test.prefix)
+		if test.want == nil {
+			if !errors.Is(err, ErrPathInvalid) {
+				t.Errorf("PrefixZipPaths(%q) error %v, want ErrPathInvalid", test.prefix, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("PrefixZipPaths(%q) failed: %v", test.prefix, err)
+			continue
+		}
+		for i, entry := range prefixed {
+			if entry.ZipPath() != test.want[i] {
+				t.Errorf("PrefixZipPaths(%q) moved %q to %q, want %q", test.prefix, entries[i].ZipPath(), entry.ZipPath(), test.want[i])
+			}
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package zipstreamer
+
+import (
+	"archive/zip"
+	"bufio"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"strings"
+	"time"
+)
+
+// gzipPeekSize bounds the gzip header, including file name and comment, that
+// is parsed before falling back to copying the data as is
+const gzipPeekSize = 64 * 1024
+
+const (
+	gzipFlagHeaderCRC = 1 << 1
+	gzipFlagExtra     = 1 << 2
+	gzipFlagName      = 1 << 3
+	gzipFlagComment   = 1 << 4
+)
+
+// IsGzipSource reports whether the entry is a .gz object stored under its
+// uncompressed name, whose deflate data is copied into the archive as is
+func (e *FileEntry) IsGzipSource() bool {
+	if e.url == nil || e.wrap != nil || e.raw != nil || e.nested {
+		return false
+	}
+	return strings.HasSuffix(strings.ToLower(e.url.Path), ".gz") &&
+		!strings.HasSuffix(strings.ToLower(e.zipPath), ".gz")
+}
+
+// gzipHeaderLength returns the length of the gzip member header at the start
+// of r without consuming it, or false when r does not start with one
+func gzipHeaderLength(r *bufio.Reader) (int, bool) {
+	fixed, err := r.Peek(10)
+	if err != nil || fixed[0] != 0x1f || fixed[1] != 0x8b || fixed[2] != 8 {
+		return 0, false
+	}
+	flags := fixed[3]
+	length := 10
+
+	if flags&gzipFlagExtra != 0 {
+		extra, err := r.Peek(length + 2)
+		if err != nil {
+			return 0, false
+		}
+		length += 2 + int(binary.LittleEndian.Uint16(extra[length:]))
+	}
+	for _, flag := range []byte{gzipFlagName, gzipFlagComment} {
+		if flags&flag == 0 {
+			continue
+		}
+		// Zero-terminated string
+		for {
+			if length >= gzipPeekSize {
+				return 0, false
+			}
+			b, err := r.Peek(length + 1)
+			if err != nil {
+				return 0, false
+			}
+			length++
+			if b[length-1] == 0 {
+				break
+			}
+		}
+	}
+	if flags&gzipFlagHeaderCRC != 0 {
+		length += 2
+	}
+	if _, err := r.Peek(length); err != nil {
+		return 0, false
+	}
+	return length, true
+}
+
+// deflateTee hands the deflate data to the inflater one byte at a time, so it
+// stops exactly at the end of the stream, and copies every byte to the archive
+type deflateTee struct {
+	r       *bufio.Reader
+	w       *bufio.Writer
+	written int64
+}
+
+func (t *deflateTee) Read(p []byte) (int, error) {
+	n, err := t.r.Read(p)
+	t.w.Write(p[:n])
+	t.written += int64(n)
+	return n, err
+}
+
+func (t *deflateTee) ReadByte() (byte, error) {
+	b, err := t.r.ReadByte()
+	if err == nil {
+		t.w.WriteByte(b)
+		t.written++
+	}
+	return b, err
+}
+
+// writeGzipMember copies the deflate data of the gzip member in source into a
+// raw archive entry. The data is inflated alongside only to learn the CRC-32
+// and size the zip headers need, which is far cheaper than recompressing it.
+// Errors writing the archive are returned as archiveErr, invalid or truncated
+// source data as dataErr.
+func writeGzipMember(zipWriter *archiveWriter, header *zip.FileHeader, source *bufio.Reader) (dataErr, archiveErr error) {
+	header.Method = zip.Deflate
+	header.Flags |= 0x8 // CRC-32 and sizes follow the data
+	setRawTimes(header)
+	raw, err := zipWriter.CreateRaw(header)
+	if err != nil {
+		return nil, err
+	}
+
+	out := bufio.NewWriterSize(raw, gzipPeekSize)
+	tee := &deflateTee{r: source, w: out}
+	checksum := crc32.NewIEEE()
+	inflated, inflateErr := io.Copy(checksum, flate.NewReader(tee))
+	if err := out.Flush(); err != nil {
+		return nil, err
+	}
+
+	header.CRC32 = checksum.Sum32()
+	header.CompressedSize64 = uint64(tee.written)
+	header.UncompressedSize64 = uint64(inflated)
+	header.CompressedSize = uint32(min(header.CompressedSize64, 0xffffffff))
+	header.UncompressedSize = uint32(min(header.UncompressedSize64, 0xffffffff))
+	if inflateErr != nil {
+		return fmt.Errorf("invalid gzip data: %v", inflateErr), nil
+	}
+
+	var trailer [8]byte
+	if _, err := io.ReadFull(source, trailer[:]); err != nil {
+		return fmt.Errorf("truncated gzip trailer: %v", err), nil
+	}
+	if binary.LittleEndian.Uint32(trailer[:4]) != header.CRC32 ||
+		binary.LittleEndian.Uint32(trailer[4:]) != uint32(inflated) {
+		return errors.New("gzip trailer does not match the data"), nil
+	}
+	if _, err := source.ReadByte(); err != io.EOF {
+		if err != nil {
+			return err, nil
+		}
+		return errors.New("multi-member gzip data is not supported"), nil
+	}
+	return nil, nil
+}
+
+// msDosTime converts a time to the MS-DOS date and time fields of a zip header
+func msDosTime(t time.Time) (uint16, uint16) {
+	t = t.UTC()
+	date := uint16(t.Day() + int(t.Month())<<5 + (t.Year()-1980)<<9)
+	clock := uint16(t.Second()/2 + t.Minute()<<5 + t.Hour()<<11)
+	return date, clock
+}
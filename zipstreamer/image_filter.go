@@ -0,0 +1,175 @@
+package zipstreamer
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"path"
+	"strings"
+)
+
+// Limits of ImageFilter when its own are not set
+const (
+	defaultImageBytes  = 64 << 20
+	defaultImagePixels = 100 << 20
+)
+
+// ImageFilter shrinks JPEG, PNG and GIF entries to fit MaxWidth x MaxHeight
+// while they stream into the archive, e.g. for web-sized copies. With a
+// Quality, JPEGs that fit are re-encoded when that makes them smaller. Images
+// that can't be decoded or exceed the limits are kept unchanged. The
+// whole image is held in memory, so the size of filtered entries is only
+// known once they are written.
+type ImageFilter struct {
+	MaxWidth  int   // 0 leaves the width unbounded
+	MaxHeight int   // 0 leaves the height unbounded
+	Quality   int   // JPEG quality from 1 to 100; 0 uses the encoder's default
+	MaxBytes  int64 // Larger images are kept unchanged; 0 uses 64MiB
+	MaxPixels int   // Larger images are kept unchanged, against decompression bombs; 0 uses 100M
+}
+
+// Middleware returns the filter as reader middleware for ZipStream.Use
+func (f *ImageFilter) Middleware() ReaderMiddleware {
+	return func(entry *FileEntry, r io.Reader) io.Reader {
+		if imageFormat(entry.ZipPath()) == "" {
+			return r
+		}
+		limit := f.MaxBytes
+		if limit <= 0 {
+			limit = defaultImageBytes
+		}
+		return &wholeReader{source: r, limit: limit, transform: func(data []byte) []byte {
+			return f.process(entry, data)
+		}}
+	}
+}
+
+// imageFormat returns the format of an image path by its extension, or ""
+func imageFormat(zipPath string) string {
+	switch strings.ToLower(path.Ext(zipPath)) {
+	case ".jpg", ".jpeg":
+		return "jpeg"
+	case ".png":
+		return "png"
+	case ".gif":
+		return "gif"
+	}
+	return ""
+}
+
+// process returns the resized image, or the original data when it is kept
+func (f *ImageFilter) process(entry *FileEntry, data []byte) []byte {
+	resized, err := f.resize(data, imageFormat(entry.ZipPath()))
+	if err != nil {
+		fmt.Printf("Keeping image %s unchanged: %v\n", entry.ZipPath(), err)
+		return data
+	}
+	if resized == nil {
+		return data
+	}
+	fmt.Printf("Resized image %s from %d to %d bytes\n", entry.ZipPath(), len(data), len(resized))
+	return resized
+}
+
+// resize decodes, scales and encodes an image, returning nil to keep it
+func (f *ImageFilter) resize(data []byte, format string) ([]byte, error) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	maxPixels := f.MaxPixels
+	if maxPixels <= 0 {
+		maxPixels = defaultImagePixels
+	}
+	if config.Width*config.Height > maxPixels {
+		return nil, fmt.Errorf("%dx%d pixels exceed the limit", config.Width, config.Height)
+	}
+	width, height := fitWithin(config.Width, config.Height, f.MaxWidth, f.MaxHeight)
+	fits := width == config.Width && height == config.Height
+	if fits && (format != "jpeg" || f.Quality <= 0) {
+		return nil, nil
+	}
+
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	var scaled image.Image = src
+	if !fits {
+		scaled = scaleDown(src, width, height)
+	}
+
+	var out bytes.Buffer
+	switch format {
+	case "jpeg":
+		options := &jpeg.Options{Quality: jpeg.DefaultQuality}
+		if f.Quality > 0 {
+			options.Quality = min(f.Quality, 100)
+		}
+		err = jpeg.Encode(&out, scaled, options)
+	case "png":
+		err = png.Encode(&out, scaled)
+	case "gif":
+		err = gif.Encode(&out, scaled, nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if fits && out.Len() >= len(data) {
+		return nil, nil
+	}
+	return out.Bytes(), nil
+}
+
+// fitWithin returns the largest size with the aspect ratio of width x height
+// that fits the bounds, never enlarging. Zero bounds are unbounded.
+func fitWithin(width, height, maxWidth, maxHeight int) (int, int) {
+	scaledWidth, scaledHeight := width, height
+	if maxWidth > 0 && scaledWidth > maxWidth {
+		scaledHeight = max(1, scaledHeight*maxWidth/scaledWidth)
+		scaledWidth = maxWidth
+	}
+	if maxHeight > 0 && scaledHeight > maxHeight {
+		scaledWidth = max(1, scaledWidth*maxHeight/scaledHeight)
+		scaledHeight = maxHeight
+	}
+	return scaledWidth, scaledHeight
+}
+
+// scaleDown shrinks an image by averaging the source pixels covered by each
+// target pixel, which avoids the aliasing of nearest-neighbour sampling
+func scaleDown(src image.Image, width, height int) *image.RGBA {
+	bounds := src.Bounds()
+	rgba := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(rgba, rgba.Bounds(), src, bounds.Min, draw.Src)
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	srcWidth, srcHeight := rgba.Bounds().Dx(), rgba.Bounds().Dy()
+	for y := 0; y < height; y++ {
+		y0, y1 := y*srcHeight/height, max((y+1)*srcHeight/height, y*srcHeight/height+1)
+		for x := 0; x < width; x++ {
+			x0, x1 := x*srcWidth/width, max((x+1)*srcWidth/width, x*srcWidth/width+1)
+			var sum [4]int
+			for sy := y0; sy < y1; sy++ {
+				row := rgba.Pix[sy*rgba.Stride+x0*4 : sy*rgba.Stride+x1*4]
+				for i := 0; i < len(row); i += 4 {
+					sum[0] += int(row[i])
+					sum[1] += int(row[i+1])
+					sum[2] += int(row[i+2])
+					sum[3] += int(row[i+3])
+				}
+			}
+			count := (y1 - y0) * (x1 - x0)
+			offset := y*dst.Stride + x*4
+			for i := range sum {
+				dst.Pix[offset+i] = uint8(sum[i] / count)
+			}
+		}
+	}
+	return dst
+}
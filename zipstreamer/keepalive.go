@@ -0,0 +1,103 @@
+package zipstreamer
+
+import (
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	keepaliveReserve = 64 * 1024 // Archive bytes held back for keepalive writes
+	keepaliveChunk   = 512       // Bytes released per idle interval
+)
+
+// keepaliveWriter holds back the most recent archive bytes and releases them
+// in small chunks while the stream is idle (e.g. waiting on a slow upstream),
+// so reverse proxies and browsers don't drop the connection
+type keepaliveWriter struct {
+	mu        sync.Mutex
+	dst       io.Writer
+	reserve   []byte
+	lastWrite time.Time
+	err       error
+	done      chan struct{}
+	stop      sync.Once
+}
+
+func newKeepaliveWriter(dst io.Writer, interval time.Duration) *keepaliveWriter {
+	k := &keepaliveWriter{dst: dst, lastWrite: time.Now(), done: make(chan struct{})}
+	go k.run(interval)
+	return k
+}
+
+func (k *keepaliveWriter) Write(p []byte) (int, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if k.err != nil {
+		return 0, k.err
+	}
+	k.reserve = append(k.reserve, p...)
+	if excess := len(k.reserve) - keepaliveReserve; excess > 0 {
+		k.release(excess)
+	}
+	return len(p), k.err
+}
+
+// Flush passes flushes through without releasing the reserve
+func (k *keepaliveWriter) Flush() {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.flushDestination()
+}
+
+// run releases a chunk of the reserve whenever nothing was written for an interval
+func (k *keepaliveWriter) run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-k.done:
+			return
+		case <-ticker.C:
+			k.mu.Lock()
+			if time.Since(k.lastWrite) >= interval && len(k.reserve) > 0 && k.err == nil {
+				k.release(min(keepaliveChunk, len(k.reserve)))
+				k.flushDestination()
+			}
+			k.mu.Unlock()
+		}
+	}
+}
+
+// finish stops the keepalive loop and writes out everything still held back.
+// It is safe to call more than once.
+func (k *keepaliveWriter) finish() error {
+	k.stop.Do(func() { close(k.done) })
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.err == nil && len(k.reserve) > 0 {
+		k.release(len(k.reserve))
+	}
+	k.flushDestination()
+	return k.err
+}
+
+// release writes the first n reserved bytes; the caller holds the lock
+func (k *keepaliveWriter) release(n int) {
+	written, err := k.dst.Write(k.reserve[:n])
+	k.reserve = append(k.reserve[:0], k.reserve[written:]...)
+	k.lastWrite = time.Now()
+	if err != nil {
+		k.err = err
+	}
+}
+
+func (k *keepaliveWriter) flushDestination() {
+	if flusher, ok := k.dst.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
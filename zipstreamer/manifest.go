@@ -0,0 +1,94 @@
+package zipstreamer
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// ManifestEntry describes a file of the archive in its manifest
+type ManifestEntry struct {
+	Path    string    `json:"path"`
+	URL     string    `json:"url,omitempty"` // Empty for data generated by the server
+	Size    int64     `json:"size"`
+	SHA256  string    `json:"sha256,omitempty"`
+	CRC32   string    `json:"crc32,omitempty"` // For copied compressed data, which is not hashed
+	Fetched time.Time `json:"fetched"`
+}
+
+// ManifestFailure describes a file that could not be added to the archive
+type ManifestFailure struct {
+	Path  string `json:"path"`
+	URL   string `json:"url,omitempty"`
+	Error string `json:"error"`
+}
+
+// archiveManifest is the document written as the manifest entry
+type archiveManifest struct {
+	Generated time.Time         `json:"generated"`
+	Files     []ManifestEntry   `json:"files"`
+	Failed    []ManifestFailure `json:"failed,omitempty"`
+}
+
+// hashing reports whether entries are hashed, for EntryHashes or the manifest
+func (z *ZipStream) hashing() bool {
+	return z.HashEntries || z.Manifest != ""
+}
+
+// noteWritten records a file added to the archive for the manifest. Hashed
+// entries have just appended their hash.
+func (z *ZipStream) noteWritten(entry *FileEntry) {
+	if z.Manifest == "" || entry.IsDir() {
+		return
+	}
+	record := ManifestEntry{Path: entry.ZipPath(), Size: entry.Size(), Fetched: z.now()}
+	if entry.Url() != nil {
+		record.URL = entry.Url().String()
+	}
+	if entry.raw != nil {
+		record.Size = entry.raw.UncompressedSize
+		record.CRC32 = fmt.Sprintf("%08x", entry.raw.CRC32)
+	} else if n := len(z.hashes); n > 0 && z.hashes[n-1].ZipPath == entry.ZipPath() {
+		record.Size = z.hashes[n-1].Size
+		record.SHA256 = z.hashes[n-1].SHA256
+	}
+	z.manifest = append(z.manifest, record)
+}
+
+// writeManifest adds the manifest describing every file and failure to the archive
+func (z *ZipStream) writeManifest(zipWriter *archiveWriter) error {
+	if z.Manifest == "" {
+		return nil
+	}
+
+	manifest := archiveManifest{Generated: z.now(), Files: z.manifest}
+	if manifest.Files == nil {
+		manifest.Files = []ManifestEntry{}
+	}
+	urls := make(map[string]string, len(z.failed))
+	for _, entry := range z.entries {
+		if entry.Url() != nil {
+			urls[entry.ZipPath()] = entry.Url().String()
+		}
+	}
+	for _, failure := range z.failed {
+		manifest.Failed = append(manifest.Failed, ManifestFailure{Path: failure.ZipPath, URL: urls[failure.ZipPath], Error: failure.Err.Error()})
+	}
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	header := &zip.FileHeader{
+		Name:     z.Manifest,
+		Method:   z.CompressionPolicy.method(z.Manifest, z.CompressionMethod),
+		Modified: z.now(),
+	}
+	entryWriter, err := zipWriter.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	_, err = entryWriter.Write(data)
+	return err
+}
@@ -0,0 +1,117 @@
+package zipstreamer
+
+import (
+	"archive/zip"
+	"context"
+	"sync"
+)
+
+// flateWriterMemory approximates the state of one Deflate compressor
+const flateWriterMemory = 1024 * 1024
+
+// MemoryBudget bounds the memory used for stream buffers across all archives.
+// Every stream reserves what its buffers need before it starts, waiting while
+// the budget is exhausted, and optional buffers such as prefetched entries
+// fall back to disk instead of exceeding it. All methods are safe on a nil
+// *MemoryBudget, which is unlimited.
+type MemoryBudget struct {
+	mu      sync.Mutex
+	limit   int64
+	used    int64
+	changed chan struct{} // Closed and replaced whenever memory is released
+}
+
+// NewMemoryBudget creates a budget of limit bytes
+func NewMemoryBudget(limit int64) *MemoryBudget {
+	return &MemoryBudget{limit: limit, changed: make(chan struct{})}
+}
+
+// Acquire reserves n bytes, waiting until they are available. A reservation
+// larger than the whole budget is granted once nothing else is reserved.
+func (b *MemoryBudget) Acquire(ctx context.Context, n int64) error {
+	if b == nil {
+		return nil
+	}
+	for {
+		b.mu.Lock()
+		if b.used+n <= b.limit || b.used == 0 {
+			b.used += n
+			b.mu.Unlock()
+			return nil
+		}
+		changed := b.changed
+		b.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// TryAcquire reserves n bytes if they are available right away
+func (b *MemoryBudget) TryAcquire(n int64) bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.used+n > b.limit {
+		return false
+	}
+	b.used += n
+	return true
+}
+
+// Release returns n bytes to the budget
+func (b *MemoryBudget) Release(n int64) {
+	if b == nil || n == 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.used -= n
+	close(b.changed)
+	b.changed = make(chan struct{})
+}
+
+// InUse returns the bytes currently reserved
+func (b *MemoryBudget) InUse() int64 {
+	if b == nil {
+		return 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.used
+}
+
+// Limit returns the size of the budget
+func (b *MemoryBudget) Limit() int64 {
+	if b == nil {
+		return 0
+	}
+	return b.limit
+}
+
+// streamReservation estimates the buffers a stream holds at once: a copy
+// buffer and compressor for the stream and for every compression worker, the
+// keepalive reserve and the gzip passthrough buffers
+func (z *ZipStream) streamReservation() int64 {
+	copiers := int64(1)
+	if z.Parallelism > 1 && z.CompressionMethod != zip.Store {
+		copiers += int64(z.Parallelism)
+	}
+
+	perCopier := int64(copyBufferSize())
+	if z.CompressionMethod != zip.Store {
+		perCopier += flateWriterMemory
+	}
+	reservation := copiers*perCopier + 2*gzipPeekSize
+	if z.KeepaliveInterval > 0 {
+		reservation += keepaliveReserve
+	}
+	return reservation
+}
@@ -0,0 +1,130 @@
+package zipstreamer
+
+import (
+	"bytes"
+	"container/list"
+	"io"
+	"sync"
+	"time"
+)
+
+// MemoryCache keeps recently streamed small entries in memory so bursts of
+// similar archive requests don't fetch the same tiny files again. Entries are
+// served without contacting upstream until they expire after ttl, and the least
+// recently used are evicted once maxTotalSize bytes are stored. All methods are
+// safe on a nil *MemoryCache, which caches nothing.
+type MemoryCache struct {
+	mu           sync.Mutex
+	maxEntrySize int64
+	maxTotalSize int64
+	ttl          time.Duration
+	totalSize    int64
+	entries      map[string]*list.Element
+	recent       *list.List // Most recently used at the front
+}
+
+type memoryEntry struct {
+	url     string
+	data    []byte
+	expires time.Time
+}
+
+// NewMemoryCache creates a cache holding entries of up to maxEntrySize bytes
+// for ttl, evicting the least recently used once maxTotalSize bytes are stored
+func NewMemoryCache(maxEntrySize, maxTotalSize int64, ttl time.Duration) *MemoryCache {
+	return &MemoryCache{
+		maxEntrySize: maxEntrySize,
+		maxTotalSize: maxTotalSize,
+		ttl:          ttl,
+		entries:      make(map[string]*list.Element),
+		recent:       list.New(),
+	}
+}
+
+// open returns the cached body of url if it has not expired
+func (c *MemoryCache) open(url string) (io.ReadCloser, bool) {
+	if c == nil {
+		return nil, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.entries[url]
+	if !ok {
+		return nil, false
+	}
+	cached := element.Value.(*memoryEntry)
+	if c.ttl > 0 && time.Now().After(cached.expires) {
+		c.remove(element)
+		return nil, false
+	}
+	c.recent.MoveToFront(element)
+	return io.NopCloser(bytes.NewReader(cached.data)), true
+}
+
+// capture returns a writer recording the body of url, or nil when it is cached
+func (c *MemoryCache) capture(url string) *memoryCapture {
+	if c == nil {
+		return nil
+	}
+
+	c.mu.Lock()
+	_, cached := c.entries[url]
+	c.mu.Unlock()
+	if cached {
+		return nil
+	}
+	return &memoryCapture{cache: c, url: url}
+}
+
+func (c *MemoryCache) store(url string, data []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element, ok := c.entries[url]; ok {
+		c.remove(element)
+	}
+	cached := &memoryEntry{url: url, data: data, expires: time.Now().Add(c.ttl)}
+	c.entries[url] = c.recent.PushFront(cached)
+	c.totalSize += int64(len(data))
+
+	for c.totalSize > c.maxTotalSize {
+		c.remove(c.recent.Back())
+	}
+}
+
+// remove drops an element; the caller holds the lock
+func (c *MemoryCache) remove(element *list.Element) {
+	cached := c.recent.Remove(element).(*memoryEntry)
+	delete(c.entries, cached.url)
+	c.totalSize -= int64(len(cached.data))
+}
+
+// memoryCapture records an entry's raw body while it is streamed, giving up
+// once it grows past the cache's entry size limit
+type memoryCapture struct {
+	cache    *MemoryCache
+	url      string
+	data     bytes.Buffer
+	tooLarge bool
+}
+
+func (m *memoryCapture) Write(p []byte) (int, error) {
+	if !m.tooLarge {
+		if int64(m.data.Len()+len(p)) > m.cache.maxEntrySize {
+			m.tooLarge = true
+			m.data = bytes.Buffer{}
+		} else {
+			m.data.Write(p)
+		}
+	}
+	return len(p), nil
+}
+
+// commit stores the captured body once the entry was streamed successfully
+func (m *memoryCapture) commit() {
+	if !m.tooLarge {
+		m.cache.store(m.url, m.data.Bytes())
+	}
+}
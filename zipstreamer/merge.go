@@ -0,0 +1,186 @@
+package zipstreamer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// DuplicatePolicy decides what happens to a file whose zip path is already
+// used by another descriptor of a merge
+type DuplicatePolicy int
+
+const (
+	DuplicatesRename    DuplicatePolicy = iota // Later files become "name (1).ext", like RenameDuplicateZipPaths
+	DuplicatesKeepFirst                        // Later files are dropped
+	DuplicatesKeepLast                         // Earlier files are dropped
+	DuplicatesReject                           // The merge fails
+)
+
+// ParseDuplicatePolicy reads "rename", "first", "last" or "reject"; empty is rename
+func ParseDuplicatePolicy(policy string) (DuplicatePolicy, error) {
+	switch strings.ToLower(policy) {
+	case "", "rename":
+		return DuplicatesRename, nil
+	case "first":
+		return DuplicatesKeepFirst, nil
+	case "last":
+		return DuplicatesKeepLast, nil
+	case "reject":
+		return DuplicatesReject, nil
+	}
+	return 0, fmt.Errorf("unknown duplicates policy %q", policy)
+}
+
+// DescriptorPart is a descriptor to merge, placed under Prefix in the archive
+type DescriptorPart struct {
+	Descriptor *ZipDescriptor
+	Prefix     string
+}
+
+// MergeZipDescriptors combines several descriptors into one. Every entry is
+// moved under its part's prefix, and provider sources keep the credentials of
+// their own descriptor, so selections from different accounts of the same
+// provider can be merged. Collisions between files are resolved with
+// duplicates; sources are only listed when the archive is built, so their
+// files are not covered. The first non-empty filename and compression win.
+func MergeZipDescriptors(parts []DescriptorPart, duplicates DuplicatePolicy) (*ZipDescriptor, error) {
+	merged := NewZipDescriptor()
+	for _, part := range parts {
+		prefix := strings.Trim(part.Prefix, "/")
+		descriptor := part.Descriptor
+		files, err := PrefixZipPaths(descriptor.files, prefix)
+		if err != nil {
+			return nil, err
+		}
+		remoteZips, err := PrefixZipPaths(descriptor.remoteZips, prefix)
+		if err != nil {
+			return nil, err
+		}
+		if merged.suggestedFilenameRaw == "" {
+			merged.suggestedFilenameRaw = descriptor.suggestedFilenameRaw
+		}
+		if merged.compression == "" {
+			merged.compression = descriptor.compression
+		}
+
+		merged.files = append(merged.files, files...)
+		merged.remoteZips = append(merged.remoteZips, remoteZips...)
+		for _, ref := range descriptor.sources {
+			if ref.Credential == "" {
+				ref.Credential = descriptor.credentials[ref.Provider]
+			}
+			ref.Prefix = path.Join(prefix, ref.Prefix)
+			merged.sources = append(merged.sources, ref)
+		}
+		// Sources carry their credentials, the map only serves Credential lookups
+		for provider, credential := range descriptor.credentials {
+			if _, ok := merged.credentials[provider]; !ok {
+				merged.credentials[provider] = credential
+			}
+		}
+	}
+
+	files, err := resolveDuplicates(merged.files, duplicates)
+	if err != nil {
+		return nil, err
+	}
+	merged.files = files
+	return merged, nil
+}
+
+// resolveDuplicates applies a duplicate policy to the files of a merge
+func resolveDuplicates(files []*FileEntry, duplicates DuplicatePolicy) ([]*FileEntry, error) {
+	switch duplicates {
+	case DuplicatesRename:
+		return RenameDuplicateZipPaths(files), nil
+	case DuplicatesReject:
+		if taken := DuplicateZipPaths(files); len(taken) > 0 {
+			return nil, fmt.Errorf("duplicate zip paths: %s", strings.Join(taken, ", "))
+		}
+		return files, nil
+	}
+
+	// The file that is kept takes the position of the first one
+	chosen := make(map[string]*FileEntry, len(files))
+	for _, file := range files {
+		if file.IsDir() {
+			continue
+		}
+		if _, ok := chosen[file.zipPath]; !ok || duplicates == DuplicatesKeepLast {
+			chosen[file.zipPath] = file
+		}
+	}
+	result := make([]*FileEntry, 0, len(chosen))
+	for _, file := range files {
+		if file.IsDir() {
+			result = append(result, file)
+			continue
+		}
+		if kept, ok := chosen[file.zipPath]; ok {
+			result = append(result, kept)
+			delete(chosen, file.zipPath)
+		}
+	}
+	return result, nil
+}
+
+// PrefixZipPaths returns the entries moved into the folder prefix, keeping
+// the order and length of entries. A prefix escaping the archive root is
+// rejected with ErrPathInvalid.
+func PrefixZipPaths(entries []*FileEntry, prefix string) ([]*FileEntry, error) {
+	trimmed := strings.Trim(prefix, "/")
+	if trimmed == "" {
+		return entries, nil
+	}
+	folder, err := cleanZipPath(trimmed)
+	if err != nil {
+		return nil, fmt.Errorf("invalid prefix %q: %w", prefix, err)
+	}
+	return mapZipPaths(entries, func(zipPath string) string {
+		return folder + "/" + zipPath
+	}), nil
+}
+
+// jsonMergedPart is a descriptor of a merge along with its prefix
+type jsonMergedPart struct {
+	Prefix string `json:"prefix"`
+}
+
+// unmarshalMergedDescriptor merges the descriptors of a payload listing them
+// under "descriptors"
+func unmarshalMergedDescriptor(parsed jsonZipPayload) (*ZipDescriptor, error) {
+	if len(parsed.Files) > 0 {
+		return nil, errors.New("files and descriptors can't be combined, add the files as another descriptor")
+	}
+	duplicates, err := ParseDuplicatePolicy(parsed.Duplicates)
+	if err != nil {
+		return nil, err
+	}
+	parts := make([]DescriptorPart, 0, len(parsed.Descriptors))
+	for i, raw := range parsed.Descriptors {
+		var part jsonMergedPart
+		if err := json.Unmarshal(raw, &part); err != nil {
+			return nil, fmt.Errorf("descriptor %d: %v", i+1, err)
+		}
+		descriptor, err := UnmarshalJsonZipDescriptor(raw)
+		if err != nil {
+			return nil, fmt.Errorf("descriptor %d: %v", i+1, err)
+		}
+		parts = append(parts, DescriptorPart{Descriptor: descriptor, Prefix: part.Prefix})
+	}
+
+	merged, err := MergeZipDescriptors(parts, duplicates)
+	if err != nil {
+		return nil, err
+	}
+	if parsed.SuggestedFilename != "" {
+		merged.suggestedFilenameRaw = parsed.SuggestedFilename
+	}
+	if parsed.Compression != "" {
+		merged.compression = parsed.Compression
+	}
+	return merged, nil
+}
@@ -0,0 +1,55 @@
+package zipstreamer
+
+import (
+	"bytes"
+	"io"
+)
+
+// ReaderMiddleware wraps the data of an entry on its way into the archive,
+// e.g. to rate limit, hash, scan or transform it. It sees the data after the
+// entry's own wrapper and checksum verification. Reading from the returned
+// reader must eventually return the error of r, such as io.EOF.
+type ReaderMiddleware func(entry *FileEntry, r io.Reader) io.Reader
+
+// Use adds middleware around the data of every downloaded entry. The first
+// middleware added is the closest to the source. Raw entries and gzip sources
+// are copied compressed, so they bypass the middleware.
+func (z *ZipStream) Use(middleware ...ReaderMiddleware) {
+	z.middleware = append(z.middleware, middleware...)
+}
+
+// applyMiddleware wraps an entry's data in the registered middleware
+func (z *ZipStream) applyMiddleware(entry *FileEntry, r io.Reader) io.Reader {
+	if entry.raw != nil || entry.IsGzipSource() {
+		return r
+	}
+	for _, middleware := range z.middleware {
+		r = middleware(entry, r)
+	}
+	return r
+}
+
+// wholeReader serves middleware that needs all of an entry's data at once. It
+// reads up to limit bytes on the first read and serves the result of
+// transform, or the data unchanged when there is more.
+type wholeReader struct {
+	source    io.Reader
+	limit     int64
+	transform func(data []byte) []byte
+	out       io.Reader
+}
+
+func (r *wholeReader) Read(p []byte) (int, error) {
+	if r.out == nil {
+		data, err := io.ReadAll(io.LimitReader(r.source, r.limit+1))
+		if err != nil {
+			return 0, err
+		}
+		if int64(len(data)) > r.limit {
+			r.out = io.MultiReader(bytes.NewReader(data), r.source)
+		} else {
+			r.out = bytes.NewReader(r.transform(data))
+		}
+	}
+	return r.out.Read(p)
+}
@@ -0,0 +1,66 @@
+package zipstreamer
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// NewNestedZipEntry creates an entry for a remote zip that is kept whole
+// inside the archive. It is always stored, whatever the archive's compression,
+// so its known size is also its size in the archive. With a CRC-32 as well it
+// is copied like a raw entry and needs no data descriptor.
+func NewNestedZipEntry(urlString string, zipPath string, size int64, checksums Checksums) (*FileEntry, error) {
+	if size >= 0 && checksums.CRC32 != "" {
+		crc, err := strconv.ParseUint(strings.TrimSpace(checksums.CRC32), 16, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid crc32: %v", err)
+		}
+		entry, err := NewRawFileEntry(urlString, zipPath, RawData{
+			Method:           zip.Store,
+			CRC32:            uint32(crc),
+			CompressedSize:   size,
+			UncompressedSize: size,
+		})
+		if err != nil {
+			return nil, err
+		}
+		entry.nested = true
+		return entry, nil
+	}
+
+	entry, err := NewFileEntry(urlString, zipPath, WithSize(size), WithChecksums(checksums))
+	if err != nil {
+		return nil, err
+	}
+	if entry.IsDir() {
+		return nil, errors.New("nested zips can't be directories")
+	}
+	entry.nested = true
+	return entry, nil
+}
+
+// Nested reports whether the entry is a zip stored as is inside the archive
+func (e *FileEntry) Nested() bool {
+	return e.nested
+}
+
+// entryMethod returns the compression method of an entry. Nested zips are
+// stored so their bytes end up in the archive unchanged.
+func (z *ZipStream) entryMethod(entry *FileEntry) uint16 {
+	if entry.nested {
+		return zip.Store
+	}
+	return z.CompressionPolicy.method(entry.ZipPath(), z.CompressionMethod)
+}
+
+// preferIdentity asks upstream for the zip itself rather than a content
+// encoding of it, which would otherwise be passed through as deflate data
+func (e *FileEntry) preferIdentity(req *http.Request) {
+	if e.nested {
+		req.Header.Set("Accept-Encoding", "identity")
+	}
+}
@@ -0,0 +1,297 @@
+package zipstreamer
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"hash/crc32"
+	"io"
+	"os"
+	"sync"
+)
+
+// spillThreshold is how much compressed data is kept in memory before a
+// prepared entry is moved to a temporary file
+const spillThreshold = 1024 * 1024
+
+// errSkipped marks an entry the pipeline gave up on before compressing it
+var errSkipped = errors.New("compression pipeline stopped")
+
+// compressedEntry is an entry compressed ahead of time, ready to be copied into
+// the archive in order
+type compressedEntry struct {
+	data         *spillBuffer
+	crc32        uint32
+	size         int64
+	hash         *EntryHash
+	err          error // Upstream failure, reported when the entry's turn comes
+	streamDirect bool  // The entry can't be prepared and is streamed as usual
+}
+
+// compressionPipeline fetches and compresses the upcoming entries on several
+// goroutines while the archive is written in order
+type compressionPipeline struct {
+	cancel  context.CancelFunc
+	results map[int]chan *compressedEntry
+	window  chan struct{} // Limits the entries prepared but not yet written
+	taken   map[int]bool
+	wg      sync.WaitGroup
+}
+
+// startCompression prepares every entry that would be deflated, using up to
+// Parallelism goroutines
+func (z *ZipStream) startCompression() *compressionPipeline {
+	ctx, cancel := context.WithCancel(z.context())
+	p := &compressionPipeline{
+		cancel:  cancel,
+		results: make(map[int]chan *compressedEntry),
+		window:  make(chan struct{}, 2*z.Parallelism),
+		taken:   make(map[int]bool),
+	}
+
+	var queue []int
+	for i := z.first; i < len(z.entries); i++ {
+		if z.prepareable(z.entries[i]) {
+			queue = append(queue, i)
+			p.results[i] = make(chan *compressedEntry, 1)
+		}
+	}
+
+	workers := make(chan struct{}, z.Parallelism)
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		for n, i := range queue {
+			select {
+			case p.window <- struct{}{}:
+			case <-ctx.Done():
+				// Settle the entries that were never started
+				for _, rest := range queue[n:] {
+					p.results[rest] <- &compressedEntry{err: errSkipped}
+				}
+				return
+			}
+
+			workers <- struct{}{}
+			p.wg.Add(1)
+			go func(i int) {
+				defer p.wg.Done()
+				p.results[i] <- z.compressEntry(ctx, z.entries[i])
+				<-workers
+			}(i)
+		}
+	}()
+	return p
+}
+
+// prepareable reports whether an entry can be compressed ahead of time. Entries
+// sharing their content with others are left to the duplicate handling.
+func (z *ZipStream) prepareable(entry *FileEntry) bool {
+	if entry.Url() == nil || entry.IsDir() || entry.raw != nil || entry.IsGzipSource() {
+		return false
+	}
+	if key := entry.contentKey(); key != "" && z.contents.remaining[key] > 1 {
+		return false
+	}
+	return z.entryMethod(entry) == zip.Deflate
+}
+
+// take waits for the prepared entry at index i, or returns nil when the entry
+// is not handled by the pipeline
+func (p *compressionPipeline) take(i int) *compressedEntry {
+	if p == nil {
+		return nil
+	}
+	results, ok := p.results[i]
+	if !ok {
+		return nil
+	}
+	result := <-results
+	p.taken[i] = true
+	if result.err != errSkipped {
+		<-p.window
+	}
+	return result
+}
+
+// stop cancels the pending work and removes what was prepared but not written
+func (p *compressionPipeline) stop() {
+	if p == nil {
+		return
+	}
+	p.cancel()
+	for i, results := range p.results {
+		if p.taken[i] {
+			continue
+		}
+		result := <-results
+		if result.err != errSkipped {
+			<-p.window
+		}
+		result.cleanup()
+	}
+	p.wg.Wait()
+}
+
+// compressEntry fetches and deflates an entry into a buffer
+func (z *ZipStream) compressEntry(parent context.Context, entry *FileEntry) *compressedEntry {
+	ctx, cancel, release := z.entryContext(parent)
+	defer release()
+
+	source, err := z.openEntrySource(ctx, cancel, entry)
+	if err != nil {
+		return &compressedEntry{err: fetchError(ctx, err)}
+	}
+	defer source.close()
+	if source.gzipEncoded {
+		return &compressedEntry{streamDirect: true}
+	}
+
+	level := z.CompressionLevel
+	if level == 0 {
+		level = flate.DefaultCompression
+	}
+	data := &spillBuffer{budget: z.Memory}
+	compressor, err := flate.NewWriter(data, level)
+	if err != nil {
+		return &compressedEntry{err: err}
+	}
+	checksum := crc32.NewIEEE()
+	outputs := []io.Writer{compressor, checksum}
+	var sha hash.Hash
+	if z.hashing() {
+		sha = sha256.New()
+		outputs = append(outputs, sha)
+	}
+
+	size, err := pooledCopy(io.MultiWriter(outputs...), source.body)
+	if err == nil {
+		err = compressor.Close()
+	}
+	result := &compressedEntry{data: data, crc32: checksum.Sum32(), size: size}
+	switch {
+	case source.verifier.err != nil:
+		result.err = fetchError(ctx, source.verifier.err)
+	case err != nil:
+		result.err = err
+	case source.checksums != nil:
+		result.err = source.checksums.verify()
+	}
+	if result.err != nil {
+		result.cleanup()
+		return &compressedEntry{err: result.err}
+	}
+
+	if sha != nil {
+		result.hash = &EntryHash{ZipPath: entry.ZipPath(), Size: size, SHA256: hex.EncodeToString(sha.Sum(nil))}
+	}
+	source.commit()
+	return result
+}
+
+// writePrepared copies a prepared entry into the archive, or records why it
+// could not be prepared
+func (z *ZipStream) writePrepared(zipWriter *archiveWriter, entry *FileEntry, prepared *compressedEntry) (bool, error) {
+	defer prepared.cleanup()
+	if prepared.err != nil {
+		return false, z.failEntry(zipWriter, entry, prepared.err)
+	}
+
+	header := entry.fileHeader(zip.Deflate)
+	header.CRC32 = prepared.crc32
+	header.CompressedSize64 = uint64(prepared.data.size)
+	header.UncompressedSize64 = uint64(prepared.size)
+	setRawTimes(header)
+	entryWriter, err := zipWriter.CreateRaw(header)
+	if err != nil {
+		return false, err
+	}
+	data, err := prepared.data.reader()
+	if err != nil {
+		return false, err
+	}
+	if _, err := pooledCopy(entryWriter, data); err != nil {
+		return false, err
+	}
+	if prepared.hash != nil {
+		z.hashes = append(z.hashes, *prepared.hash)
+	}
+	z.entrySize = prepared.size
+	return true, nil
+}
+
+func (c *compressedEntry) cleanup() {
+	if c != nil && c.data != nil {
+		c.data.cleanup()
+	}
+}
+
+// spillBuffer keeps data in memory up to spillThreshold, or while the memory
+// budget allows, and moves it to a temporary file beyond that
+type spillBuffer struct {
+	memory   bytes.Buffer
+	file     *os.File
+	size     int64
+	budget   *MemoryBudget
+	reserved int64
+}
+
+func (b *spillBuffer) Write(p []byte) (int, error) {
+	if b.file == nil {
+		inMemory := b.memory.Len()+len(p) <= spillThreshold
+		if inMemory && b.budget.TryAcquire(int64(len(p))) {
+			b.reserved += int64(len(p))
+		} else if err := b.spill(); err != nil {
+			return 0, err
+		}
+	}
+
+	var n int
+	var err error
+	if b.file != nil {
+		n, err = b.file.Write(p)
+	} else {
+		n, err = b.memory.Write(p)
+	}
+	b.size += int64(n)
+	return n, err
+}
+
+// spill moves the buffered data to a temporary file and frees its memory
+func (b *spillBuffer) spill() error {
+	file, err := os.CreateTemp("", "zs-deflate-*")
+	if err != nil {
+		return err
+	}
+	b.file = file
+	_, err = b.memory.WriteTo(file)
+	b.memory = bytes.Buffer{}
+	b.budget.Release(b.reserved)
+	b.reserved = 0
+	return err
+}
+
+// reader returns the buffered data from the start
+func (b *spillBuffer) reader() (io.Reader, error) {
+	if b.file == nil {
+		return &b.memory, nil
+	}
+	if _, err := b.file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return b.file, nil
+}
+
+func (b *spillBuffer) cleanup() {
+	b.budget.Release(b.reserved)
+	b.reserved = 0
+	if b.file != nil {
+		b.file.Close()
+		os.Remove(b.file.Name())
+	}
+}
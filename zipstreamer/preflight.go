@@ -0,0 +1,87 @@
+package zipstreamer
+
+import (
+	"net/http"
+	"sync"
+)
+
+const preflightConcurrency = 8
+
+// Preflight checks that every remote entry is reachable before streaming
+// starts, while the HTTP status can still be changed. Each URL is probed with
+// HEAD, falling back to a one-byte ranged GET for servers that reject HEAD.
+func Preflight(client *http.Client, entries []*FileEntry) []FailedEntry {
+	if client == nil {
+		client = DefaultClient()
+	}
+
+	var (
+		mu     sync.Mutex
+		failed []FailedEntry
+		wg     sync.WaitGroup
+	)
+	slots := make(chan struct{}, preflightConcurrency)
+
+	for _, entry := range entries {
+		if entry.Url() == nil {
+			continue
+		}
+
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(entry *FileEntry) {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			if err := probe(client, entry); err != nil {
+				mu.Lock()
+				failed = append(failed, FailedEntry{ZipPath: entry.ZipPath(), Err: err})
+				mu.Unlock()
+			}
+		}(entry)
+	}
+	wg.Wait()
+
+	return failed
+}
+
+// probe reports whether an entry's URL can be fetched
+func probe(client *http.Client, entry *FileEntry) error {
+	url := entry.Url().String()
+	req, err := http.NewRequest("HEAD", url, nil)
+	if err != nil {
+		return err
+	}
+	if err := entry.prepareRequest(req); err != nil {
+		return err
+	}
+	if entry.client != nil {
+		client = entry.client
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode == http.StatusMethodNotAllowed || resp.StatusCode == http.StatusNotImplemented {
+		req, err := http.NewRequest("GET", url, nil)
+		if err != nil {
+			return err
+		}
+		if err := entry.prepareRequest(req); err != nil {
+			return err
+		}
+		req.Header.Set("Range", "bytes=0-0")
+		resp, err = client.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+	}
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		return fetchFailed(resp)
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package zipstreamer
+
+import (
+	"archive/zip"
+	"errors"
+	"io"
+)
+
+// RawData describes an entry whose source already provides compressed data,
+// such as the data of an entry in another zip, which is copied into the archive
+// as is instead of being compressed again
+type RawData struct {
+	Method           uint16 // Compression method of the data, e.g. zip.Deflate
+	CRC32            uint32 // CRC-32 of the uncompressed data
+	CompressedSize   int64  // Size of the data as fetched
+	UncompressedSize int64
+}
+
+// NewRawFileEntry creates an entry whose compressed data is fetched from the
+// url and written to the archive without recompressing it
+func NewRawFileEntry(urlString string, zipPath string, raw RawData) (*FileEntry, error) {
+	if raw.CompressedSize < 0 || raw.UncompressedSize < 0 {
+		return nil, errors.New("raw entries need known sizes")
+	}
+	entry, err := NewFileEntry(urlString, zipPath, WithSize(raw.CompressedSize))
+	if err != nil {
+		return nil, err
+	}
+	if entry.IsDir() {
+		return nil, errors.New("raw entries can't be directories")
+	}
+	entry.raw = &raw
+	return entry, nil
+}
+
+// Raw returns the description of pre-compressed data, or nil for regular entries
+func (e *FileEntry) Raw() *RawData {
+	return e.raw
+}
+
+// writeRawData copies pre-compressed data into the archive. As the CRC-32 and
+// sizes are known up front they go into the local header and no data
+// descriptor is written.
+func writeRawData(zipWriter *archiveWriter, header *zip.FileHeader, raw *RawData, source io.Reader) error {
+	header.Method = raw.Method
+	header.CRC32 = raw.CRC32
+	header.CompressedSize64 = uint64(raw.CompressedSize)
+	header.UncompressedSize64 = uint64(raw.UncompressedSize)
+	setRawTimes(header)
+	entryWriter, err := zipWriter.CreateRaw(header)
+	if err != nil {
+		return err
+	}
+
+	_, err = pooledCopy(entryWriter, source)
+	return err
+}
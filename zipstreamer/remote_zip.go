@@ -0,0 +1,318 @@
+package zipstreamer
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Record signatures and sizes of the zip format used to expand remote zips
+const (
+	localHeaderSignature    = 0x04034b50
+	directorySignature      = 0x02014b50
+	endOfDirectorySignature = 0x06054b50
+	zip64LocatorSignature   = 0x07064b50
+	zip64EndSignature       = 0x06064b50
+	localHeaderLen          = 30
+	directoryHeaderLen      = 46
+	endOfDirectoryLen       = 22
+	zip64LocatorLen         = 20
+	zip64EndLen             = 56
+	zip64ExtraID            = 0x0001
+	maxRemoteDirectorySize  = 64 << 20 // Central directories are read into memory
+	maxEndOfDirectorySearch = endOfDirectoryLen + 1<<16 - 1
+	encryptedFlag           = 0x1
+	byteRangeFormat         = "bytes=%d-%d"
+)
+
+// byteRange is a span of an upstream object fetched with a Range request
+type byteRange struct {
+	start, end int64 // end is exclusive
+}
+
+// header returns the Range header value for the span from offset on
+func (b *byteRange) header(offset int64) string {
+	return fmt.Sprintf(byteRangeFormat, b.start+offset, b.end-1)
+}
+
+// remoteMember is an entry of a remote zip as listed in its central directory
+type remoteMember struct {
+	name     string
+	method   uint16
+	flags    uint16
+	crc32    uint32
+	packed   int64
+	unpacked int64
+	offset   int64 // Of the local header
+	modified time.Time
+}
+
+// ExpandRemoteZip reads the central directory of a remote zip with ranged
+// requests and returns entries that copy each member's compressed data into
+// the archive under prefix, without downloading the rest of the zip or
+// recompressing anything. Members that can't be copied, such as encrypted
+// ones, are returned as failures. The source entry supplies the URL and the
+// credentials; client may be nil.
+func ExpandRemoteZip(ctx context.Context, client *http.Client, source *FileEntry, prefix string) ([]*FileEntry, []FailedEntry, error) {
+	if client == nil {
+		client = DefaultClient()
+		if source.client != nil {
+			client = source.client
+		}
+	}
+	remote := &remoteZip{ctx: ctx, client: client, source: source}
+	members, directoryOffset, err := remote.members()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// A member's local header, data and descriptor run up to the next member
+	sort.Slice(members, func(i, j int) bool { return members[i].offset < members[j].offset })
+	var entries []*FileEntry
+	var failed []FailedEntry
+	for i, member := range members {
+		zipPath := path.Join(prefix, member.name)
+		if strings.HasSuffix(member.name, "/") {
+			entry, err := NewDirectoryEntry(zipPath, member.modified)
+			if err != nil {
+				failed = append(failed, FailedEntry{ZipPath: zipPath, Err: err})
+				continue
+			}
+			entries = append(entries, entry)
+			continue
+		}
+		if member.flags&encryptedFlag != 0 {
+			failed = append(failed, FailedEntry{ZipPath: zipPath, Err: errors.New("encrypted entries can't be copied")})
+			continue
+		}
+
+		end := directoryOffset
+		if i+1 < len(members) {
+			end = members[i+1].offset
+		}
+		if end < member.offset+localHeaderLen+member.packed {
+			failed = append(failed, FailedEntry{ZipPath: zipPath, Err: errors.New("entry overlaps the next one")})
+			continue
+		}
+		entry, err := NewRawFileEntry(source.url.String(), zipPath, RawData{
+			Method:           member.method,
+			CRC32:            member.crc32,
+			CompressedSize:   member.packed,
+			UncompressedSize: member.unpacked,
+		})
+		if err != nil {
+			failed = append(failed, FailedEntry{ZipPath: zipPath, Err: err})
+			continue
+		}
+		entry.member = &byteRange{start: member.offset, end: end}
+		entry.modTime = member.modified
+		entry.cookies = source.cookies
+		entry.basicAuth = source.basicAuth
+		entry.authorize = source.authorize
+		entry.client = source.client
+		entries = append(entries, entry)
+	}
+	return entries, failed, nil
+}
+
+// remoteZip fetches parts of a remote zip
+type remoteZip struct {
+	ctx    context.Context
+	client *http.Client
+	source *FileEntry
+}
+
+// fetch requests a byte range and returns its data along with the size of
+// the whole object
+func (r *remoteZip) fetch(rangeHeader string) ([]byte, int64, error) {
+	req, err := http.NewRequestWithContext(r.ctx, "GET", r.source.url.String(), nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	if err := r.source.prepareRequest(req); err != nil {
+		return nil, 0, err
+	}
+	req.Header.Set("Range", rangeHeader)
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusPartialContent {
+		if resp.StatusCode == http.StatusOK {
+			return nil, 0, errors.New("upstream does not support range requests")
+		}
+		return nil, 0, fetchFailed(resp)
+	}
+
+	_, total, ok := strings.Cut(resp.Header.Get("Content-Range"), "/")
+	size, err := strconv.ParseInt(total, 10, 64)
+	if !ok || err != nil {
+		return nil, 0, fmt.Errorf("invalid Content-Range: %q", resp.Header.Get("Content-Range"))
+	}
+	data, err := io.ReadAll(resp.Body)
+	return data, size, err
+}
+
+// members reads the central directory and returns the listed members along
+// with the offset of the directory, which ends the data of the last member
+func (r *remoteZip) members() ([]remoteMember, int64, error) {
+	tail, size, err := r.fetch(fmt.Sprintf("bytes=-%d", maxEndOfDirectorySearch))
+	if err != nil {
+		return nil, 0, err
+	}
+	tailOffset := size - int64(len(tail))
+
+	end := -1
+	for i := len(tail) - endOfDirectoryLen; i >= 0; i-- {
+		if binary.LittleEndian.Uint32(tail[i:]) == endOfDirectorySignature {
+			end = i
+			break
+		}
+	}
+	if end < 0 {
+		return nil, 0, errors.New("not a zip file: end of central directory not found")
+	}
+	record := tail[end:]
+	count := int64(binary.LittleEndian.Uint16(record[10:]))
+	directorySize := int64(binary.LittleEndian.Uint32(record[12:]))
+	directoryOffset := int64(binary.LittleEndian.Uint32(record[16:]))
+
+	// Zip64 archives keep the real values in a record found through a locator
+	if count == 0xffff || directorySize == 0xffffffff || directoryOffset == 0xffffffff {
+		if end < zip64LocatorLen || binary.LittleEndian.Uint32(tail[end-zip64LocatorLen:]) != zip64LocatorSignature {
+			return nil, 0, errors.New("zip64 end of central directory locator not found")
+		}
+		recordOffset := int64(binary.LittleEndian.Uint64(tail[end-zip64LocatorLen+8:]))
+		record, _, err = r.fetch(fmt.Sprintf(byteRangeFormat, recordOffset, recordOffset+zip64EndLen-1))
+		if err != nil {
+			return nil, 0, err
+		}
+		if len(record) < zip64EndLen || binary.LittleEndian.Uint32(record) != zip64EndSignature {
+			return nil, 0, errors.New("invalid zip64 end of central directory")
+		}
+		count = int64(binary.LittleEndian.Uint64(record[32:]))
+		directorySize = int64(binary.LittleEndian.Uint64(record[40:]))
+		directoryOffset = int64(binary.LittleEndian.Uint64(record[48:]))
+	}
+	if directorySize > maxRemoteDirectorySize {
+		return nil, 0, fmt.Errorf("central directory of %d bytes is too large", directorySize)
+	}
+	if directoryOffset < 0 || directorySize < 0 || directoryOffset+directorySize > size {
+		return nil, 0, errors.New("central directory is out of bounds")
+	}
+
+	// The directory is usually part of the tail that was already fetched
+	var directory []byte
+	if directoryOffset >= tailOffset {
+		directory = tail[directoryOffset-tailOffset : directoryOffset-tailOffset+directorySize]
+	} else if directorySize > 0 {
+		directory, _, err = r.fetch(fmt.Sprintf(byteRangeFormat, directoryOffset, directoryOffset+directorySize-1))
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+
+	members := make([]remoteMember, 0, min(count, directorySize/directoryHeaderLen))
+	for len(directory) > 0 {
+		member, n, err := parseDirectoryRecord(directory)
+		if err != nil {
+			return nil, 0, err
+		}
+		members = append(members, member)
+		directory = directory[n:]
+	}
+	return members, directoryOffset, nil
+}
+
+// parseDirectoryRecord reads one central directory record and returns its length
+func parseDirectoryRecord(b []byte) (remoteMember, int, error) {
+	if len(b) < directoryHeaderLen || binary.LittleEndian.Uint32(b) != directorySignature {
+		return remoteMember{}, 0, errors.New("invalid central directory record")
+	}
+	nameLen := int(binary.LittleEndian.Uint16(b[28:]))
+	extraLen := int(binary.LittleEndian.Uint16(b[30:]))
+	commentLen := int(binary.LittleEndian.Uint16(b[32:]))
+	n := directoryHeaderLen + nameLen + extraLen + commentLen
+	if len(b) < n {
+		return remoteMember{}, 0, errors.New("truncated central directory record")
+	}
+
+	member := remoteMember{
+		name:     string(b[directoryHeaderLen : directoryHeaderLen+nameLen]),
+		flags:    binary.LittleEndian.Uint16(b[8:]),
+		method:   binary.LittleEndian.Uint16(b[10:]),
+		modified: msDosTimeToTime(binary.LittleEndian.Uint16(b[14:]), binary.LittleEndian.Uint16(b[12:])),
+		crc32:    binary.LittleEndian.Uint32(b[16:]),
+		packed:   int64(binary.LittleEndian.Uint32(b[20:])),
+		unpacked: int64(binary.LittleEndian.Uint32(b[24:])),
+		offset:   int64(binary.LittleEndian.Uint32(b[42:])),
+	}
+
+	// Values that don't fit 32 bits are in the Zip64 extra field, in this order
+	extra := b[directoryHeaderLen+nameLen : directoryHeaderLen+nameLen+extraLen]
+	for len(extra) >= 4 {
+		id := binary.LittleEndian.Uint16(extra)
+		size := int(binary.LittleEndian.Uint16(extra[2:]))
+		if len(extra) < 4+size {
+			break
+		}
+		field := extra[4 : 4+size]
+		if id == zip64ExtraID {
+			for _, value := range []*int64{&member.unpacked, &member.packed, &member.offset} {
+				if *value != 0xffffffff {
+					continue
+				}
+				if len(field) < 8 {
+					return remoteMember{}, 0, errors.New("invalid zip64 extra field")
+				}
+				*value = int64(binary.LittleEndian.Uint64(field))
+				field = field[8:]
+			}
+		}
+		extra = extra[4+size:]
+	}
+	return member, n, nil
+}
+
+// msDosTimeToTime converts an MS-DOS date and time, which carry no time zone
+func msDosTimeToTime(dosDate, dosTime uint16) time.Time {
+	if dosDate == 0 {
+		return time.Time{}
+	}
+	return time.Date(
+		int(dosDate>>9+1980),
+		time.Month(dosDate>>5&0xf),
+		int(dosDate&0x1f),
+		int(dosTime>>11),
+		int(dosTime>>5&0x3f),
+		int(dosTime&0x1f*2),
+		0,
+		time.UTC,
+	)
+}
+
+// skipLocalHeader positions a member's span at its compressed data and limits
+// it to that data, leaving out any data descriptor
+func skipLocalHeader(body io.Reader, packed int64) (io.Reader, error) {
+	var header [localHeaderLen]byte
+	if _, err := io.ReadFull(body, header[:]); err != nil {
+		return nil, fmt.Errorf("failed to read local header: %v", err)
+	}
+	if binary.LittleEndian.Uint32(header[:]) != localHeaderSignature {
+		return nil, errors.New("invalid local header")
+	}
+	skip := int64(binary.LittleEndian.Uint16(header[26:])) + int64(binary.LittleEndian.Uint16(header[28:]))
+	if _, err := io.CopyN(io.Discard, body, skip); err != nil {
+		return nil, fmt.Errorf("failed to read local header: %v", err)
+	}
+	return io.LimitReader(body, packed), nil
+}
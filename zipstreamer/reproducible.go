@@ -0,0 +1,33 @@
+package zipstreamer
+
+import "time"
+
+// ReproducibleTime is stamped on entries without a modification time and on
+// generated files of reproducible archives. It is the earliest MS-DOS time.
+var ReproducibleTime = time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// now returns the time stamped on files generated while streaming
+func (z *ZipStream) now() time.Time {
+	if z.Reproducible {
+		return ReproducibleTime
+	}
+	return time.Now().UTC()
+}
+
+// SetReproducibleTimes gives entries without a modification time
+// ReproducibleTime instead of the time of streaming. The time adds extra
+// fields, so it must be set before the archive size is estimated.
+func SetReproducibleTimes(entries []*FileEntry) {
+	for _, entry := range entries {
+		if entry.modTime.IsZero() {
+			entry.modTime = ReproducibleTime
+		}
+	}
+}
+
+// fixTimes sets the reproducible times of the stream's entries
+func (z *ZipStream) fixTimes() {
+	if z.Reproducible {
+		SetReproducibleTimes(z.entries)
+	}
+}
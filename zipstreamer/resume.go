@@ -0,0 +1,110 @@
+package zipstreamer
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// maxRangeRetries is how often a short or dropped upstream body is resumed before giving up
+const maxRangeRetries = 3
+
+// verifyingReader counts the bytes read from an upstream body and, when the
+// body ends before the expected size or the connection drops partway through,
+// resumes it with a Range request
+type verifyingReader struct {
+	ctx      context.Context
+	client   *http.Client
+	url      string
+	body     io.ReadCloser
+	expected int64 // -1 if unknown
+	read     int64
+	retries  int
+	err      error                     // Upstream failure, as opposed to a failure writing the zip
+	prepare  func(*http.Request) error // Adds the entry's credentials to resume requests
+	span     *byteRange                // Part of the upstream object the body covers; nil is all of it
+}
+
+func newVerifyingReader(ctx context.Context, client *http.Client, url string, body io.ReadCloser, expected int64) *verifyingReader {
+	return &verifyingReader{ctx: ctx, client: client, url: url, body: body, expected: expected}
+}
+
+func (v *verifyingReader) Read(p []byte) (int, error) {
+	for {
+		n, err := v.body.Read(p)
+		v.read += int64(n)
+
+		if err == io.EOF && v.expected >= 0 && v.read < v.expected {
+			if v.retries < maxRangeRetries && v.resume() {
+				if n > 0 {
+					return n, nil
+				}
+				continue
+			}
+			v.err = fmt.Errorf("short read: got %d of %d bytes", v.read, v.expected)
+			return n, v.err
+		}
+		if err == nil && v.expected >= 0 && v.read > v.expected {
+			v.err = fmt.Errorf("size mismatch: got more than %d bytes", v.expected)
+			return n, v.err
+		}
+		if err != nil && err != io.EOF {
+			// A dropped connection is resumed, but not a fetch that was cancelled on purpose
+			if v.ctx.Err() == nil && v.retries < maxRangeRetries {
+				fmt.Printf("Upstream read of %s failed after %d bytes: %v\n", v.url, v.read, err)
+				if v.resume() {
+					if n > 0 {
+						return n, nil
+					}
+					continue
+				}
+			}
+			v.err = err
+		}
+		return n, err
+	}
+}
+
+// resume replaces the exhausted body with the remainder fetched by a Range request
+func (v *verifyingReader) resume() bool {
+	v.retries++
+	v.body.Close()
+
+	req, err := http.NewRequestWithContext(v.ctx, "GET", v.url, nil)
+	if err != nil {
+		return false
+	}
+	if v.prepare != nil {
+		if err := v.prepare(req); err != nil {
+			fmt.Printf("Cannot resume %s: %v\n", v.url, err)
+			return false
+		}
+	}
+	start := v.read
+	rangeHeader := fmt.Sprintf("bytes=%d-", start)
+	if v.span != nil {
+		start += v.span.start
+		rangeHeader = v.span.header(v.read)
+	}
+	req.Header.Set("Range", rangeHeader)
+
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return false
+	}
+	// The range must start where the body stopped, or the entry would be corrupted
+	if resp.StatusCode != http.StatusPartialContent || !strings.HasPrefix(resp.Header.Get("Content-Range"), fmt.Sprintf("bytes %d-", start)) {
+		resp.Body.Close()
+		return false
+	}
+
+	fmt.Printf("Resuming %s at byte %d\n", v.url, v.read)
+	v.body = resp.Body
+	return true
+}
+
+func (v *verifyingReader) Close() error {
+	return v.body.Close()
+}
@@ -0,0 +1,234 @@
+package zipstreamer
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// Scanner checks the data of entries for malware
+type Scanner interface {
+	// Scan reads r and returns the name of the threat found, or "" when the
+	// data is clean. It may stop reading early.
+	Scan(ctx context.Context, r io.Reader) (string, error)
+}
+
+// ScanPolicy decides what happens to an entry a Scanner flagged
+type ScanPolicy int
+
+const (
+	ScanSkip    ScanPolicy = iota // The entry is left out like a failed one
+	ScanReplace                   // A warning file takes the entry's place
+	ScanAbort                     // The whole archive fails
+)
+
+// ParseScanPolicy reads "skip", "replace" or "abort"; empty is skip
+func ParseScanPolicy(policy string) (ScanPolicy, error) {
+	switch strings.ToLower(policy) {
+	case "", "skip":
+		return ScanSkip, nil
+	case "replace":
+		return ScanReplace, nil
+	case "abort":
+		return ScanAbort, nil
+	}
+	return 0, fmt.Errorf("unknown scan policy %q", policy)
+}
+
+// ErrInfected is returned for an entry whose data a Scanner flagged
+type ErrInfected struct {
+	Threat string
+}
+
+func (e *ErrInfected) Error() string {
+	return "malware detected: " + e.Threat
+}
+
+// infectedWarningSuffix names the warning file replacing a flagged entry
+const infectedWarningSuffix = ".infected.txt"
+
+// scanSource spools an entry's data while the scanner reads it, so nothing
+// reaches the archive before the verdict. The source then serves the spool.
+func (z *ZipStream) scanSource(ctx context.Context, entry *FileEntry, source *entrySource) error {
+	spool := &spillBuffer{budget: z.Memory}
+	source.closers = append(source.closers, spool.cleanup)
+
+	pipeReader, pipeWriter := io.Pipe()
+	verdict := make(chan error, 1)
+	go func() {
+		defer io.Copy(io.Discard, pipeReader) // The scanner may stop early, the rest still has to be spooled
+		data, err := decodedData(entry, source, pipeReader)
+		if err != nil {
+			verdict <- err
+			return
+		}
+		threat, err := z.Scanner.Scan(ctx, data)
+		switch {
+		case err != nil:
+			verdict <- fmt.Errorf("scan failed: %v", err)
+		case threat != "":
+			verdict <- &ErrInfected{Threat: threat}
+		default:
+			verdict <- nil
+		}
+	}()
+
+	_, err := pooledCopy(io.MultiWriter(spool, pipeWriter), source.body)
+	pipeWriter.CloseWithError(err)
+	scanErr := <-verdict
+	switch {
+	case source.verifier.err != nil:
+		return source.verifier.err
+	case err != nil:
+		return err
+	case scanErr != nil:
+		return scanErr
+	}
+
+	body, err := spool.reader()
+	if err != nil {
+		return err
+	}
+	source.body = body
+	return nil
+}
+
+// decodedData decodes the data of an entry that is copied compressed
+func decodedData(entry *FileEntry, source *entrySource, data io.Reader) (io.Reader, error) {
+	switch {
+	case source.gzipEncoded:
+		return gzip.NewReader(data)
+	case entry.raw == nil, entry.raw.Method == zip.Store:
+		return data, nil
+	case entry.raw.Method == zip.Deflate:
+		return flate.NewReader(data), nil
+	}
+	return nil, fmt.Errorf("can't scan data compressed with method %d", entry.raw.Method)
+}
+
+// failEntry records an entry that could not be added. Entries flagged by the
+// scanner are handled by ScanPolicy, which may abort the archive.
+func (z *ZipStream) failEntry(zipWriter *archiveWriter, entry *FileEntry, err error) error {
+	z.fail(entry, err)
+	var infected *ErrInfected
+	if !errors.As(err, &infected) {
+		return nil
+	}
+	switch z.ScanPolicy {
+	case ScanAbort:
+		return fmt.Errorf("aborted, %s: %v", entry.ZipPath(), err)
+	case ScanReplace:
+		warning := fmt.Sprintf("%s was removed from this archive: %v\n", entry.ZipPath(), err)
+		entryWriter, err := zipWriter.CreateHeader(&zip.FileHeader{
+			Name:     entry.ZipPath() + infectedWarningSuffix,
+			Method:   z.CompressionPolicy.method(infectedWarningSuffix, z.CompressionMethod),
+			Modified: z.now(),
+		})
+		if err != nil {
+			return err
+		}
+		_, err = io.WriteString(entryWriter, warning)
+		return err
+	}
+	return nil
+}
+
+// ClamdScanner scans data with a clamd daemon using its INSTREAM command
+type ClamdScanner struct {
+	Network string        // "tcp" or "unix"
+	Address string        // host:port, or the path of the socket
+	Timeout time.Duration // Per scan; 0 waits as long as the context
+}
+
+// clamdChunkSize is the largest chunk sent to clamd at once
+const clamdChunkSize = 64 * 1024
+
+// NewClamdScanner parses a clamd address such as "tcp://127.0.0.1:3310",
+// "unix:///run/clamav/clamd.ctl" or a plain host:port
+func NewClamdScanner(address string, timeout time.Duration) (*ClamdScanner, error) {
+	network, rest, ok := strings.Cut(address, "://")
+	if !ok {
+		network, rest = "tcp", address
+	}
+	if network != "tcp" && network != "unix" {
+		return nil, fmt.Errorf("unsupported clamd network %q", network)
+	}
+	if rest == "" {
+		return nil, errors.New("clamd address is empty")
+	}
+	return &ClamdScanner{Network: network, Address: rest, Timeout: timeout}, nil
+}
+
+// Scan streams r to clamd and reads its verdict
+func (c *ClamdScanner) Scan(ctx context.Context, r io.Reader) (string, error) {
+	if c.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, c.Network, c.Address)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Now()) })
+	defer stop()
+
+	if _, err := io.WriteString(conn, "zINSTREAM\x00"); err != nil {
+		return "", err
+	}
+	chunk := make([]byte, 4+clamdChunkSize)
+	for {
+		n, readErr := io.ReadFull(r, chunk[4:])
+		if n > 0 {
+			binary.BigEndian.PutUint32(chunk, uint32(n))
+			if _, err := conn.Write(chunk[:4+n]); err != nil {
+				// clamd closes the connection once the stream exceeds its limit
+				return c.reply(conn, err)
+			}
+		}
+		if readErr == io.EOF || readErr == io.ErrUnexpectedEOF {
+			break
+		}
+		if readErr != nil {
+			return "", readErr
+		}
+	}
+	if _, err := conn.Write([]byte{0, 0, 0, 0}); err != nil {
+		return c.reply(conn, err)
+	}
+	return c.reply(conn, nil)
+}
+
+// reply reads clamd's answer, e.g. "stream: OK" or "stream: Eicar-Signature FOUND"
+func (c *ClamdScanner) reply(conn net.Conn, writeErr error) (string, error) {
+	answer, err := io.ReadAll(io.LimitReader(conn, 4096))
+	if len(answer) == 0 {
+		if writeErr != nil {
+			return "", writeErr
+		}
+		return "", err
+	}
+	text := strings.TrimSpace(string(bytes.TrimRight(answer, "\x00")))
+	text = strings.TrimPrefix(text, "stream: ")
+	switch {
+	case text == "OK":
+		return "", nil
+	case strings.HasSuffix(text, " FOUND"):
+		return strings.TrimSuffix(text, " FOUND"), nil
+	}
+	return "", fmt.Errorf("clamd: %s", text)
+}
@@ -0,0 +1,45 @@
+package zipstreamer
+
+import "fmt"
+
+// SetMetadata attaches a JSON document describing the entry's source, which
+// AddSidecars writes next to the entry
+func (f *FileEntry) SetMetadata(metadata []byte) {
+	f.metadata = metadata
+}
+
+// Metadata returns the JSON document attached with SetMetadata, or nil
+func (f *FileEntry) Metadata() []byte {
+	return f.metadata
+}
+
+// AddSidecars returns entries with an inline entry holding the metadata of
+// every entry that has some placed right after it, named after the entry with
+// suffix appended. Sidecars whose name is already taken are left out.
+func AddSidecars(entries []*FileEntry, suffix string) []*FileEntry {
+	taken := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		taken[entry.zipPath] = true
+	}
+
+	result := make([]*FileEntry, 0, len(entries))
+	for _, entry := range entries {
+		result = append(result, entry)
+		if entry.metadata == nil || entry.IsDir() {
+			continue
+		}
+		name := entry.zipPath + suffix
+		if taken[name] {
+			fmt.Printf("Skipping sidecar %s: the name is taken\n", name)
+			continue
+		}
+		sidecar, err := NewInlineFileEntry(name, entry.metadata)
+		if err != nil {
+			continue
+		}
+		sidecar.modTime = entry.modTime
+		taken[name] = true
+		result = append(result, sidecar)
+	}
+	return result
+}
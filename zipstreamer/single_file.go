@@ -0,0 +1,85 @@
+package zipstreamer
+
+import (
+	"errors"
+	"time"
+)
+
+// Servable reports whether the entry's data can be sent as a file of its own
+// instead of inside an archive. Pre-compressed and gzip sourced entries only
+// have their data in archive form.
+func (e *FileEntry) Servable() bool {
+	if e.IsDir() {
+		return false
+	}
+	return e.url == nil || (e.raw == nil && !e.IsGzipSource())
+}
+
+// Redirectable reports whether a client can fetch the entry's URL itself and
+// get the same data, which rules out credentials and wrappers
+func (e *FileEntry) Redirectable() bool {
+	return e.url != nil && e.Servable() && e.wrap == nil && !e.private() && e.client == nil
+}
+
+// StreamFile writes the data of the stream's only entry as is, instead of an
+// archive holding it. It goes through the same caches, checks, middleware and
+// scanning as StreamAllFiles. Nothing is written when the entry can't be
+// fetched, so the caller can still answer with an error.
+func (z *ZipStream) StreamFile() ([]EntryResult, error) {
+	start := time.Now()
+	defer func() { z.duration = time.Since(start) }()
+	if len(z.entries) != 1 || !z.entries[0].Servable() {
+		return nil, errors.New("only a single file can be streamed as is")
+	}
+	entry := z.entries[0]
+	z.startEntry()
+
+	reservation := z.streamReservation()
+	if err := z.Memory.Acquire(z.context(), reservation); err != nil {
+		return nil, err
+	}
+	defer z.Memory.Release(reservation)
+	destination := &timedWriter{w: z.destination, counters: &z.counters}
+
+	if entry.url == nil {
+		if _, err := destination.Write(entry.content); err != nil {
+			return nil, err
+		}
+		z.entrySize = int64(len(entry.content))
+		z.added(entry)
+		z.counters.entries.Store(1)
+		return z.results, nil
+	}
+
+	ctx, cancel, release := z.entryContext(z.context())
+	defer release()
+	source, err := z.openEntrySource(ctx, cancel, entry)
+	if err != nil {
+		err = fetchError(ctx, err)
+		z.fail(entry, err)
+		return z.results, err
+	}
+	defer source.close()
+	body, err := decodedData(entry, source, source.body)
+	if err != nil {
+		z.fail(entry, err)
+		return z.results, err
+	}
+
+	z.entrySize, err = pooledCopy(destination, body)
+	if source.verifier.err != nil {
+		err = fetchError(ctx, source.verifier.err)
+	}
+	if err == nil && source.checksums != nil {
+		err = source.checksums.verify()
+	}
+	if err != nil {
+		// Part of the data may be out already, so the failure only shows in the result
+		z.fail(entry, err)
+		return z.results, err
+	}
+	source.commit()
+	z.added(entry)
+	z.counters.entries.Store(1)
+	return z.results, nil
+}
@@ -0,0 +1,60 @@
+package zipstreamer
+
+import (
+	"context"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// stallReader counts bytes read from an upstream body so a stall detector can
+// cancel fetches whose throughput drops below the configured minimum
+type stallReader struct {
+	r    io.Reader
+	read atomic.Int64
+}
+
+func (s *stallReader) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	s.read.Add(int64(n))
+	return n, err
+}
+
+// watchStall cancels the fetch when fewer than minThroughput bytes per second
+// arrive during a window. It returns a function that stops the watcher.
+func watchStall(ctx context.Context, cancel context.CancelCauseFunc, reader *stallReader, window time.Duration, minThroughput int64) func() {
+	if window <= 0 {
+		return func() {}
+	}
+	if minThroughput <= 0 {
+		minThroughput = 1
+	}
+	minBytes := int64(window.Seconds() * float64(minThroughput))
+	if minBytes < 1 {
+		minBytes = 1
+	}
+
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(window)
+		defer ticker.Stop()
+
+		var last int64
+		for {
+			select {
+			case <-done:
+				return
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				current := reader.read.Load()
+				if current-last < minBytes {
+					cancel(errStalled)
+					return
+				}
+				last = current
+			}
+		}
+	}()
+	return func() { close(done) }
+}
@@ -0,0 +1,90 @@
+package zipstreamer
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// StreamStats describes where an archive stream spent its time, to tell
+// whether the provider or the client is the bottleneck
+type StreamStats struct {
+	Duration     time.Duration // Wall-clock time of the whole stream
+	BytesRead    int64         // Bytes received from upstream, before any wrapper
+	BytesWritten int64         // Archive bytes written to the client
+	UpstreamWait time.Duration // Time spent waiting for upstream reads, summed over concurrent fetches
+	ClientWait   time.Duration // Time spent waiting for the client to accept data
+	Stalls       int           // Entries cancelled for falling below the minimum throughput
+}
+
+// String formats the stats for the log
+func (s StreamStats) String() string {
+	return fmt.Sprintf("%d bytes in %s (%s/s), read %d bytes, upstream wait %s, client wait %s, %d stalls",
+		s.BytesWritten, s.Duration.Round(time.Millisecond), formatRate(s.BytesWritten, s.Duration),
+		s.BytesRead, s.UpstreamWait.Round(time.Millisecond), s.ClientWait.Round(time.Millisecond), s.Stalls)
+}
+
+// formatRate formats a byte rate with a binary unit
+func formatRate(bytes int64, duration time.Duration) string {
+	if duration <= 0 {
+		return "0 B"
+	}
+	rate := float64(bytes) / duration.Seconds()
+	units := []string{"B", "KiB", "MiB", "GiB"}
+	unit := 0
+	for rate >= 1024 && unit < len(units)-1 {
+		rate /= 1024
+		unit++
+	}
+	return fmt.Sprintf("%.1f %s", rate, units[unit])
+}
+
+// streamCounters collects the stats; upstream counters are updated from the
+// compression goroutines, so all of them are atomic
+type streamCounters struct {
+	bytesRead    atomic.Int64
+	bytesWritten atomic.Int64
+	upstreamWait atomic.Int64
+	clientWait   atomic.Int64
+	stalls       atomic.Int64
+	entries      atomic.Int64 // Entries finished so far, for progress reports
+}
+
+// timedReader measures the time spent in upstream reads
+type timedReader struct {
+	r        io.Reader
+	counters *streamCounters
+}
+
+func (t *timedReader) Read(p []byte) (int, error) {
+	start := time.Now()
+	n, err := t.r.Read(p)
+	t.counters.upstreamWait.Add(int64(time.Since(start)))
+	t.counters.bytesRead.Add(int64(n))
+	return n, err
+}
+
+// timedWriter measures the time spent writing to the client
+type timedWriter struct {
+	w        io.Writer
+	counters *streamCounters
+}
+
+func (t *timedWriter) Write(p []byte) (int, error) {
+	start := time.Now()
+	n, err := t.w.Write(p)
+	t.counters.clientWait.Add(int64(time.Since(start)))
+	t.counters.bytesWritten.Add(int64(n))
+	return n, err
+}
+
+// Flush passes flushes through, counting them as client time
+func (t *timedWriter) Flush() {
+	if flusher, ok := t.w.(http.Flusher); ok {
+		start := time.Now()
+		flusher.Flush()
+		t.counters.clientWait.Add(int64(time.Since(start)))
+	}
+}
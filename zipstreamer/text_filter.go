@@ -0,0 +1,106 @@
+package zipstreamer
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/unicode"
+)
+
+// defaultTextBytes bounds the text files converted by TextFilter
+const defaultTextBytes = 16 << 20
+
+// textExtensions are the entries TextFilter converts, such as subtitles and scripts
+var textExtensions = map[string]bool{
+	".txt": true, ".srt": true, ".vtt": true, ".ass": true, ".ssa": true, ".sub": true,
+	".nfo": true, ".csv": true, ".md": true, ".ini": true, ".cfg": true, ".log": true,
+	".sh": true, ".bat": true, ".cmd": true, ".ps1": true, ".py": true, ".js": true,
+	".json": true, ".xml": true, ".html": true, ".htm": true, ".css": true,
+}
+
+// Line endings of TextFilter
+const (
+	KeepLineEndings = ""
+	LF              = "\n"
+	CRLF            = "\r\n"
+)
+
+// TextFilter converts text entries to UTF-8 and normalizes their line endings
+// while they stream into the archive. Files larger than MaxBytes are kept
+// unchanged, as are the others when the conversion fails.
+type TextFilter struct {
+	Source     encoding.Encoding // Encoding of the files; nil detects UTF-16 and UTF-8 by BOM, then falls back to Windows-1252 for invalid UTF-8
+	LineEnding string            // LF, CRLF or KeepLineEndings
+	MaxBytes   int64             // 0 uses 16MiB
+}
+
+// Middleware returns the filter as reader middleware for ZipStream.Use
+func (f *TextFilter) Middleware() ReaderMiddleware {
+	return func(entry *FileEntry, r io.Reader) io.Reader {
+		if !textExtensions[strings.ToLower(path.Ext(entry.ZipPath()))] {
+			return r
+		}
+		limit := f.MaxBytes
+		if limit <= 0 {
+			limit = defaultTextBytes
+		}
+		return &wholeReader{source: r, limit: limit, transform: func(data []byte) []byte {
+			return f.process(entry, data)
+		}}
+	}
+}
+
+// process returns the converted text, or the original data when it is kept
+func (f *TextFilter) process(entry *FileEntry, data []byte) []byte {
+	text, err := f.decode(data)
+	if err != nil {
+		fmt.Printf("Keeping text %s unchanged: %v\n", entry.ZipPath(), err)
+		return data
+	}
+	return convertLineEndings(text, f.LineEnding)
+}
+
+// decode converts data to UTF-8 without a byte order mark
+func (f *TextFilter) decode(data []byte) ([]byte, error) {
+	source := f.Source
+	if source == nil {
+		source = detectEncoding(data)
+	}
+	if source == unicode.UTF8 {
+		return bytes.TrimPrefix(data, []byte("\xef\xbb\xbf")), nil
+	}
+	return source.NewDecoder().Bytes(data)
+}
+
+// detectEncoding guesses the encoding of text from its byte order mark and
+// whether it is valid UTF-8
+func detectEncoding(data []byte) encoding.Encoding {
+	switch {
+	case bytes.HasPrefix(data, []byte("\xff\xfe")):
+		return unicode.UTF16(unicode.LittleEndian, unicode.ExpectBOM)
+	case bytes.HasPrefix(data, []byte("\xfe\xff")):
+		return unicode.UTF16(unicode.BigEndian, unicode.ExpectBOM)
+	case utf8.Valid(data):
+		return unicode.UTF8
+	}
+	return charmap.Windows1252
+}
+
+// convertLineEndings rewrites CRLF, CR and LF line breaks to lineEnding
+func convertLineEndings(text []byte, lineEnding string) []byte {
+	if lineEnding == KeepLineEndings {
+		return text
+	}
+	text = bytes.ReplaceAll(text, []byte("\r\n"), []byte("\n"))
+	text = bytes.ReplaceAll(text, []byte("\r"), []byte("\n"))
+	if lineEnding == LF {
+		return text
+	}
+	return bytes.ReplaceAll(text, []byte("\n"), []byte(lineEnding))
+}
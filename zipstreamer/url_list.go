@@ -0,0 +1,70 @@
+package zipstreamer
+
+import (
+	"encoding/json"
+	"errors"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// urlListItem is an element of a URL list: either a plain URL string or an
+// object naming the file
+type urlListItem struct {
+	URL  string `json:"url"`
+	Name string `json:"name,omitempty"` // Zip path; defaults to the URL's basename
+	Size *int64 `json:"size,omitempty"`
+}
+
+func (item *urlListItem) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '"' {
+		return json.Unmarshal(data, &item.URL)
+	}
+	type plain urlListItem
+	return json.Unmarshal(data, (*plain)(item))
+}
+
+// UnmarshalJsonURLList parses a JSON array of direct URLs, each either a
+// string or an object with url, name and size, into a descriptor. Files
+// without a name are named after the last segment of their URL path.
+func UnmarshalJsonURLList(payload []byte) (*ZipDescriptor, error) {
+	var items []urlListItem
+	if err := json.Unmarshal(payload, &items); err != nil {
+		return nil, err
+	}
+	if len(items) == 0 {
+		return nil, errors.New("empty URL list")
+	}
+
+	zd := NewZipDescriptor()
+	for _, item := range items {
+		zipPath := item.Name
+		if zipPath == "" {
+			zipPath = URLBasename(item.URL)
+		}
+		size := int64(-1)
+		if item.Size != nil {
+			size = *item.Size
+		}
+
+		fileEntry, err := NewFileEntry(item.URL, zipPath, WithSize(size))
+		if err == nil {
+			zd.files = append(zd.files, fileEntry)
+		}
+	}
+	return zd, nil
+}
+
+// URLBasename returns the unescaped last path segment of a URL, or "file"
+// when the path has none, e.g. for a bare host
+func URLBasename(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "file"
+	}
+	name := path.Base(strings.TrimRight(u.Path, "/"))
+	if name == "." || name == "/" || name == "" {
+		return "file"
+	}
+	return name
+}
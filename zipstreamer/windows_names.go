@@ -0,0 +1,55 @@
+package zipstreamer
+
+import (
+	"strings"
+)
+
+// Device names that Windows refuses as file names, with or without an extension
+var windowsReservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true,
+	"COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true,
+	"LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// SanitizeWindowsZipPaths returns the entries with their zip paths rewritten so
+// they extract cleanly on Windows, keeping the order and length of entries
+func SanitizeWindowsZipPaths(entries []*FileEntry) []*FileEntry {
+	return mapZipPaths(entries, sanitizeWindowsPath)
+}
+
+// sanitizeWindowsPath rewrites each segment of a zip path: invalid characters
+// become '_', trailing dots and spaces are removed and device names get a '_' prefix
+func sanitizeWindowsPath(zipPath string) string {
+	segments := strings.Split(zipPath, "/")
+	for i, segment := range segments {
+		// The empty segment after a directory's trailing slash must stay
+		if segment == "" {
+			continue
+		}
+
+		segment = strings.Map(func(r rune) rune {
+			if r < 32 || strings.ContainsRune(`<>:"|?*`, r) {
+				return '_'
+			}
+			return r
+		}, segment)
+
+		segment = strings.TrimRight(segment, ". ")
+		if segment == "" {
+			segment = "_"
+		}
+
+		stem := segment
+		if dot := strings.Index(stem, "."); dot >= 0 {
+			stem = stem[:dot]
+		}
+		if windowsReservedNames[strings.ToUpper(strings.TrimRight(stem, " "))] {
+			segment = "_" + segment
+		}
+
+		segments[i] = segment
+	}
+	return strings.Join(segments, "/")
+}
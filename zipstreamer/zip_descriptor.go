@@ -2,26 +2,61 @@ package zipstreamer
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
+	"unicode/utf8"
 )
 
 type ZipDescriptor struct {
 	suggestedFilenameRaw string
+	compression          string
 	files                []*FileEntry
+	sources              []SourceRef
+	remoteZips           []*FileEntry
+	credentials          map[string]string
+}
+
+// SourceRef is a descriptor entry that has to be resolved through a provider
+// (a folder or file path on that provider) rather than fetched from a URL
+type SourceRef struct {
+	Provider string
+	Path     string
+	ID       string // Provider item ID, used instead of Path when set
+	ZipPath  string
+	// Set by MergeZipDescriptors: the folder the resolved files are placed in,
+	// and the credential of the descriptor the source came from
+	Prefix     string
+	Credential string
 }
 
 func NewZipDescriptor() *ZipDescriptor {
 	return &ZipDescriptor{
 		suggestedFilenameRaw: "",
 		files:                make([]*FileEntry, 0),
+		sources:              make([]SourceRef, 0),
+		credentials:          make(map[string]string),
 	}
 }
 
 // ✅ Ensures the filename is valid for ZIP download
 func (zd ZipDescriptor) EscapedSuggestedFilename() string {
-	rawFilename := zd.suggestedFilenameRaw
+	return EscapeSuggestedFilename(zd.suggestedFilenameRaw)
+}
+
+// EscapeSuggestedFilename makes a requested filename safe for a
+// Content-Disposition header, falling back to archive.zip
+func EscapeSuggestedFilename(rawFilename string) string {
 	escapedFilenameBuilder := make([]rune, 0, len(rawFilename))
 	for _, r := range rawFilename {
+		if r == '\\' {
+			// Clients read a backslash in a quoted string as an escape
+			r = '_'
+		}
 		if r > 31 && r < 127 && r != '"' {
 			escapedFilenameBuilder = append(escapedFilenameBuilder, r)
 		}
@@ -38,18 +73,150 @@ func (zd ZipDescriptor) EscapedSuggestedFilename() string {
 	return "archive.zip"
 }
 
+// SuggestedFilename returns the filename requested by the descriptor, unescaped
+func (zd ZipDescriptor) SuggestedFilename() string {
+	return zd.suggestedFilenameRaw
+}
+
+// Compression returns the compression method requested by the descriptor
+func (zd ZipDescriptor) Compression() string {
+	return zd.compression
+}
+
+// ContentDisposition builds a Content-Disposition header value for the requested
+// filename, with an ASCII fallback in filename and the full UTF-8 name in
+// filename* (RFC 5987) when the two differ
+func ContentDisposition(dispositionType, rawFilename string) string {
+	asciiFilename := EscapeSuggestedFilename(rawFilename)
+	value := fmt.Sprintf("%s; filename=\"%s\"", dispositionType, asciiFilename)
+
+	utf8Filename := strings.Map(func(r rune) rune {
+		if r < 32 || r == 127 || r == utf8.RuneError {
+			return -1
+		}
+		return r
+	}, rawFilename)
+	if utf8Filename == "" || utf8Filename == ".zip" {
+		return value
+	}
+	if !strings.HasSuffix(utf8Filename, ".zip") {
+		utf8Filename += ".zip"
+	}
+	if utf8Filename == asciiFilename {
+		return value
+	}
+
+	return value + "; filename*=UTF-8''" + encodeRFC5987(utf8Filename)
+}
+
+// FileContentDisposition is ContentDisposition for a file served as is, which
+// keeps its own name and extension
+func FileContentDisposition(dispositionType, filename string) string {
+	asciiFilename := strings.Map(func(r rune) rune {
+		if r == '\\' {
+			return '_'
+		}
+		if r > 31 && r < 127 && r != '"' {
+			return r
+		}
+		return -1
+	}, filename)
+	if asciiFilename == "" {
+		asciiFilename = "download"
+	}
+	value := fmt.Sprintf("%s; filename=\"%s\"", dispositionType, asciiFilename)
+
+	utf8Filename := strings.Map(func(r rune) rune {
+		if r < 32 || r == 127 || r == utf8.RuneError {
+			return -1
+		}
+		return r
+	}, filename)
+	if utf8Filename == "" || utf8Filename == asciiFilename {
+		return value
+	}
+	return value + "; filename*=UTF-8''" + encodeRFC5987(utf8Filename)
+}
+
+// encodeRFC5987 percent-encodes every byte that is not an RFC 5987 attr-char
+func encodeRFC5987(s string) string {
+	const attrChars = "!#$&+-.^_`|~"
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') || strings.IndexByte(attrChars, c) >= 0 {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
 func (zd ZipDescriptor) Files() []*FileEntry {
 	return zd.files
 }
 
+// Sources returns the provider-backed entries still to be resolved
+func (zd ZipDescriptor) Sources() []SourceRef {
+	return zd.sources
+}
+
+// RemoteZips returns the remote zips whose entries are expanded into the
+// archive under their zip path, see ExpandRemoteZip
+func (zd ZipDescriptor) RemoteZips() []*FileEntry {
+	return zd.remoteZips
+}
+
+// Credential returns the API key or token supplied for a provider
+func (zd ZipDescriptor) Credential(provider string) string {
+	return zd.credentials[provider]
+}
+
 type jsonZipEntry struct {
-	Url     string `json:"url"`
-	ZipPath string `json:"zipPath"`
+	Url      string `json:"url"`
+	ZipPath  string `json:"zipPath"`
+	Size     *int64 `json:"size,omitempty"`
+	CRC32    string `json:"crc32,omitempty"`
+	MD5      string `json:"md5,omitempty"`
+	Provider string `json:"provider,omitempty"`
+	Path     string `json:"path,omitempty"`
+	ID       string `json:"id,omitempty"` // Provider item ID, instead of a path
+
+	// Cookies sent with the request, for sources that require a session
+	Cookies map[string]string `json:"cookies,omitempty"`
+	// Basic auth credentials for private HTTP servers
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+
+	// Set for data that is already compressed, e.g. a byte range of another
+	// zip; size and crc32 then describe the uncompressed data
+	Method         uint16 `json:"method,omitempty"`
+	CompressedSize *int64 `json:"compressedSize,omitempty"`
+
+	// Times and numeric owner of the extracted file
+	Modified *time.Time `json:"modified,omitempty"`
+	Accessed *time.Time `json:"accessed,omitempty"`
+	Created  *time.Time `json:"created,omitempty"`
+	UID      *int       `json:"uid,omitempty"`
+	GID      *int       `json:"gid,omitempty"`
+
+	// Set for a remote zip that is stored whole instead of being recompressed
+	Nested bool `json:"nested,omitempty"`
+	// Set for a remote zip whose entries are copied into the archive under zipPath
+	Expand bool `json:"expand,omitempty"`
 }
 
 type jsonZipPayload struct {
-	Files             []jsonZipEntry `json:"files"`
-	SuggestedFilename string         `json:"suggestedFilename"`
+	Files             []jsonZipEntry    `json:"files"`
+	SuggestedFilename string            `json:"suggestedFilename"`
+	Compression       string            `json:"compression,omitempty"`
+	Credentials       map[string]string `json:"credentials,omitempty"`
+
+	// Descriptors merged into one archive, each with an optional "prefix";
+	// duplicates is the policy for colliding files, see ParseDuplicatePolicy
+	Descriptors []json.RawMessage `json:"descriptors,omitempty"`
+	Duplicates  string            `json:"duplicates,omitempty"`
 }
 
 func UnmarshalJsonZipDescriptor(payload []byte) (*ZipDescriptor, error) {
@@ -58,21 +225,140 @@ func UnmarshalJsonZipDescriptor(payload []byte) (*ZipDescriptor, error) {
 	if err != nil {
 		return nil, err
 	}
+	if len(parsed.Descriptors) > 0 {
+		return unmarshalMergedDescriptor(parsed)
+	}
 
 	zd := NewZipDescriptor()
 	zd.suggestedFilenameRaw = parsed.SuggestedFilename
+	zd.compression = parsed.Compression
+	for provider, credential := range parsed.Credentials {
+		zd.credentials[provider] = credential
+	}
 
 	for _, jsonZipFileItem := range parsed.Files {
+		// Entries with a provider hint are resolved by the caller
+		if jsonZipFileItem.Provider != "" && jsonZipFileItem.Provider != "url" {
+			if jsonZipFileItem.Path != "" || jsonZipFileItem.ID != "" {
+				zd.sources = append(zd.sources, SourceRef{
+					Provider: jsonZipFileItem.Provider,
+					Path:     jsonZipFileItem.Path,
+					ID:       jsonZipFileItem.ID,
+					ZipPath:  jsonZipFileItem.ZipPath,
+				})
+			}
+			continue
+		}
+
 		// ✅ Allow empty folders (without URLs)
 		if jsonZipFileItem.Url == "" && !strings.HasSuffix(jsonZipFileItem.ZipPath, "/") {
 			continue
 		}
 
-		fileEntry, err := NewFileEntry(jsonZipFileItem.Url, jsonZipFileItem.ZipPath)
+		size := int64(-1)
+		if jsonZipFileItem.Size != nil {
+			size = *jsonZipFileItem.Size
+		}
+
+		if jsonZipFileItem.Expand {
+			// The zip path is a folder, named after the zip unless given
+			zipPath := strings.TrimRight(jsonZipFileItem.ZipPath, "/")
+			if zipPath == "" {
+				zipPath = strings.TrimSuffix(URLBasename(jsonZipFileItem.Url), ".zip")
+			}
+			fileEntry, err := NewFileEntry(jsonZipFileItem.Url, zipPath)
+			if err == nil {
+				jsonZipFileItem.applyCredentials(fileEntry)
+				zd.remoteZips = append(zd.remoteZips, fileEntry)
+			}
+			continue
+		}
+
+		checksums := Checksums{CRC32: jsonZipFileItem.CRC32, MD5: jsonZipFileItem.MD5}
+		if jsonZipFileItem.Nested {
+			fileEntry, err := NewNestedZipEntry(jsonZipFileItem.Url, jsonZipFileItem.ZipPath, size, checksums)
+			if err == nil {
+				jsonZipFileItem.applyCredentials(fileEntry)
+				jsonZipFileItem.applyMetadata(fileEntry)
+				zd.files = append(zd.files, fileEntry)
+			}
+			continue
+		}
+
+		if jsonZipFileItem.CompressedSize != nil {
+			fileEntry, err := jsonZipFileItem.rawEntry()
+			if err == nil {
+				jsonZipFileItem.applyCredentials(fileEntry)
+				jsonZipFileItem.applyMetadata(fileEntry)
+				zd.files = append(zd.files, fileEntry)
+			}
+			continue
+		}
+
+		fileEntry, err := NewFileEntry(jsonZipFileItem.Url, jsonZipFileItem.ZipPath, WithSize(size), WithChecksums(checksums))
 		if err == nil {
+			jsonZipFileItem.applyCredentials(fileEntry)
+			jsonZipFileItem.applyMetadata(fileEntry)
 			zd.files = append(zd.files, fileEntry)
 		}
 	}
 
 	return zd, nil
 }
+
+// rawEntry creates an entry for pre-compressed data, which needs the CRC-32 and
+// size of the uncompressed data
+func (item jsonZipEntry) rawEntry() (*FileEntry, error) {
+	if item.Size == nil || item.CRC32 == "" {
+		return nil, errors.New("compressed entries need size and crc32")
+	}
+	crc, err := strconv.ParseUint(strings.TrimSpace(item.CRC32), 16, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid crc32: %v", err)
+	}
+	return NewRawFileEntry(item.Url, item.ZipPath, RawData{
+		Method:           item.Method,
+		CRC32:            uint32(crc),
+		CompressedSize:   *item.CompressedSize,
+		UncompressedSize: *item.Size,
+	})
+}
+
+// applyCredentials sets the entry's cookies and Basic auth credentials
+func (item jsonZipEntry) applyCredentials(entry *FileEntry) {
+	entry.cookies = item.cookies()
+	if item.Username != "" || item.Password != "" {
+		entry.SetBasicAuth(item.Username, item.Password)
+	}
+}
+
+// applyMetadata sets the times and owner of the extracted file
+func (item jsonZipEntry) applyMetadata(entry *FileEntry) {
+	if item.Modified != nil {
+		entry.modTime = *item.Modified
+	}
+	if item.Accessed != nil {
+		entry.accessed = *item.Accessed
+	}
+	if item.Created != nil {
+		entry.created = *item.Created
+	}
+	if item.UID != nil && item.GID != nil {
+		entry.SetOwner(*item.UID, *item.GID)
+	}
+}
+
+// cookies converts the entry's cookies in a stable order
+func (item jsonZipEntry) cookies() []*http.Cookie {
+	names := make([]string, 0, len(item.Cookies))
+	for name := range item.Cookies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var cookies []*http.Cookie
+	for _, name := range names {
+		cookies = append(cookies, &http.Cookie{Name: name, Value: item.Cookies[name]})
+	}
+	return cookies
+}
@@ -0,0 +1,38 @@
+package zipstreamer
+
+import "testing"
+
+func TestContentDisposition(t *testing.T) {
+	tests := []struct {
+		filename string
+		want     string
+	}{
+		{"photos.zip", `attachment; filename="photos.zip"`},
+		{"photos", `attachment; filename="photos.zip"`},
+		{"", `attachment; filename="archive.zip"`},
+		{`say "hi".zip`, `attachment; filename="say hi.zip"; filename*=UTF-8''say%20%22hi%22.zip`},
+		{`a\b.zip`, `attachment; filename="a_b.zip"; filename*=UTF-8''a%5Cb.zip`},
+		{"café.zip", `attachment; filename="caf.zip"; filename*=UTF-8''caf%C3%A9.zip`},
+	}
+	for _, tt := range tests {
+		if got := ContentDisposition("attachment", tt.filename); got != tt.want {
+			t.Errorf("ContentDisposition(%q) = %s, want %s", tt.filename, got, tt.want)
+		}
+	}
+}
+
+func TestFileContentDisposition(t *testing.T) {
+	tests := []struct {
+		filename string
+		want     string
+	}{
+		{"report.pdf", `inline; filename="report.pdf"`},
+		{`a\b.pdf`, `inline; filename="a_b.pdf"; filename*=UTF-8''a%5Cb.pdf`},
+		{"", `inline; filename="download"`},
+	}
+	for _, tt := range tests {
+		if got := FileContentDisposition("inline", tt.filename); got != tt.want {
+			t.Errorf("FileContentDisposition(%q) = %s, want %s", tt.filename, got, tt.want)
+		}
+	}
+}
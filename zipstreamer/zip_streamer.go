@@ -2,6 +2,9 @@ package zipstreamer
 
 import (
 	"archive/zip"
+	"bufio"
+	"compress/flate"
+	"context"
 	"errors"
 	"fmt"
 	"io"
@@ -15,13 +18,67 @@ import (
 type ZipStream struct {
 	entries           []*FileEntry
 	destination       io.Writer
-	CompressionMethod uint16
+	CompressionMethod uint16            // zip.Store, zip.Deflate or Zstd
+	CompressionLevel  int               // Level from flate.BestSpeed to flate.BestCompression; 0 uses the default
+	CompressionPolicy CompressionPolicy // Per-extension overrides of CompressionMethod
+	Parallelism       int               // Entries deflated concurrently ahead of the stream; below 2 disables
+	Memory            *MemoryBudget     // Shared budget for stream buffers; nil is unlimited
+	Comment           string            // Archive comment, e.g. where and when it was generated
+	Context           context.Context   // Cancels the whole stream, e.g. when the client went away; nil never cancels
+	HashEntries       bool              // Compute a SHA-256 of every entry, see EntryHashes
+	Client            *http.Client      // Upstream client; nil uses DefaultClient
+	Jar               http.CookieJar    // Cookies kept across the archive's upstream requests; nil disables
+	EntryTimeout      time.Duration     // Maximum time to fetch one entry; 0 disables
+	StallTimeout      time.Duration     // Window over which MinThroughput must be reached; 0 disables
+	MinThroughput     int64             // Bytes per second below which a fetch counts as stalled
+	Deadline          time.Time         // Entries not finished by then are skipped; zero disables
+	FailureManifest   string            // Zip path of a report listing failed entries; empty disables
+	Manifest          string            // Zip path of a JSON manifest describing every file, see ManifestEntry; empty disables
+	KeepaliveInterval time.Duration     // Release held-back bytes when idle this long; 0 disables
+	Cache             *ETagCache        // Optional cache for small upstream responses
+	DiskCache         *DiskCache        // Optional disk spool for frequently requested entries
+	MemoryCache       *MemoryCache      // Optional in-memory cache for small entries
+	OnCheckpoint      CheckpointHook    // Called with the state of the stream between entries, see Checkpoint; nil disables
+	CheckpointPeriod  time.Duration     // Minimum time between checkpoints; 0 takes one at every entry boundary
+	Resume            *Checkpoint       // Continues the archive of an earlier stream of the same entries from its checkpoint
+	OnEntryStart      EntryStartHook    // Called before each entry; an error skips it. nil disables
+	OnEntryComplete   EntryResultHook   // Called for each entry added to the archive; nil disables
+	OnEntryError      EntryResultHook   // Called for each entry left out of the archive; nil disables
+	Scanner           Scanner           // Scans every entry before it is written, e.g. a ClamdScanner; nil disables
+	ScanPolicy        ScanPolicy        // What happens to entries the Scanner flags
+	ContentTypes      ContentTypePolicy // Rejects entries by the type sniffed from their data; empty allows any
+	Alignment         int               // Stored entries' data starts at a multiple of this many bytes, e.g. 4; below 2 disables
+	Reproducible      bool              // Stamp ReproducibleTime instead of the current time, so the same entries give identical bytes
+	contents          *contentSpool
+	counters          streamCounters
+	duration          time.Duration
+	failed            []FailedEntry
+	hashes            []EntryHash
+	manifest          []ManifestEntry
+	first             int       // Index of the first entry to write, after those of Resume
+	lastCheckpoint    time.Time // When OnCheckpoint was last called
+	results           []EntryResult
+	entryStart        time.Time // Of the entry being written, for its EntryResult
+	entrySize         int64     // Data written for the entry being written
+	middleware        []ReaderMiddleware
+}
+
+var (
+	errEntryTimeout = errors.New("entry fetch timed out")
+	errStalled      = errors.New("entry fetch stalled below the minimum throughput")
+	errDeadline     = errors.New("archive deadline reached")
+)
+
+// FailedEntry records an entry that could not be added to the archive
+type FailedEntry struct {
+	ZipPath string
+	Err     error
 }
 
 // ✅ Constructor function to create a new ZipStream
 func NewZipStream(entries []*FileEntry, w io.Writer) (*ZipStream, error) {
 	if len(entries) == 0 {
-		return nil, errors.New("must have at least 1 entry")
+		return nil, classify(ErrEmptyArchive, "must have at least 1 entry")
 	}
 
 	return &ZipStream{
@@ -31,63 +88,159 @@ func NewZipStream(entries []*FileEntry, w io.Writer) (*ZipStream, error) {
 	}, nil
 }
 
-func (z *ZipStream) StreamAllFiles() error {
-	zipWriter := zip.NewWriter(z.destination)
+// StreamAllFiles writes the archive and returns the result of every entry
+// that was reached, in order, along with the error that stopped the stream.
+// A stream resuming from a checkpoint reports the entries after it.
+func (z *ZipStream) StreamAllFiles() ([]EntryResult, error) {
+	err := z.streamAllFiles()
+	return z.results, err
+}
+
+func (z *ZipStream) streamAllFiles() error {
+	start := time.Now()
+	defer func() { z.duration = time.Since(start) }()
+	z.fixTimes()
+
+	// Streams wait for their buffers instead of pushing the process past the budget
+	reservation := z.streamReservation()
+	if err := z.Memory.Acquire(z.context(), reservation); err != nil {
+		return err
+	}
+	defer z.Memory.Release(reservation)
+
+	var destination io.Writer = &timedWriter{w: z.destination, counters: &z.counters}
+	var keepalive *keepaliveWriter
+	if z.KeepaliveInterval > 0 {
+		keepalive = newKeepaliveWriter(destination, z.KeepaliveInterval)
+		destination = keepalive
+		defer keepalive.finish()
+	}
+
+	zipWriter := newArchiveWriter(destination, z.Alignment)
+	if z.OnCheckpoint != nil {
+		zipWriter.trackEntries()
+	}
+	if z.CompressionLevel != 0 {
+		level := z.CompressionLevel
+		zipWriter.RegisterCompressor(zip.Deflate, func(out io.Writer) (io.WriteCloser, error) {
+			return flate.NewWriter(out, level)
+		})
+	}
+	if z.CompressionMethod == Zstd {
+		zipWriter.RegisterCompressor(Zstd, zstdCompressor(z.CompressionLevel))
+	}
 	success := 0
+	if z.Resume != nil {
+		if err := z.resume(zipWriter); err != nil {
+			return err
+		}
+		success = z.Resume.Added
+	}
+	z.contents = newContentSpool(z.entries[z.first:])
+	defer z.contents.cleanup()
 
-	for _, entry := range z.entries {
-		// ✅ Explicitly add empty folders to the ZIP
-		if entry.Url() == nil {
+	var pipeline *compressionPipeline
+	if z.Parallelism > 1 && z.CompressionMethod == zip.Deflate {
+		pipeline = z.startCompression()
+		defer pipeline.stop()
+	}
+
+	z.lastCheckpoint = time.Now()
+	for i := z.first; i < len(z.entries); i++ {
+		entry := z.entries[i]
+		if err := z.checkpoint(zipWriter, i, success); err != nil {
+			return err
+		}
+		z.counters.entries.Store(int64(i))
+		z.startEntry()
+		if err := z.context().Err(); err != nil {
+			return fmt.Errorf("stream cancelled: %v", context.Cause(z.context()))
+		}
+		prepared := pipeline.take(i)
+
+		// Past the deadline the archive is finalized with whatever completed
+		if !z.Deadline.IsZero() && time.Now().After(z.Deadline) {
+			prepared.cleanup()
+			z.fail(entry, errDeadline)
+			continue
+		}
+		if z.OnEntryStart != nil {
+			if err := z.OnEntryStart(entry); err != nil {
+				prepared.cleanup()
+				z.fail(entry, err)
+				continue
+			}
+		}
+
+		// ✅ Explicitly add folders to the ZIP
+		if entry.IsDir() {
 			folderPath := entry.ZipPath()
 			if !strings.HasSuffix(folderPath, "/") {
 				folderPath += "/"
 			}
 
-			fmt.Printf("Adding empty folder to ZIP: %s\n", folderPath) // Debugging log
-
-			header := &zip.FileHeader{
-				Name:     folderPath,
-				Method:   zip.Store, // No compression for folders
-				Modified: time.Now(),
+			header := entry.fileHeader(zip.Store) // No compression for folders
+			header.Name = folderPath
+			mode := os.FileMode(0755)
+			if entry.mode != 0 {
+				mode = entry.mode.Perm()
 			}
-			header.SetMode(os.ModeDir | 0755) // ✅ Ensure it's treated as a directory
+			header.SetMode(os.ModeDir | mode) // ✅ Ensure it's treated as a directory
 
 			_, err := zipWriter.CreateHeader(header)
 			if err != nil {
 				return fmt.Errorf("failed to create directory entry %s: %v", folderPath, err)
 			}
+			z.added(entry)
 
 			success++
 			continue
 		}
 
-		// ✅ Handle files as usual
-		resp, err := http.Get(entry.Url().String())
-		if err != nil {
-			continue
-		}
-		defer resp.Body.Close()
-		if resp.StatusCode != http.StatusOK {
+		// Inline entries carry their own data
+		if entry.Url() == nil {
+			header := entry.fileHeader(z.entryMethod(entry))
+			entryWriter, err := zipWriter.CreateHeader(header)
+			if err != nil {
+				return err
+			}
+			out, hasher := z.entryOutput(entryWriter)
+			if _, err := out.Write(entry.Content()); err != nil {
+				return err
+			}
+			if hasher != nil {
+				z.hashes = append(z.hashes, hasher.result(entry.ZipPath()))
+			}
+			z.entrySize = int64(len(entry.Content()))
+			z.added(entry)
+
+			success++
 			continue
 		}
 
-		header := &zip.FileHeader{
-			Name:     entry.ZipPath(),
-			Method:   z.CompressionMethod,
-			Modified: time.Now(),
+		// ✅ Handle files as usual, reusing the data of an earlier duplicate when possible
+		key := entry.contentKey()
+		var added bool
+		var err error
+		if prepared != nil && !prepared.streamDirect {
+			added, err = z.writePrepared(zipWriter, entry, prepared)
+		} else {
+			added, err = z.writeSpooled(zipWriter, entry, key)
+			if err == nil && !added {
+				added, err = z.streamRemoteEntry(zipWriter, entry)
+			}
 		}
-		entryWriter, err := zipWriter.CreateHeader(header)
+		z.contents.done(key)
 		if err != nil {
 			return err
 		}
-
-		_, err = io.Copy(entryWriter, resp.Body)
-		if err != nil {
-			return err
+		if !added {
+			continue
 		}
+		z.added(entry)
 
 		zipWriter.Flush()
-		flushingWriter, ok := z.destination.(http.Flusher)
+		flushingWriter, ok := destination.(http.Flusher)
 		if ok {
 			flushingWriter.Flush()
 		}
@@ -95,14 +248,419 @@ func (z *ZipStream) StreamAllFiles() error {
 		success++
 	}
 
+	z.counters.entries.Store(int64(len(z.entries)))
+
+	if err := z.writeFailureManifest(zipWriter); err != nil {
+		return err
+	}
+	if err := z.writeManifest(zipWriter); err != nil {
+		return err
+	}
+
+	if z.Comment != "" {
+		if err := zipWriter.SetComment(z.Comment); err != nil {
+			return err
+		}
+	}
+
 	// ✅ Ensure at least one entry (file or folder) is added, otherwise return an error
 	if err := zipWriter.Close(); err != nil {
 		return err
 	}
+	if keepalive != nil {
+		if err := keepalive.finish(); err != nil {
+			return err
+		}
+	}
 
 	if success == 0 {
-		return errors.New("empty file - all files and folders failed")
+		return ErrEmptyArchive
 	}
 
 	return nil
 }
+
+// streamRemoteEntry downloads an entry into the archive. Upstream failures are
+// recorded and reported as not added; only errors writing the archive are returned.
+func (z *ZipStream) streamRemoteEntry(zipWriter *archiveWriter, entry *FileEntry) (bool, error) {
+	ctx, cancel, release := z.entryContext(z.context())
+	defer release()
+
+	source, err := z.openEntrySource(ctx, cancel, entry)
+	if err != nil {
+		return false, z.failEntry(zipWriter, entry, fetchError(ctx, err))
+	}
+	defer source.close()
+	verifier, checksums := source.verifier, source.checksums
+	body := source.body
+
+	header := entry.fileHeader(z.entryMethod(entry))
+
+	// Pre-compressed data and gzip data stored under its uncompressed name are
+	// copied without recompressing
+	passthrough := false
+	var dataErr error
+	if entry.raw != nil {
+		passthrough = true
+		err = writeRawData(zipWriter, header, entry.raw, body)
+	} else if (source.gzipEncoded || entry.IsGzipSource()) && !entry.nested && !z.hashing() {
+		gzipSource := bufio.NewReaderSize(body, gzipPeekSize)
+		body = gzipSource
+		if headerLength, ok := gzipHeaderLength(gzipSource); ok {
+			passthrough = true
+			gzipSource.Discard(headerLength)
+			dataErr, err = writeGzipMember(zipWriter, header, gzipSource)
+		}
+	}
+	if passthrough {
+		if verifier.err != nil {
+			z.fail(entry, fetchError(ctx, verifier.err))
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		if dataErr == nil && checksums != nil {
+			dataErr = checksums.verify()
+		}
+		if dataErr != nil {
+			z.fail(entry, dataErr)
+			return false, nil
+		}
+		z.entrySize = int64(header.UncompressedSize64)
+		source.commit()
+		return true, nil
+	}
+
+	entryWriter, err := zipWriter.CreateHeader(header)
+	if err != nil {
+		return false, err
+	}
+	out, hasher := z.entryOutput(entryWriter)
+	var copyTo io.Writer = out
+	key := entry.contentKey()
+	duplicate := z.contents.create(key)
+	complete := false
+	if duplicate != nil {
+		defer func() { z.contents.store(key, duplicate, complete) }()
+		copyTo = io.MultiWriter(out, duplicate)
+	}
+	z.entrySize, err = pooledCopy(copyTo, body)
+	if verifier.err != nil {
+		// The entry is already partly written, so it is reported rather than dropped
+		z.fail(entry, fetchError(ctx, verifier.err))
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if checksums != nil {
+		if err := checksums.verify(); err != nil {
+			z.fail(entry, err)
+			return false, nil
+		}
+	}
+	if hasher != nil {
+		z.hashes = append(z.hashes, hasher.result(entry.ZipPath()))
+	}
+	source.commit()
+	complete = true
+
+	return true, nil
+}
+
+// context returns the context of the whole stream
+func (z *ZipStream) context() context.Context {
+	if z.Context != nil {
+		return z.Context
+	}
+	return context.Background()
+}
+
+// entryContext returns the context for fetching one entry, bounded by the entry
+// timeout and the archive deadline, and a function releasing it
+func (z *ZipStream) entryContext(parent context.Context) (context.Context, context.CancelCauseFunc, func()) {
+	ctx, cancel := context.WithCancelCause(parent)
+	release := []func(){func() { cancel(nil) }}
+	if z.EntryTimeout > 0 {
+		var cancelTimeout context.CancelFunc
+		ctx, cancelTimeout = context.WithTimeoutCause(ctx, z.EntryTimeout, errEntryTimeout)
+		release = append(release, cancelTimeout)
+	}
+	if !z.Deadline.IsZero() {
+		var cancelDeadline context.CancelFunc
+		ctx, cancelDeadline = context.WithDeadlineCause(ctx, z.Deadline, errDeadline)
+		release = append(release, cancelDeadline)
+	}
+
+	return ctx, cancel, func() {
+		for i := len(release) - 1; i >= 0; i-- {
+			release[i]()
+		}
+	}
+}
+
+// entrySource is an entry's body as read into the archive, with the readers
+// that verify and cache it along the way
+type entrySource struct {
+	body        io.Reader
+	verifier    *verifyingReader
+	checksums   *checksumVerifier
+	spool       *diskSpool
+	capture     *memoryCapture
+	gzipEncoded bool
+	closers     []func()
+}
+
+// openEntrySource fetches an entry and builds the reader chain for its body:
+// resumption, caching, stall detection, the entry's wrapper, checksums,
+// middleware, content type checks and scanning
+func (z *ZipStream) openEntrySource(ctx context.Context, cancel context.CancelCauseFunc, entry *FileEntry) (*entrySource, error) {
+	upstreamBody, gzipEncoded, err := z.openUpstream(ctx, entry)
+	if err != nil {
+		return nil, err
+	}
+	source := &entrySource{gzipEncoded: gzipEncoded, closers: []func(){func() { upstreamBody.Close() }}}
+
+	// Resumption happens below the wrapper so stateful decoders see one continuous body
+	expectedSize := entry.Size()
+	if gzipEncoded {
+		expectedSize = -1
+	} else if entry.member != nil {
+		expectedSize = entry.member.end - entry.member.start
+	}
+	source.verifier = newVerifyingReader(ctx, z.client(entry), entry.Url().String(), upstreamBody, expectedSize)
+	source.verifier.prepare = entry.prepareRequest
+	source.verifier.span = entry.member
+	source.closers = append(source.closers, func() { source.verifier.Close() })
+	var raw io.Reader = source.verifier
+	if !gzipEncoded && z.cacheable(entry) {
+		// Caches hold bodies without their content encoding
+		source.spool = z.DiskCache.spool(entry.Url().String())
+		source.capture = z.MemoryCache.capture(entry.Url().String())
+	}
+	if source.spool != nil {
+		source.closers = append(source.closers, source.spool.discard)
+		raw = io.TeeReader(raw, source.spool)
+	}
+	if source.capture != nil {
+		raw = io.TeeReader(raw, source.capture)
+	}
+	stall := &stallReader{r: &timedReader{r: raw, counters: &z.counters}}
+	source.closers = append(source.closers, watchStall(ctx, cancel, stall, z.StallTimeout, z.MinThroughput))
+	source.body = stall
+	if entry.member != nil {
+		body, err := skipLocalHeader(source.body, entry.raw.CompressedSize)
+		if err != nil {
+			source.close()
+			return nil, err
+		}
+		source.body = body
+	}
+	if entry.wrap != nil {
+		source.body = entry.wrap(source.body)
+	}
+	source.checksums = newChecksumVerifier(entry.checksums)
+	if source.checksums != nil {
+		source.body = source.checksums.wrap(source.body)
+	}
+	if !gzipEncoded {
+		source.body = z.applyMiddleware(entry, source.body)
+	}
+	if !z.ContentTypes.Empty() {
+		if err := z.sniffSource(entry, source); err != nil {
+			source.close()
+			return nil, err
+		}
+	}
+	if z.Scanner != nil {
+		if err := z.scanSource(ctx, entry, source); err != nil {
+			source.close()
+			return nil, err
+		}
+	}
+	return source, nil
+}
+
+// commit stores the completely read body in the caches
+func (s *entrySource) commit() {
+	if s.spool != nil {
+		s.spool.commit()
+	}
+	if s.capture != nil {
+		s.capture.commit()
+	}
+}
+
+// close releases the source in reverse order of opening
+func (s *entrySource) close() {
+	for i := len(s.closers) - 1; i >= 0; i-- {
+		s.closers[i]()
+	}
+}
+
+// openUpstream returns the raw body of an entry, served from the memory, disk
+// or ETag cache when possible, and whether it still carries a gzip content
+// encoding. Errors are upstream failures for this entry only.
+func (z *ZipStream) openUpstream(ctx context.Context, entry *FileEntry) (io.ReadCloser, bool, error) {
+	url := entry.Url().String()
+	// Caches are keyed by URL alone, so they never see credentialed entries
+	cache := z.Cache
+	if !z.cacheable(entry) {
+		cache = nil
+	} else if cached, ok := z.MemoryCache.open(url); ok {
+		return cached, false, nil
+	} else if cached, ok := z.DiskCache.open(url); ok {
+		return cached, false, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	if err := entry.prepareRequest(req); err != nil {
+		return nil, false, err
+	}
+	if cache != nil {
+		cache.prepare(req)
+	}
+	status := http.StatusOK
+	if entry.member != nil {
+		req.Header.Set("Range", entry.member.header(0))
+		status = http.StatusPartialContent
+	}
+	resp, err := z.client(entry).Do(req)
+	if err != nil {
+		return nil, false, err
+	}
+
+	// The transport only leaves the encoding in place when it didn't ask for it
+	gzipEncoded := resp.StatusCode == http.StatusOK && strings.EqualFold(resp.Header.Get("Content-Encoding"), "gzip")
+	body := resp.Body
+	if cache != nil {
+		cachedBody, ok := cache.serve(url, resp)
+		if !ok {
+			resp.Body.Close()
+			return nil, false, fetchFailed(resp)
+		}
+		body = cachedBody
+		if resp.StatusCode == http.StatusNotModified {
+			resp.StatusCode = http.StatusOK
+		}
+	}
+	if resp.StatusCode != status {
+		body.Close()
+		return nil, false, fetchFailed(resp)
+	}
+	return body, gzipEncoded, nil
+}
+
+// writeFailureManifest adds a report of the failed entries to the archive
+func (z *ZipStream) writeFailureManifest(zipWriter *archiveWriter) error {
+	if z.FailureManifest == "" || len(z.failed) == 0 {
+		return nil
+	}
+
+	var manifest strings.Builder
+	fmt.Fprintf(&manifest, "The following %d files could not be added to this archive:\n\n", len(z.failed))
+	for _, failure := range z.failed {
+		fmt.Fprintf(&manifest, "%s: %v\n", failure.ZipPath, failure.Err)
+	}
+
+	header := &zip.FileHeader{
+		Name:     z.FailureManifest,
+		Method:   z.CompressionPolicy.method(z.FailureManifest, z.CompressionMethod),
+		Modified: z.now(),
+	}
+	entryWriter, err := zipWriter.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(entryWriter, manifest.String())
+	return err
+}
+
+// cacheable reports whether the entry's data may be shared through the caches.
+// With a cookie jar responses depend on the cookies of earlier ones.
+func (z *ZipStream) cacheable(entry *FileEntry) bool {
+	return !entry.private() && z.Jar == nil
+}
+
+// client returns the HTTP client used for an entry's upstream requests
+func (z *ZipStream) client(entry *FileEntry) *http.Client {
+	client := DefaultClient()
+	if entry.client != nil {
+		client = entry.client
+	} else if z.Client != nil {
+		client = z.Client
+	}
+	if z.Jar != nil {
+		// A copy shares the transport, so the jar doesn't leak into other archives
+		withJar := *client
+		withJar.Jar = z.Jar
+		return &withJar
+	}
+	return client
+}
+
+// fetchError prefers the reason a fetch was cancelled (timeout, stall) over the
+// generic error it surfaced as
+func fetchError(ctx context.Context, err error) error {
+	if cause := context.Cause(ctx); cause != nil {
+		return cause
+	}
+	return err
+}
+
+func (z *ZipStream) fail(entry *FileEntry, err error) {
+	fmt.Printf("Failed to add %s: %v\n", entry.ZipPath(), err)
+	if errors.Is(err, errStalled) {
+		z.counters.stalls.Add(1)
+	}
+	z.failed = append(z.failed, FailedEntry{ZipPath: entry.ZipPath(), Err: err})
+	result := EntryResult{ZipPath: entry.ZipPath(), Duration: time.Since(z.entryStart), Err: err}
+	z.results = append(z.results, result)
+	if z.OnEntryError != nil {
+		z.OnEntryError(result)
+	}
+}
+
+// Failed returns the entries that were left out of the archive during streaming
+func (z *ZipStream) Failed() []FailedEntry {
+	return z.failed
+}
+
+// entryOutput wraps an entry writer with a hashing writer when entries are hashed
+func (z *ZipStream) entryOutput(entryWriter io.Writer) (io.Writer, *hashingWriter) {
+	if !z.hashing() {
+		return entryWriter, nil
+	}
+	hasher := newHashingWriter(entryWriter)
+	return hasher, hasher
+}
+
+// Stats returns the throughput figures of the stream; Duration is set once
+// StreamAllFiles has returned
+func (z *ZipStream) Stats() StreamStats {
+	return StreamStats{
+		Duration:     z.duration,
+		BytesRead:    z.counters.bytesRead.Load(),
+		BytesWritten: z.counters.bytesWritten.Load(),
+		UpstreamWait: time.Duration(z.counters.upstreamWait.Load()),
+		ClientWait:   time.Duration(z.counters.clientWait.Load()),
+		Stalls:       int(z.counters.stalls.Load()),
+	}
+}
+
+// Progress returns how many entries have been finished and how many archive
+// bytes were written so far. Unlike Stats, it may be called while streaming.
+func (z *ZipStream) Progress() (entries int, bytes int64) {
+	return int(z.counters.entries.Load()), z.counters.bytesWritten.Load()
+}
+
+// EntryHashes returns the hashes of all completed entries when HashEntries is
+// set. Pre-compressed entries, which are copied without reading their data, are
+// not hashed.
+func (z *ZipStream) EntryHashes() []EntryHash {
+	return z.hashes
+}
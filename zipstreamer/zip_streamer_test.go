@@ -0,0 +1,68 @@
+package zipstreamer
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCookieJarSkipsCaches(t *testing.T) {
+	// The first response starts a session, later ones depend on it
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if _, err := r.Cookie("session"); err != nil {
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: "1"})
+			io.WriteString(w, "anonymous")
+			return
+		}
+		io.WriteString(w, "signed in")
+	}))
+	defer server.Close()
+
+	var entries []*FileEntry
+	for _, name := range []string{"first.txt", "second.txt"} {
+		entry, err := NewFileEntry(server.URL+"/data", name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, entry)
+	}
+	var archive bytes.Buffer
+	stream, err := NewZipStream(entries, &archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream.Jar = NewCookieJar()
+	stream.Cache = NewETagCache(1024, 1024*1024)
+	stream.MemoryCache = NewMemoryCache(1024, 1024*1024, time.Minute)
+	if stream.DiskCache, err = NewDiskCache(t.TempDir(), 1024*1024, 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stream.StreamAllFiles(); err != nil {
+		t.Fatal(err)
+	}
+
+	if requests != 2 {
+		t.Errorf("upstream got %d requests, want 2", requests)
+	}
+	reader, err := zip.NewReader(bytes.NewReader(archive.Bytes()), int64(archive.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"anonymous", "signed in"}
+	for i, file := range reader.File {
+		data, err := file.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, _ := io.ReadAll(data)
+		if string(got) != want[i] {
+			t.Errorf("%s = %q, want %q", file.Name, got, want[i])
+		}
+	}
+}
@@ -0,0 +1,22 @@
+package zipstreamer
+
+import (
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Zstd is the zip compression method ID assigned to Zstandard
+const Zstd uint16 = 93
+
+// zstdCompressor returns a zip compressor for Zstandard. level follows the
+// 1-9 scale of Deflate and is mapped onto the zstd levels; 0 uses the default.
+func zstdCompressor(level int) func(io.Writer) (io.WriteCloser, error) {
+	encoderLevel := zstd.SpeedDefault
+	if level > 0 {
+		encoderLevel = zstd.EncoderLevelFromZstd(level)
+	}
+	return func(out io.Writer) (io.WriteCloser, error) {
+		return zstd.NewWriter(out, zstd.WithEncoderLevel(encoderLevel), zstd.WithEncoderConcurrency(1))
+	}
+}